@@ -0,0 +1,168 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/go-resty/resty/v2"
+	"github.com/schoolyear/avd-cli/lib/lib_credentials"
+	"github.com/schoolyear/avd-cli/lib/lib_github"
+	"github.com/schoolyear/avd-cli/static"
+	"github.com/urfave/cli/v2"
+)
+
+func newGithubRestyClient() *resty.Client {
+	return resty.New().
+		SetTimeout(10 * time.Second).
+		SetRetryCount(2).
+		SetRetryWaitTime(1 * time.Second)
+}
+
+var CredentialStoreFlag = &cli.StringFlag{
+	Name:    "credential-store",
+	Usage:   "Where to store credentials: auto (OS keyring, falls back to a file in ~/.avdcli), keyring or file",
+	Value:   string(lib_credentials.StoreKindAuto),
+	EnvVars: []string{"AVDCLI_CREDENTIAL_STORE"},
+}
+
+// credentialStore returns the credential store selected with the global --credential-store flag
+func credentialStore(c *cli.Context) (lib_credentials.Store, error) {
+	kind, err := lib_credentials.ParseStoreKind(c.String(CredentialStoreFlag.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	return lib_credentials.NewStore(kind)
+}
+
+var AuthLoginCommand = &cli.Command{
+	Name:  "login",
+	Usage: "Log in to GitHub to download community layers. Refreshes an expired login if possible",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Ignore the cached token and log in again",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		force := c.Bool("force")
+
+		store, err := credentialStore(c)
+		if err != nil {
+			return err
+		}
+
+		client := newGithubRestyClient()
+
+		token, err := lib_github.GithubDeviceFlow(client, store, static.GithubAppClientId, !force, true, "To download community layers")
+		if err != nil {
+			return errors.Wrap(err, "failed to authenticate with GitHub")
+		}
+
+		user, _, _, err := lib_github.GithubGetAuthenticatedUser(client, token)
+		if err != nil {
+			return errors.Wrap(err, "failed to verify the GitHub token")
+		}
+
+		color.HiGreen("Logged in to GitHub as %s", user.Login)
+		fmt.Printf("Stored in: %s\n", store.Name())
+		if warning := store.Warning(); warning != "" {
+			color.Yellow("Warning: %s", warning)
+		}
+		return nil
+	},
+}
+
+var AuthStatusCommand = &cli.Command{
+	Name:  "status",
+	Usage: "Show the GitHub login that is cached locally",
+	Action: func(c *cli.Context) error {
+		store, err := credentialStore(c)
+		if err != nil {
+			return err
+		}
+
+		stored, err := lib_github.LoadGithubToken(store, static.GithubAppClientId)
+		if err != nil {
+			return err
+		}
+
+		if stored == nil {
+			fmt.Println("Not logged in to GitHub. Run \"avdcli auth login\" to log in.")
+			return nil
+		}
+
+		if stored.AccessTokenExpired() {
+			color.Yellow("Access token:  expired %s", humanize.Time(stored.ExpiresAt))
+		} else {
+			client := newGithubRestyClient()
+			user, scopes, _, err := lib_github.GithubGetAuthenticatedUser(client, stored.AccessToken)
+			if err != nil {
+				if errors.Is(err, lib_github.ErrGithubUnauthorized) {
+					color.HiRed("Access token:  rejected by GitHub (revoked?). Run \"avdcli auth login --force\"")
+					return nil
+				}
+				return errors.Wrap(err, "failed to get GitHub user")
+			}
+
+			userName := user.Login
+			if user.Name != nil && *user.Name != "" {
+				userName = fmt.Sprintf("%s (%s)", user.Login, *user.Name)
+			}
+
+			scopesString := "none (GitHub App token)"
+			if len(scopes) > 0 {
+				scopesString = strings.Join(scopes, ", ")
+			}
+
+			fmt.Printf("User:          %s\n", color.GreenString(userName))
+			fmt.Printf("Scopes:        %s\n", scopesString)
+			fmt.Printf("Access token:  expires %s (%s)\n", humanize.Time(stored.ExpiresAt), stored.ExpiresAt.Local().Format(time.RFC1123))
+		}
+
+		switch {
+		case stored.RefreshToken == "":
+			fmt.Println("Refresh token: none")
+		case stored.RefreshTokenExpired():
+			color.Yellow("Refresh token: expired, you will have to log in again")
+		case stored.RefreshTokenExpiresAt != nil:
+			fmt.Printf("Refresh token: expires %s\n", humanize.Time(*stored.RefreshTokenExpiresAt))
+		default:
+			fmt.Println("Refresh token: available")
+		}
+
+		fmt.Printf("Stored in:     %s\n", store.Name())
+		if warning := store.Warning(); warning != "" {
+			color.Yellow("Warning: %s", warning)
+		}
+
+		return nil
+	},
+}
+
+var AuthLogoutCommand = &cli.Command{
+	Name:  "logout",
+	Usage: "Remove the cached GitHub login",
+	Action: func(c *cli.Context) error {
+		store, err := credentialStore(c)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := lib_github.DeleteGithubToken(store, static.GithubAppClientId)
+		if err != nil {
+			return err
+		}
+
+		if deleted {
+			color.HiGreen("Logged out of GitHub")
+		} else {
+			fmt.Println("Not logged in to GitHub")
+		}
+		return nil
+	},
+}
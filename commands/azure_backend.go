@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/urfave/cli/v2"
+)
+
+var azureBackendFlag = &cli.StringFlag{
+	Name:    "azure-backend",
+	Usage:   "How to talk to Azure: sdk (no Azure CLI required, uses the default Azure credentials) or az (shells out to the Azure CLI)",
+	Value:   string(lib_azure.BackendKindSDK),
+	EnvVars: []string{"AVDCLI_AZURE_BACKEND"},
+}
+
+var azureTenantIdFlag = &cli.StringFlag{
+	Name:    "azure-tenant-id",
+	Usage:   "Overwrite the default Azure Tenant ID",
+	Aliases: []string{"atd"},
+}
+
+// azureBackend returns the backend selected with the azure-backend and azure-tenant-id flags
+func azureBackend(c *cli.Context) (lib_azure.Backend, error) {
+	kind, err := lib_azure.ParseBackendKind(c.String(azureBackendFlag.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	return lib_azure.NewBackend(kind, c.String(azureTenantIdFlag.Name))
+}
@@ -0,0 +1,203 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib"
+	"github.com/urfave/cli/v2"
+)
+
+var BuildListCommand = &cli.Command{
+	Name:  "list",
+	Usage: "List the builds started from this machine (most recent first)",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:    "limit",
+			Usage:   "Maximum number of builds to show. 0 shows all",
+			Value:   20,
+			Aliases: []string{"n"},
+		},
+		&cli.StringFlag{
+			Name:  "image-definition",
+			Usage: "Only show builds of this image definition (name or resource id)",
+		},
+		&cli.StringFlag{
+			Name:  "status",
+			Usage: "Only show builds with this status",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output the builds as JSON",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		limit := c.Int("limit")
+		imageDefinitionFilter := c.String("image-definition")
+		statusFilter := c.String("status")
+		outputJson := c.Bool("json")
+
+		records, err := lib.LoadBuildRecords()
+		if err != nil {
+			return err
+		}
+
+		records = slices.DeleteFunc(records, func(record lib.BuildRecord) bool {
+			if imageDefinitionFilter != "" && record.ImageDefinition != imageDefinitionFilter && path.Base(record.ImageDefinition) != imageDefinitionFilter {
+				return true
+			}
+			return statusFilter != "" && string(record.Status) != statusFilter
+		})
+
+		sort.SliceStable(records, func(i, j int) bool {
+			return records[i].CreatedAt.After(records[j].CreatedAt)
+		})
+		if limit > 0 && len(records) > limit {
+			records = records[:limit]
+		}
+
+		if outputJson {
+			return printJSON(records)
+		}
+
+		if len(records) == 0 {
+			ledgerPath, _ := lib.BuildLedgerPath()
+			fmt.Printf("No builds found in %s\n", ledgerPath)
+			return nil
+		}
+
+		fmt.Printf("%-8s  %-16s  %-20s  %-30s  %s\n", "ID", "CREATED", "STATUS", "IMAGE DEFINITION", "TEMPLATE")
+		for _, record := range records {
+			imageDefinition := path.Base(record.ImageDefinition)
+			if record.ImageDefinition == "" {
+				imageDefinition = "-"
+			}
+
+			fmt.Printf("%-8s  %-16s  %-20s  %-30s  %s\n",
+				record.ID,
+				record.CreatedAt.Local().Format("2006-01-02 15:04"),
+				record.Status,
+				imageDefinition,
+				record.TemplateName,
+			)
+		}
+
+		return nil
+	},
+}
+
+var BuildShowCommand = &cli.Command{
+	Name:      "show",
+	Usage:     "Show the details of a build started from this machine",
+	ArgsUsage: "<build-id|template-name>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output the build as JSON",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		query := c.Args().First()
+		outputJson := c.Bool("json")
+
+		if query == "" {
+			return errors.New("build id or template name argument is required")
+		}
+
+		records, err := lib.FindBuildRecords(query)
+		if err != nil {
+			return err
+		}
+
+		switch len(records) {
+		case 0:
+			return fmt.Errorf("no build found with id or template name %s. Run \"avdcli build list\" to see all builds", query)
+		case 1:
+		default:
+			ids := make([]string, len(records))
+			for i, record := range records {
+				ids[i] = record.ID
+			}
+			return fmt.Errorf("multiple builds match %s, select one by id: %s", query, strings.Join(ids, ", "))
+		}
+
+		record := records[0]
+		if outputJson {
+			return printJSON(record)
+		}
+
+		printBuildRecord(record)
+		return nil
+	},
+}
+
+func printBuildRecord(record lib.BuildRecord) {
+	fmt.Printf("Build:            %s\n", color.CyanString(record.ID))
+	fmt.Printf("Command:          %s\n", record.Command)
+	fmt.Printf("Status:           %s\n", record.Status.Colored())
+	if record.Message != "" {
+		fmt.Printf("Message:          %s\n", record.Message)
+	}
+	fmt.Printf("Subscription:     %s\n", record.SubscriptionId)
+	fmt.Printf("Resource group:   %s\n", record.ResourceGroup)
+	fmt.Printf("Image Template:   %s\n", record.TemplateName)
+	if record.ImageDefinition != "" {
+		fmt.Printf("Image definition: %s\n", record.ImageDefinition)
+	}
+	if record.ImageVersion != "" {
+		fmt.Printf("Image version:    %s\n", record.ImageVersion)
+	}
+	if record.BundleSha256 != "" {
+		fmt.Printf("Bundle sha256:    %s\n", record.BundleSha256)
+	}
+	fmt.Printf("Created:          %s\n", record.CreatedAt.Local().Format(time.RFC1123))
+	fmt.Printf("Updated:          %s\n", record.UpdatedAt.Local().Format(time.RFC1123))
+	if record.FinishedAt != nil {
+		fmt.Printf("Finished:         %s\n", record.FinishedAt.Local().Format(time.RFC1123))
+	}
+
+	if len(record.Layers) > 0 {
+		fmt.Println("Layers:")
+		for _, layer := range record.Layers {
+			if layer.Source != "" {
+				fmt.Printf("    - %s (%s)\n", layer.Name, layer.Source)
+			} else {
+				fmt.Printf("    - %s\n", layer.Name)
+			}
+		}
+	}
+
+	if len(record.Parameters) > 0 {
+		fmt.Println("Parameters:")
+		keys := make([]string, 0, len(record.Parameters))
+		for key := range record.Parameters {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("    %s = %s\n", key, record.Parameters[key])
+		}
+	}
+
+	if !record.Finished() && record.Status == lib.BuildStatusDeployed {
+		fmt.Println()
+		fmt.Printf("Update the status with: avdcli build status %s --subscription-id %s --resource-group %s\n", record.TemplateName, record.SubscriptionId, record.ResourceGroup)
+	}
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(v); err != nil {
+		return errors.Wrap(err, "failed to write JSON")
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/urfave/cli/v2"
+)
+
+var BuildStatusCommand = &cli.Command{
+	Name:      "status",
+	Usage:     "Show the status of the last run of an Image Template (created by \"bundle autobuild\" or \"package deploy\")",
+	ArgsUsage: "<template-name>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "subscription-id",
+			Usage:   "Azure subscription ID",
+			Aliases: []string{"sid"},
+		},
+		&cli.StringFlag{
+			Name:    "resource-group",
+			Usage:   "Name of the Resource Group of the Image Template",
+			Aliases: []string{"g"},
+		},
+		&cli.BoolFlag{
+			Name:    "watch",
+			Usage:   "Keep polling until the build finished",
+			Aliases: []string{"w"},
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "Polling interval when watching",
+			Value: 30 * time.Second,
+		},
+		&cli.PathFlag{
+			Name:      "log-output",
+			Usage:     "Path to which the customization.log is downloaded once the build finished. Defaults to <template-name>.customization.log",
+			TakesFile: true,
+		},
+		&cli.BoolFlag{
+			Name:  "no-log",
+			Usage: "Don't download the customization.log",
+		},
+		azureBackendFlag,
+		azureTenantIdFlag,
+		commandProfileFlag,
+	},
+	Before: applyProfile("subscription-id", "resource-group"),
+	Action: func(c *cli.Context) error {
+		templateName := c.Args().First()
+		subscriptionId := c.String("subscription-id")
+		resourceGroup := c.String("resource-group")
+		watch := c.Bool("watch")
+		interval := c.Duration("interval")
+		logOutputPath := c.Path("log-output")
+		noLog := c.Bool("no-log")
+
+		if templateName == "" {
+			return errors.New("template name argument is required")
+		}
+		if logOutputPath == "" {
+			logOutputPath = templateName + ".customization.log"
+		}
+
+		backend, err := azureBackend(c)
+		if err != nil {
+			return err
+		}
+
+		status, err := watchImageTemplate(c.Context, backend, subscriptionId, resourceGroup, templateName, watch, interval)
+		if err != nil {
+			return err
+		}
+
+		if buildStatus, finished := lib.BuildStatusFromRunState(status.RunState); finished {
+			if err := lib.FinishBuildRecords(subscriptionId, resourceGroup, templateName, buildStatus, status.RunMessage); err != nil {
+				color.Yellow("Warning: failed to update the local build history: %s", err)
+			}
+		}
+
+		if status.RunFinished() && !noLog {
+			fmt.Println()
+			fmt.Printf("Downloading customization log to %s...", logOutputPath)
+			if err := backend.DownloadCustomizationLog(c.Context, subscriptionId, status.StagingResourceGroup, logOutputPath); err != nil {
+				if errors.Is(err, lib_azure.ErrCustomizationLogNotFound) {
+					color.Yellow("[NOT FOUND]")
+				} else {
+					color.Yellow("[FAILED]: %s", err)
+				}
+			} else {
+				color.Green("[DONE]")
+			}
+		}
+
+		if status.RunFailed() {
+			return fmt.Errorf("the build of %s did not succeed (%s)", templateName, status.RunState)
+		}
+
+		return nil
+	},
+}
+
+// watchImageTemplate prints the status of the Image Template.
+// if watch is set, it keeps polling until the run finished, printing every change
+func watchImageTemplate(ctx context.Context, backend lib_azure.Backend, subscriptionId, resourceGroup, templateName string, watch bool, interval time.Duration) (*lib_azure.ImageTemplateStatus, error) {
+	var (
+		lastPrinted          string
+		printedWaitingNotice bool
+	)
+	for {
+		status, err := backend.GetImageTemplateStatus(ctx, subscriptionId, resourceGroup, templateName)
+		if err != nil {
+			if errors.Is(err, lib_azure.ErrImageTemplateNotFound) {
+				return nil, fmt.Errorf("Image Template %s not found in resource group %s", templateName, resourceGroup)
+			}
+			return nil, errors.Wrap(err, "failed to get Image Template status")
+		}
+
+		if lastPrinted == "" {
+			printImageTemplateStatus(templateName, status)
+		} else if line := imageTemplateStatusLine(status); line != lastPrinted {
+			fmt.Printf("%s %s\n", time.Now().Format(time.TimeOnly), line)
+			if status.RunFinished() && status.RunMessage != "" {
+				fmt.Printf("Message: %s\n", status.RunMessage)
+			}
+		}
+		lastPrinted = imageTemplateStatusLine(status)
+
+		switch {
+		case !watch, status.RunFinished():
+			return status, nil
+		case status.ProvisioningState == "Failed":
+			return nil, errors.New("the Image Template failed to provision, so it cannot run")
+		case !status.HasRun() && !printedWaitingNotice:
+			fmt.Println("The Image Template has not run yet. Waiting for it to start...")
+			printedWaitingNotice = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func printImageTemplateStatus(templateName string, status *lib_azure.ImageTemplateStatus) {
+	fmt.Printf("Image Template: %s\n", color.CyanString(templateName))
+	fmt.Printf("Provisioning:   %s\n", status.ProvisioningState)
+	if status.ProvisioningError != "" {
+		color.HiRed("                %s", status.ProvisioningError)
+	}
+
+	if !status.HasRun() {
+		fmt.Println("Last run:       never started")
+		return
+	}
+
+	fmt.Printf("Last run:       %s\n", imageTemplateStatusLine(status))
+	if status.StartTime != nil {
+		fmt.Printf("Started:        %s\n", status.StartTime.Local().Format(time.RFC1123))
+	}
+	if status.RunMessage != "" {
+		fmt.Printf("Message:        %s\n", status.RunMessage)
+	}
+}
+
+// imageTemplateStatusLine summarizes the run state in a single line
+func imageTemplateStatusLine(status *lib_azure.ImageTemplateStatus) string {
+	if !status.HasRun() {
+		return fmt.Sprintf("provisioning %s, not started", status.ProvisioningState)
+	}
+
+	state := status.RunState
+	if status.RunSubState != "" && !status.RunFinished() {
+		state = fmt.Sprintf("%s (%s)", state, status.RunSubState)
+	}
+
+	var coloredState string
+	switch {
+	case status.RunFailed():
+		coloredState = color.HiRedString(state)
+	case status.RunFinished():
+		coloredState = color.HiGreenString(state)
+	default:
+		coloredState = color.YellowString(state)
+	}
+
+	return fmt.Sprintf("%s, %s", coloredState, status.RunDuration(time.Now()).Round(time.Minute))
+}
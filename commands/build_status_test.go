@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/schoolyear/avd-cli/lib/lib_azure/azuretest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_watchImageTemplate(t *testing.T) {
+	backend := azuretest.NewFakeBackend()
+	key := azuretest.FakeResourceKey("sub", "rg", "template")
+
+	start := time.Now().Add(-time.Hour)
+	backend.ImageTemplates[key] = &lib_azure.ImageTemplateStatus{
+		ProvisioningState: "Succeeded",
+		RunState:          "Running",
+		RunSubState:       "Customizing",
+		StartTime:         &start,
+	}
+
+	// without watching, the current status is returned immediately
+	status, err := watchImageTemplate(context.Background(), backend, "sub", "rg", "template", false, time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, status.RunFinished())
+
+	// the run finishes while watching
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		end := start.Add(90 * time.Minute)
+		backend.UpdateImageTemplate(key, func(status *lib_azure.ImageTemplateStatus) {
+			status.RunState = "Failed"
+			status.RunSubState = ""
+			status.EndTime = &end
+		})
+	}()
+
+	status, err = watchImageTemplate(context.Background(), backend, "sub", "rg", "template", true, time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, status.RunFailed())
+	require.Equal(t, 90*time.Minute, status.RunDuration(time.Now()))
+
+	_, err = watchImageTemplate(context.Background(), backend, "sub", "rg", "other", false, time.Millisecond)
+	require.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/urfave/cli/v2"
+)
+
+const subnetResourceType = "Microsoft.Network/virtualNetworks/subnets"
+
+// builderNetworkConfig places the Image Builder VM in an existing subnet instead of a network that Azure creates
+type builderNetworkConfig struct {
+	SubnetID                  string
+	ProxyVMSize               string
+	ContainerInstanceSubnetID string
+}
+
+// resolveBuilderNetwork returns the network of the builder VM from the flags, which may come from the profile.
+// returns nil if the builder VM does not run in an existing subnet.
+//
+// the bundle properties have no network setting on purpose: a bundle describes the image and is reused across
+// subscriptions, while the subnet is a resource of the subscription it is deployed to. The bundle properties schema
+// is also owned by avd-image-types, which is shared with the image build tooling.
+func resolveBuilderNetwork(c *cli.Context) *builderNetworkConfig {
+	network := builderNetworkConfig{
+		SubnetID:                  c.String("builder-subnet-id"),
+		ProxyVMSize:               c.String("builder-proxy-vm-size"),
+		ContainerInstanceSubnetID: c.String("builder-container-instance-subnet-id"),
+	}
+	if network == (builderNetworkConfig{}) {
+		return nil
+	}
+	return &network
+}
+
+// builderVnetConfig converts the network to the VNet configuration of the Image Template
+func builderVnetConfig(network *builderNetworkConfig) *armvirtualmachineimagebuilder.VirtualNetworkConfig {
+	if network == nil {
+		return nil
+	}
+
+	config := &armvirtualmachineimagebuilder.VirtualNetworkConfig{
+		SubnetID: to.Ptr(network.SubnetID),
+	}
+	if network.ProxyVMSize != "" {
+		config.ProxyVMSize = to.Ptr(network.ProxyVMSize)
+	}
+	if network.ContainerInstanceSubnetID != "" {
+		config.ContainerInstanceSubnetID = to.Ptr(network.ContainerInstanceSubnetID)
+	}
+	return config
+}
+
+func preflightVnetConfig(config *armvirtualmachineimagebuilder.VirtualNetworkConfig) []error {
+	var problems []error
+
+	subnet, err := lib_azure.ParseResourceIDOfType(deref(config.SubnetID), subnetResourceType)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("invalid builder subnet: %w", err))
+	}
+
+	proxyVmSize := deref(config.ProxyVMSize)
+	if proxyVmSize != "" && !vmSizeRegex.MatchString(proxyVmSize) {
+		problems = append(problems, fmt.Errorf("the proxy VM size %s is not a valid VM size name, like Standard_A1_v2", proxyVmSize))
+	}
+
+	if containerSubnetId := deref(config.ContainerInstanceSubnetID); containerSubnetId != "" {
+		if proxyVmSize != "" {
+			problems = append(problems, fmt.Errorf("a proxy VM size cannot be set together with a container instance subnet, no proxy VM is deployed then"))
+		}
+
+		containerSubnet, err := lib_azure.ParseResourceIDOfType(containerSubnetId, subnetResourceType)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Errorf("invalid container instance subnet: %w", err))
+		case subnet != nil && !strings.EqualFold(containerSubnet.Parent.String(), subnet.Parent.String()):
+			problems = append(problems, fmt.Errorf("the container instance subnet must be in virtual network %s of the builder subnet", subnet.Parent.Name))
+		case subnet != nil && strings.EqualFold(containerSubnet.Name, subnet.Name):
+			problems = append(problems, fmt.Errorf("the container instance subnet must be another subnet than the builder subnet"))
+		}
+	}
+
+	return problems
+}
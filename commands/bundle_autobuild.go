@@ -12,8 +12,8 @@ import (
 	"io/fs"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -23,6 +23,7 @@ import (
 	"github.com/friendsofgo/errors"
 	"github.com/schoolyear/avd-cli/embeddedfiles"
 	"github.com/schoolyear/avd-cli/lib"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
 	"github.com/schoolyear/avd-cli/schema"
 	avdimagetypes "github.com/schoolyear/avd-image-types"
 	"github.com/urfave/cli/v2"
@@ -39,22 +40,19 @@ var BundleAutoDeployCommand = &cli.Command{
 			Aliases: []string{"b"},
 		},
 		&cli.StringFlag{
-			Name:     "subscription-id",
-			Usage:    "Azure subscription ID",
-			Required: true,
-			Aliases:  []string{"sid"},
+			Name:    "subscription-id",
+			Usage:   "Azure subscription ID",
+			Aliases: []string{"sid"},
 		},
 		&cli.StringFlag{
-			Name:     "resource-group",
-			Usage:    "Name of the Resource Group in which the Image Template Builder is created and in which the Image Gallery is stored.",
-			Required: true,
-			Aliases:  []string{"g"},
+			Name:    "resource-group",
+			Usage:   "Name of the Resource Group in which the Image Template Builder is created and in which the Image Gallery is stored.",
+			Aliases: []string{"g"},
 		},
 		&cli.StringFlag{
-			Name:     "image-gallery",
-			Usage:    "Name of the shared image gallery",
-			Required: true,
-			Aliases:  []string{"sig"},
+			Name:    "image-gallery",
+			Usage:   "Name of the shared image gallery",
+			Aliases: []string{"sig"},
 		},
 		&cli.StringFlag{
 			Name:    "image-definition",
@@ -62,26 +60,23 @@ var BundleAutoDeployCommand = &cli.Command{
 			Aliases: []string{"i"},
 		},
 		&cli.StringFlag{
-			Name:     "storage-account",
-			Usage:    "Name of the Azure Storage Account to use to storage the bundle",
-			Required: true,
-			Aliases:  []string{"sa"},
+			Name:    "storage-account",
+			Usage:   "Name of the Azure Storage Account to use to storage the bundle",
+			Aliases: []string{"sa"},
 		},
 		&cli.StringFlag{
-			Name:     "blob-container",
-			Usage:    "Name of Azure Blob Container to use to store the bundle",
-			Required: true,
-			Aliases:  []string{"bc"},
+			Name:    "blob-container",
+			Usage:   "Name of Azure Blob Container to use to store the bundle",
+			Aliases: []string{"bc"},
 		},
 		&cli.StringFlag{
 			Name:  "template-name",
-			Usage: "Overwrite the name of the Image Template (defaults to [image-definition]-[timestamp]). Note that the operation will fail if this name is already taken.",
+			Usage: "Overwrite the name of the Image Template (defaults to [image-definition]-[timestamp], or [image-definition]-[bundle hash] for bicep and terraform). Note that the operation will fail if this name is already taken.",
 		},
 		&cli.StringSliceFlag{
-			Name:     "replication-regions",
-			Usage:    "Names of the regions to replicate the image to",
-			Required: true,
-			Aliases:  []string{"reg"},
+			Name:    "replication-regions",
+			Usage:   "Regions to replicate the image to, as region[=count[:storage-type]]. For example: westeurope=20:Standard_ZRS,northeurope=2. Regions without a count use --replication-count",
+			Aliases: []string{"reg"},
 		},
 		&cli.StringFlag{
 			Name:  "builder-vm-size",
@@ -94,10 +89,21 @@ var BundleAutoDeployCommand = &cli.Command{
 			Value: 127,
 		},
 		&cli.StringFlag{
-			Name:     "managed-identity",
-			Usage:    "Managed identity to use during image building",
-			Required: true,
-			Aliases:  []string{"mid"},
+			Name:  "builder-subnet-id",
+			Usage: "Resource id of an existing subnet in which the builder VM is deployed. The subnet must be able to reach the storage account, for example through a private endpoint, and the managed identity needs permission to join it. Defaults to builder_subnet_id of the profile",
+		},
+		&cli.StringFlag{
+			Name:  "builder-proxy-vm-size",
+			Usage: "VM size of the proxy VM that passes traffic to the builder VM in --builder-subnet-id. Azure uses Standard_A1_v2 by default",
+		},
+		&cli.StringFlag{
+			Name:  "builder-container-instance-subnet-id",
+			Usage: "Resource id of a subnet, in the virtual network of --builder-subnet-id, in which an Azure Container Instance is deployed for an isolated build instead of a proxy VM",
+		},
+		&cli.StringFlag{
+			Name:    "managed-identity",
+			Usage:   "Managed identity to use during image building",
+			Aliases: []string{"mid"},
 		},
 		&cli.UintFlag{
 			Name:  "build-timeout",
@@ -114,9 +120,21 @@ var BundleAutoDeployCommand = &cli.Command{
 			Usage: `Don't tag new image version as "latest"`,
 			Value: false,
 		},
+		&cli.StringFlag{
+			Name:  "image-version",
+			Usage: "Explicit version number (major.minor.patch) of the new image version. Fails if the version already exists",
+		},
+		&cli.StringFlag{
+			Name:  "version-strategy",
+			Usage: fmt.Sprintf("Strategy to number the new image version when --image-version is not set: %s. By default Azure picks the version number", joinVersionStrategies()),
+			Action: func(c *cli.Context, s string) error {
+				_, err := parseVersionStrategy(s)
+				return err
+			},
+		},
 		&cli.UintFlag{
 			Name:  "replication-count",
-			Usage: "Number of disk replications in each region. Azure recommends one for every 50 concurrently start students.",
+			Usage: "Number of disk replications in each region without an explicit count. Azure recommends one for every 50 concurrently start students.",
 			Value: 5,
 		},
 		&cli.BoolFlag{
@@ -124,21 +142,32 @@ var BundleAutoDeployCommand = &cli.Command{
 			Usage: "Enabled VM Boot optimization during image build (experimental)",
 		},
 		&cli.StringFlag{
-			Name:     "template-location",
-			Usage:    "Azure location to create the image building template in",
-			Required: true,
+			Name:  "template-location",
+			Usage: "Azure location to create the image building template in",
 		},
 		&cli.PathFlag{
 			Name:      "deployment-template",
-			Usage:     "Filepath to which to write the deployment template",
+			Usage:     "Filepath to which to write the deployment template. Defaults to deploy.json, deploy.bicep or deploy.tf, depending on --format",
 			TakesFile: true,
-			Value:     "deploy.json",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: fmt.Sprintf("Format of the deployment template: %s. Bicep and Terraform can only be used with --skip-deployment", joinTemplateFormats()),
+			Value: string(templateFormatARM),
+			Action: func(c *cli.Context, s string) error {
+				_, err := parseTemplateFormat(s)
+				return err
+			},
 		},
 		&cli.BoolFlag{
 			Name:  "skip-deployment",
 			Usage: "Skip the deployment of the Image Template. This allows you to tweak the Image Template deployment before manually deploying it.",
 			Value: false,
 		},
+		windowsUpdateFlag,
+		windowsUpdateFilterFlag,
+		windowsUpdateSearchCriteriaFlag,
+		windowsUpdateLimitFlag,
 		&cli.PathFlag{
 			Name:      "bundle-properties",
 			Usage:     "Path to which the bundle properties output will be written.",
@@ -146,10 +175,13 @@ var BundleAutoDeployCommand = &cli.Command{
 			Aliases:   []string{"p"},
 			Value:     "bundle.json",
 		},
+		azureBackendFlag,
+		azureTenantIdFlag,
+		commandProfileFlag,
 	},
+	Before: applyProfile("subscription-id", "resource-group", "image-gallery", "storage-account", "blob-container", "replication-regions", "managed-identity", "template-location"),
 	Action: func(c *cli.Context) error {
 		now := time.Now()
-		nowFormatted := now.Format("2006-01-02_15-04-05")
 
 		bundlePath := c.Path("bundle")
 		subscriptionId := c.String("subscription-id")
@@ -166,49 +198,78 @@ var BundleAutoDeployCommand = &cli.Command{
 		buildTimeout := c.Uint("build-timeout")
 		start := c.Bool("start")
 		excludeFromLatest := c.Bool("exclude-from-latest")
+		imageVersion := c.String("image-version")
+		versionStrategyName := c.String("version-strategy")
 		replicationCount := c.Uint("replication-count")
 		optimizeImage := c.Bool("optimize-image")
 		templateLocation := c.String("template-location")
 		deploymentTemplatePath := c.String("deployment-template")
 		skipDeployment := c.Bool("skip-deployment")
+		format, err := parseTemplateFormat(c.String("format"))
+		if err != nil {
+			return err
+		}
+		if format != templateFormatARM && !skipDeployment {
+			return fmt.Errorf("--format %s can only be used with --skip-deployment, deployments use the ARM template", format)
+		}
+		if deploymentTemplatePath == "" {
+			deploymentTemplatePath = format.defaultFilename()
+		}
 		bundlePropertiesPath := c.Path("bundle-properties")
 
-		layers, _, bundleProperties, err := validateBundle(bundlePath)
+		replications, err := parseRegionReplications(replicationRegions, int32(replicationCount))
+		if err != nil {
+			return errors.Wrap(err, "invalid --replication-regions")
+		}
+		for _, warning := range unknownRegionWarnings(templateLocation, replications) {
+			color.Yellow("Warning: %s", warning)
+		}
+
+		builderNetwork := resolveBuilderNetwork(c)
+		if builderNetwork != nil {
+			fmt.Printf("The builder VM is deployed in subnet: %s\n", builderNetwork.SubnetID)
+		}
+
+		layers, buildParameters, bundleProperties, err := validateBundle(bundlePath)
 		if err != nil {
 			return errors.Wrap(err, "bundle validation error")
 		}
 
-		// check if azure CLI is installed locally
-		if _, err := exec.LookPath("az"); err != nil {
-			return fmt.Errorf("az command not found. Install the Azure CLI and restart this terminal: https://learn.microsoft.com/en-us/cli/azure/install-azure-cli (%w)", err)
+		bundleSources, err := readBundleSources(bundlePath)
+		if err != nil {
+			color.Yellow("Warning: failed to read the layer sources of the bundle: %s", err)
 		}
 
-		// check if logged into the azure CLI
-		fmt.Printf("Checking if you are logged in to the Azure CLI...")
-		azureAccountList, err := lib.ExecuteAsParseAsJSON[[]lib.AzAccount](c.Context, "az", "account", "list", "--only-show-errors")
+		var bundleWindowsUpdate *schema.V2WindowsUpdateStage
+		if bundleSources != nil {
+			bundleWindowsUpdate = bundleSources.WindowsUpdate
+		}
+		windowsUpdate, err := resolveWindowsUpdateStage(c, bundleWindowsUpdate)
 		if err != nil {
-			return errors.Wrap(err, "Azure CLI login check failed. Make sure you are logged in. You can run 'az login' (https://learn.microsoft.com/en-us/cli/azure/authenticate-azure-cli?view=azure-cli-latest#sign-into-azure-with-azure-cli)")
+			return err
 		}
-		color.Green("[DONE]\n")
 
-		// check if subscription is in list of accounts
-		var foundSubscription *lib.AzAccount
-		for _, azureAccount := range azureAccountList {
-			if azureAccount.SubscriptionId == subscriptionId {
-				foundSubscription = to.Ptr(azureAccount)
-				break
-			}
+		backend, err := azureBackend(c)
+		if err != nil {
+			return err
 		}
-		if foundSubscription == nil {
-			return errors.New("Azure CLI is not logged in or logged into the wrong tenant. Try running 'az login'.")
-		} else {
-			fmt.Printf("Working in Subscription: %s (%s)\n", color.GreenString(foundSubscription.Name), subscriptionId)
+
+		// check if logged into Azure
+		fmt.Printf("Checking if you are logged in to Azure (%s)...", backend.Name())
+		foundSubscription, err := backend.GetSubscription(c.Context, subscriptionId)
+		if err != nil {
+			if errors.Is(err, lib_azure.ErrSubscriptionNotFound) {
+				return fmt.Errorf("subscription %s not found. You are not logged in or logged into the wrong tenant. Try running 'az login'", subscriptionId)
+			}
+			return errors.Wrap(err, "Azure login check failed")
 		}
+		color.Green("[DONE]\n")
+		fmt.Printf("Working in Subscription: %s (%s)\n", color.GreenString(foundSubscription.Name), subscriptionId)
 
 		fmt.Println()
 
 		// list image definitions in the gallery
-		imageDefinitions, err := lib.ExecuteAsParseAsJSON[[]lib.AzImageDefinition](c.Context, "az", "sig", "image-definition", "list", "-r", imageGallery, "-g", resourceGroup, "--subscription", subscriptionId)
+		imageDefinitions, err := backend.ListImageDefinitions(c.Context, subscriptionId, resourceGroup, imageGallery)
 		if err != nil {
 			return errors.Wrapf(err, "failed to list Image Definitions in the Image Gallery: %s/%s", resourceGroup, imageGallery)
 		}
@@ -234,18 +295,20 @@ var BundleAutoDeployCommand = &cli.Command{
 		fmt.Printf("Target Image Definition: ")
 		color.Green("%s/%s", imageGallery, imageDefinition)
 
-		fmt.Println()
-
-		if err := writeBundleProperties(*bundleProperties, bundlePropertiesPath); err != nil {
-			return err
-		}
+		var versioning *imageVersioning
+		if imageVersion != "" || versionStrategyName != "" {
+			existingVersions, err := backend.ListImageVersions(c.Context, subscriptionId, resourceGroup, imageGallery, imageDefinition)
+			if err != nil {
+				return errors.Wrapf(err, "failed to list the versions of Image Definition %s", imageDefinition)
+			}
 
-		fmt.Printf("Uploading bundle properties to Azure Storage Account %s/%s...\n", storageAccount, blobContainer)
-		bundlePropsBlobName := fmt.Sprintf("bundle-%s-%s.json", imageDefinition, nowFormatted)
-		if err := uploadIfNotExist(c.Context, storageAccount, blobContainer, bundlePropertiesPath, bundlePropsBlobName); err != nil {
-			return errors.Wrap(err, "failed to upload bundle properties to Azure Storage Account")
+			versioning, err = resolveImageVersioning(imageVersion, versionStrategyName, bundleSources, existingVersions, now)
+			if err != nil {
+				return errors.Wrap(err, "failed to determine the image version")
+			}
+			fmt.Printf("Image version: ")
+			color.Green("%s", versioning.description)
 		}
-		bundlePropsBlobUri := fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", storageAccount, blobContainer, bundlePropsBlobName)
 
 		fmt.Println()
 
@@ -257,46 +320,91 @@ var BundleAutoDeployCommand = &cli.Command{
 		hashHex := hex.EncodeToString(hash)
 		color.Green("[DONE] (sha256: %s)", hashHex)
 
-		fmt.Printf("Uploading bundle archive to Azure Storage Account %s/%s...\n", storageAccount, blobContainer)
+		defaultTemplateName, bundlePropsBlobName := bundleArtifactNames(format, imageDefinition, hashHex, now)
+		bundlePropsBlobUri := lib_azure.BlobURL(storageAccount, blobContainer, bundlePropsBlobName)
 		bundleArchiveBlobName := fmt.Sprintf("bundle-%s.zip", hashHex)
-		if err := uploadIfNotExist(c.Context, storageAccount, blobContainer, bundlePath, bundleArchiveBlobName); err != nil {
-			return errors.Wrap(err, "failed to upload bundle archive to Azure Storage Account")
-		}
 
 		fmt.Println("")
 
 		if templateName == "" {
-			templateName = fmt.Sprintf("%s-%s", imageDefinition, time.Now().Format("2006-01-02_15-04-05"))
+			templateName = defaultTemplateName
 			fmt.Printf("Defaulting template name to %s. Overwrite using --template-name.\n", templateName)
 		} else {
 			fmt.Printf("Using template name: %s (Note: overwriting an existing template will fail)\n", templateName)
 		}
 
+		galleryImageId := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/galleries/%s/images/%s", subscriptionId, resourceGroup, imageGallery, imageDefinition)
+
 		imageTemplate := buildImageTemplate(
 			templateName,
 			templateLocation,
 			managedIdentity,
 			int32(buildTimeout),
 			start,
-			fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", storageAccount, blobContainer, bundleArchiveBlobName),
+			lib_azure.BlobURL(storageAccount, blobContainer, bundleArchiveBlobName),
 			builderVmSize,
 			int32(builderDiskSize),
+			builderNetwork,
 			bundleProperties.BaseImage,
-			fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/galleries/%s/images/%s", subscriptionId, resourceGroup, imageGallery, imageDefinition),
-			int32(replicationCount),
+			galleryImageId,
+			versioning,
 			optimizeImage,
 			excludeFromLatest,
-			replicationRegions,
+			replications,
 			bundlePropsBlobUri,
 			layers,
+			windowsUpdate,
 		)
 
+		fmt.Printf("Checking the Image Template...")
+		if err := preflightImageTemplate(imageTemplate); err != nil {
+			color.Red("[FAILED]")
+			return err
+		}
+		color.Green("[DONE]")
+
+		fmt.Println()
+
+		if err := writeBundleProperties(*bundleProperties, bundlePropertiesPath); err != nil {
+			return err
+		}
+
+		fmt.Printf("Uploading bundle properties to Azure Storage Account %s/%s...\n", storageAccount, blobContainer)
+		if err := uploadIfNotExist(c.Context, backend, storageAccount, blobContainer, bundlePropertiesPath, bundlePropsBlobName); err != nil {
+			return errors.Wrap(err, "failed to upload bundle properties to Azure Storage Account")
+		}
+
+		fmt.Println()
+
+		fmt.Printf("Uploading bundle archive to Azure Storage Account %s/%s...\n", storageAccount, blobContainer)
+		if err := uploadIfNotExist(c.Context, backend, storageAccount, blobContainer, bundlePath, bundleArchiveBlobName); err != nil {
+			return errors.Wrap(err, "failed to upload bundle archive to Azure Storage Account")
+		}
+
 		deploymentTemplate := buildDeploymentTemplate(imageTemplate)
 		deploymentTemplateBytes, err := json.MarshalIndent(deploymentTemplate, "", "    ")
 		if err != nil {
 			return errors.Wrap(err, "failed to stringify deployment template")
 		}
 
+		locationParam, templateParams := imageTemplateParameters(templateLocation, managedIdentity, galleryImageId, builderNetwork)
+		resourceGroupParam := templateParameter{
+			terraformName: "resource_group_id",
+			description:   "Resource id of the resource group in which the Image Template is created",
+			value:         fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", subscriptionId, resourceGroup),
+		}
+
+		outputBytes := deploymentTemplateBytes
+		switch format {
+		case templateFormatBicep:
+			outputBytes, err = renderImageTemplateBicep(imageTemplate, locationParam, templateParams)
+		case templateFormatTerraform:
+			outputBytes, err = renderImageTemplateTerraform(imageTemplate, locationParam, resourceGroupParam, templateParams)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to render the Image Template as %s", format)
+		}
+
 		fmt.Println("")
 
 		fmt.Printf("Writing the Image Template deployment to %s...", deploymentTemplatePath)
@@ -307,42 +415,62 @@ var BundleAutoDeployCommand = &cli.Command{
 		}
 		defer deploymentTemplateFile.Close()
 
-		if _, err := deploymentTemplateFile.Write(deploymentTemplateBytes); err != nil {
+		if _, err := deploymentTemplateFile.Write(outputBytes); err != nil {
 			return errors.Wrap(err, "failed to write deployment template to disk")
 		}
 		color.Green("[DONE]")
 
 		fmt.Println()
 
+		buildRecord := newAutobuildRecord(bundleSources, layers, buildParameters, subscriptionId, resourceGroup, templateName, galleryImageId, hashHex)
+		if versioning != nil {
+			buildRecord.ImageVersion = versioning.version
+		}
+
 		if skipDeployment {
+			buildRecord.Status = lib.BuildStatusNotDeployed
+			lib.RecordBuild(buildRecord)
+
 			fmt.Println("You opted to skip the deployment of the Image Template")
-			fmt.Println("Once you have inspected and/or modified the template, you can deploy it using the Azure CLI")
-			fmt.Printf("az deployment group create --resource-group %s --template-file %s --subscription %s\n", resourceGroup, deploymentTemplatePath, subscriptionId)
+			switch format {
+			case templateFormatBicep:
+				fmt.Println("Once you have inspected and/or modified the template, you can deploy it using the Azure CLI")
+				fmt.Printf("az deployment group create --resource-group %s --template-file %s --subscription %s --parameters", resourceGroup, deploymentTemplatePath, subscriptionId)
+				for _, param := range append([]templateParameter{locationParam}, templateParams...) {
+					fmt.Printf(" %s=%s", param.bicepName, param.value)
+				}
+				fmt.Println()
+			case templateFormatTerraform:
+				fmt.Println("Add the template to your Terraform configuration (it requires the azure/azapi provider), and set its variables:")
+				for _, param := range append([]templateParameter{locationParam, resourceGroupParam}, templateParams...) {
+					fmt.Printf("%s = \"%s\"\n", param.terraformName, param.value)
+				}
+			default:
+				fmt.Println("Once you have inspected and/or modified the template, you can deploy it using the Azure CLI")
+				fmt.Printf("az deployment group create --resource-group %s --template-file %s --subscription %s\n", resourceGroup, deploymentTemplatePath, subscriptionId)
+			}
 		} else {
-			fmt.Println("Deploying Image Template to Azure...")
-
-			cmd := exec.CommandContext(c.Context, "az", "deployment", "group", "create",
-				"--subscription", subscriptionId,
-				"--resource-group", resourceGroup,
-				"--template-file", deploymentTemplatePath,
-				"--no-prompt", "true",
-				"--only-show-errors",
-				"-o", "none")
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-
-			if err := cmd.Run(); err != nil {
-				return errors.Wrap(err, "failed to create deployment template")
+			fmt.Printf("Deploying Image Template to Azure (%s)...\n", backend.Name())
+
+			if err := backend.DeployTemplate(c.Context, subscriptionId, resourceGroup, deploymentName(templateName), deploymentTemplateBytes); err != nil {
+				buildRecord.Status = lib.BuildStatusDeploymentFailed
+				buildRecord.Message = err.Error()
+				lib.RecordBuild(buildRecord)
+				return errors.Wrap(err, "failed to deploy the Image Template")
 			}
 
+			buildRecord.Status = lib.BuildStatusDeployed
+			lib.RecordBuild(buildRecord)
+
 			color.HiGreen("Image Template deployed successfully. You can now see the image builder in the Azure Portal: https://portal.azure.com/#view/Microsoft_Azure_WVD/WvdManagerMenuBlade/~/customImageTemplate")
+			fmt.Printf("Follow the build with: %s --watch\n", buildStatusCommand(c, templateName, subscriptionId, resourceGroup))
 		}
 
 		return nil
 	},
 }
 
-func validateBundle(bundlePath string) (layers []*avdimagetypes.V2LayerProperties, buildParameters *avdimagetypes.V2BuildParameters, bundleProperties *avdimagetypes.V2BundleProperties, err error) {
+func validateBundle(bundlePath string) (layers []bundleLayer, buildParameters *avdimagetypes.V2BuildParameters, bundleProperties *avdimagetypes.V2BundleProperties, err error) {
 	archive, err := zip.OpenReader(bundlePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -420,8 +548,15 @@ func validateBundle(bundlePath string) (layers []*avdimagetypes.V2LayerPropertie
 		}
 	}
 
-	layers = make([]*avdimagetypes.V2LayerProperties, 0, len(layerNames))
+	sortedLayerNames := make([]string, 0, len(layerNames))
 	for layerName := range layerNames {
+		sortedLayerNames = append(sortedLayerNames, layerName)
+	}
+	// the execute script runs the layer directories in alphabetical order
+	slices.Sort(sortedLayerNames)
+
+	layers = make([]bundleLayer, 0, len(layerNames))
+	for _, layerName := range sortedLayerNames {
 		layerFs, err := fs.Sub(archive, layerName)
 		if err != nil {
 			return nil, nil, nil, errors.Wrapf(err, "failed to open layer %s", layerName)
@@ -439,7 +574,17 @@ func validateBundle(bundlePath string) (layers []*avdimagetypes.V2LayerPropertie
 					return nil, nil, nil, errors.Wrapf(err, "failed to parse layer %s", layerName)
 				}
 
-				layers = append(layers, &properties)
+				var stage layerStageProperties
+				if err := json.Unmarshal(propsBytes, &stage); err != nil {
+					validationErrors = append(validationErrors, errors.Wrapf(err, "invalid restart_after or windows_update_after in layer %s", layerName))
+					continue
+				}
+
+				layers = append(layers, bundleLayer{
+					directory:  layerName,
+					properties: &properties,
+					stage:      stage,
+				})
 			}
 		}
 	}
@@ -451,7 +596,73 @@ func validateBundle(bundlePath string) (layers []*avdimagetypes.V2LayerPropertie
 	return layers, buildParameters, bundleProperties, nil
 }
 
-func selectImageDefinition(existingImageDefinitions []lib.AzImageDefinition, tenantId, subscriptionId, rgName, galleryName string) (name string, err error) {
+// newAutobuildRecord describes the build for the local build history
+func newAutobuildRecord(
+	bundleSources *schema.V2BundleSources,
+	layers []bundleLayer,
+	buildParameters *avdimagetypes.V2BuildParameters,
+	subscriptionId, resourceGroup, templateName, galleryImageId, bundleSha256 string,
+) *lib.BuildRecord {
+	record := lib.NewBuildRecord("bundle autobuild", subscriptionId, resourceGroup, templateName)
+	record.ImageDefinition = galleryImageId
+	record.BundleSha256 = bundleSha256
+
+	if bundleSources != nil {
+		for _, layer := range bundleSources.Layers {
+			record.Layers = append(record.Layers, lib.BuildRecordLayer{Name: layer.Name, Source: layer.Source})
+		}
+	} else {
+		// bundle created by an older version, only the names are known
+		for _, layer := range layers {
+			record.Layers = append(record.Layers, lib.BuildRecordLayer{Name: layer.properties.Name})
+		}
+	}
+
+	if buildParameters != nil {
+		params := make(map[string]string)
+		for layerName, layerParams := range buildParameters.Layers {
+			for paramName, param := range layerParams {
+				params[layerName+"."+paramName] = param.Value
+			}
+		}
+		record.Parameters = lib.RedactParameters(params)
+	}
+
+	return record
+}
+
+// readBundleSources reads the layer sources from the bundle
+// returns nil if the bundle does not contain them
+func readBundleSources(bundlePath string) (*schema.V2BundleSources, error) {
+	archive, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open bundle")
+	}
+	defer archive.Close()
+
+	return readBundleSourcesFromArchive(&archive.Reader)
+}
+
+// readBundleSourcesFromArchive reads the layer sources from the opened bundle
+// returns nil if the bundle does not contain them
+func readBundleSourcesFromArchive(archive *zip.Reader) (*schema.V2BundleSources, error) {
+	data, err := fs.ReadFile(archive, schema.V2BundleSourcesFilename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to read bundle sources file")
+	}
+
+	var sources schema.V2BundleSources
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return nil, errors.Wrap(err, "failed to parse bundle sources file")
+	}
+
+	return &sources, nil
+}
+
+func selectImageDefinition(existingImageDefinitions []lib_azure.ImageDefinition, tenantId, subscriptionId, rgName, galleryName string) (name string, err error) {
 	createURL := fmt.Sprintf("https://portal.azure.com/#@%s/resource/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/galleries/%s/overview", tenantId, subscriptionId, rgName, galleryName)
 
 	if len(existingImageDefinitions) == 0 {
@@ -496,36 +707,23 @@ func calcBundleShaAndSize(filepath string) ([]byte, error) {
 	return sha.Sum(nil), nil
 }
 
-func uploadIfNotExist(ctx context.Context, storageAccount, blobContainer, bundlePath, bundleFileName string) error {
-	type existsOut struct {
-		Exists bool `json:"exists"`
-	}
-	exists, err := lib.ExecuteAsParseAsJSON[*existsOut](ctx, "az", "storage", "blob", "exists",
-		"--account-name", storageAccount,
-		"--container-name", blobContainer,
-		"-n", bundleFileName,
-		"--auth-mode", "login",
-		"--only-show-errors")
+func uploadIfNotExist(ctx context.Context, backend lib_azure.Backend, storageAccount, blobContainer, bundlePath, bundleFileName string) error {
+	exists, err := backend.BlobExists(ctx, storageAccount, blobContainer, bundleFileName)
 	if err != nil {
 		return errors.Wrap(err, "failed to check if the bundle is already uploaded")
 	}
 
-	if exists.Exists {
+	if exists {
+		// the blob may have been uploaded long ago, touch it so cleanup does not remove it
+		// before the Image Template that references it is deployed
+		if err := backend.TouchBlob(ctx, storageAccount, blobContainer, bundleFileName); err != nil {
+			return errors.Wrap(err, "failed to update the last modified time of the uploaded bundle")
+		}
 		fmt.Println("Bundle is already uploaded")
 		return nil
 	}
 
-	uploadCmd := exec.CommandContext(ctx, "az", "storage", "blob", "upload",
-		"-f", bundlePath,
-		"-c", blobContainer,
-		"-n", bundleFileName,
-		"--account-name", storageAccount,
-		"--only-show-errors",
-		"--auth-mode", "login",
-		"-o", "none")
-	uploadCmd.Stderr = os.Stderr
-	uploadCmd.Stdout = os.Stdout
-	if err := uploadCmd.Run(); err != nil {
+	if err := backend.UploadBlob(ctx, storageAccount, blobContainer, bundleFileName, bundlePath); err != nil {
 		return errors.Wrap(err, "failed to upload bundle")
 	}
 
@@ -533,6 +731,30 @@ func uploadIfNotExist(ctx context.Context, storageAccount, blobContainer, bundle
 	return nil
 }
 
+// deploymentName derives the name of the ARM deployment from the template name
+// deployment names are limited to 64 characters
+// bundleArtifactNames returns the default Image Template name and the name of the bundle properties blob.
+// ARM templates are deployed right away, so their names contain the time of the build.
+// Bicep and Terraform output is meant to be committed and applied later, so its names are derived from the bundle hash
+// and rendering the same bundle twice produces the same file
+func bundleArtifactNames(format templateFormat, imageDefinition, bundleHash string, now time.Time) (templateName, bundlePropsBlobName string) {
+	if format == templateFormatBicep || format == templateFormatTerraform {
+		shortHash := bundleHash[:min(len(bundleHash), 12)]
+		return fmt.Sprintf("%s-%s", imageDefinition, shortHash), fmt.Sprintf("bundle-%s-%s.json", imageDefinition, bundleHash)
+	}
+
+	timestamp := now.Format("2006-01-02_15-04-05")
+	return fmt.Sprintf("%s-%s", imageDefinition, timestamp), fmt.Sprintf("bundle-%s-%s.json", imageDefinition, timestamp)
+}
+
+func deploymentName(templateName string) string {
+	const maxLength = 64
+	if len(templateName) > maxLength {
+		return templateName[:maxLength]
+	}
+	return templateName
+}
+
 func buildImageTemplate(
 	name string,
 	location string,
@@ -544,18 +766,20 @@ func buildImageTemplate(
 
 	builderVmSize string,
 	builderDiskSize int32,
+	builderNetwork *builderNetworkConfig,
 
 	baseImage *avdimagetypes.V2BaseImage,
 
 	targetGalleryImageId string,
-	replicateCount int32,
+	versioning *imageVersioning,
 	optimizeImage bool,
 	excludeFromLatest bool,
-	targetRegions []string,
+	targetRegions []regionReplication,
 
 	bundlePropertiesUri string,
 
-	layerProperties []*avdimagetypes.V2LayerProperties,
+	layers []bundleLayer,
+	windowsUpdate *schema.V2WindowsUpdateStage,
 ) *armvirtualmachineimagebuilder.ImageTemplate {
 	return &armvirtualmachineimagebuilder.ImageTemplate{
 		Identity: &armvirtualmachineimagebuilder.ImageTemplateIdentity{
@@ -567,7 +791,7 @@ func buildImageTemplate(
 		Location: to.Ptr(location),
 		Properties: &armvirtualmachineimagebuilder.ImageTemplateProperties{
 			Distribute: []armvirtualmachineimagebuilder.ImageTemplateDistributorClassification{
-				buildTemplateDistributor(targetGalleryImageId, targetRegions, replicateCount, excludeFromLatest, bundlePropertiesUri),
+				buildTemplateDistributor(targetGalleryImageId, versioning, targetRegions, excludeFromLatest, bundleUri, bundlePropertiesUri),
 			},
 			Source: baseImageSourceToTemplateSource(baseImage),
 			AutoRun: (func() *armvirtualmachineimagebuilder.ImageTemplateAutoRun {
@@ -580,11 +804,12 @@ func buildImageTemplate(
 				}
 			})(),
 			BuildTimeoutInMinutes: to.Ptr(buildTimeoutMinutes),
-			Customize:             buildCustomizationSteps(layerProperties, managedIdentityId, bundleUri),
+			Customize:             buildCustomizationSteps(layers, windowsUpdate, managedIdentityId, bundleUri),
 			VMProfile: &armvirtualmachineimagebuilder.ImageTemplateVMProfile{
 				OSDiskSizeGB:           to.Ptr(builderDiskSize),
 				VMSize:                 to.Ptr(builderVmSize),
 				UserAssignedIdentities: to.SliceOfPtrs(managedIdentityId),
+				VnetConfig:             builderVnetConfig(builderNetwork),
 			},
 			Optimize: &armvirtualmachineimagebuilder.ImageTemplatePropertiesOptimize{
 				VMBoot: &armvirtualmachineimagebuilder.ImageTemplatePropertiesOptimizeVMBoot{
@@ -643,7 +868,20 @@ func baseImageSourceToTemplateSource(baseImage *avdimagetypes.V2BaseImage) armvi
 	}
 }
 
-func buildTemplateDistributor(galleryImageId string, targetRegions []string, replicateCount int32, excludeFromLatest bool, bundlePropertiesUri string) *armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor {
+// the image versions are tagged with the blobs of the bundle they were built from
+const (
+	bundlePropertiesUrlTag = "SY_BUNDLE_URL"
+	bundleArchiveUrlTag    = "SY_BUNDLE_ARCHIVE_URL"
+)
+
+// buildTemplateDistributor distributes to the image definition. versioning is optional, Azure picks the version number if nil
+func buildTemplateDistributor(galleryImageId string, versioning *imageVersioning, targetRegions []regionReplication, excludeFromLatest bool, bundleUri, bundlePropertiesUri string) *armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor {
+	var versioner armvirtualmachineimagebuilder.DistributeVersionerClassification
+	if versioning != nil {
+		galleryImageId = versioning.galleryImageId(galleryImageId)
+		versioner = versioning.versioner
+	}
+
 	return &armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor{
 		GalleryImageID:    to.Ptr(galleryImageId),
 		RunOutputName:     to.Ptr("gallery"),
@@ -652,32 +890,83 @@ func buildTemplateDistributor(galleryImageId string, targetRegions []string, rep
 		TargetRegions: (func() []*armvirtualmachineimagebuilder.TargetRegion {
 			regions := make([]*armvirtualmachineimagebuilder.TargetRegion, len(targetRegions))
 			for i, region := range targetRegions {
-				regionName := region
 				regions[i] = &armvirtualmachineimagebuilder.TargetRegion{
-					Name:               to.Ptr(regionName),
-					ReplicaCount:       to.Ptr(replicateCount),
-					StorageAccountType: nil,
+					Name:               to.Ptr(region.Region),
+					ReplicaCount:       to.Ptr(region.ReplicaCount),
+					StorageAccountType: region.StorageAccountType,
 				}
 			}
 			return regions
 		})(),
-		Versioning: nil, // could be useful in the future
+		Versioning: versioner,
 		ArtifactTags: map[string]*string{
-			"SY_BUNDLE_URL": to.Ptr(bundlePropertiesUri),
+			bundlePropertiesUrlTag: to.Ptr(bundlePropertiesUri),
+			bundleArchiveUrlTag:    to.Ptr(bundleUri),
 		},
 	}
 }
 
-func buildCustomizationSteps(layerProperties []*avdimagetypes.V2LayerProperties, managedIdentityId, bundleSourceUri string) []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification {
-	const imageBundleZipFilepath = `C:\image_bundle.zip`
-	const imageBundleFilepath = `C:\image_bundle`
+// where the bundle is extracted on the builder VM
+const (
+	imageBundleZipFilepath = `C:\image_bundle.zip`
+	imageBundleFilepath    = `C:\image_bundle`
+)
+
+func buildCustomizationSteps(layers []bundleLayer, windowsUpdate *schema.V2WindowsUpdateStage, managedIdentityId, bundleSourceUri string) []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification {
+	return customizationSteps(layers, windowsUpdate, bundleExtractionCustomizer(managedIdentityId, bundleSourceUri))
+}
+
+// customizationSteps runs the bundle, after bundleExtraction has extracted it to imageBundleFilepath
+func customizationSteps(layers []bundleLayer, windowsUpdate *schema.V2WindowsUpdateStage, bundleExtraction armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification) []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification {
+	preCustomizers, postCustomizer := extractPreAndPostCustomizersFromLayers(layers)
+	executionCustomizers := bundleExecutionCustomizers(layers, imageBundleFilepath)
 
-	preCustomizers, postCustomizer := extractPreAndPostCustomizersFromLayerProperties(layerProperties)
+	customizers := make([]armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification, 0, 6+len(preCustomizers)+len(executionCustomizers)+len(postCustomizer))
 
-	customizers := make([]armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification, 0, 4+len(preCustomizers)+len(postCustomizer))
+	// Update the base image
+	if windowsUpdate != nil && windowsUpdate.Before {
+		customizers = append(customizers, windowsUpdateCustomizers(windowsUpdate, "PreBundleWindowsUpdate")...)
+	}
 
 	// Extract Bundle
+	customizers = append(customizers, bundleExtraction)
+
+	// Pre customizers
+	customizers = append(customizers, preCustomizers...)
+
+	// Our main bundle execution, ending with a windows restart
+	customizers = append(customizers, executionCustomizers...)
+
+	// Update what the bundle installed
+	if windowsUpdate != nil && windowsUpdate.After {
+		customizers = append(customizers, windowsUpdateCustomizers(windowsUpdate, "PostBundleWindowsUpdate")...)
+	}
+
+	// Post customizers
+	customizers = append(customizers, postCustomizer...)
+
+	// Remove bundle
 	customizers = append(customizers, &armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer{
+		Type:        to.Ptr("PowerShell"),
+		Name:        to.Ptr("BundleCleanup"),
+		RunAsSystem: to.Ptr(true),
+		RunElevated: to.Ptr(true),
+		Inline: to.SliceOfPtrs(
+			`Write-Host "Removing bundle directory and archive"`,
+			fmt.Sprintf(`Remove-Item -Path "%s", "%s" -Recurse`, imageBundleZipFilepath, imageBundleFilepath),
+			`Write-Host "Adjusting deprovisioning script"`,
+			// based on: https://raw.githubusercontent.com/Azure/RDS-Templates/master/CustomImageTemplateScripts/CustomImageTemplateScripts_2024-03-27/AdminSysPrep.ps1
+			`((Get-Content -path C:\\DeprovisioningScript.ps1 -Raw) -replace 'Sysprep.exe /oobe /generalize /quiet /quit','Sysprep.exe /oobe /generalize /quit /mode:vm' ) | Set-Content -Path C:\\DeprovisioningScript.ps1`,
+			`Write-Host "Ready for sysprep"`,
+		),
+	})
+
+	return customizers
+}
+
+// bundleExtractionCustomizer downloads the bundle with the managed identity of the builder VM and extracts it
+func bundleExtractionCustomizer(managedIdentityId, bundleSourceUri string) armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification {
+	return &armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer{
 		Type:        to.Ptr("PowerShell"),
 		Name:        to.Ptr("BundleExtraction"),
 		RunAsSystem: to.Ptr(true),
@@ -721,83 +1010,26 @@ func buildCustomizationSteps(layerProperties []*avdimagetypes.V2LayerProperties,
 			`Start-Sleep -Seconds 45`,
 			`Write-Host "Sleep ended."`,
 		),
-	})
-
-	// Pre customizers
-	customizers = append(customizers, preCustomizers...)
-
-	// Our main bundle execution + windows restart
-	customizers = append(customizers,
-		&armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer{
-			Type: to.Ptr("PowerShell"),
-			Inline: to.SliceOfPtrs(
-				`Write-Host "Set error action to 'stop'"`,
-				`$ErrorActionPreference = "Stop"`,
-				`Write-Host "Set progressPreference to silentlyContinue"`,
-				`$ProgressPreference = "SilentlyContinue"`,
-				`Write-Host "Entering the bundle directory"`,
-				fmt.Sprintf(`Push-Location "%s"`, imageBundleFilepath),
-				`Write-Host "Executing bundle"`,
-				fmt.Sprintf(`& "./%s" -ScanForDirectories -Force`, embeddedfiles.V2ExecuteScriptFilename),
-				`if (!$?) {Write-Error "The bundle execution failed"; exit 5}`,
-				`Write-Host Exiting the bundle directory`,
-				`Pop-Location`,
-			),
-			Name:        to.Ptr("BundleExecution"),
-			RunAsSystem: to.Ptr(true),
-			RunElevated: to.Ptr(true),
-		},
-		&armvirtualmachineimagebuilder.ImageTemplateRestartCustomizer{
-			Type: to.Ptr("WindowsRestart"),
-			Name: to.Ptr("PostBundleExecutionRestart"),
-		},
-	)
-
-	// Post customizers
-	customizers = append(customizers, postCustomizer...)
-
-	// Remove bundle
-	customizers = append(customizers, &armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer{
-		Type:        to.Ptr("PowerShell"),
-		Name:        to.Ptr("BundleCleanup"),
-		RunAsSystem: to.Ptr(true),
-		RunElevated: to.Ptr(true),
-		Inline: to.SliceOfPtrs(
-			`Write-Host "Removing bundle directory and archive"`,
-			fmt.Sprintf(`Remove-Item -Path "%s", "%s" -Recurse`, imageBundleZipFilepath, imageBundleFilepath),
-			`Write-Host "Adjusting deprovisioning script"`,
-			// based on: https://raw.githubusercontent.com/Azure/RDS-Templates/master/CustomImageTemplateScripts/CustomImageTemplateScripts_2024-03-27/AdminSysPrep.ps1
-			`((Get-Content -path C:\\DeprovisioningScript.ps1 -Raw) -replace 'Sysprep.exe /oobe /generalize /quiet /quit','Sysprep.exe /oobe /generalize /quit /mode:vm' ) | Set-Content -Path C:\\DeprovisioningScript.ps1`,
-			`Write-Host "Ready for sysprep"`,
-		),
-	})
-
-	return customizers
+	}
 }
 
-func extractPreAndPostCustomizersFromLayerProperties(layerProperties []*avdimagetypes.V2LayerProperties) ([]armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification, []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification) {
+// extractPreAndPostCustomizersFromLayers collects the pre and post customizers of all layers, in layer order
+func extractPreAndPostCustomizersFromLayers(layers []bundleLayer) ([]armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification, []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification) {
 	var (
 		pre  []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification
 		post []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification
 	)
 
-	for _, lp := range layerProperties {
-		if lp == nil || lp.Customizers == nil {
+	for _, layer := range layers {
+		if layer.properties == nil || layer.properties.Customizers == nil {
 			continue
 		}
 
-		if lp.Customizers.Pre != nil {
-			pre = make([]armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification, len(lp.Customizers.Pre))
-			for i, preCustomizer := range lp.Customizers.Pre {
-				pre[i] = lib.CustomizerToArmImageTemplateCustomizer(preCustomizer)
-			}
+		for _, preCustomizer := range layer.properties.Customizers.Pre {
+			pre = append(pre, lib.CustomizerToArmImageTemplateCustomizer(preCustomizer))
 		}
-
-		if lp.Customizers.Post != nil {
-			post = make([]armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification, len(lp.Customizers.Post))
-			for i, postCustomizer := range lp.Customizers.Post {
-				post[i] = lib.CustomizerToArmImageTemplateCustomizer(postCustomizer)
-			}
+		for _, postCustomizer := range layer.properties.Customizers.Post {
+			post = append(post, lib.CustomizerToArmImageTemplateCustomizer(postCustomizer))
 		}
 	}
 
@@ -808,7 +1040,7 @@ func buildDeploymentTemplate(imageTemplate *armvirtualmachineimagebuilder.ImageT
 	resource := lib.JSONCombinedMarshaller{
 		Objects: []any{imageTemplate, struct {
 			ApiVersion string `json:"apiVersion"`
-		}{ApiVersion: "2024-02-01"}},
+		}{ApiVersion: imageTemplateApiVersion}},
 	}
 
 	return map[string]any{
@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schoolyear/avd-cli/lib/lib_azure/azuretest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_uploadIfNotExist(t *testing.T) {
+	backend := azuretest.NewFakeBackend()
+	key := azuretest.FakeBlobKey("account", "bundles", "bundle.zip")
+
+	bundlePath := filepath.Join(t.TempDir(), "bundle.zip")
+	require.NoError(t, os.WriteFile(bundlePath, []byte("new bundle"), 0644))
+
+	require.NoError(t, uploadIfNotExist(context.Background(), backend, "account", "bundles", bundlePath, "bundle.zip"))
+	require.Equal(t, []byte("new bundle"), backend.Blobs[key])
+
+	// existing blobs are not overwritten, but touched so the cleanup grace period starts again
+	backend.Blobs[key] = []byte("existing bundle")
+	backend.BlobsLastModified[key] = time.Now().Add(-60 * 24 * time.Hour)
+	require.NoError(t, uploadIfNotExist(context.Background(), backend, "account", "bundles", bundlePath, "bundle.zip"))
+	require.Equal(t, []byte("existing bundle"), backend.Blobs[key])
+	require.WithinDuration(t, time.Now(), backend.BlobsLastModified[key], time.Minute)
+}
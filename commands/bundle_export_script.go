@@ -0,0 +1,263 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/embeddedfiles"
+	"github.com/schoolyear/avd-cli/schema"
+	"github.com/urfave/cli/v2"
+)
+
+var BundleExportScriptCommand = &cli.Command{
+	Name:  "export-script",
+	Usage: "Export the build steps of the bundle as a PowerShell script, to build an image by hand on a VM without Azure Image Builder",
+	Description: `The script runs the same steps as "bundle autobuild" lets Image Builder run, in stages that end with a restart.
+After a restart, the script continues with the next stage by itself.
+Run it as administrator on the VM, passing the SAS URL or local path of the bundle:
+
+   .\build.ps1 -BundleSource 'https://<account>.blob.core.windows.net/<container>/bundle.zip?<sas-token>'
+
+Once it completes, run C:\DeprovisioningScript.ps1 to generalize the VM and capture the image.`,
+	Flags: []cli.Flag{
+		&cli.PathFlag{
+			Name:    "bundle",
+			Value:   "bundle.zip",
+			Usage:   "Path to the bundle zip file",
+			Aliases: []string{"b"},
+		},
+		&cli.PathFlag{
+			Name:      "output",
+			Value:     "build.ps1",
+			Usage:     "Path to which the script is written",
+			TakesFile: true,
+			Aliases:   []string{"o"},
+		},
+		&cli.StringFlag{
+			Name:  "bundle-source",
+			Usage: "Default SAS URL or local path of the bundle on the VM, so -BundleSource can be left out when running the script",
+		},
+		windowsUpdateFlag,
+		windowsUpdateFilterFlag,
+		windowsUpdateSearchCriteriaFlag,
+		windowsUpdateLimitFlag,
+	},
+	Action: func(c *cli.Context) error {
+		bundlePath := c.Path("bundle")
+		outputPath := c.Path("output")
+		bundleSource := c.String("bundle-source")
+
+		layers, _, _, err := validateBundle(bundlePath)
+		if err != nil {
+			return errors.Wrap(err, "failed to validate bundle")
+		}
+
+		bundleSources, err := readBundleSources(bundlePath)
+		if err != nil {
+			color.Yellow("Warning: failed to read the layer sources of the bundle: %s", err)
+		}
+
+		var bundleWindowsUpdate *schema.V2WindowsUpdateStage
+		if bundleSources != nil {
+			bundleWindowsUpdate = bundleSources.WindowsUpdate
+		}
+		windowsUpdate, err := resolveWindowsUpdateStage(c, bundleWindowsUpdate)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Writing build script to %s...", outputPath)
+		script, err := renderExportScript(customizationSteps(layers, windowsUpdate, exportBundleExtractionCustomizer()), bundleSource)
+		if err != nil {
+			color.Red("[FAILED]")
+			return err
+		}
+		if err := os.WriteFile(outputPath, []byte(script), 0644); err != nil {
+			color.Red("[FAILED]")
+			return errors.Wrapf(err, "failed to write %s", outputPath)
+		}
+		color.Green("[DONE]")
+
+		return nil
+	},
+}
+
+// exportBundleExtractionCustomizer copies or downloads the bundle from the source passed to the script.
+// the script passes the source to the steps in AVDCLI_BUNDLE_SOURCE
+func exportBundleExtractionCustomizer() armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification {
+	return &armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer{
+		Type:        to.Ptr("PowerShell"),
+		Name:        to.Ptr("BundleExtraction"),
+		RunAsSystem: to.Ptr(true),
+		RunElevated: to.Ptr(true),
+		Inline: to.SliceOfPtrs(
+			`$ErrorActionPreference = "Stop"`,
+			`$ProgressPreference = "SilentlyContinue"`,
+			`$bundleSource = $env:AVDCLI_BUNDLE_SOURCE`,
+			`if ($bundleSource -match '^https?://') {`,
+			fmt.Sprintf(`	Write-Host "Downloading bundle to %s"`, imageBundleZipFilepath),
+			fmt.Sprintf(`	Invoke-WebRequest -Uri $bundleSource -OutFile '%s'`, imageBundleZipFilepath),
+			`} else {`,
+			fmt.Sprintf(`	Write-Host "Copying bundle from $bundleSource to %s"`, imageBundleZipFilepath),
+			fmt.Sprintf(`	Copy-Item -LiteralPath $bundleSource -Destination '%s'`, imageBundleZipFilepath),
+			`}`,
+			`Write-Host "Extracting bundle archive"`,
+			fmt.Sprintf(`Expand-Archive -LiteralPath '%s' -DestinationPath '%s' -Force`, imageBundleZipFilepath, imageBundleFilepath),
+		),
+	}
+}
+
+// exportStage is a group of steps that ends with a restart, except for the last stage
+type exportStage struct {
+	steps   []string
+	restart string // name of the restart customizer that ends the stage
+}
+
+// renderExportScript converts the customizers to the stages of the export script
+func renderExportScript(customizers []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification, bundleSource string) (string, error) {
+	stages, err := exportScriptStages(customizers)
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	builder.WriteString("$Stages = @(\n")
+	for i, stage := range stages {
+		builder.WriteString("    @{\n")
+		fmt.Fprintf(&builder, "        Restart = %s\n", powershellQuote(stage.restart))
+		builder.WriteString("        Steps = {\n")
+		for _, step := range stage.steps {
+			builder.WriteString(indentLines(step, "            "))
+		}
+		builder.WriteString("        }\n")
+		if i < len(stages)-1 {
+			builder.WriteString("    },\n")
+		} else {
+			builder.WriteString("    }\n")
+		}
+	}
+	builder.WriteString(")")
+
+	script := strings.Replace(embeddedfiles.V2ExportScript, embeddedfiles.V2ExportScriptStagesPlaceholder, builder.String(), 1)
+	script = strings.Replace(script, embeddedfiles.V2ExportScriptBundleSourcePlaceholder, powershellQuote(bundleSource), 1)
+	return script, nil
+}
+
+func exportScriptStages(customizers []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification) ([]exportStage, error) {
+	stages := []exportStage{{}}
+	for _, customizer := range customizers {
+		current := &stages[len(stages)-1]
+		name := deref(customizer.GetImageTemplateCustomizer().Name)
+
+		switch customizer := customizer.(type) {
+		case *armvirtualmachineimagebuilder.ImageTemplateRestartCustomizer:
+			current.restart = name
+			stages = append(stages, exportStage{})
+		case *armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer:
+			step, err := exportPowerShellStep(name, customizer)
+			if err != nil {
+				return nil, err
+			}
+			current.steps = append(current.steps, step)
+		case *armvirtualmachineimagebuilder.ImageTemplateFileCustomizer:
+			step := fmt.Sprintf("Invoke-BuildDownload -Name %s -Uri %s -Destination %s",
+				powershellQuote(name), powershellQuote(deref(customizer.SourceURI)), powershellQuote(deref(customizer.Destination)))
+			if sha256 := deref(customizer.SHA256Checksum); sha256 != "" {
+				step += " -Sha256 " + powershellQuote(sha256)
+			}
+			current.steps = append(current.steps, step)
+		case *armvirtualmachineimagebuilder.ImageTemplateWindowsUpdateCustomizer:
+			step := "Install-BuildWindowsUpdates -Name " + powershellQuote(name)
+			if searchCriteria := deref(customizer.SearchCriteria); searchCriteria != "" {
+				step += " -SearchCriteria " + powershellQuote(searchCriteria)
+			}
+			if len(customizer.Filters) > 0 {
+				filters := make([]string, len(customizer.Filters))
+				for i, filter := range customizer.Filters {
+					filters[i] = powershellQuote(deref(filter))
+				}
+				step += " -Filters @(" + strings.Join(filters, ", ") + ")"
+			}
+			if updateLimit := deref(customizer.UpdateLimit); updateLimit > 0 {
+				step += " -UpdateLimit " + strconv.Itoa(int(updateLimit))
+			}
+			current.steps = append(current.steps, step)
+		default:
+			return nil, fmt.Errorf("customizer %s of type %s can't be exported", name, deref(customizer.GetImageTemplateCustomizer().Type))
+		}
+	}
+
+	// a restart at the end needs no stage after it
+	if last := stages[len(stages)-1]; len(last.steps) == 0 && len(stages) > 1 {
+		stages = stages[:len(stages)-1]
+	}
+	return stages, nil
+}
+
+func exportPowerShellStep(name string, customizer *armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer) (string, error) {
+	step := "Invoke-BuildPowerShell -Name " + powershellQuote(name)
+
+	if scriptUri := deref(customizer.ScriptURI); scriptUri != "" {
+		step += " -ScriptUri " + powershellQuote(scriptUri)
+		if sha256 := deref(customizer.SHA256Checksum); sha256 != "" {
+			step += " -Sha256 " + powershellQuote(sha256)
+		}
+	}
+
+	if len(customizer.ValidExitCodes) > 0 {
+		exitCodes := make([]string, len(customizer.ValidExitCodes))
+		for i, code := range customizer.ValidExitCodes {
+			exitCodes[i] = strconv.Itoa(int(deref(code)))
+		}
+		step += " -ValidExitCodes @(" + strings.Join(exitCodes, ", ") + ")"
+	}
+
+	if len(customizer.Inline) > 0 {
+		lines := make([]string, len(customizer.Inline))
+		for i, line := range customizer.Inline {
+			lines[i] = deref(line)
+			// a here-string ends at a line that starts with '@
+			if strings.HasPrefix(lines[i], "'@") {
+				return "", fmt.Errorf("line %d of customizer %s starts with '@, which can't be exported", i+1, name)
+			}
+		}
+		step += " -Script @'\n" + strings.Join(lines, "\n") + "\n'@"
+	}
+
+	return step, nil
+}
+
+// powershellQuote quotes the value as a literal PowerShell string
+func powershellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// indentLines indents all lines except the content of here-strings, which has to be kept as is
+func indentLines(value, indent string) string {
+	var (
+		builder      strings.Builder
+		inHereString bool
+	)
+	for _, line := range strings.Split(value, "\n") {
+		if inHereString {
+			builder.WriteString(line)
+		} else {
+			builder.WriteString(indent + line)
+		}
+		builder.WriteString("\n")
+
+		switch {
+		case !inHereString && strings.HasSuffix(line, "@'"):
+			inHereString = true
+		case inHereString && strings.HasPrefix(line, "'@"):
+			inHereString = false
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/schoolyear/avd-cli/schema"
+	avdimagetypes "github.com/schoolyear/avd-image-types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_exportScriptStages(t *testing.T) {
+	layers := []bundleLayer{{
+		directory: "001-browser",
+		properties: &avdimagetypes.V2LayerProperties{
+			Name: "browser",
+			Customizers: &avdimagetypes.V2Customizers{
+				Pre: []avdimagetypes.V2Customizer{{
+					File: &avdimagetypes.V2FileCustomizer{
+						Type:           "File",
+						Name:           "DownloadInstaller",
+						Destination:    `C:\installers\browser.msi`,
+						SourceURI:      "https://example.com/browser.msi",
+						Sha256Checksum: "ABC123",
+					},
+				}},
+			},
+		},
+	}}
+
+	customizers := customizationSteps(layers, &schema.V2WindowsUpdateStage{After: true, UpdateLimit: 50}, exportBundleExtractionCustomizer())
+	stages, err := exportScriptStages(customizers)
+	require.NoError(t, err)
+
+	require.Len(t, stages, 3)
+	require.Equal(t, "PostBundleExecutionRestart", stages[0].restart)
+	require.Equal(t, "PostBundleWindowsUpdateRestart", stages[1].restart)
+	require.Empty(t, stages[2].restart)
+
+	require.Len(t, stages[0].steps, 3)
+	require.True(t, strings.HasPrefix(stages[0].steps[0], "Invoke-BuildPowerShell -Name 'BundleExtraction' -Script @'\n"))
+	require.Equal(t, `Invoke-BuildDownload -Name 'DownloadInstaller' -Uri 'https://example.com/browser.msi' -Destination 'C:\installers\browser.msi' -Sha256 'ABC123'`, stages[0].steps[1])
+	require.Contains(t, stages[0].steps[2], `& "./execute.ps1" -ScanForDirectories -Force`)
+	require.Equal(t, []string{"Install-BuildWindowsUpdates -Name 'PostBundleWindowsUpdate' -UpdateLimit 50"}, stages[1].steps)
+	require.Len(t, stages[2].steps, 1)
+	require.Contains(t, stages[2].steps[0], "DeprovisioningScript.ps1")
+}
+
+func Test_renderExportScript(t *testing.T) {
+	customizers := []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification{
+		&armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer{
+			Type:           to.Ptr("PowerShell"),
+			Name:           to.Ptr("Install"),
+			Inline:         to.SliceOfPtrs(`Write-Host "it's installing"`, `if ($true) {`, `	exit 3010`, `}`),
+			ValidExitCodes: []*int32{to.Ptr(int32(0)), to.Ptr(int32(3010))},
+		},
+		&armvirtualmachineimagebuilder.ImageTemplateRestartCustomizer{Type: to.Ptr("WindowsRestart"), Name: to.Ptr("Restart")},
+		&armvirtualmachineimagebuilder.ImageTemplateWindowsUpdateCustomizer{
+			Type:    to.Ptr("WindowsUpdate"),
+			Name:    to.Ptr("Updates"),
+			Filters: to.SliceOfPtrs("exclude:$_.Title -like '*Preview*'"),
+		},
+	}
+
+	script, err := renderExportScript(customizers, "D:\\bundle's.zip")
+	require.NoError(t, err)
+	require.Contains(t, script, `[string]$BundleSource = 'D:\bundle''s.zip',`)
+	require.NotContains(t, script, "## STAGES ##")
+	// here-string content is not indented, the closing '@ must be at the start of the line
+	require.Contains(t, script, "            Invoke-BuildPowerShell -Name 'Install' -ValidExitCodes @(0, 3010) -Script @'\nWrite-Host \"it's installing\"\nif ($true) {\n\texit 3010\n}\n'@\n")
+	require.Contains(t, script, "        Restart = 'Restart'\n")
+	require.Contains(t, script, `Install-BuildWindowsUpdates -Name 'Updates' -Filters @('exclude:$_.Title -like ''*Preview*''')`)
+
+	_, err = renderExportScript([]armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification{
+		&armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer{
+			Type:   to.Ptr("PowerShell"),
+			Name:   to.Ptr("HereString"),
+			Inline: to.SliceOfPtrs(`$value = @'`, `text`, `'@`),
+		},
+	}, "")
+	require.ErrorContains(t, err, "can't be exported")
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"os"
@@ -14,7 +15,6 @@ import (
 	"slices"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
@@ -39,7 +39,7 @@ var BundleLayersCommand = &cli.Command{
 	Flags: []cli.Flag{
 		&cli.StringSliceFlag{
 			Name:      "layer",
-			Usage:     "Paths to local image layers or download layers on-demand using @community:name[@versiontag]",
+			Usage:     "Paths to local image layers or download layers on-demand using @community:name[@ref]. The ref can be a git ref or a version constraint (e.g. ^1.4, ~2.0.1 or =1.4.2) that is resolved against the name/vX.Y.Z tags of the layer",
 			Required:  true,
 			TakesFile: true,
 			Aliases:   []string{"l"},
@@ -75,11 +75,11 @@ var BundleLayersCommand = &cli.Command{
 		},
 		&cli.BoolFlag{
 			Name:  "ignore-keyring",
-			Usage: "Set if you want to ignore any existing tokens in your local keyring and force reauthentication",
+			Usage: "Set if you want to ignore any existing tokens in your credential store and force reauthentication",
 		},
 		&cli.BoolFlag{
 			Name:  "no-keyring-cache",
-			Usage: "Set if you do not want to store tokens in your local keyring for later use",
+			Usage: "Set if you do not want to store tokens in your credential store for later use",
 		},
 		&cli.StringFlag{
 			Name:  "layer-base-image",
@@ -97,6 +97,26 @@ var BundleLayersCommand = &cli.Command{
 				return nil
 			},
 		},
+		&cli.BoolFlag{
+			Name:  "require-signed",
+			Usage: "Fail if any layer is unsigned or signed by a key that is not trusted",
+		},
+		&cli.StringSliceFlag{
+			Name:  "trusted-key",
+			Usage: "Public key of which layer signatures are trusted. Adds to the trusted_layer_keys of your ~/.avdcli/config",
+		},
+		&cli.StringFlag{
+			Name:  "bundle-version",
+			Usage: "Version of the bundle (major[.minor[.patch]]). Stored in the bundle, so \"bundle autobuild --version-strategy major-from-bundle\" can number the image versions after it",
+			Action: func(c *cli.Context, s string) error {
+				_, err := bundleMajorVersion(s)
+				return err
+			},
+		},
+		windowsUpdateFlag,
+		windowsUpdateFilterFlag,
+		windowsUpdateSearchCriteriaFlag,
+		windowsUpdateLimitFlag,
 	},
 	Action: func(c *cli.Context) error {
 		layerPaths := c.StringSlice("layer")
@@ -109,28 +129,43 @@ var BundleLayersCommand = &cli.Command{
 		noKeyringCache := c.Bool("no-keyring-cache")
 		layerBaseImage := c.String("layer-base-image")
 		baseLayerShortname := c.String("base-layer")
+		requireSigned := c.Bool("require-signed")
+		trustedKeyFlags := c.StringSlice("trusted-key")
+		bundleVersion := c.String("bundle-version")
+
+		windowsUpdate, err := resolveWindowsUpdateStage(c, nil)
+		if err != nil {
+			return err
+		}
 
 		baseLayer := v2_default_layers.BaseLayers[baseLayerShortname]
 
 		// resolve ~ for community cache folder
-		if strings.HasPrefix(communityCachePath, "~") {
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				return errors.Wrap(err, "failed to get home directory")
-			}
-			communityCachePath = filepath.Join(homeDir, communityCachePath[1:])
+		communityCachePath, err = lib.ExpandHomeDir(communityCachePath)
+		if err != nil {
+			return err
 		}
 
-		client := resty.New().
-			SetTimeout(10 * time.Second).
-			SetRetryCount(2).
-			SetRetryWaitTime(1 * time.Second)
+		trustedKeys, err := loadTrustedLayerKeys(trustedKeyFlags)
+		if err != nil {
+			return errors.Wrap(err, "failed to load trusted layer keys")
+		}
 
-		parsedLayerPaths, hasCommunityLayers := parseLayerPaths(layerPaths)
+		client := newGithubRestyClient()
+
+		parsedLayerPaths, hasCommunityLayers, err := parseLayerPaths(layerPaths)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse layer paths")
+		}
 
 		var githubToken string
 		if hasCommunityLayers {
-			token, err := lib_github.GithubDeviceFlow(client, static.GithubAppClientId, !ignoreKeyring, !noKeyringCache, "To download community layers, you must authenticate with GitHub")
+			store, err := credentialStore(c)
+			if err != nil {
+				return err
+			}
+
+			token, err := lib_github.GithubDeviceFlow(client, store, static.GithubAppClientId, !ignoreKeyring, !noKeyringCache, "To download community layers, you must authenticate with GitHub")
 			if err != nil {
 				return errors.Wrap(err, "failed to authenticate with GitHub")
 			}
@@ -149,6 +184,11 @@ var BundleLayersCommand = &cli.Command{
 			return errors.Wrap(err, "failed to load and validate layers")
 		}
 
+		fmt.Println()
+		if err := verifyLayerSignatures(layers, trustedKeys, requireSigned); err != nil {
+			return errors.Wrap(err, "failed to verify layer signatures")
+		}
+
 		layerProperties := make([]avdimagetypes.V2LayerProperties, len(layers))
 		for i, layer := range layers {
 			layerProperties[i] = *layer.properties
@@ -210,7 +250,7 @@ var BundleLayersCommand = &cli.Command{
 		}
 
 		fmt.Println("")
-		if err := createBundleFile(layers, buildParameters, bundle, bundleOutput); err != nil {
+		if err := createBundleFile(layers, buildParameters, bundle, bundleVersion, windowsUpdate, bundleOutput); err != nil {
 			return errors.Wrap(err, "failed to copy layers into the bundle file")
 		}
 
@@ -245,11 +285,12 @@ type parsedLayerPath struct {
 }
 
 type communityLayerPath struct {
-	name string
-	ref  string
+	name       string
+	ref        string
+	constraint *lib.SemverConstraint // nil if ref is a literal git ref
 }
 
-func parseLayerPaths(layerPaths []string) (layers []parsedLayerPath, hasCommunityLayers bool) {
+func parseLayerPaths(layerPaths []string) (layers []parsedLayerPath, hasCommunityLayers bool, err error) {
 	layers = make([]parsedLayerPath, len(layerPaths))
 	for i, layerPath := range layerPaths {
 		layerPath = filepath.Clean(layerPath)
@@ -265,9 +306,18 @@ func parseLayerPaths(layerPaths []string) (layers []parsedLayerPath, hasCommunit
 				version = "main"
 			}
 
+			var constraint *lib.SemverConstraint
+			if lib.IsSemverConstraint(version) {
+				constraint, err = lib.ParseSemverConstraint(version)
+				if err != nil {
+					return nil, false, errors.Wrapf(err, "invalid version constraint for layer %s", layerPath)
+				}
+			}
+
 			layer.community = &communityLayerPath{
-				name: name,
-				ref:  version,
+				name:       name,
+				ref:        version,
+				constraint: constraint,
 			}
 			hasCommunityLayers = true
 		}
@@ -275,7 +325,7 @@ func parseLayerPaths(layerPaths []string) (layers []parsedLayerPath, hasCommunit
 		layers[i] = layer
 	}
 
-	return layers, hasCommunityLayers
+	return layers, hasCommunityLayers, nil
 }
 
 func resolveLayersToBundle(
@@ -291,6 +341,8 @@ func resolveLayersToBundle(
 		originalPathName: "default layer (built-in)",
 		path:             baseLayer.Path,
 		fs:               baseLayer.FS,
+		builtIn:          true,
+		source:           "built-in (avdcli " + static.Version + ")",
 	})
 
 	fmt.Println("Resolving layers to bundle:")
@@ -306,13 +358,26 @@ func resolveLayersToBundle(
 				originalPathName: layerPath.originalValue,
 				path:             filepath.Base(layerPath.originalValue),
 				fs:               dirFS,
+				source:           "local:" + layerPath.originalValue,
 			}
 
 			fmt.Println("LOCAL")
 		} else {
-			fmt.Printf("scanning repository...")
+			communityLayer := *layerPath.community
+			// resolving the version ends the line, the scan continues indented on the next one
+			indent := ""
+			if communityLayer.constraint != nil {
+				ref, err := resolveCommunityLayerVersion(client, communityLayer, githubToken)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to resolve the version of layer %s", layerPath.originalValue)
+				}
+				communityLayer.ref = ref
+				indent = "        "
+			}
+
+			fmt.Printf("%sscanning repository...", indent)
 
-			localPath, err := downloadLayerFromGithub(client, *layerPath.community, communityCachePath, githubToken)
+			localPath, err := downloadLayerFromGithub(client, communityLayer, communityCachePath, githubToken)
 			if err != nil {
 				return nil, errors.Wrapf(err, "failed to download layer %s from the community repository", layerPath.originalValue)
 			}
@@ -323,6 +388,7 @@ func resolveLayersToBundle(
 				originalPathName: layerPath.originalValue,
 				path:             filepath.Base(localPath),
 				fs:               dirFS,
+				source:           fmt.Sprintf("community:%s@%s (tree %s)", communityLayer.name, communityLayer.ref, filepath.Base(localPath)),
 			}
 
 			fmt.Println()
@@ -334,6 +400,36 @@ func resolveLayersToBundle(
 	return layersToBundle, nil
 }
 
+// resolveCommunityLayerVersion finds the highest name/vX.Y.Z tag that satisfies the version constraint of the layer
+// returns the tag name, which can be used as a git ref
+func resolveCommunityLayerVersion(client *resty.Client, layer communityLayerPath, githubToken string) (ref string, err error) {
+	fmt.Printf("resolving version %s...", layer.constraint)
+
+	tagPrefix := layer.name + "/v"
+	tags, err := lib_github.GithubListMatchingTags(client, githubToken, static.GithubImageCommunityOwner, static.GithubImageCommunityRepo, tagPrefix)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list the tags of the community repository")
+	}
+
+	versions := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		// refs/tags/name/v1.4.2 -> v1.4.2
+		versions = append(versions, strings.TrimPrefix(tag.Ref, "refs/tags/"+layer.name+"/"))
+	}
+
+	selected, newerMajor, found := layer.constraint.Resolve(versions)
+	if !found {
+		return "", errors.Errorf("no version of %s satisfies %s (found %d version tag(s))", layer.name, layer.constraint, len(versions))
+	}
+
+	color.Green("%s", selected)
+	if newerMajor != "" {
+		color.Yellow("        Warning: a newer major version of %s is available: %s (selected %s)", layer.name, newerMajor, selected)
+	}
+
+	return layer.name + "/" + selected, nil
+}
+
 func downloadLayerFromGithub(client *resty.Client, layer communityLayerPath, cachePath, githubToken string) (path string, err error) {
 	// find layer tree sha
 	items, err := lib_github.GithubListContents(client, githubToken, static.GithubImageCommunityOwner, static.GithubImageCommunityRepo, static.GithubImageCommunityLayerPath, &layer.ref)
@@ -399,9 +495,24 @@ func downloadLayerFromGithub(client *resty.Client, layer communityLayerPath, cac
 	progress.Describe(description)
 
 	layerCachePath := filepath.Join(cachePath, treeSha)
+	if err := os.MkdirAll(cachePath, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create community cache directory %s", cachePath)
+	}
+
+	// the lock and temporary downloads live next to the tree directory, so they never end up in the bundle
+	cacheLock, err := lib.LockFile(layerCachePath+".lock", func() {
+		fmt.Printf("        Waiting for another avdcli process that is using the cache of %s...\n", layer.name)
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to lock community cache")
+	}
+	defer cacheLock.Unlock()
+
+	removeStaleDownloads(cachePath, treeSha)
+
 	cacheHitCount := 0
 	for _, file := range filesToDownload {
-		cacheHit, err := downloadGithubFile(client, layerCachePath, file, progress)
+		cacheHit, err := downloadGithubFile(client, cachePath, treeSha, file, progress)
 		if err != nil {
 			return "", errors.Wrapf(err, "failed to download %s from GitHub", file.url)
 		}
@@ -425,41 +536,32 @@ type fileToDownload struct {
 	url  string
 }
 
-func downloadGithubFile(client *resty.Client, layerCachePath string, file fileToDownload, bar *progressbar.ProgressBar) (cacheHit bool, err error) {
-	targetPath := filepath.Join(layerCachePath, file.path)
+// downloadGithubFile makes sure the file is in the cached tree directory and matches the git blob sha.
+// must be called while holding the lock of the tree directory.
+// files are downloaded to a temporary file first, verified and then moved into place,
+// so an interrupted download never leaves a corrupt file in the cache
+func downloadGithubFile(client *resty.Client, cachePath, treeSha string, file fileToDownload, bar *progressbar.ProgressBar) (cacheHit bool, err error) {
+	targetPath := filepath.Join(cachePath, treeSha, file.path)
 	targetDir := filepath.Dir(targetPath)
 
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return false, errors.Wrapf(err, "failed to create directory %s", targetDir)
-	}
-
-	f, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, file.mode)
+	existingSha, err := gitBlobShaOfFile(targetPath)
 	if err != nil {
-		return false, errors.Wrapf(err, "failed to create file %s", targetPath)
+		return false, err
 	}
-	defer f.Close()
-
-	hash := sha1.New()
-
-	// the Git SHA hash includes a header
-	hash.Write([]byte("blob "))
-	hash.Write([]byte(strconv.Itoa(int(file.size))))
-	hash.Write([]byte{0})
-
-	if _, err := io.Copy(hash, f); err != nil {
-		return false, errors.Wrapf(err, "failed to calculate sha of file %s", targetPath)
-	}
-	existingSha := hash.Sum(nil)
-	existingShaHex := hex.EncodeToString(existingSha)
-	if existingShaHex == file.sha {
+	if existingSha == file.sha {
 		return true, nil
 	}
-	if err := f.Truncate(0); err != nil {
-		return false, errors.Wrap(err, "failed to truncate cached but outdated file")
+
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return false, errors.Wrapf(err, "failed to create directory %s", targetDir)
 	}
-	if _, err := f.Seek(0, 0); err != nil {
-		return false, errors.Wrap(err, "failed to seek back to start of outdated file")
+
+	tmpFile, err := os.CreateTemp(cachePath, treeSha+".*.download")
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create temporary download file")
 	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
 
 	res, err := client.R().
 		SetDoNotParseResponse(true).
@@ -472,20 +574,91 @@ func downloadGithubFile(client *resty.Client, layerCachePath string, file fileTo
 	defer body.Close()
 
 	if res.StatusCode() != 200 {
-		return false, errors.Wrapf(err, "failed to download (%d): %s", res.StatusCode(), res.String())
+		return false, errors.Errorf("failed to download (%d): %s", res.StatusCode(), res.String())
 	}
 
-	if _, err := io.Copy(f, io.TeeReader(body, bar)); err != nil {
+	blobHash := newGitBlobHash(file.size)
+	written, err := io.Copy(io.MultiWriter(tmpFile, blobHash), io.TeeReader(body, bar))
+	if err != nil {
 		return false, errors.Wrap(err, "download failed")
 	}
 
+	if written != file.size {
+		return false, errors.Errorf("downloaded %d bytes of %s, expected %d", written, file.path, file.size)
+	}
+	if downloadedSha := hex.EncodeToString(blobHash.Sum(nil)); downloadedSha != file.sha {
+		return false, errors.Errorf("downloaded %s has sha %s, expected %s", file.path, downloadedSha, file.sha)
+	}
+
+	if err := tmpFile.Chmod(file.mode); err != nil {
+		return false, errors.Wrap(err, "failed to set file mode of download")
+	}
+	if err := tmpFile.Close(); err != nil {
+		return false, errors.Wrap(err, "failed to write download")
+	}
+
+	if err := os.Rename(tmpFile.Name(), targetPath); err != nil {
+		return false, errors.Wrapf(err, "failed to move download into cache %s", targetPath)
+	}
+
 	return false, nil
 }
 
+// newGitBlobHash returns the hash that git uses for blobs of the given size
+func newGitBlobHash(size int64) hash.Hash {
+	h := sha1.New()
+
+	// the Git SHA hash includes a header
+	h.Write([]byte("blob "))
+	h.Write([]byte(strconv.FormatInt(size, 10)))
+	h.Write([]byte{0})
+
+	return h
+}
+
+// gitBlobShaOfFile returns the git blob sha of the file
+// returns an empty string if the file does not exist
+func gitBlobShaOfFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "failed to open cached file %s", path)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to stat cached file %s", path)
+	}
+	if !info.Mode().IsRegular() {
+		return "", errors.Errorf("cached path %s is not a file", path)
+	}
+
+	h := newGitBlobHash(info.Size())
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "failed to calculate sha of file %s", path)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// removeStaleDownloads removes temporary downloads that were left behind by an interrupted process
+// must be called while holding the lock of the tree directory
+func removeStaleDownloads(cachePath, treeSha string) {
+	staleDownloads, _ := filepath.Glob(filepath.Join(cachePath, treeSha+".*.download"))
+	for _, staleDownload := range staleDownloads {
+		_ = os.Remove(staleDownload)
+	}
+}
+
 type layerToBundle struct {
 	originalPathName string // the original string used to reference this layer. may not be an actual path
 	path             string
 	fs               fs.FS
+	builtIn          bool   // embedded in the CLI, so it is trusted without a signature
+	source           string // where the layer came from, recorded in the bundle
 }
 
 type validatedLayer struct {
@@ -557,6 +730,84 @@ func validateLayers(layersToBundle []layerToBundle) ([]validatedLayer, error) {
 	return layers, nil
 }
 
+// loadTrustedLayerKeys combines the trusted keys from the user config with the ones passed as flags
+func loadTrustedLayerKeys(flagKeys []string) ([]*lib.LayerPublicKey, error) {
+	userConfig, err := lib.LoadUserConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	encodedKeys := append(slices.Clone(userConfig.TrustedLayerKeys), flagKeys...)
+	keys := make([]*lib.LayerPublicKey, len(encodedKeys))
+	for i, encodedKey := range encodedKeys {
+		key, err := lib.ParseLayerPublicKey(encodedKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid trusted key %s", encodedKey)
+		}
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+// verifyLayerSignatures checks the signature of every layer against the trusted keys
+// layers with an invalid signature always fail. unsigned or untrusted layers only fail if requireSigned is set
+func verifyLayerSignatures(layers []validatedLayer, trustedKeys []*lib.LayerPublicKey, requireSigned bool) error {
+	fmt.Printf("Verifying layer signatures (%d trusted key(s)):\n", len(trustedKeys))
+
+	allVerified := true
+	for i, layer := range layers {
+		fmt.Printf("    - Layer %d: %-60s ", i+1, layer.properties.Name+":")
+
+		if layer.builtIn {
+			color.Green("[Built-in]")
+			continue
+		}
+
+		signature, err := fs.ReadFile(layer.fs, path.Join(layer.path, schema.V2LayerSignatureFilename))
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return errors.Wrapf(err, "failed to read the signature of layer %s", layer.properties.Name)
+			}
+
+			if requireSigned {
+				allVerified = false
+				color.HiRed("[Unsigned]")
+			} else {
+				color.Yellow("[Unsigned]")
+			}
+			continue
+		}
+
+		manifest, err := lib.BuildLayerManifest(layer.fs, layer.path, schema.V2LayerSignatureFilename)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create the manifest of layer %s", layer.properties.Name)
+		}
+
+		verified, err := lib.VerifyLayerSignature(manifest, signature, trustedKeys)
+		switch {
+		case err == nil:
+			color.Green("[Signed]: key %s (%s)", verified.KeyId, verified.TrustedComment)
+		case errors.Is(err, lib.ErrLayerSignatureUntrusted):
+			if requireSigned {
+				allVerified = false
+				color.HiRed("[Untrusted]: %s", err)
+			} else {
+				color.Yellow("[Untrusted]: %s", err)
+			}
+		default:
+			allVerified = false
+			color.HiRed("[Invalid]: %s", err)
+		}
+	}
+
+	if !allVerified {
+		return errors.New("all layers must have a valid signature of a trusted key")
+	}
+
+	return nil
+}
+
 func validateLayer(layer layerToBundle) (*validatedLayer, error) {
 	// check if dir exists
 	fileInfo, err := fs.Stat(layer.fs, layer.path)
@@ -805,7 +1056,7 @@ func baseImageToString(image *avdimagetypes.V2BaseImage) string {
 	}
 }
 
-func createBundleFile(layers []validatedLayer, buildParams avdimagetypes.V2BuildParameters, bundleProperties avdimagetypes.V2BundleProperties, targetPath string) error {
+func createBundleFile(layers []validatedLayer, buildParams avdimagetypes.V2BuildParameters, bundleProperties avdimagetypes.V2BundleProperties, bundleVersion string, windowsUpdate *schema.V2WindowsUpdateStage, targetPath string) error {
 	fmt.Println("Creating the bundle file:")
 
 	bundleFile, err := os.Create(targetPath)
@@ -857,8 +1108,31 @@ func createBundleFile(layers []validatedLayer, buildParams avdimagetypes.V2Build
 	}
 	fmt.Printf("[DONE]\n")
 
+	fmt.Printf("    - Adding %s...", schema.V2BundleSourcesFilename)
+	bundleSources := schema.V2BundleSources{Version: bundleVersion, WindowsUpdate: windowsUpdate}
+	for i, layer := range layers {
+		bundleSources.Layers = append(bundleSources.Layers, schema.V2BundleLayerSource{
+			Directory: bundleLayerDirectory(i, layer),
+			Name:      layer.properties.Name,
+			Source:    layer.source,
+		})
+	}
+	bundleSourcesData, err := json.MarshalIndent(bundleSources, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal bundle sources to JSON")
+	}
+
+	bundleSourcesFile, err := zipWriter.Create(schema.V2BundleSourcesFilename)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the bundle sources file in the bundle")
+	}
+	if _, err := bundleSourcesFile.Write(bundleSourcesData); err != nil {
+		return errors.Wrap(err, "failed to write the bundle sources file to the bundle")
+	}
+	fmt.Printf("[DONE]\n")
+
 	for i, layer := range layers {
-		layerName := fmt.Sprintf("%03d-%s", i+1, layers[i].properties.Name)
+		layerName := bundleLayerDirectory(i, layer)
 
 		fmt.Printf("    - Copying layer %s...", layerName)
 		if err := copyLayerToBundle(zipWriter, layerName, layer.fs, layer.path); err != nil {
@@ -872,6 +1146,11 @@ func createBundleFile(layers []validatedLayer, buildParams avdimagetypes.V2Build
 	return nil
 }
 
+// bundleLayerDirectory is the name of the directory of the layer in the bundle, which determines the execution order
+func bundleLayerDirectory(idx int, layer validatedLayer) string {
+	return fmt.Sprintf("%03d-%s", idx+1, layer.properties.Name)
+}
+
 func copyLayerToBundle(zipFile *zip.Writer, layerName string, sourceFS fs.FS, sourcePath string) error {
 	source, err := fs.Sub(sourceFS, sourcePath)
 	if err != nil {
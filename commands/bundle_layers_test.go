@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/go-resty/resty/v2"
+	"github.com/schollz/progressbar/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_downloadGithubFile(t *testing.T) {
+	const content = "Write-Host 'hello'\n"
+
+	blobHash := newGitBlobHash(int64(len(content)))
+	blobHash.Write([]byte(content))
+	sha := hex.EncodeToString(blobHash.Sum(nil))
+
+	testCases := []struct {
+		name        string
+		served      string
+		cached      *string
+		expectHit   bool
+		expectError bool
+	}{
+		{name: "download", served: content},
+		{name: "cache hit", served: content, cached: to.Ptr(content), expectHit: true},
+		{name: "outdated cache", served: content, cached: to.Ptr("old content")},
+		{name: "corrupt download", served: "Write-Host 'hellO'\n", expectError: true},
+		{name: "truncated download", served: content[:5], expectError: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, testCase.served)
+			}))
+			defer server.Close()
+
+			cachePath := t.TempDir()
+			targetPath := filepath.Join(cachePath, "tree", "scripts", "hello.ps1")
+			if testCase.cached != nil {
+				require.NoError(t, os.MkdirAll(filepath.Dir(targetPath), 0755))
+				require.NoError(t, os.WriteFile(targetPath, []byte(*testCase.cached), 0644))
+			}
+
+			file := fileToDownload{
+				path: "scripts/hello.ps1",
+				mode: 0644,
+				sha:  sha,
+				size: int64(len(content)),
+				url:  server.URL,
+			}
+			bar := progressbar.NewOptions(-1, progressbar.OptionSetWriter(io.Discard))
+
+			cacheHit, err := downloadGithubFile(resty.New(), cachePath, "tree", file, bar)
+			if testCase.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, testCase.expectHit, cacheHit)
+
+				data, err := os.ReadFile(targetPath)
+				require.NoError(t, err)
+				require.Equal(t, content, string(data))
+			}
+
+			// temporary downloads never stay behind
+			leftovers, err := filepath.Glob(filepath.Join(cachePath, "tree.*.download"))
+			require.NoError(t, err)
+			require.Empty(t, leftovers)
+		})
+	}
+}
@@ -0,0 +1,514 @@
+package commands
+
+import (
+	"cmp"
+	"context"
+	stdErr "errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/urfave/cli/v2"
+)
+
+// bundle blobs are uploaded (or touched, when reused) before the Image Template that references them is deployed,
+// so recently modified blobs are never removed
+const stagingBlobGracePeriod = 24 * time.Hour
+
+// bundleBlobPrefix is the prefix of the bundle archives and bundle properties that autobuild uploads
+const bundleBlobPrefix = "bundle-"
+
+var CleanupCommand = &cli.Command{
+	Name:  "cleanup",
+	Usage: "Remove old image versions, Image Templates and uploaded bundles. Only shows what would be removed, unless --execute is set",
+	Description: `Image versions: the most recent --keep-versions versions of every image definition are kept.
+Versions that are the latest version, or of which the name or id is the value of a tag on the image definition, are never removed.
+
+Image Templates: templates that distribute to the gallery and are older than --template-max-age days are removed, unless they are running.
+
+Bundles: if --storage-account and --blob-container are set, the bundle blobs uploaded by "bundle autobuild" are removed
+when no remaining image version in the gallery or Image Template in the resource group references them.
+Bundle archives are kept as long as a remaining version was built without the SY_BUNDLE_ARCHIVE_URL tag, as its archive is unknown.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "subscription-id",
+			Usage:   "Azure subscription ID",
+			Aliases: []string{"sid"},
+		},
+		&cli.StringFlag{
+			Name:    "resource-group",
+			Usage:   "Name of the Resource Group of the Image Templates and the Image Gallery",
+			Aliases: []string{"g"},
+		},
+		&cli.StringFlag{
+			Name:    "image-gallery",
+			Usage:   "Name of the shared image gallery",
+			Aliases: []string{"sig"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "image-definition",
+			Usage:   "Only clean up these image definitions (and their Image Templates). Defaults to all image definitions in the gallery",
+			Aliases: []string{"i"},
+		},
+		&cli.UintFlag{
+			Name:  "keep-versions",
+			Usage: "Number of most recent image versions to keep per image definition. 0 keeps all versions",
+			Value: 5,
+		},
+		&cli.UintFlag{
+			Name:  "template-max-age",
+			Usage: "Remove Image Templates older than this number of days. 0 keeps all templates",
+			Value: 30,
+		},
+		&cli.StringFlag{
+			Name:    "storage-account",
+			Usage:   "Name of the Azure Storage Account to which bundles were uploaded. Bundles are not removed if not set",
+			Aliases: []string{"sa"},
+		},
+		&cli.StringFlag{
+			Name:    "blob-container",
+			Usage:   "Name of the Azure Blob Container to which bundles were uploaded",
+			Aliases: []string{"bc"},
+		},
+		&cli.BoolFlag{
+			Name:  "execute",
+			Usage: "Remove the resources instead of only showing them",
+		},
+		azureBackendFlag,
+		azureTenantIdFlag,
+		commandProfileFlag,
+	},
+	Before: applyProfile("subscription-id", "resource-group", "image-gallery"),
+	Action: func(c *cli.Context) error {
+		subscriptionId := c.String("subscription-id")
+		resourceGroup := c.String("resource-group")
+		imageGallery := c.String("image-gallery")
+		imageDefinitionFilter := c.StringSlice("image-definition")
+		keepVersions := c.Uint("keep-versions")
+		templateMaxAgeDays := c.Uint("template-max-age")
+		storageAccount := c.String("storage-account")
+		blobContainer := c.String("blob-container")
+		execute := c.Bool("execute")
+
+		if (storageAccount == "") != (blobContainer == "") {
+			return errors.New("--storage-account and --blob-container must be set together")
+		}
+
+		backend, err := azureBackend(c)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Collecting images, Image Templates and bundles (%s)...", backend.Name())
+		inventory, err := collectCleanupInventory(c.Context, backend, subscriptionId, resourceGroup, imageGallery, imageDefinitionFilter, storageAccount, blobContainer)
+		if err != nil {
+			return err
+		}
+		color.Green("[DONE]")
+		fmt.Println()
+
+		plan := planCleanup(inventory, cleanupPolicy{
+			keepVersions:    int(keepVersions),
+			templateMaxAge:  time.Duration(templateMaxAgeDays) * 24 * time.Hour,
+			blobGracePeriod: stagingBlobGracePeriod,
+		}, time.Now())
+
+		printCleanupPlan(plan, storageAccount != "")
+
+		if plan.empty() {
+			color.Green("Nothing to clean up")
+			return nil
+		}
+
+		if !execute {
+			fmt.Println()
+			color.Yellow("Dry run: nothing was removed. Run again with --execute to remove the resources above")
+			return nil
+		}
+
+		fmt.Println()
+		return executeCleanupPlan(c.Context, backend, plan, subscriptionId, resourceGroup, imageGallery, storageAccount, blobContainer)
+	},
+}
+
+type cleanupPolicy struct {
+	keepVersions    int           // 0 keeps all versions
+	templateMaxAge  time.Duration // 0 keeps all templates
+	blobGracePeriod time.Duration
+}
+
+type cleanupImageDefinition struct {
+	definition lib_azure.ImageDefinition
+	versions   []lib_azure.ImageVersion
+	inScope    bool // versions and templates of definitions that are not in scope are only used to find references
+}
+
+// cleanupInventory is everything that the cleanup considers
+type cleanupInventory struct {
+	galleryId   string
+	definitions []cleanupImageDefinition
+	templates   []lib_azure.ImageTemplate
+	blobs       []lib_azure.Blob
+	blobURL     func(blobName string) string
+}
+
+type cleanupVersion struct {
+	definition string
+	version    lib_azure.ImageVersion
+	reason     string
+}
+
+type cleanupPlan struct {
+	versions      []cleanupVersion
+	keptVersions  []cleanupVersion // versions that are protected even though they are beyond the number of versions to keep
+	templates     []lib_azure.ImageTemplate
+	keptTemplates []lib_azure.ImageTemplate // old templates that are still running
+	blobs         []lib_azure.Blob
+	// legacyVersions are remaining versions that do not tag their bundle archive, so no archive is removed
+	legacyVersions []cleanupVersion
+
+	blobURL func(blobName string) string
+}
+
+func (p cleanupPlan) empty() bool {
+	return len(p.versions) == 0 && len(p.templates) == 0 && len(p.blobs) == 0
+}
+
+func collectCleanupInventory(ctx context.Context, backend lib_azure.Backend, subscriptionId, resourceGroup, gallery string, definitionFilter []string, storageAccount, blobContainer string) (*cleanupInventory, error) {
+	definitions, err := backend.ListImageDefinitions(ctx, subscriptionId, resourceGroup, gallery)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list Image Definitions in the Image Gallery: %s/%s", resourceGroup, gallery)
+	}
+
+	for _, name := range definitionFilter {
+		if !slices.ContainsFunc(definitions, func(def lib_azure.ImageDefinition) bool { return def.Name == name }) {
+			return nil, fmt.Errorf("the specified Image Definition (%s) doesn't exist in the Image Gallery (%s)", name, gallery)
+		}
+	}
+
+	inventory := &cleanupInventory{
+		galleryId: fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/galleries/%s", subscriptionId, resourceGroup, gallery),
+		blobURL: func(blobName string) string {
+			return lib_azure.BlobURL(storageAccount, blobContainer, blobName)
+		},
+	}
+
+	// the versions of all definitions are needed to find the bundles that are still referenced
+	for _, definition := range definitions {
+		versions, err := backend.ListImageVersions(ctx, subscriptionId, resourceGroup, gallery, definition.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list the versions of Image Definition %s", definition.Name)
+		}
+
+		inventory.definitions = append(inventory.definitions, cleanupImageDefinition{
+			definition: definition,
+			versions:   versions,
+			inScope:    len(definitionFilter) == 0 || slices.Contains(definitionFilter, definition.Name),
+		})
+	}
+
+	inventory.templates, err = backend.ListImageTemplates(ctx, subscriptionId, resourceGroup)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the Image Templates in resource group %s", resourceGroup)
+	}
+
+	if storageAccount != "" {
+		inventory.blobs, err = backend.ListBlobs(ctx, storageAccount, blobContainer, bundleBlobPrefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list the bundles in %s/%s", storageAccount, blobContainer)
+		}
+	}
+
+	return inventory, nil
+}
+
+// planCleanup decides what to remove
+func planCleanup(inventory *cleanupInventory, policy cleanupPolicy, now time.Time) cleanupPlan {
+	plan := cleanupPlan{blobURL: inventory.blobURL}
+
+	removedVersions := map[string]struct{}{}
+	if policy.keepVersions > 0 {
+		for _, def := range inventory.definitions {
+			if !def.inScope {
+				continue
+			}
+
+			versions := slices.Clone(def.versions)
+			slices.SortStableFunc(versions, compareImageVersionsNewestFirst)
+
+			latest, hasLatest := latestImageVersion(versions)
+			for i, version := range versions {
+				if i < policy.keepVersions {
+					continue
+				}
+
+				var keepReason string
+				switch {
+				case hasLatest && version.Name == latest:
+					keepReason = "latest version"
+				case isVersionReferencedByTag(def.definition, version):
+					keepReason = "referenced by a tag of the image definition"
+				case !isProvisioningFinished(version.ProvisioningState):
+					keepReason = fmt.Sprintf("provisioning state %s", version.ProvisioningState)
+				}
+
+				if keepReason != "" {
+					plan.keptVersions = append(plan.keptVersions, cleanupVersion{definition: def.definition.Name, version: version, reason: keepReason})
+					continue
+				}
+
+				plan.versions = append(plan.versions, cleanupVersion{
+					definition: def.definition.Name,
+					version:    version,
+					reason:     fmt.Sprintf("not one of the %d most recent versions", policy.keepVersions),
+				})
+				removedVersions[version.ID] = struct{}{}
+			}
+		}
+	}
+
+	removedTemplates := map[string]struct{}{}
+	if policy.templateMaxAge > 0 {
+		for _, template := range inventory.templates {
+			if !isTemplateInScope(template, inventory) {
+				continue
+			}
+
+			created := templateCreationTime(template)
+			if created == nil || now.Sub(*created) < policy.templateMaxAge {
+				continue
+			}
+
+			if template.Status.HasRun() && !template.Status.RunFinished() {
+				plan.keptTemplates = append(plan.keptTemplates, template)
+				continue
+			}
+
+			plan.templates = append(plan.templates, template)
+			removedTemplates[template.ID] = struct{}{}
+		}
+	}
+
+	// versions built before the bundle archive was tagged only reference the bundle properties,
+	// which do not say which archive was used
+	for _, def := range inventory.definitions {
+		for _, version := range def.versions {
+			_, removed := removedVersions[version.ID]
+			if !removed && version.Tags[bundlePropertiesUrlTag] != "" && version.Tags[bundleArchiveUrlTag] == "" {
+				plan.legacyVersions = append(plan.legacyVersions, cleanupVersion{definition: def.definition.Name, version: version})
+			}
+		}
+	}
+
+	for _, blob := range inventory.blobs {
+		if now.Sub(blob.LastModified) < policy.blobGracePeriod {
+			continue
+		}
+		if len(plan.legacyVersions) > 0 && isBundleArchive(blob.Name) {
+			continue
+		}
+
+		blobURL := inventory.blobURL(blob.Name)
+		referenced := slices.ContainsFunc(inventory.templates, func(template lib_azure.ImageTemplate) bool {
+			_, removed := removedTemplates[template.ID]
+			return !removed && template.References(blobURL)
+		})
+		for _, def := range inventory.definitions {
+			referenced = referenced || slices.ContainsFunc(def.versions, func(version lib_azure.ImageVersion) bool {
+				_, removed := removedVersions[version.ID]
+				return !removed && isBlobReferencedByTags(version.Tags, blobURL)
+			})
+		}
+
+		if !referenced {
+			plan.blobs = append(plan.blobs, blob)
+		}
+	}
+
+	return plan
+}
+
+// compareImageVersionsNewestFirst orders by publish date, or by version number if the date is unknown
+func compareImageVersionsNewestFirst(a, b lib_azure.ImageVersion) int {
+	if a.PublishedDate != nil && b.PublishedDate != nil && !a.PublishedDate.Equal(*b.PublishedDate) {
+		return b.PublishedDate.Compare(*a.PublishedDate)
+	}
+
+	aVersion, aErr := lib_azure.ParseGalleryVersion(a.Name)
+	bVersion, bErr := lib_azure.ParseGalleryVersion(b.Name)
+	if aErr != nil || bErr != nil {
+		return cmp.Compare(b.Name, a.Name)
+	}
+	return bVersion.Compare(aVersion)
+}
+
+// latestImageVersion returns the name of the version that Azure uses as "latest":
+// the highest version number that is not excluded from latest
+func latestImageVersion(versions []lib_azure.ImageVersion) (string, bool) {
+	candidates := slices.DeleteFunc(slices.Clone(versions), func(version lib_azure.ImageVersion) bool {
+		return version.ExcludeFromLatest
+	})
+
+	latest, ok := lib_azure.LatestGalleryVersion(candidates)
+	if !ok {
+		return "", false
+	}
+	return latest.String(), true
+}
+
+func isVersionReferencedByTag(definition lib_azure.ImageDefinition, version lib_azure.ImageVersion) bool {
+	for _, value := range definition.Tags {
+		if strings.EqualFold(value, version.Name) || (version.ID != "" && strings.EqualFold(value, version.ID)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isProvisioningFinished(state string) bool {
+	return state == "" || state == "Succeeded" || state == "Failed"
+}
+
+func isBundleArchive(blobName string) bool {
+	return strings.HasPrefix(blobName, bundleBlobPrefix) && strings.HasSuffix(blobName, ".zip")
+}
+
+func isBlobReferencedByTags(tags map[string]string, blobURL string) bool {
+	for _, value := range tags {
+		if value == blobURL {
+			return true
+		}
+	}
+	return false
+}
+
+// isTemplateInScope returns whether the template distributes to an image definition that is cleaned up
+func isTemplateInScope(template lib_azure.ImageTemplate, inventory *cleanupInventory) bool {
+	for _, galleryImageId := range template.GalleryImageIDs {
+		for _, def := range inventory.definitions {
+			definitionId := inventory.galleryId + "/images/" + def.definition.Name
+			if !def.inScope {
+				continue
+			}
+
+			if strings.EqualFold(galleryImageId, definitionId) || strings.HasPrefix(strings.ToLower(galleryImageId), strings.ToLower(definitionId+"/versions/")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func templateCreationTime(template lib_azure.ImageTemplate) *time.Time {
+	if template.CreatedAt != nil {
+		return template.CreatedAt
+	}
+	return template.Status.StartTime
+}
+
+func printCleanupPlan(plan cleanupPlan, includesBlobs bool) {
+	fmt.Println("Image versions to remove:")
+	if len(plan.versions) == 0 {
+		fmt.Println("    none")
+	}
+	for _, version := range plan.versions {
+		fmt.Printf("    - %s/%s%s: %s\n", version.definition, version.version.Name, publishedSuffix(version.version), version.reason)
+	}
+	for _, version := range plan.keptVersions {
+		fmt.Printf("    Keeping %s/%s: %s\n", version.definition, version.version.Name, version.reason)
+	}
+
+	fmt.Println("Image Templates to remove:")
+	if len(plan.templates) == 0 {
+		fmt.Println("    none")
+	}
+	for _, template := range plan.templates {
+		fmt.Printf("    - %s (created %s)\n", template.Name, humanize.Time(*templateCreationTime(template)))
+	}
+	for _, template := range plan.keptTemplates {
+		fmt.Printf("    Keeping %s: it is running\n", template.Name)
+	}
+
+	if includesBlobs {
+		fmt.Println("Bundles to remove:")
+		if len(plan.blobs) == 0 {
+			fmt.Println("    none")
+		}
+		var totalSize int64
+		for _, blob := range plan.blobs {
+			fmt.Printf("    - %s (%s, uploaded %s)\n", blob.Name, humanize.Bytes(uint64(blob.Size)), humanize.Time(blob.LastModified))
+			totalSize += blob.Size
+		}
+		if len(plan.blobs) > 0 {
+			fmt.Printf("    Total: %s\n", humanize.Bytes(uint64(totalSize)))
+		}
+		if len(plan.legacyVersions) > 0 {
+			fmt.Printf("    Keeping all bundle archives: these versions do not have the %s tag, so their archive is unknown:\n", bundleArchiveUrlTag)
+			for _, version := range plan.legacyVersions {
+				fmt.Printf("        %s/%s\n", version.definition, version.version.Name)
+			}
+		}
+	}
+}
+
+func publishedSuffix(version lib_azure.ImageVersion) string {
+	if version.PublishedDate == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (published %s)", version.PublishedDate.Local().Format(time.DateOnly))
+}
+
+// executeCleanupPlan removes the templates first, as they reference the bundles.
+// it continues after a failure, and returns all errors.
+// bundles that are referenced by a template or version that failed to be removed are kept
+func executeCleanupPlan(ctx context.Context, backend lib_azure.Backend, plan cleanupPlan, subscriptionId, resourceGroup, gallery, storageAccount, blobContainer string) error {
+	var errs []error
+	run := func(description string, fn func() error) bool {
+		fmt.Printf("Removing %s...", description)
+		if err := fn(); err != nil {
+			color.Red("[FAILED]")
+			errs = append(errs, errors.Wrapf(err, "failed to remove %s", description))
+			return false
+		}
+		color.Green("[DONE]")
+		return true
+	}
+
+	var failedTemplates []lib_azure.ImageTemplate
+	for _, template := range plan.templates {
+		if !run("Image Template "+template.Name, func() error {
+			return backend.DeleteImageTemplate(ctx, subscriptionId, resourceGroup, template.Name)
+		}) {
+			failedTemplates = append(failedTemplates, template)
+		}
+	}
+
+	var failedVersions []lib_azure.ImageVersion
+	for _, version := range plan.versions {
+		if !run(fmt.Sprintf("image version %s/%s", version.definition, version.version.Name), func() error {
+			return backend.DeleteImageVersion(ctx, subscriptionId, resourceGroup, gallery, version.definition, version.version.Name)
+		}) {
+			failedVersions = append(failedVersions, version.version)
+		}
+	}
+
+	for _, blob := range plan.blobs {
+		blobURL := plan.blobURL(blob.Name)
+		if slices.ContainsFunc(failedTemplates, func(template lib_azure.ImageTemplate) bool { return template.References(blobURL) }) ||
+			slices.ContainsFunc(failedVersions, func(version lib_azure.ImageVersion) bool { return isBlobReferencedByTags(version.Tags, blobURL) }) {
+			color.Yellow("Keeping bundle %s: it is referenced by a resource that failed to be removed", blob.Name)
+			continue
+		}
+
+		run("bundle "+blob.Name, func() error {
+			return backend.DeleteBlob(ctx, storageAccount, blobContainer, blob.Name)
+		})
+	}
+
+	return stdErr.Join(errs...)
+}
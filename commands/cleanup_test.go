@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/schoolyear/avd-cli/lib/lib_azure/azuretest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_planCleanup(t *testing.T) {
+	now := time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) *time.Time {
+		at := now.Add(-time.Duration(days) * 24 * time.Hour)
+		return &at
+	}
+
+	const galleryId = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery"
+	blobURL := func(blobName string) string {
+		return lib_azure.BlobURL("sa", "bundles", blobName)
+	}
+
+	version := func(name string, published int, excludeFromLatest bool, bundle string) lib_azure.ImageVersion {
+		return lib_azure.ImageVersion{
+			ID:                galleryId + "/images/win11/versions/" + name,
+			Name:              name,
+			ProvisioningState: "Succeeded",
+			PublishedDate:     daysAgo(published),
+			ExcludeFromLatest: excludeFromLatest,
+			Tags: map[string]string{
+				bundlePropertiesUrlTag: blobURL(bundle),
+				bundleArchiveUrlTag:    blobURL("bundle-" + name + ".zip"),
+			},
+		}
+	}
+
+	inventory := &cleanupInventory{
+		galleryId: galleryId,
+		definitions: []cleanupImageDefinition{
+			{
+				definition: lib_azure.ImageDefinition{Name: "win11", Tags: map[string]string{"exam-2026": "1.0.0"}},
+				versions: []lib_azure.ImageVersion{
+					version("1.0.0", 50, false, "bundle-a.json"), // referenced by a tag
+					version("1.1.0", 40, false, "bundle-b.json"), // removed
+					version("1.5.0", 30, false, "bundle-c.json"), // latest, published before the excluded versions
+					version("1.2.0", 20, true, "bundle-d.json"),  // kept, one of the 2 most recent
+					version("1.3.0", 10, true, "bundle-e.json"),  // kept, one of the 2 most recent
+				},
+				inScope: true,
+			},
+			{
+				definition: lib_azure.ImageDefinition{Name: "win10"},
+				versions: []lib_azure.ImageVersion{
+					version("1.0.0", 90, false, "bundle-f.json"),
+					version("0.9.0", 100, false, "bundle-g.json"),
+					version("0.8.0", 110, false, "bundle-h.json"),
+				},
+			},
+		},
+		templates: []lib_azure.ImageTemplate{
+			{ID: "old", Name: "old", CreatedAt: daysAgo(45), GalleryImageIDs: []string{galleryId + "/images/win11"}, Content: blobURL("bundle-1.zip")},
+			{ID: "old-version", Name: "old-version", CreatedAt: daysAgo(45), GalleryImageIDs: []string{galleryId + "/images/win11/versions/1.1.0"}},
+			{ID: "recent", Name: "recent", CreatedAt: daysAgo(5), GalleryImageIDs: []string{galleryId + "/images/win11"}, Content: blobURL("bundle-2.zip")},
+			{ID: "running", Name: "running", CreatedAt: daysAgo(45), GalleryImageIDs: []string{galleryId + "/images/win11"}, Status: lib_azure.ImageTemplateStatus{RunState: "Running"}},
+			{ID: "other-definition", Name: "other-definition", CreatedAt: daysAgo(45), GalleryImageIDs: []string{galleryId + "/images/win10"}, Content: blobURL("bundle-3.zip")},
+		},
+		blobs: []lib_azure.Blob{
+			{Name: "bundle-1.zip", LastModified: *daysAgo(45)},  // template is removed
+			{Name: "bundle-2.zip", LastModified: *daysAgo(5)},   // referenced by a recent template
+			{Name: "bundle-3.zip", LastModified: *daysAgo(45)},  // referenced by a template out of scope
+			{Name: "bundle-4.zip", LastModified: now},           // just uploaded
+			{Name: "bundle-a.json", LastModified: *daysAgo(50)}, // referenced by a kept version
+			{Name: "bundle-b.json", LastModified: *daysAgo(40)}, // version is removed
+			{Name: "bundle-h.json", LastModified: *daysAgo(40)}, // referenced by a version out of scope
+		},
+		blobURL: blobURL,
+	}
+
+	plan := planCleanup(inventory, cleanupPolicy{
+		keepVersions:    2,
+		templateMaxAge:  30 * 24 * time.Hour,
+		blobGracePeriod: stagingBlobGracePeriod,
+	}, now)
+
+	var versions, keptVersions, templates, keptTemplates, blobs []string
+	for _, v := range plan.versions {
+		versions = append(versions, v.definition+"/"+v.version.Name)
+	}
+	for _, v := range plan.keptVersions {
+		keptVersions = append(keptVersions, v.definition+"/"+v.version.Name+": "+v.reason)
+	}
+	for _, template := range plan.templates {
+		templates = append(templates, template.Name)
+	}
+	for _, template := range plan.keptTemplates {
+		keptTemplates = append(keptTemplates, template.Name)
+	}
+	for _, blob := range plan.blobs {
+		blobs = append(blobs, blob.Name)
+	}
+
+	require.Equal(t, []string{"win11/1.1.0"}, versions)
+	require.Equal(t, []string{"win11/1.5.0: latest version", "win11/1.0.0: referenced by a tag of the image definition"}, keptVersions)
+	require.Equal(t, []string{"old", "old-version"}, templates)
+	require.Equal(t, []string{"running"}, keptTemplates)
+	require.Equal(t, []string{"bundle-1.zip", "bundle-b.json"}, blobs)
+	require.Empty(t, plan.legacyVersions)
+}
+
+func Test_planCleanup_legacyVersions(t *testing.T) {
+	now := time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-40 * 24 * time.Hour)
+	blobURL := func(blobName string) string {
+		return lib_azure.BlobURL("sa", "bundles", blobName)
+	}
+
+	tests := []struct {
+		name         string
+		keepVersions int
+		wantBlobs    []string
+		wantLegacy   int
+	}{
+		{
+			name:         "legacy version is kept",
+			keepVersions: 2,
+			wantBlobs:    []string{"bundle-unused.json"},
+			wantLegacy:   1,
+		},
+		{
+			name:         "legacy version is removed",
+			keepVersions: 1,
+			wantBlobs:    []string{"bundle-legacy.json", "bundle-unused.json", "bundle-unused.zip"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inventory := &cleanupInventory{
+				definitions: []cleanupImageDefinition{{
+					definition: lib_azure.ImageDefinition{Name: "win11"},
+					versions: []lib_azure.ImageVersion{
+						{
+							ID:            "new",
+							Name:          "1.1.0",
+							PublishedDate: &now,
+							Tags: map[string]string{
+								bundlePropertiesUrlTag: blobURL("bundle-new.json"),
+								bundleArchiveUrlTag:    blobURL("bundle-new.zip"),
+							},
+						},
+						{
+							ID:            "legacy",
+							Name:          "1.0.0",
+							PublishedDate: &old,
+							Tags:          map[string]string{bundlePropertiesUrlTag: blobURL("bundle-legacy.json")},
+						},
+					},
+					inScope: true,
+				}},
+				blobs: []lib_azure.Blob{
+					{Name: "bundle-new.json", LastModified: old},
+					{Name: "bundle-new.zip", LastModified: old},
+					{Name: "bundle-legacy.json", LastModified: old},
+					{Name: "bundle-unused.json", LastModified: old},
+					{Name: "bundle-unused.zip", LastModified: old}, // may be the archive of the legacy version
+				},
+				blobURL: blobURL,
+			}
+
+			plan := planCleanup(inventory, cleanupPolicy{keepVersions: tt.keepVersions, blobGracePeriod: stagingBlobGracePeriod}, now)
+
+			var blobs []string
+			for _, blob := range plan.blobs {
+				blobs = append(blobs, blob.Name)
+			}
+			require.Equal(t, tt.wantBlobs, blobs)
+			require.Len(t, plan.legacyVersions, tt.wantLegacy)
+		})
+	}
+}
+
+// failingVersionDeleteBackend fails to delete any image version
+type failingVersionDeleteBackend struct {
+	lib_azure.Backend
+}
+
+func (failingVersionDeleteBackend) DeleteImageVersion(context.Context, string, string, string, string, string) error {
+	return errors.New("conflict")
+}
+
+func Test_executeCleanupPlan_failedVersion(t *testing.T) {
+	fake := azuretest.NewFakeBackend()
+	backend := failingVersionDeleteBackend{Backend: fake}
+
+	blobURL := func(blobName string) string {
+		return lib_azure.BlobURL("sa", "bundles", blobName)
+	}
+	fake.Blobs[azuretest.FakeBlobKey("sa", "bundles", "bundle-template.zip")] = []byte("template")
+	fake.Blobs[azuretest.FakeBlobKey("sa", "bundles", "bundle-version.json")] = []byte("version")
+	fake.ImageTemplateSummaries[azuretest.FakeResourceKey("sub", "rg", "old")] = lib_azure.ImageTemplate{Name: "old"}
+
+	plan := cleanupPlan{
+		versions: []cleanupVersion{{
+			definition: "win11",
+			version:    lib_azure.ImageVersion{Name: "1.0.0", Tags: map[string]string{bundlePropertiesUrlTag: blobURL("bundle-version.json")}},
+		}},
+		templates: []lib_azure.ImageTemplate{{Name: "old", Content: blobURL("bundle-template.zip")}},
+		blobs:     []lib_azure.Blob{{Name: "bundle-template.zip"}, {Name: "bundle-version.json"}},
+		blobURL:   blobURL,
+	}
+
+	err := executeCleanupPlan(context.Background(), backend, plan, "sub", "rg", "gallery", "sa", "bundles")
+	require.ErrorContains(t, err, "failed to remove image version win11/1.0.0")
+
+	require.NotContains(t, fake.Blobs, azuretest.FakeBlobKey("sa", "bundles", "bundle-template.zip"))
+	require.Contains(t, fake.Blobs, azuretest.FakeBlobKey("sa", "bundles", "bundle-version.json"))
+}
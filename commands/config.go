@@ -0,0 +1,196 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib"
+	"github.com/urfave/cli/v2"
+)
+
+// defaultProfileKey selects the default profile in "config get" and "config set", next to the ProfileKeys
+const defaultProfileKey = "default_profile"
+
+// the profile that "config set" creates when no profile is selected and there is no default profile yet
+const initialProfileName = "default"
+
+func profileKeysDescription() string {
+	var builder strings.Builder
+	builder.WriteString("Keys:\n")
+	for _, key := range lib.ProfileKeys {
+		fmt.Fprintf(&builder, "   %-38s %s\n", key.Name, key.Description)
+	}
+	fmt.Fprintf(&builder, "   %-38s %s\n", defaultProfileKey, "Profile used when --profile and AVDCLI_PROFILE are not set (not part of a profile)")
+	builder.WriteString("\nExplicit flags take precedence over the values of the profile.")
+	return builder.String()
+}
+
+var ConfigListCommand = &cli.Command{
+	Name:  "list",
+	Usage: "List the profiles in the user config and their settings",
+	Action: func(c *cli.Context) error {
+		userConfig, err := lib.LoadUserConfig()
+		if err != nil {
+			return err
+		}
+
+		if len(userConfig.Profiles) == 0 {
+			configPath, _ := lib.UserConfigPath()
+			fmt.Printf("No profiles in %s. Create one with \"avdcli --profile <name> config set <key> <value>\"\n", configPath)
+			return nil
+		}
+
+		names := make([]string, 0, len(userConfig.Profiles))
+		for name := range userConfig.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for i, name := range names {
+			if i > 0 {
+				fmt.Println()
+			}
+			if name == userConfig.DefaultProfile {
+				fmt.Printf("%s %s\n", color.CyanString(name), color.GreenString("(default)"))
+			} else {
+				fmt.Println(color.CyanString(name))
+			}
+
+			profile := userConfig.Profiles[name]
+			for _, key := range lib.ProfileKeys {
+				if value, ok := profile[key.Name]; ok {
+					fmt.Printf("    %s = %s\n", key.Name, value)
+				}
+			}
+		}
+
+		return nil
+	},
+}
+
+var ConfigGetCommand = &cli.Command{
+	Name:        "get",
+	Usage:       "Print a setting of the selected profile",
+	ArgsUsage:   "<key>",
+	Description: profileKeysDescription(),
+	Flags:       []cli.Flag{commandProfileFlag},
+	Action: func(c *cli.Context) error {
+		key := c.Args().First()
+		if c.Args().Len() != 1 {
+			return errors.New("key argument is required")
+		}
+
+		userConfig, err := lib.LoadUserConfig()
+		if err != nil {
+			return err
+		}
+
+		if key == defaultProfileKey {
+			if userConfig.DefaultProfile == "" {
+				return errors.New("no default profile is set")
+			}
+			fmt.Println(userConfig.DefaultProfile)
+			return nil
+		}
+		if !lib.IsProfileKey(key) {
+			return fmt.Errorf("unknown key %s. Run \"avdcli config get --help\" for the available keys", key)
+		}
+
+		profileName, profile, err := userConfig.SelectProfile(selectedProfileName(c))
+		if err != nil {
+			return err
+		}
+		if profileName == "" {
+			return errors.New("no profile selected and no default profile is set. Use --profile or AVDCLI_PROFILE")
+		}
+
+		value, ok := profile[key]
+		if !ok {
+			return fmt.Errorf("%s is not set in profile %s", key, profileName)
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var ConfigSetCommand = &cli.Command{
+	Name:        "set",
+	Usage:       "Change a setting of the selected profile. The profile is created if it does not exist. An empty value removes the setting",
+	ArgsUsage:   "<key> <value>",
+	Description: profileKeysDescription(),
+	Flags:       []cli.Flag{commandProfileFlag},
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 2 {
+			return errors.New("key and value arguments are required")
+		}
+		key, value := c.Args().Get(0), c.Args().Get(1)
+
+		userConfig, err := lib.LoadUserConfig()
+		if err != nil {
+			return err
+		}
+
+		profileName, err := setUserConfigValue(userConfig, selectedProfileName(c), key, value)
+		if err != nil {
+			return err
+		}
+
+		if err := lib.SaveUserConfig(userConfig); err != nil {
+			return err
+		}
+
+		switch {
+		case key == defaultProfileKey && value == "":
+			fmt.Println("Removed the default profile")
+		case key == defaultProfileKey:
+			fmt.Printf("Default profile: %s\n", color.CyanString(value))
+		case value == "":
+			fmt.Printf("Removed %s from profile %s\n", key, color.CyanString(profileName))
+		default:
+			fmt.Printf("Set %s of profile %s\n", key, color.CyanString(profileName))
+		}
+		return nil
+	},
+}
+
+// setUserConfigValue changes the key in the profile, or the default profile if profileName is empty.
+// without a default profile, the initial profile is created and made the default
+func setUserConfigValue(userConfig *lib.UserConfig, profileName, key, value string) (string, error) {
+	if key == defaultProfileKey {
+		if _, ok := userConfig.Profiles[value]; value != "" && !ok {
+			return "", fmt.Errorf("profile %s does not exist", value)
+		}
+		userConfig.DefaultProfile = value
+		return value, nil
+	}
+	if !lib.IsProfileKey(key) {
+		return "", fmt.Errorf("unknown key %s. Run \"avdcli config set --help\" for the available keys", key)
+	}
+
+	if profileName == "" {
+		profileName = userConfig.DefaultProfile
+	}
+	if profileName == "" {
+		profileName = initialProfileName
+		userConfig.DefaultProfile = profileName
+	}
+
+	if userConfig.Profiles == nil {
+		userConfig.Profiles = map[string]lib.Profile{}
+	}
+	profile := userConfig.Profiles[profileName]
+	if profile == nil {
+		profile = lib.Profile{}
+		userConfig.Profiles[profileName] = profile
+	}
+
+	if value == "" {
+		delete(profile, key)
+	} else {
+		profile[key] = value
+	}
+	return profileName, nil
+}
@@ -11,13 +11,13 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/adhocore/jsonc"
-	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/friendsofgo/errors"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/inhies/go-bytesize"
@@ -31,6 +31,10 @@ import (
 var ImagePackageCommand = &cli.Command{
 	Name:  "package",
 	Usage: "Build image package from image layers",
+	Description: `Resource files of the layers are merged into a single resources folder.
+Two layers can only ship the same unordered resource file if the content is identical, or if the higher layer overrides it:
+either by prefixing the file name with override_ (resources/override_config.json replaces resources/config.json),
+or by listing the path relative to the resources folder in the overrides.json file of the layer: ["config.json"]`,
 	Flags: []cli.Flag{
 		&cli.StringSliceFlag{
 			Name:        "layer",
@@ -62,6 +66,12 @@ var ImagePackageCommand = &cli.Command{
 			Name:  "overwrite",
 			Usage: "Overwrite the output directory",
 		},
+		&cli.BoolFlag{
+			Name: "explain-merge",
+			Usage: "Show which layer set each value of the merged image properties. " +
+				"Layers replace arrays, unless the array is given as {\"$merge\": \"<strategy>\", \"$value\": [...]} with the strategy " + joinMergeStrategies() +
+				". The merge strategy merges objects with the same name, or the field set in \"$key\"",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		layerPaths := c.StringSlice("layer")
@@ -69,6 +79,7 @@ var ImagePackageCommand = &cli.Command{
 		dryRun := c.Bool("dry-run")
 		overwriteOutput := c.Bool("overwrite")
 		deploymentTemplateFlag := c.String("deployment-template")
+		explainMerge := c.Bool("explain-merge")
 
 		cwd, err := os.Getwd()
 		if err != nil {
@@ -122,10 +133,13 @@ var ImagePackageCommand = &cli.Command{
 		}
 
 		fmt.Println("Merging image properties")
-		imageProperties, err := mergeImageProperties(layers)
+		imageProperties, mergedValues, err := mergeImageProperties(layers)
 		if err != nil {
 			return errors.Wrap(err, "failed to merge image property documents")
 		}
+		if explainMerge {
+			printMergeExplanation(layers, mergedValues)
+		}
 
 		fmt.Println("Resolving deployment template placeholders")
 		deploymentTemplateJSON := resolveDeploymentTemplateProperties(
@@ -168,6 +182,39 @@ var ImagePackageCommand = &cli.Command{
 		}
 		fmt.Println()
 
+		fmt.Println("The following resource files are overridden:")
+		overridden := 0
+		for _, fileMapping := range resourceFileMappings {
+			for _, overriddenLayerIdx := range fileMapping.OverriddenLayerIndexes {
+				fmt.Printf("\t%s: (layer %d) %s -> (layer %d) %s\n", fileMapping.TargetPath,
+					overriddenLayerIdx+1, layers[overriddenLayerIdx].basePath, fileMapping.LayerIdx+1, layers[fileMapping.LayerIdx].basePath)
+				overridden++
+			}
+		}
+		if overridden == 0 {
+			fmt.Println("\tNo files are overridden")
+		}
+		fmt.Println()
+
+		deduplicated := 0
+		for _, fileMapping := range resourceFileMappings {
+			if len(fileMapping.DeduplicatedLayerIndexes) == 0 {
+				continue
+			}
+			if deduplicated == 0 {
+				fmt.Println("The following resource files are identical in multiple layers and are only included once:")
+			}
+			layerNumbers := make([]string, 0, len(fileMapping.DeduplicatedLayerIndexes)+1)
+			for _, layerIdx := range append([]int{fileMapping.LayerIdx}, fileMapping.DeduplicatedLayerIndexes...) {
+				layerNumbers = append(layerNumbers, strconv.Itoa(layerIdx+1))
+			}
+			fmt.Printf("\t%s (layers %s)\n", fileMapping.TargetPath, strings.Join(layerNumbers, ", "))
+			deduplicated++
+		}
+		if deduplicated > 0 {
+			fmt.Println()
+		}
+
 		if dryRun {
 			fmt.Println("Dry run: not writing the image building package")
 			return nil
@@ -200,6 +247,9 @@ type layerScanResult struct {
 	buildStepsConfig   *schema.BuildStepsConfig
 	hasResourcesFolder bool
 	resourcesSize      int64 // 0, if hasResourcesFolder = false
+
+	// resource file paths that replace the files of lower layers, from the overrides file
+	resourceOverrides map[string]struct{}
 }
 
 const (
@@ -208,6 +258,7 @@ const (
 	deploymentTemplateFilename          = "deployment_template"
 	deploymentTemplateFileWithExtension = "deployment_template.json"
 	buildStepsFileName                  = "build_steps"
+	overridesFileName                   = "overrides"
 	resourcesDirName                    = "resources"
 	resourcesArchiveName                = resourcesDirName + ".zip"
 )
@@ -308,9 +359,42 @@ func scanLayerPath(layerFs fs.FS) (layer layerScanResult, err error) {
 		}
 	}
 
+	overrides, _, err := lib.UnmarshalJSONorJSON5File[[]string](layerFs, overridesFileName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) { // ok if the file does not exist
+		return layer, errors.Wrap(err, "failed to read overrides file")
+	}
+	if overrides != nil {
+		layer.resourceOverrides, err = resolveResourceOverrides(layerFs, *overrides)
+		if err != nil {
+			return layer, errors.Wrap(err, "invalid overrides file")
+		}
+	}
+
 	return layer, nil
 }
 
+// resolveResourceOverrides checks that the paths in the overrides file point to resource files of the layer.
+// the paths are relative to the resources folder
+func resolveResourceOverrides(layerFs fs.FS, overrides []string) (map[string]struct{}, error) {
+	resolved := make(map[string]struct{}, len(overrides))
+	for _, override := range overrides {
+		cleanPath := path.Clean(strings.ReplaceAll(override, "\\", "/"))
+		if !fs.ValidPath(cleanPath) || cleanPath == "." {
+			return nil, fmt.Errorf("%s is not a path inside the resources folder", override)
+		}
+
+		info, err := fs.Stat(layerFs, path.Join(resourcesDirName, cleanPath))
+		if err != nil {
+			return nil, errors.Wrapf(err, "resource %s does not exist", override)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("resource %s is a directory, only files can be overridden", override)
+		}
+		resolved[cleanPath] = struct{}{}
+	}
+	return resolved, nil
+}
+
 func printLayers(layers []layerProps) error {
 	fmt.Println("Merging the following layers")
 	for i, layer := range layers {
@@ -337,30 +421,59 @@ func printLayers(layers []layerProps) error {
 	return nil
 }
 
-func mergeImageProperties(layers []layerProps) (*schema.ImageProperties, error) {
-	var propsJSON []byte
+// mergeImageProperties merges the properties of the layers in order, see mergeValue.
+// returns the values of the merged document with the index of the layer that set them
+func mergeImageProperties(layers []layerProps) (*schema.ImageProperties, []mergedValue, error) {
+	var (
+		merged any
+		origin *mergeOrigin
+	)
 	for i, layer := range layers {
 		if layer.cleanPropertiesJSON == nil {
 			continue
 		}
 
-		if i == 0 {
-			propsJSON = layer.cleanPropertiesJSON
-		} else {
-			var err error
-			propsJSON, err = jsonpatch.MergePatch(propsJSON, layer.cleanPropertiesJSON)
-			if err != nil {
-				return nil, errors.Wrapf(err, "failed to merge properties of layer %d", i+1)
-			}
+		patch, err := decodeMergeDocument(layer.cleanPropertiesJSON)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to parse properties of layer %d", i+1)
+		}
+
+		merged, origin, err = mergeValue(merged, origin, patch, i, "")
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to merge properties of layer %d", i+1)
 		}
 	}
 
+	propsJSON, err := json.Marshal(merged)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal merged json document")
+	}
+
 	var props schema.ImageProperties
 	if err := json.Unmarshal(propsJSON, &props); err != nil {
-		return nil, errors.Wrap(err, "failed to marshal patched json document")
+		return nil, nil, errors.Wrap(err, "failed to unmarshal merged json document")
+	}
+
+	var values []mergedValue
+	if origin != nil {
+		values, err = explainMerge(merged, origin, "")
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	return &props, nil
+	return &props, values, nil
+}
+
+func printMergeExplanation(layers []layerProps, values []mergedValue) {
+	fmt.Println("Image properties set by each layer:")
+	if len(values) == 0 {
+		fmt.Println("	No layer has image properties")
+	}
+	for _, value := range values {
+		fmt.Printf("	%s = %s\t(layer %d: %s)\n", value.path, value.value, value.layer+1, layers[value.layer].basePath)
+	}
+	fmt.Println()
 }
 
 func resolveDeploymentTemplateProperties(properties *schema.ImageProperties, deploymentTemplateJSONRaw []byte) []byte {
@@ -395,11 +508,13 @@ func mergeBuildStepConfigs(layers []layerProps) schema.BuildSteps {
 
 func mergeResourcesDir(layers []layerProps) ([]lib.FileMapping, error) {
 	layerFSs := make([]fs.FS, len(layers))
+	overrides := make([]map[string]struct{}, len(layers))
 	for i, layer := range layers {
 		layerFSs[i] = layer.resourcesFs
+		overrides[i] = layer.resourceOverrides
 	}
 
-	fileMappings, fileCollisions, pathTypeCollisions, err := lib.MergeDirectoryLayers(layerFSs, ".")
+	fileMappings, fileCollisions, pathTypeCollisions, err := lib.MergeDirectoryLayers(layerFSs, overrides, ".")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to merge layer directories")
 	}
@@ -413,6 +528,7 @@ func mergeResourcesDir(layers []layerProps) ([]lib.FileMapping, error) {
 					fmt.Printf("\t+(%d): %s\n", layerIdx, layers[layerIdx].basePath)
 				}
 			}
+			fmt.Printf("To replace the file of a lower layer, prefix the file name with %s or add its path to the %s.json file of the layer\n", lib.OverridePrefix, overridesFileName)
 		}
 
 		if len(pathTypeCollisions) > 0 {
@@ -0,0 +1,280 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/urfave/cli/v2"
+)
+
+// tags that record the last promotion action on an image version
+const (
+	promotionActionTag         = "SY_PROMOTION_ACTION"
+	promotionTimeTag           = "SY_PROMOTION_TIME"
+	promotionPreviousLatestTag = "SY_PROMOTION_PREVIOUS_LATEST"
+)
+
+type promotionAction string
+
+const (
+	promotionActionPromote  promotionAction = "promote"
+	promotionActionDemote   promotionAction = "demote"
+	promotionActionRollback promotionAction = "rollback"
+)
+
+var imagePromotionFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "subscription-id",
+		Usage:   "Azure subscription ID",
+		Aliases: []string{"sid"},
+	},
+	&cli.StringFlag{
+		Name:    "resource-group",
+		Usage:   "Name of the Resource Group of the Image Gallery",
+		Aliases: []string{"g"},
+	},
+	&cli.StringFlag{
+		Name:    "image-gallery",
+		Usage:   "Name of the shared image gallery",
+		Aliases: []string{"sig"},
+	},
+	azureBackendFlag,
+	azureTenantIdFlag,
+	commandProfileFlag,
+}
+
+var imagePromotionRequiredFlags = []string{"subscription-id", "resource-group", "image-gallery"}
+
+var ImagePromoteCommand = &cli.Command{
+	Name:      "promote",
+	Usage:     "Allow an image version to be the latest version, after it was built with --exclude-from-latest and tested",
+	ArgsUsage: "<image-definition> <version>",
+	Flags:     imagePromotionFlags,
+	Before:    applyProfile(imagePromotionRequiredFlags...),
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 2 {
+			return errors.New("image definition and version arguments are required")
+		}
+		return runImagePromotion(c, promotionActionPromote, c.Args().Get(0), c.Args().Get(1))
+	},
+}
+
+var ImageDemoteCommand = &cli.Command{
+	Name:      "demote",
+	Usage:     "Exclude an image version from being the latest version, so the highest remaining version becomes the latest. Defaults to the current latest version",
+	ArgsUsage: "<image-definition> [version]",
+	Flags:     imagePromotionFlags,
+	Before:    applyProfile(imagePromotionRequiredFlags...),
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() < 1 || c.Args().Len() > 2 {
+			return errors.New("image definition argument is required")
+		}
+		return runImagePromotion(c, promotionActionDemote, c.Args().Get(0), c.Args().Get(1))
+	},
+}
+
+var ImageRollbackCommand = &cli.Command{
+	Name:      "rollback",
+	Usage:     "Make the version before the current latest version the latest version again. The current latest version is excluded from latest",
+	ArgsUsage: "<image-definition>",
+	Flags:     imagePromotionFlags,
+	Before:    applyProfile(imagePromotionRequiredFlags...),
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 1 {
+			return errors.New("image definition argument is required")
+		}
+		return runImagePromotion(c, promotionActionRollback, c.Args().First(), "")
+	},
+}
+
+func runImagePromotion(c *cli.Context, action promotionAction, imageDefinition, versionName string) error {
+	subscriptionId := c.String("subscription-id")
+	resourceGroup := c.String("resource-group")
+	imageGallery := c.String("image-gallery")
+
+	backend, err := azureBackend(c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Listing the versions of %s (%s)...", imageDefinition, backend.Name())
+	versions, err := backend.ListImageVersions(c.Context, subscriptionId, resourceGroup, imageGallery, imageDefinition)
+	if err != nil {
+		color.Red("[FAILED]")
+		return errors.Wrap(err, "failed to list image versions")
+	}
+	color.Green("[DONE]")
+
+	latestBefore, _ := latestImageVersion(versions)
+	fmt.Printf("Latest version before %s: %s\n", action, describeLatestVersion(latestBefore))
+
+	changes, err := planPromotion(action, versions, versionName, time.Now())
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		color.Yellow("Nothing to %s: version %s is already included in latest", action, versionName)
+		return nil
+	}
+
+	if err := applyPromotionChanges(c.Context, backend, changes, subscriptionId, resourceGroup, imageGallery, imageDefinition); err != nil {
+		return err
+	}
+
+	versions, err = backend.ListImageVersions(c.Context, subscriptionId, resourceGroup, imageGallery, imageDefinition)
+	if err != nil {
+		return errors.Wrap(err, "failed to list image versions")
+	}
+	latestAfter, _ := latestImageVersion(versions)
+	fmt.Printf("Latest version after %s: %s\n", action, color.GreenString(describeLatestVersion(latestAfter)))
+
+	if action == promotionActionPromote && latestAfter != versionName {
+		color.Yellow("Version %s is included in latest, but a higher version (%s) is the latest version", versionName, latestAfter)
+	}
+
+	return nil
+}
+
+func describeLatestVersion(version string) string {
+	if version == "" {
+		return "none (all versions are excluded from latest)"
+	}
+	return version
+}
+
+// promotionChange sets whether a version is excluded from latest, and records the action in its tags
+type promotionChange struct {
+	version lib_azure.ImageVersion
+	update  lib_azure.ImageVersionUpdate
+}
+
+// planPromotion determines which versions to change. versionName is optional for demote and unused for rollback.
+// returns no changes if the version is already in the requested state
+func planPromotion(action promotionAction, versions []lib_azure.ImageVersion, versionName string, now time.Time) ([]promotionChange, error) {
+	latest, hasLatest := latestImageVersion(versions)
+
+	change := func(version lib_azure.ImageVersion, excludeFromLatest bool) promotionChange {
+		tags := maps.Clone(version.Tags)
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		tags[promotionActionTag] = string(action)
+		tags[promotionTimeTag] = now.UTC().Format(time.RFC3339)
+		tags[promotionPreviousLatestTag] = latest
+
+		return promotionChange{
+			version: version,
+			update: lib_azure.ImageVersionUpdate{
+				ExcludeFromLatest: excludeFromLatest,
+				Tags:              tags,
+			},
+		}
+	}
+
+	switch action {
+	case promotionActionPromote:
+		version, err := findImageVersion(versions, versionName)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(version.ProvisioningState, "Succeeded") {
+			return nil, fmt.Errorf("version %s is not provisioned successfully (%s)", versionName, version.ProvisioningState)
+		}
+		if !version.ExcludeFromLatest {
+			return nil, nil
+		}
+		return []promotionChange{change(version, false)}, nil
+	case promotionActionDemote:
+		if versionName == "" {
+			if !hasLatest {
+				return nil, errors.New("there is no latest version to demote")
+			}
+			versionName = latest
+		}
+		version, err := findImageVersion(versions, versionName)
+		if err != nil {
+			return nil, err
+		}
+		if version.ExcludeFromLatest {
+			return nil, fmt.Errorf("version %s is already excluded from latest", versionName)
+		}
+		return []promotionChange{change(version, true)}, nil
+	case promotionActionRollback:
+		if !hasLatest {
+			return nil, errors.New("there is no latest version to roll back")
+		}
+		current, err := findImageVersion(versions, latest)
+		if err != nil {
+			return nil, err
+		}
+		previous, ok := previousSucceededVersion(versions, latest)
+		if !ok {
+			return nil, fmt.Errorf("there is no version before %s to roll back to", latest)
+		}
+
+		// the previous version is included first, so latest never falls back to an older version if excluding the current one fails
+		var changes []promotionChange
+		if previous.ExcludeFromLatest {
+			changes = append(changes, change(previous, false))
+		}
+		return append(changes, change(current, true)), nil
+	default:
+		return nil, fmt.Errorf("unknown promotion action %s", action)
+	}
+}
+
+func findImageVersion(versions []lib_azure.ImageVersion, versionName string) (lib_azure.ImageVersion, error) {
+	for _, version := range versions {
+		if version.Name == versionName {
+			return version, nil
+		}
+	}
+	return lib_azure.ImageVersion{}, fmt.Errorf("version %s not found", versionName)
+}
+
+// previousSucceededVersion returns the successfully provisioned version with the highest number below versionName
+func previousSucceededVersion(versions []lib_azure.ImageVersion, versionName string) (lib_azure.ImageVersion, bool) {
+	current, err := lib_azure.ParseGalleryVersion(versionName)
+	if err != nil {
+		return lib_azure.ImageVersion{}, false
+	}
+
+	var (
+		previous       lib_azure.ImageVersion
+		previousNumber lib_azure.GalleryVersion
+		found          bool
+	)
+	for _, version := range versions {
+		parsed, err := lib_azure.ParseGalleryVersion(version.Name)
+		if err != nil || parsed.Compare(current) >= 0 || !strings.EqualFold(version.ProvisioningState, "Succeeded") {
+			continue
+		}
+		if !found || parsed.Compare(previousNumber) > 0 {
+			previous, previousNumber, found = version, parsed, true
+		}
+	}
+	return previous, found
+}
+
+func applyPromotionChanges(ctx context.Context, backend lib_azure.Backend, changes []promotionChange, subscriptionId, resourceGroup, imageGallery, imageDefinition string) error {
+	for _, change := range changes {
+		if change.update.ExcludeFromLatest {
+			fmt.Printf("Excluding version %s from latest...", change.version.Name)
+		} else {
+			fmt.Printf("Including version %s in latest...", change.version.Name)
+		}
+
+		if err := backend.UpdateImageVersion(ctx, subscriptionId, resourceGroup, imageGallery, imageDefinition, change.version.Name, change.update); err != nil {
+			color.Red("[FAILED]")
+			return errors.Wrapf(err, "failed to update version %s", change.version.Name)
+		}
+		color.Green("[DONE]")
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/schoolyear/avd-cli/lib/lib_azure/azuretest"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_planPromotion(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	version := func(name string, excludeFromLatest bool, provisioningState string) lib_azure.ImageVersion {
+		return lib_azure.ImageVersion{
+			Name:              name,
+			ProvisioningState: provisioningState,
+			ExcludeFromLatest: excludeFromLatest,
+			Tags:              map[string]string{"owner": "team"},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		action        promotionAction
+		versions      []lib_azure.ImageVersion
+		versionName   string
+		expectChanges map[string]bool // version name => exclude from latest
+		expectLatest  string
+		expectErr     string
+	}{
+		{
+			name:          "promote a tested version",
+			action:        promotionActionPromote,
+			versions:      []lib_azure.ImageVersion{version("1.0.0", false, "Succeeded"), version("1.1.0", true, "Succeeded")},
+			versionName:   "1.1.0",
+			expectChanges: map[string]bool{"1.1.0": false},
+			expectLatest:  "1.1.0",
+		},
+		{
+			name:        "promote a version that is already included",
+			action:      promotionActionPromote,
+			versions:    []lib_azure.ImageVersion{version("1.0.0", false, "Succeeded")},
+			versionName: "1.0.0",
+		},
+		{
+			name:        "promote a failed version",
+			action:      promotionActionPromote,
+			versions:    []lib_azure.ImageVersion{version("1.1.0", true, "Failed")},
+			versionName: "1.1.0",
+			expectErr:   "not provisioned successfully",
+		},
+		{
+			name:          "demote the latest version",
+			action:        promotionActionDemote,
+			versions:      []lib_azure.ImageVersion{version("1.0.0", false, "Succeeded"), version("1.1.0", false, "Succeeded")},
+			expectChanges: map[string]bool{"1.1.0": true},
+			expectLatest:  "1.0.0",
+		},
+		{
+			name:        "demote an excluded version",
+			action:      promotionActionDemote,
+			versions:    []lib_azure.ImageVersion{version("1.0.0", false, "Succeeded"), version("1.1.0", true, "Succeeded")},
+			versionName: "1.1.0",
+			expectErr:   "already excluded",
+		},
+		{
+			name:   "rollback includes the previous version again",
+			action: promotionActionRollback,
+			versions: []lib_azure.ImageVersion{
+				version("1.0.0", false, "Succeeded"),
+				version("1.1.0", true, "Succeeded"),
+				version("1.1.1", true, "Failed"),
+				version("1.2.0", false, "Succeeded"),
+			},
+			expectChanges: map[string]bool{"1.2.0": true, "1.1.0": false},
+			expectLatest:  "1.1.0",
+		},
+		{
+			name:      "rollback without a previous version",
+			action:    promotionActionRollback,
+			versions:  []lib_azure.ImageVersion{version("1.0.0", false, "Succeeded")},
+			expectErr: "no version before 1.0.0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			latestBefore, _ := latestImageVersion(test.versions)
+
+			changes, err := planPromotion(test.action, test.versions, test.versionName, now)
+			if test.expectErr != "" {
+				require.ErrorContains(t, err, test.expectErr)
+				return
+			}
+			require.NoError(t, err)
+
+			backend := azuretest.NewFakeBackend()
+			key := azuretest.FakeImageDefinitionKey("sub", "rg", "gallery", "win11")
+			backend.ImageVersions[key] = test.versions
+
+			changed := map[string]bool{}
+			for _, change := range changes {
+				changed[change.version.Name] = change.update.ExcludeFromLatest
+				require.Equal(t, string(test.action), change.update.Tags[promotionActionTag])
+				require.Equal(t, "2026-10-18T12:00:00Z", change.update.Tags[promotionTimeTag])
+				require.Equal(t, latestBefore, change.update.Tags[promotionPreviousLatestTag])
+				require.Equal(t, "team", change.update.Tags["owner"])
+			}
+			if test.expectChanges == nil {
+				require.Empty(t, changed)
+				return
+			}
+			require.Equal(t, test.expectChanges, changed)
+			for i := 1; i < len(changes); i++ {
+				require.False(t, changes[i-1].update.ExcludeFromLatest && !changes[i].update.ExcludeFromLatest, "versions are included before versions are excluded")
+			}
+
+			require.NoError(t, applyPromotionChanges(t.Context(), backend, changes, "sub", "rg", "gallery", "win11"))
+			latestAfter, _ := latestImageVersion(backend.ImageVersions[key])
+			require.Equal(t, test.expectLatest, latestAfter)
+		})
+	}
+}
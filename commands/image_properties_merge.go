@@ -0,0 +1,320 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+// Layer properties are merged like a JSON merge patch (RFC 7386), which replaces arrays.
+// To change an array instead, a layer replaces it with a merge directive:
+//
+//	"distribute": {"$merge": "append", "$value": [...]}
+//
+// the strategies are:
+//   - append: add the elements after the existing elements
+//   - prepend: add the elements before the existing elements
+//   - replace: replace the array, like a merge patch does
+//   - unique: append the elements that are not in the array yet
+//   - merge: merge objects with the same value for $key (defaults to "name") into the existing object, append the others
+const (
+	mergeDirectiveKey      = "$merge"
+	mergeDirectiveValueKey = "$value"
+	mergeDirectiveKeyKey   = "$key"
+	defaultMergeKey        = "name"
+)
+
+type mergeStrategy string
+
+const (
+	mergeStrategyAppend  mergeStrategy = "append"
+	mergeStrategyPrepend mergeStrategy = "prepend"
+	mergeStrategyReplace mergeStrategy = "replace"
+	mergeStrategyUnique  mergeStrategy = "unique"
+	mergeStrategyMerge   mergeStrategy = "merge"
+)
+
+var mergeStrategies = []mergeStrategy{mergeStrategyAppend, mergeStrategyPrepend, mergeStrategyReplace, mergeStrategyUnique, mergeStrategyMerge}
+
+// mergeOrigin records which layer set a value. Fields and items without an origin of their own were set by the same layer
+type mergeOrigin struct {
+	layer  int
+	fields map[string]*mergeOrigin
+	items  []*mergeOrigin
+}
+
+func (o *mergeOrigin) field(key string) *mergeOrigin {
+	if child, ok := o.fields[key]; ok {
+		return child
+	}
+	return &mergeOrigin{layer: o.layer}
+}
+
+func (o *mergeOrigin) item(i int) *mergeOrigin {
+	if i < len(o.items) && o.items[i] != nil {
+		return o.items[i]
+	}
+	return &mergeOrigin{layer: o.layer}
+}
+
+func decodeMergeDocument(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// mergeValue merges the patch of the layer into the target. The target and its origin are nil if the value does not exist yet
+func mergeValue(target any, targetOrigin *mergeOrigin, patch any, layer int, path string) (any, *mergeOrigin, error) {
+	switch patch := patch.(type) {
+	case map[string]any:
+		if directive, ok := patch[mergeDirectiveKey]; ok {
+			return mergeArray(target, targetOrigin, patch, directive, layer, path)
+		}
+
+		targetObject, ok := target.(map[string]any)
+		if !ok || targetOrigin == nil {
+			targetObject = map[string]any{}
+			targetOrigin = &mergeOrigin{layer: layer}
+		}
+		result := make(map[string]any, len(targetObject)+len(patch))
+		for key, value := range targetObject {
+			result[key] = value
+		}
+		origin := &mergeOrigin{layer: targetOrigin.layer, fields: map[string]*mergeOrigin{}}
+		for key, fieldOrigin := range targetOrigin.fields {
+			origin.fields[key] = fieldOrigin
+		}
+
+		for key, value := range patch {
+			if value == nil {
+				delete(result, key)
+				delete(origin.fields, key)
+				continue
+			}
+
+			var fieldTarget any
+			var fieldOrigin *mergeOrigin
+			if existing, ok := result[key]; ok {
+				fieldTarget, fieldOrigin = existing, targetOrigin.field(key)
+			}
+
+			merged, mergedOrigin, err := mergeValue(fieldTarget, fieldOrigin, value, layer, joinMergePath(path, key))
+			if err != nil {
+				return nil, nil, err
+			}
+			result[key] = merged
+			origin.fields[key] = mergedOrigin
+		}
+		return result, origin, nil
+	case []any:
+		// the array is replaced, but its elements may contain directives
+		items := make([]any, len(patch))
+		for i, item := range patch {
+			var err error
+			if items[i], _, err = mergeValue(nil, nil, item, layer, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, &mergeOrigin{layer: layer}, nil
+	default:
+		return patch, &mergeOrigin{layer: layer}, nil
+	}
+}
+
+func mergeArray(target any, targetOrigin *mergeOrigin, directive map[string]any, strategyValue any, layer int, path string) (any, *mergeOrigin, error) {
+	strategyName, _ := strategyValue.(string)
+	strategy := mergeStrategy(strategyName)
+	if !slices.Contains(mergeStrategies, strategy) {
+		return nil, nil, fmt.Errorf("%s: unknown %s strategy %v. available: %s", path, mergeDirectiveKey, strategyValue, joinMergeStrategies())
+	}
+
+	mergeKey := defaultMergeKey
+	for key, value := range directive {
+		switch key {
+		case mergeDirectiveKey, mergeDirectiveValueKey:
+		case mergeDirectiveKeyKey:
+			keyName, ok := value.(string)
+			if !ok || strategy != mergeStrategyMerge {
+				return nil, nil, fmt.Errorf("%s: %s must be a string and can only be used with the %s strategy", path, mergeDirectiveKeyKey, mergeStrategyMerge)
+			}
+			mergeKey = keyName
+		default:
+			return nil, nil, fmt.Errorf("%s: unexpected key %s next to %s", path, key, mergeDirectiveKey)
+		}
+	}
+
+	patchItems, ok := directive[mergeDirectiveValueKey].([]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: %s must be an array", path, mergeDirectiveValueKey)
+	}
+
+	var targetItems []any
+	if target != nil {
+		if targetItems, ok = target.([]any); !ok {
+			return nil, nil, fmt.Errorf("%s: can't %s to a value that is not an array", path, strategy)
+		}
+	}
+
+	// the elements of the layer, with their directives resolved
+	newItems := make([]any, len(patchItems))
+	for i, item := range patchItems {
+		var err error
+		if newItems[i], _, err = mergeValue(nil, nil, item, layer, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	origin := &mergeOrigin{layer: layer}
+	if targetOrigin != nil && target != nil {
+		origin.layer = targetOrigin.layer
+	}
+	var items []any
+	var itemOrigins []*mergeOrigin
+	addTargetItems := func() {
+		for i, item := range targetItems {
+			items = append(items, item)
+			itemOrigins = append(itemOrigins, targetOrigin.item(i))
+		}
+	}
+	addNewItem := func(item any) {
+		items = append(items, item)
+		itemOrigins = append(itemOrigins, &mergeOrigin{layer: layer})
+	}
+
+	switch strategy {
+	case mergeStrategyReplace:
+		return newItems, &mergeOrigin{layer: layer}, nil
+	case mergeStrategyAppend:
+		addTargetItems()
+		for _, item := range newItems {
+			addNewItem(item)
+		}
+	case mergeStrategyPrepend:
+		for _, item := range newItems {
+			addNewItem(item)
+		}
+		addTargetItems()
+	case mergeStrategyUnique:
+		addTargetItems()
+		for _, item := range newItems {
+			if !slices.ContainsFunc(items, func(existing any) bool { return jsonEqual(existing, item) }) {
+				addNewItem(item)
+			}
+		}
+	case mergeStrategyMerge:
+		addTargetItems()
+		for i, item := range patchItems {
+			object, ok := item.(map[string]any)
+			if !ok || object[mergeKey] == nil {
+				return nil, nil, fmt.Errorf("%s[%d]: elements merged by %s must be objects with a %s", path, i, mergeKey, mergeKey)
+			}
+
+			index := slices.IndexFunc(items, func(existing any) bool {
+				existingObject, ok := existing.(map[string]any)
+				return ok && jsonEqual(existingObject[mergeKey], object[mergeKey])
+			})
+			if index < 0 {
+				addNewItem(newItems[i])
+				continue
+			}
+
+			merged, mergedOrigin, err := mergeValue(items[index], itemOrigins[index], object, layer, fmt.Sprintf("%s[%d]", path, index))
+			if err != nil {
+				return nil, nil, err
+			}
+			items[index], itemOrigins[index] = merged, mergedOrigin
+		}
+	}
+
+	if items == nil {
+		items = []any{}
+	}
+	origin.items = itemOrigins
+	return items, origin, nil
+}
+
+func jsonEqual(a, b any) bool {
+	aJSON, aErr := json.Marshal(a)
+	bJSON, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aJSON, bJSON)
+}
+
+func joinMergeStrategies() string {
+	names := make([]string, len(mergeStrategies))
+	for i, strategy := range mergeStrategies {
+		names[i] = string(strategy)
+	}
+	return strings.Join(names, ", ")
+}
+
+var mergePathIdentifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+
+func joinMergePath(path, key string) string {
+	if !mergePathIdentifierRegex.MatchString(key) {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// mergedValue is a value in the merged document and the index of the layer that set it
+type mergedValue struct {
+	path  string
+	value string
+	layer int
+}
+
+// explainMerge lists the values in the merged document with the layer that set them, in path order
+func explainMerge(value any, origin *mergeOrigin, path string) ([]mergedValue, error) {
+	var values []mergedValue
+	switch value := value.(type) {
+	case map[string]any:
+		if len(value) == 0 {
+			break
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldValues, err := explainMerge(value[key], origin.field(key), joinMergePath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, fieldValues...)
+		}
+		return values, nil
+	case []any:
+		if len(value) == 0 {
+			break
+		}
+		for i, item := range value {
+			itemValues, err := explainMerge(item, origin.item(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, itemValues...)
+		}
+		return values, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to serialize %s", path)
+	}
+	return []mergedValue{{path: path, value: string(data), layer: origin.layer}}, nil
+}
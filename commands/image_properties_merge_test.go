@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mergeValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		layers   []string
+		expected string
+		err      string
+	}{
+		{
+			name:     "arrays are replaced by default",
+			layers:   []string{`{"tags": ["a", "b"], "name": "base"}`, `{"tags": ["c"]}`},
+			expected: `{"name": "base", "tags": ["c"]}`,
+		},
+		{
+			name:     "null deletes",
+			layers:   []string{`{"a": 1, "b": {"c": 2, "d": 3}}`, `{"a": null, "b": {"c": null}}`},
+			expected: `{"b": {"d": 3}}`,
+		},
+		{
+			name:     "append",
+			layers:   []string{`{"tags": ["a", "b"]}`, `{"tags": {"$merge": "append", "$value": ["b", "c"]}}`},
+			expected: `{"tags": ["a", "b", "b", "c"]}`,
+		},
+		{
+			name:     "prepend",
+			layers:   []string{`{"tags": ["a"]}`, `{"tags": {"$merge": "prepend", "$value": ["b"]}}`},
+			expected: `{"tags": ["b", "a"]}`,
+		},
+		{
+			name:     "replace",
+			layers:   []string{`{"tags": ["a"]}`, `{"tags": {"$merge": "replace", "$value": ["b"]}}`},
+			expected: `{"tags": ["b"]}`,
+		},
+		{
+			name:     "unique",
+			layers:   []string{`{"tags": ["a", {"x": 1}]}`, `{"tags": {"$merge": "unique", "$value": ["a", {"x": 1}, "c"]}}`},
+			expected: `{"tags": ["a", {"x": 1}, "c"]}`,
+		},
+		{
+			name:     "append to missing array",
+			layers:   []string{`{}`, `{"tags": {"$merge": "append", "$value": ["a"]}}`},
+			expected: `{"tags": ["a"]}`,
+		},
+		{
+			name: "merge by name",
+			layers: []string{
+				`{"regions": [{"name": "westeurope", "replicas": 1}, {"name": "northeurope", "replicas": 1}]}`,
+				`{"regions": {"$merge": "merge", "$value": [{"name": "northeurope", "replicas": 2}, {"name": "eastus", "replicas": 1}]}}`,
+			},
+			expected: `{"regions": [{"name": "westeurope", "replicas": 1}, {"name": "northeurope", "replicas": 2}, {"name": "eastus", "replicas": 1}]}`,
+		},
+		{
+			name: "merge by key",
+			layers: []string{
+				`{"regions": [{"region": "westeurope", "replicas": 1, "sku": "Standard_LRS"}]}`,
+				`{"regions": {"$merge": "merge", "$key": "region", "$value": [{"region": "westeurope", "sku": null}]}}`,
+			},
+			expected: `{"regions": [{"region": "westeurope", "replicas": 1}]}`,
+		},
+		{
+			name:   "unknown strategy",
+			layers: []string{`{"tags": ["a"]}`, `{"tags": {"$merge": "zip", "$value": ["b"]}}`},
+			err:    "tags: unknown $merge strategy zip",
+		},
+		{
+			name:   "not an array",
+			layers: []string{`{"tags": "a"}`, `{"tags": {"$merge": "append", "$value": ["b"]}}`},
+			err:    "tags: can't append to a value that is not an array",
+		},
+		{
+			name:   "key without merge",
+			layers: []string{`{"tags": ["a"]}`, `{"tags": {"$merge": "append", "$key": "id", "$value": ["b"]}}`},
+			err:    "can only be used with the merge strategy",
+		},
+		{
+			name:   "merge element without key",
+			layers: []string{`{"regions": []}`, `{"regions": {"$merge": "merge", "$value": [{"replicas": 2}]}}`},
+			err:    "regions[0]: elements merged by name must be objects with a name",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				merged any
+				origin *mergeOrigin
+				err    error
+			)
+			for i, layer := range test.layers {
+				patch, decodeErr := decodeMergeDocument([]byte(layer))
+				require.NoError(t, decodeErr)
+				merged, origin, err = mergeValue(merged, origin, patch, i, "")
+				if err != nil {
+					break
+				}
+			}
+
+			if test.err != "" {
+				require.ErrorContains(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+
+			mergedJSON, err := json.Marshal(merged)
+			require.NoError(t, err)
+			require.JSONEq(t, test.expected, string(mergedJSON))
+		})
+	}
+}
+
+func Test_explainMerge(t *testing.T) {
+	layers := []string{
+		`{"name": "base", "regions": [{"name": "westeurope", "replicas": 1}], "tags": {"team": "it"}}`,
+		`{"regions": {"$merge": "merge", "$value": [{"name": "westeurope", "replicas": 2}, {"name": "eastus"}]}, "tags": {"my tag": "x"}}`,
+	}
+
+	var (
+		merged any
+		origin *mergeOrigin
+	)
+	for i, layer := range layers {
+		patch, err := decodeMergeDocument([]byte(layer))
+		require.NoError(t, err)
+		merged, origin, err = mergeValue(merged, origin, patch, i, "")
+		require.NoError(t, err)
+	}
+
+	values, err := explainMerge(merged, origin, "")
+	require.NoError(t, err)
+	require.Equal(t, []mergedValue{
+		{path: "name", value: `"base"`, layer: 0},
+		{path: "regions[0].name", value: `"westeurope"`, layer: 1},
+		{path: "regions[0].replicas", value: "2", layer: 1},
+		{path: "regions[1].name", value: `"eastus"`, layer: 1},
+		{path: `tags["my tag"]`, value: `"x"`, layer: 1},
+		{path: "tags.team", value: `"it"`, layer: 0},
+	}, values)
+}
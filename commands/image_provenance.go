@@ -0,0 +1,506 @@
+package commands
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	stdErr "errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/schoolyear/avd-cli/schema"
+	avdimagetypes "github.com/schoolyear/avd-image-types"
+	"github.com/urfave/cli/v2"
+)
+
+var imageGalleryLookupFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "subscription-id",
+		Usage:   "Azure subscription ID",
+		Aliases: []string{"sid"},
+	},
+	&cli.StringFlag{
+		Name:    "resource-group",
+		Usage:   "Name of the Resource Group of the Image Gallery",
+		Aliases: []string{"g"},
+	},
+	azureBackendFlag,
+	azureTenantIdFlag,
+	commandProfileFlag,
+}
+
+var ImageListCommand = &cli.Command{
+	Name:      "list",
+	Usage:     "List the versions of an image definition with their build date, replication state and which one is the latest",
+	ArgsUsage: "<gallery>/<image-definition>",
+	Flags: append(slices.Clone(imageGalleryLookupFlags),
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output the versions as JSON",
+		},
+	),
+	Before: applyProfile("subscription-id", "resource-group"),
+	Action: func(c *cli.Context) error {
+		subscriptionId := c.String("subscription-id")
+		resourceGroup := c.String("resource-group")
+		outputJson := c.Bool("json")
+
+		parts, err := splitImagePath(c.Args().First(), "<gallery>/<image-definition>")
+		if err != nil {
+			return err
+		}
+		gallery, imageDefinition := parts[0], parts[1]
+
+		backend, err := azureBackend(c)
+		if err != nil {
+			return err
+		}
+
+		versions, err := listImageVersionsWithReplication(c.Context, backend, subscriptionId, resourceGroup, gallery, imageDefinition, !outputJson)
+		if err != nil {
+			return err
+		}
+
+		rows := imageVersionRows(versions)
+		if outputJson {
+			return printJSON(rows)
+		}
+
+		if len(rows) == 0 {
+			fmt.Printf("Image definition %s has no versions\n", imageDefinition)
+			return nil
+		}
+
+		fmt.Printf("%-16s  %-16s  %-12s  %-12s  %-6s  %s\n", "VERSION", "PUBLISHED", "PROVISIONING", "REPLICATION", "LATEST", "REGIONS")
+		for _, row := range rows {
+			published := "-"
+			if row.PublishedDate != nil {
+				published = row.PublishedDate.Local().Format("2006-01-02 15:04")
+			}
+			latest := ""
+			switch {
+			case row.Latest:
+				latest = color.GreenString("%-6s", "yes")
+			case row.ExcludeFromLatest:
+				latest = fmt.Sprintf("%-6s", "excl.")
+			default:
+				latest = fmt.Sprintf("%-6s", "")
+			}
+
+			fmt.Printf("%-16s  %-16s  %-12s  %-12s  %s  %s\n",
+				row.Name,
+				published,
+				row.ProvisioningState,
+				valueOrDash(row.ReplicationState),
+				latest,
+				strings.Join(row.TargetRegions, ", "),
+			)
+		}
+
+		return nil
+	},
+}
+
+var ImageProvenanceCommand = &cli.Command{
+	Name:      "provenance",
+	Usage:     "Show the layers, sources, base image, CLI version and build parameters that an image version was built from",
+	ArgsUsage: "<gallery>/<image-definition>/<version>",
+	Flags: append(slices.Clone(imageGalleryLookupFlags),
+		&cli.BoolFlag{
+			Name:  "no-sources",
+			Usage: "Do not look up the layer sources and bundle version in the bundle archive. Only the bundle properties are downloaded",
+		},
+		&cli.BoolFlag{
+			Name:  "download-archive",
+			Usage: "Download the whole bundle archive to look up the layer sources, instead of reading only the sources file from it. Use this if ranged reads of the archive fail",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output the provenance as JSON",
+		},
+	),
+	Before: applyProfile("subscription-id", "resource-group"),
+	Action: func(c *cli.Context) error {
+		subscriptionId := c.String("subscription-id")
+		resourceGroup := c.String("resource-group")
+		sources := provenanceSourcesRanged
+		switch {
+		case c.Bool("no-sources"):
+			sources = provenanceSourcesNone
+		case c.Bool("download-archive"):
+			sources = provenanceSourcesFullArchive
+		}
+		outputJson := c.Bool("json")
+
+		parts, err := splitImagePath(c.Args().First(), "<gallery>/<image-definition>/<version>")
+		if err != nil {
+			return err
+		}
+		gallery, imageDefinition, versionName := parts[0], parts[1], parts[2]
+
+		backend, err := azureBackend(c)
+		if err != nil {
+			return err
+		}
+
+		version, err := backend.GetImageVersion(c.Context, subscriptionId, resourceGroup, gallery, imageDefinition, versionName)
+		if err != nil {
+			if errors.Is(err, lib_azure.ErrImageVersionNotFound) {
+				return fmt.Errorf("version %s of image definition %s does not exist in gallery %s", versionName, imageDefinition, gallery)
+			}
+			return errors.Wrap(err, "failed to get image version")
+		}
+
+		provenance, err := loadImageProvenance(c.Context, backend, *version, sources)
+		if err != nil {
+			return err
+		}
+
+		if outputJson {
+			return printJSON(provenance)
+		}
+
+		printImageProvenance(provenance)
+		return nil
+	},
+}
+
+// splitImagePath splits a path like <gallery>/<image-definition> into its parts, as many as in the usage
+func splitImagePath(value, usage string) ([]string, error) {
+	expected := strings.Count(usage, "/") + 1
+	parts := strings.Split(value, "/")
+	if len(parts) != expected || slices.Contains(parts, "") {
+		return nil, fmt.Errorf("expected an argument in the form %s, got \"%s\"", usage, value)
+	}
+	return parts, nil
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// maxConcurrentVersionLookups limits the number of image versions that are requested at the same time
+const maxConcurrentVersionLookups = 8
+
+// listImageVersionsWithReplication gets every version separately, because listing the versions does not return their replication state
+// (the list API has no $expand). the versions are requested concurrently
+func listImageVersionsWithReplication(ctx context.Context, backend lib_azure.Backend, subscriptionId, resourceGroup, gallery, imageDefinition string, printProgress bool) ([]lib_azure.ImageVersion, error) {
+	if printProgress {
+		fmt.Printf("Listing the versions of %s (%s)...", imageDefinition, backend.Name())
+	}
+	versions, err := backend.ListImageVersions(ctx, subscriptionId, resourceGroup, gallery, imageDefinition)
+	if err != nil {
+		if printProgress {
+			color.Red("[FAILED]")
+		}
+		return nil, errors.Wrap(err, "failed to list image versions")
+	}
+
+	errs := make([]error, len(versions))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentVersionLookups)
+	for i, version := range versions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			detailed, err := backend.GetImageVersion(ctx, subscriptionId, resourceGroup, gallery, imageDefinition, version.Name)
+			switch {
+			case errors.Is(err, lib_azure.ErrImageVersionNotFound):
+				// deleted while listing
+			case err != nil:
+				errs[i] = errors.Wrapf(err, "failed to get the replication state of version %s", version.Name)
+			default:
+				versions[i] = *detailed
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := stdErr.Join(errs...); err != nil {
+		if printProgress {
+			color.Red("[FAILED]")
+		}
+		return nil, err
+	}
+	if printProgress {
+		color.Green("[DONE]")
+	}
+
+	return versions, nil
+}
+
+type imageVersionRow struct {
+	Name              string     `json:"name"`
+	PublishedDate     *time.Time `json:"published_date,omitempty"`
+	ProvisioningState string     `json:"provisioning_state"`
+	ReplicationState  string     `json:"replication_state,omitempty"`
+	TargetRegions     []string   `json:"target_regions,omitempty"`
+	ExcludeFromLatest bool       `json:"exclude_from_latest"`
+	Latest            bool       `json:"latest"`
+}
+
+// imageVersionRows describes the versions newest first, marking the version Azure uses as latest
+func imageVersionRows(versions []lib_azure.ImageVersion) []imageVersionRow {
+	latest, hasLatest := latestImageVersion(versions)
+
+	sorted := slices.Clone(versions)
+	slices.SortStableFunc(sorted, compareImageVersionsNewestFirst)
+
+	rows := make([]imageVersionRow, len(sorted))
+	for i, version := range sorted {
+		rows[i] = imageVersionRow{
+			Name:              version.Name,
+			PublishedDate:     version.PublishedDate,
+			ProvisioningState: version.ProvisioningState,
+			ReplicationState:  version.ReplicationState,
+			TargetRegions:     version.TargetRegions,
+			ExcludeFromLatest: version.ExcludeFromLatest,
+			Latest:            hasLatest && version.Name == latest,
+		}
+	}
+	return rows
+}
+
+type imageProvenance struct {
+	ImageVersion     string                       `json:"image_version"`
+	ImageVersionId   string                       `json:"image_version_id,omitempty"`
+	PublishedDate    *time.Time                   `json:"published_date,omitempty"`
+	BundleURL        string                       `json:"bundle_url"`
+	BundleArchiveURL string                       `json:"bundle_archive_url,omitempty"`
+	BundleVersion    string                       `json:"bundle_version,omitempty"`
+	CliVersion       string                       `json:"cli_version"`
+	BaseImage        *avdimagetypes.V2BaseImage   `json:"base_image,omitempty"`
+	Layers           []imageProvenanceLayer       `json:"layers"`
+	BuildParameters  map[string]string            `json:"build_parameters,omitempty"`
+	WindowsUpdate    *schema.V2WindowsUpdateStage `json:"windows_update,omitempty"`
+}
+
+type imageProvenanceLayer struct {
+	Name   string `json:"name"`
+	Source string `json:"source,omitempty"`
+}
+
+// provenanceSources selects how the layer sources are looked up in the bundle archive
+type provenanceSources int
+
+const (
+	provenanceSourcesNone provenanceSources = iota
+	// provenanceSourcesRanged reads only the sources file, using ranged reads of the zip directory and entry
+	provenanceSourcesRanged
+	provenanceSourcesFullArchive
+)
+
+// loadImageProvenance downloads the bundle properties that the version is tagged with.
+// unless sources is provenanceSourcesNone, the bundle archive is read as well to look up where the layers came from
+func loadImageProvenance(ctx context.Context, backend lib_azure.Backend, version lib_azure.ImageVersion, sources provenanceSources) (*imageProvenance, error) {
+	bundleURL := version.Tags[bundlePropertiesUrlTag]
+	if bundleURL == "" {
+		return nil, fmt.Errorf("version %s has no %s tag, it was not built with \"avdcli bundle autobuild\"", version.Name, bundlePropertiesUrlTag)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "avdcli-provenance-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	propertiesPath := filepath.Join(tmpDir, schema.V2BundlePropertiesFilename)
+	if err := downloadBlobURL(ctx, backend, bundleURL, propertiesPath); err != nil {
+		return nil, errors.Wrap(err, "failed to download bundle properties")
+	}
+
+	data, err := os.ReadFile(propertiesPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read bundle properties")
+	}
+	var bundleProperties avdimagetypes.V2BundleProperties
+	if err := json.Unmarshal(data, &bundleProperties); err != nil {
+		return nil, errors.Wrap(err, "failed to parse bundle properties")
+	}
+
+	provenance := &imageProvenance{
+		ImageVersion:     version.Name,
+		ImageVersionId:   version.ID,
+		PublishedDate:    version.PublishedDate,
+		BundleURL:        bundleURL,
+		BundleArchiveURL: version.Tags[bundleArchiveUrlTag],
+		CliVersion:       bundleProperties.CliVersion,
+		BaseImage:        bundleProperties.BaseImage,
+	}
+
+	for _, layer := range bundleProperties.Layers {
+		provenance.Layers = append(provenance.Layers, imageProvenanceLayer{Name: layer.Name})
+	}
+
+	params := make(map[string]string)
+	for layerName, layerParams := range bundleProperties.BuildParameters {
+		for paramName, param := range layerParams {
+			params[layerName+"."+paramName] = param.Value
+		}
+	}
+	provenance.BuildParameters = lib.RedactParameters(params)
+
+	if sources != provenanceSourcesNone && provenance.BundleArchiveURL != "" {
+		var (
+			bundleSources *schema.V2BundleSources
+			err           error
+		)
+		if sources == provenanceSourcesFullArchive {
+			archivePath := filepath.Join(tmpDir, "bundle.zip")
+			if err := downloadBlobURL(ctx, backend, provenance.BundleArchiveURL, archivePath); err != nil {
+				return nil, errors.Wrap(err, "failed to download bundle archive")
+			}
+			bundleSources, err = readBundleSources(archivePath)
+		} else {
+			bundleSources, err = readRemoteBundleSources(ctx, backend, provenance.BundleArchiveURL)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bundleSources != nil {
+			applyBundleSources(provenance, bundleSources)
+		}
+	}
+
+	return provenance, nil
+}
+
+// applyBundleSources adds the layer sources to the layers with the same name
+func applyBundleSources(provenance *imageProvenance, sources *schema.V2BundleSources) {
+	provenance.BundleVersion = sources.Version
+	provenance.WindowsUpdate = sources.WindowsUpdate
+
+	for i, layer := range provenance.Layers {
+		for _, source := range sources.Layers {
+			if source.Name == layer.Name {
+				provenance.Layers[i].Source = source.Source
+				break
+			}
+		}
+	}
+}
+
+// readRemoteBundleSources reads the layer sources from the bundle archive in blob storage.
+// only the zip directory and the sources file are read, not the whole archive
+func readRemoteBundleSources(ctx context.Context, backend lib_azure.Backend, archiveURL string) (*schema.V2BundleSources, error) {
+	storageAccount, container, blobName, err := lib_azure.ParseBlobURL(archiveURL)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := backend.GetBlobSize(ctx, storageAccount, container, blobName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the size of the bundle archive")
+	}
+
+	archive, err := zip.NewReader(&blobReaderAt{
+		ctx:            ctx,
+		backend:        backend,
+		storageAccount: storageAccount,
+		container:      container,
+		blobName:       blobName,
+	}, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the bundle archive")
+	}
+
+	return readBundleSourcesFromArchive(archive)
+}
+
+// blobReaderAt reads a blob with ranged reads
+type blobReaderAt struct {
+	ctx                                 context.Context
+	backend                             lib_azure.Backend
+	storageAccount, container, blobName string
+}
+
+func (b *blobReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	data, err := b.backend.ReadBlobRange(b.ctx, b.storageAccount, b.container, b.blobName, offset, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+
+	n := copy(p, data)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func downloadBlobURL(ctx context.Context, backend lib_azure.Backend, blobURL, targetPath string) error {
+	storageAccount, container, blobName, err := lib_azure.ParseBlobURL(blobURL)
+	if err != nil {
+		return err
+	}
+
+	return backend.DownloadBlob(ctx, storageAccount, container, blobName, targetPath)
+}
+
+func printImageProvenance(provenance *imageProvenance) {
+	fmt.Printf("Image version:    %s\n", color.CyanString(provenance.ImageVersion))
+	if provenance.PublishedDate != nil {
+		fmt.Printf("Published:        %s\n", provenance.PublishedDate.Local().Format(time.RFC1123))
+	}
+	if provenance.BundleVersion != "" {
+		fmt.Printf("Bundle version:   %s\n", provenance.BundleVersion)
+	}
+	fmt.Printf("CLI version:      %s\n", valueOrDash(provenance.CliVersion))
+	if provenance.BaseImage != nil {
+		fmt.Printf("Base image:       %s\n", baseImageToString(provenance.BaseImage))
+	}
+	fmt.Printf("Bundle:           %s\n", provenance.BundleURL)
+	if provenance.BundleArchiveURL != "" {
+		fmt.Printf("Bundle archive:   %s\n", provenance.BundleArchiveURL)
+	}
+
+	if provenance.WindowsUpdate != nil {
+		before, after := "no", "no"
+		if provenance.WindowsUpdate.Before {
+			before = "yes"
+		}
+		if provenance.WindowsUpdate.After {
+			after = "yes"
+		}
+		fmt.Printf("Windows update:   before bundle: %s, after bundle: %s\n", before, after)
+	}
+
+	if len(provenance.Layers) > 0 {
+		fmt.Println("Layers:")
+		for _, layer := range provenance.Layers {
+			if layer.Source != "" {
+				fmt.Printf("    - %s (%s)\n", layer.Name, layer.Source)
+			} else {
+				fmt.Printf("    - %s\n", layer.Name)
+			}
+		}
+	}
+
+	if len(provenance.BuildParameters) > 0 {
+		fmt.Println("Build parameters:")
+		keys := make([]string, 0, len(provenance.BuildParameters))
+		for key := range provenance.BuildParameters {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("    %s = %s\n", key, provenance.BuildParameters[key])
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package commands
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/schoolyear/avd-cli/lib/lib_azure/azuretest"
+	"github.com/schoolyear/avd-cli/schema"
+	avdimagetypes "github.com/schoolyear/avd-image-types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_imageVersionRows(t *testing.T) {
+	published := func(day int) *time.Time {
+		at := time.Date(2026, time.June, day, 12, 0, 0, 0, time.UTC)
+		return &at
+	}
+
+	rows := imageVersionRows([]lib_azure.ImageVersion{
+		{Name: "1.0.0", PublishedDate: published(1), ProvisioningState: "Succeeded", ReplicationState: "Completed"},
+		{Name: "1.2.0", PublishedDate: published(3), ProvisioningState: "Succeeded", ExcludeFromLatest: true, ReplicationState: "InProgress"},
+		{Name: "1.1.0", PublishedDate: published(2), ProvisioningState: "Succeeded", ReplicationState: "Completed"},
+	})
+
+	names := make([]string, len(rows))
+	for i, row := range rows {
+		names[i] = row.Name
+	}
+	require.Equal(t, []string{"1.2.0", "1.1.0", "1.0.0"}, names)
+	require.False(t, rows[0].Latest)
+	require.True(t, rows[0].ExcludeFromLatest)
+	require.True(t, rows[1].Latest)
+	require.False(t, rows[2].Latest)
+}
+
+func Test_splitImagePath(t *testing.T) {
+	parts, err := splitImagePath("gallery/win11/1.0.0", "<gallery>/<image-definition>/<version>")
+	require.NoError(t, err)
+	require.Equal(t, []string{"gallery", "win11", "1.0.0"}, parts)
+
+	_, err = splitImagePath("gallery/win11", "<gallery>/<image-definition>/<version>")
+	require.Error(t, err)
+
+	_, err = splitImagePath("gallery/", "<gallery>/<image-definition>")
+	require.Error(t, err)
+}
+
+func Test_loadImageProvenance(t *testing.T) {
+	fake := azuretest.NewFakeAzure()
+	backend, server, err := azuretest.NewSDKBackend(fake)
+	require.NoError(t, err)
+	defer server.Close()
+
+	bundleProperties, err := json.Marshal(avdimagetypes.V2BundleProperties{
+		Version:    avdimagetypes.V2BundlePropertiesVersionV2,
+		CliVersion: "1.4.0",
+		Layers:     []avdimagetypes.V2LayerProperties{{Name: "base"}, {Name: "exam-browser"}},
+		BaseImage: &avdimagetypes.V2BaseImage{PlatformImage: &avdimagetypes.V2PlatformImage{
+			Publisher: "MicrosoftWindowsDesktop", Offer: "windows-11", Sku: "win11-24h2-avd", Version: "latest",
+		}},
+		BuildParameters: map[string]map[string]avdimagetypes.BuildParameterValue{
+			"exam-browser": {"version": {Value: "3.2"}, "api_key": {Value: "secret-value"}},
+		},
+	})
+	require.NoError(t, err)
+	fake.Blobs["/sa/bundles/bundle.json"] = bundleProperties
+
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	// a large layer file, which must not be downloaded to read the sources
+	layerFile, err := zipWriter.CreateHeader(&zip.FileHeader{Name: "0_base/payload.bin", Method: zip.Store})
+	require.NoError(t, err)
+	_, err = layerFile.Write(bytes.Repeat([]byte{0xAB}, 4<<20))
+	require.NoError(t, err)
+	sourcesFile, err := zipWriter.Create(schema.V2BundleSourcesFilename)
+	require.NoError(t, err)
+	require.NoError(t, json.NewEncoder(sourcesFile).Encode(schema.V2BundleSources{
+		Version: "2026.06",
+		Layers: []schema.V2BundleLayerSource{
+			{Directory: "0_base", Name: "base", Source: "./layers/base"},
+			{Directory: "1_exam-browser", Name: "exam-browser", Source: "git+https://example.com/layers.git#v1.2"},
+		},
+	}))
+	require.NoError(t, zipWriter.Close())
+	fake.Blobs["/sa/bundles/bundle.zip"] = archive.Bytes()
+
+	version := lib_azure.ImageVersion{
+		Name: "1.0.0",
+		Tags: map[string]string{
+			bundlePropertiesUrlTag: lib_azure.BlobURL("sa", "bundles", "bundle.json"),
+			bundleArchiveUrlTag:    lib_azure.BlobURL("sa", "bundles", "bundle.zip"),
+		},
+	}
+
+	provenance, err := loadImageProvenance(context.Background(), backend, version, provenanceSourcesRanged)
+	require.NoError(t, err)
+	require.Less(t, fake.DownloadedBytes["/sa/bundles/bundle.zip"], 64<<10, "only the zip directory and the sources file are read")
+	require.Equal(t, "1.4.0", provenance.CliVersion)
+	require.Equal(t, "2026.06", provenance.BundleVersion)
+	require.Equal(t, "windows-11", provenance.BaseImage.PlatformImage.Offer)
+	require.Equal(t, []imageProvenanceLayer{
+		{Name: "base", Source: "./layers/base"},
+		{Name: "exam-browser", Source: "git+https://example.com/layers.git#v1.2"},
+	}, provenance.Layers)
+	require.Equal(t, map[string]string{
+		"exam-browser.version": "3.2",
+		"exam-browser.api_key": "<redacted>",
+	}, provenance.BuildParameters)
+
+	fake.DownloadedBytes = map[string]int{}
+	fullProvenance, err := loadImageProvenance(context.Background(), backend, version, provenanceSourcesFullArchive)
+	require.NoError(t, err)
+	require.Equal(t, provenance, fullProvenance)
+	require.Equal(t, archive.Len(), fake.DownloadedBytes["/sa/bundles/bundle.zip"])
+
+	provenance, err = loadImageProvenance(context.Background(), backend, version, provenanceSourcesNone)
+	require.NoError(t, err)
+	require.Empty(t, provenance.BundleVersion)
+	require.Empty(t, provenance.Layers[0].Source)
+
+	_, err = loadImageProvenance(context.Background(), backend, lib_azure.ImageVersion{Name: "0.9.0"}, provenanceSourcesRanged)
+	require.ErrorContains(t, err, bundlePropertiesUrlTag)
+}
+
+// concurrencyBackend tracks how many image versions are requested at the same time
+type concurrencyBackend struct {
+	*azuretest.FakeBackend
+	lock                 sync.Mutex
+	current, maxObserved int
+}
+
+func (b *concurrencyBackend) GetImageVersion(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string) (*lib_azure.ImageVersion, error) {
+	b.lock.Lock()
+	b.current++
+	b.maxObserved = max(b.maxObserved, b.current)
+	b.lock.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	b.lock.Lock()
+	b.current--
+	b.lock.Unlock()
+
+	return b.FakeBackend.GetImageVersion(ctx, subscriptionId, resourceGroup, gallery, imageDefinition, version)
+}
+
+func Test_listImageVersionsWithReplication(t *testing.T) {
+	fake := azuretest.NewFakeBackend()
+	var versions []lib_azure.ImageVersion
+	for i := range 20 {
+		versions = append(versions, lib_azure.ImageVersion{Name: fmt.Sprintf("1.0.%d", i), ReplicationState: "Completed"})
+	}
+	fake.ImageVersions[azuretest.FakeImageDefinitionKey("sub", "rg", "gallery", "win11")] = versions
+
+	backend := &concurrencyBackend{FakeBackend: fake}
+	listed, err := listImageVersionsWithReplication(context.Background(), backend, "sub", "rg", "gallery", "win11", false)
+	require.NoError(t, err)
+	require.Len(t, listed, 20)
+	for i, version := range listed {
+		require.Equal(t, fmt.Sprintf("1.0.%d", i), version.Name)
+		require.Equal(t, "Completed", version.ReplicationState)
+	}
+	require.Greater(t, backend.maxObserved, 1, "versions are requested concurrently")
+	require.LessOrEqual(t, backend.maxObserved, maxConcurrentVersionLookups)
+}
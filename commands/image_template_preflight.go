@@ -0,0 +1,228 @@
+package commands
+
+import (
+	stdErr "errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+)
+
+// limits of the Azure Image Builder
+const (
+	imageTemplateMaxBuildTimeoutMinutes = 960
+	imageTemplateMinBuildTimeoutMinutes = 6
+	imageTemplateMinDiskSizeGB          = 30
+	imageTemplateMaxDiskSizeGB          = 4095
+	imageTemplateMaxReplicaCount        = 100
+)
+
+const (
+	managedIdentityResourceType      = "Microsoft.ManagedIdentity/userAssignedIdentities"
+	imageDefinitionResourceType      = "Microsoft.Compute/galleries/images"
+	imageVersionResourceType         = "Microsoft.Compute/galleries/images/versions"
+	managedImageResourceType         = "Microsoft.Compute/images"
+	imageTemplateNameMaxLength       = 64
+	imageTemplateRunOutputNameLength = 64
+)
+
+var (
+	imageTemplateNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	vmSizeRegex            = regexp.MustCompile(`^(Standard|Basic)_[A-Za-z0-9]+(_[A-Za-z0-9]+)*$`)
+)
+
+// preflightImageTemplate checks the Image Template for mistakes that would otherwise only surface when it is deployed.
+// all problems are returned together
+func preflightImageTemplate(template *armvirtualmachineimagebuilder.ImageTemplate) error {
+	var problems []error
+	addProblem := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	name := deref(template.Name)
+	switch {
+	case name == "":
+		addProblem("the template name is empty")
+	case len(name) > imageTemplateNameMaxLength:
+		addProblem("the template name %s is %d characters long, the maximum is %d", name, len(name), imageTemplateNameMaxLength)
+	case !imageTemplateNameRegex.MatchString(name):
+		addProblem("the template name %s may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit", name)
+	}
+
+	if location := deref(template.Location); location == "" {
+		addProblem("the template location is empty")
+	} else if normalized, _ := lib_azure.NormalizeRegion(location); !lib_azure.IsValidRegionName(normalized) {
+		addProblem("the template location %s is not a valid Azure region name", location)
+	}
+
+	// resource ids are case-insensitive
+	templateIdentities := map[string]struct{}{}
+	if template.Identity == nil || len(template.Identity.UserAssignedIdentities) == 0 {
+		addProblem("the template has no managed identity")
+	} else {
+		for identityId := range template.Identity.UserAssignedIdentities {
+			if _, err := lib_azure.ParseResourceIDOfType(identityId, managedIdentityResourceType); err != nil {
+				addProblem("invalid managed identity: %w", err)
+			}
+			templateIdentities[strings.ToLower(identityId)] = struct{}{}
+		}
+	}
+
+	props := template.Properties
+	if props == nil {
+		addProblem("the template has no properties")
+		return preflightResult(problems)
+	}
+
+	if timeout := deref(props.BuildTimeoutInMinutes); timeout != 0 && (timeout < imageTemplateMinBuildTimeoutMinutes || timeout > imageTemplateMaxBuildTimeoutMinutes) {
+		addProblem("the build timeout is %d minutes, it must be between %d and %d minutes", timeout, imageTemplateMinBuildTimeoutMinutes, imageTemplateMaxBuildTimeoutMinutes)
+	}
+
+	if vmProfile := props.VMProfile; vmProfile != nil {
+		if vmSize := deref(vmProfile.VMSize); vmSize != "" && !vmSizeRegex.MatchString(vmSize) {
+			addProblem("the builder VM size %s is not a valid VM size name, like Standard_D2s_v4", vmSize)
+		}
+		if diskSize := deref(vmProfile.OSDiskSizeGB); diskSize != 0 && (diskSize < imageTemplateMinDiskSizeGB || diskSize > imageTemplateMaxDiskSizeGB) {
+			addProblem("the builder disk size is %d GB, it must be between %d and %d GB", diskSize, imageTemplateMinDiskSizeGB, imageTemplateMaxDiskSizeGB)
+		}
+
+		// the builder VM downloads the bundle with the managed identity of the template
+		seenVMIdentities := map[string]struct{}{}
+		for _, identityId := range vmProfile.UserAssignedIdentities {
+			id := deref(identityId)
+			if _, err := lib_azure.ParseResourceIDOfType(id, managedIdentityResourceType); err != nil {
+				addProblem("invalid managed identity of the builder VM: %w", err)
+			}
+			if _, ok := seenVMIdentities[strings.ToLower(id)]; ok {
+				addProblem("managed identity %s is assigned to the builder VM more than once", id)
+			}
+			seenVMIdentities[strings.ToLower(id)] = struct{}{}
+
+			if _, ok := templateIdentities[strings.ToLower(id)]; !ok && len(templateIdentities) > 0 {
+				addProblem("managed identity %s is assigned to the builder VM, but not to the template", id)
+			}
+		}
+		if template.Identity != nil {
+			for identityId := range template.Identity.UserAssignedIdentities {
+				if _, ok := seenVMIdentities[strings.ToLower(identityId)]; !ok {
+					addProblem("managed identity %s of the template is not assigned to the builder VM", identityId)
+				}
+			}
+		}
+
+		if vmProfile.VnetConfig != nil {
+			problems = append(problems, preflightVnetConfig(vmProfile.VnetConfig)...)
+		}
+	}
+
+	problems = append(problems, preflightImageSource(props.Source)...)
+
+	if len(props.Distribute) == 0 {
+		addProblem("the template has no distribution targets")
+	}
+	seenRunOutputNames := map[string]struct{}{}
+	for _, distributor := range props.Distribute {
+		runOutputName := deref(distributor.GetImageTemplateDistributor().RunOutputName)
+		if runOutputName == "" || len(runOutputName) > imageTemplateRunOutputNameLength || !imageTemplateNameRegex.MatchString(runOutputName) {
+			addProblem("invalid run output name %q", runOutputName)
+		}
+		if _, ok := seenRunOutputNames[runOutputName]; ok {
+			addProblem("run output name %s is used more than once", runOutputName)
+		}
+		seenRunOutputNames[runOutputName] = struct{}{}
+
+		if sharedImage, ok := distributor.(*armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor); ok {
+			problems = append(problems, preflightSharedImageDistributor(sharedImage)...)
+		}
+	}
+
+	seenCustomizerNames := map[string]struct{}{}
+	for _, customizer := range props.Customize {
+		customizerName := deref(customizer.GetImageTemplateCustomizer().Name)
+		if customizerName == "" {
+			continue
+		}
+		if _, ok := seenCustomizerNames[customizerName]; ok {
+			addProblem("customizer name %s is used more than once", customizerName)
+		}
+		seenCustomizerNames[customizerName] = struct{}{}
+	}
+
+	return preflightResult(problems)
+}
+
+func preflightImageSource(source armvirtualmachineimagebuilder.ImageTemplateSourceClassification) []error {
+	switch source := source.(type) {
+	case nil:
+		return []error{fmt.Errorf("the template has no base image")}
+	case *armvirtualmachineimagebuilder.ImageTemplateManagedImageSource:
+		if _, err := lib_azure.ParseResourceIDOfType(deref(source.ImageID), managedImageResourceType); err != nil {
+			return []error{fmt.Errorf("invalid managed base image: %w", err)}
+		}
+	case *armvirtualmachineimagebuilder.ImageTemplateSharedImageVersionSource:
+		if _, err := lib_azure.ParseResourceIDOfType(deref(source.ImageVersionID), imageVersionResourceType); err != nil {
+			return []error{fmt.Errorf("invalid shared image version base image: %w", err)}
+		}
+	case *armvirtualmachineimagebuilder.ImageTemplatePlatformImageSource:
+		var problems []error
+		fields := []struct {
+			name  string
+			value *string
+		}{
+			{"publisher", source.Publisher},
+			{"offer", source.Offer},
+			{"sku", source.SKU},
+			{"version", source.Version},
+		}
+		for _, field := range fields {
+			if deref(field.value) == "" {
+				problems = append(problems, fmt.Errorf("the %s of the platform base image is empty", field.name))
+			}
+		}
+		return problems
+	}
+	return nil
+}
+
+func preflightSharedImageDistributor(distributor *armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor) []error {
+	var problems []error
+
+	galleryImageId := deref(distributor.GalleryImageID)
+	if _, err := lib_azure.ParseResourceIDOfType(galleryImageId, imageDefinitionResourceType); err != nil {
+		if _, versionErr := lib_azure.ParseResourceIDOfType(galleryImageId, imageVersionResourceType); versionErr != nil {
+			problems = append(problems, fmt.Errorf("invalid gallery image: %w", err))
+		}
+	}
+
+	if len(distributor.TargetRegions) == 0 {
+		problems = append(problems, fmt.Errorf("the gallery distribution has no target regions"))
+	}
+	for _, region := range distributor.TargetRegions {
+		name := deref(region.Name)
+		if normalized, _ := lib_azure.NormalizeRegion(name); !lib_azure.IsValidRegionName(normalized) {
+			problems = append(problems, fmt.Errorf("target region %s is not a valid Azure region name", name))
+		}
+		if count := deref(region.ReplicaCount); count < 1 || count > imageTemplateMaxReplicaCount {
+			problems = append(problems, fmt.Errorf("the replica count of region %s is %d, it must be between 1 and %d", name, count, imageTemplateMaxReplicaCount))
+		}
+	}
+
+	return problems
+}
+
+func preflightResult(problems []error) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("the Image Template has %d problem(s):\n%w", len(problems), stdErr.Join(problems...))
+}
+
+func deref[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
+	}
+	return *v
+}
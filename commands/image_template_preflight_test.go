@@ -0,0 +1,152 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	avdimagetypes "github.com/schoolyear/avd-image-types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_preflightImageTemplate(t *testing.T) {
+	const (
+		identity       = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/builder"
+		galleryImageId = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/win11"
+	)
+
+	newTemplate := func() *armvirtualmachineimagebuilder.ImageTemplate {
+		return buildImageTemplate(
+			"win11-2026-01-01_10-00-00",
+			"westeurope",
+			identity,
+			180,
+			true,
+			"https://sa.blob.core.windows.net/bundles/bundle-abc.zip",
+			"Standard_D2s_v4",
+			127,
+			nil,
+			&avdimagetypes.V2BaseImage{PlatformImage: &avdimagetypes.V2PlatformImage{Publisher: "MicrosoftWindowsDesktop", Offer: "windows-11", Sku: "win11-24h2-avd", Version: "latest"}},
+			galleryImageId,
+			nil,
+			false,
+			false,
+			[]regionReplication{{Region: "westeurope", ReplicaCount: 5}},
+			"https://sa.blob.core.windows.net/bundles/bundle-win11.json",
+			nil,
+			nil,
+		)
+	}
+
+	tests := []struct {
+		name           string
+		modify         func(template *armvirtualmachineimagebuilder.ImageTemplate)
+		expectProblems []string
+	}{
+		{
+			name:   "valid",
+			modify: func(*armvirtualmachineimagebuilder.ImageTemplate) {},
+		},
+		{
+			name: "all problems are reported",
+			modify: func(template *armvirtualmachineimagebuilder.ImageTemplate) {
+				template.Name = to.Ptr(strings.Repeat("a", 65))
+				template.Location = to.Ptr("west-europe")
+				template.Identity.UserAssignedIdentities = map[string]*armvirtualmachineimagebuilder.UserAssignedIdentity{"builder": {}}
+				template.Properties.BuildTimeoutInMinutes = to.Ptr(int32(1000))
+				template.Properties.VMProfile.VMSize = to.Ptr("D2s_v4")
+				template.Properties.VMProfile.OSDiskSizeGB = to.Ptr(int32(8000))
+				template.Properties.Customize = append(template.Properties.Customize, template.Properties.Customize[0])
+				distributor := template.Properties.Distribute[0].(*armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor)
+				distributor.GalleryImageID = to.Ptr("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery")
+				distributor.TargetRegions[0].ReplicaCount = to.Ptr(int32(0))
+			},
+			expectProblems: []string{
+				"template name",
+				"template location west-europe",
+				"invalid managed identity",
+				"build timeout",
+				"VM size D2s_v4",
+				"disk size",
+				"is used more than once",
+				"invalid gallery image",
+				"replica count",
+			},
+		},
+		{
+			name: "identity ids differ in case",
+			modify: func(template *armvirtualmachineimagebuilder.ImageTemplate) {
+				template.Properties.VMProfile.UserAssignedIdentities = to.SliceOfPtrs(strings.ToUpper(identity))
+			},
+		},
+		{
+			name: "builder VM has another identity than the template",
+			modify: func(template *armvirtualmachineimagebuilder.ImageTemplate) {
+				template.Properties.VMProfile.UserAssignedIdentities = to.SliceOfPtrs(strings.Replace(identity, "builder", "other", 1))
+			},
+			expectProblems: []string{
+				"userAssignedIdentities/other is assigned to the builder VM, but not to the template",
+				"userAssignedIdentities/builder of the template is not assigned to the builder VM",
+			},
+		},
+		{
+			name: "builder VM has no identity",
+			modify: func(template *armvirtualmachineimagebuilder.ImageTemplate) {
+				template.Properties.VMProfile.UserAssignedIdentities = nil
+			},
+			expectProblems: []string{"of the template is not assigned to the builder VM"},
+		},
+		{
+			name: "builder subnet",
+			modify: func(template *armvirtualmachineimagebuilder.ImageTemplate) {
+				template.Properties.VMProfile.VnetConfig = builderVnetConfig(&builderNetworkConfig{
+					SubnetID:                  "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/network/providers/Microsoft.Network/virtualNetworks/vnet/subnets/builders",
+					ContainerInstanceSubnetID: "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/network/providers/Microsoft.Network/virtualNetworks/vnet/subnets/aci",
+				})
+			},
+		},
+		{
+			name: "invalid builder subnet",
+			modify: func(template *armvirtualmachineimagebuilder.ImageTemplate) {
+				template.Properties.VMProfile.VnetConfig = builderVnetConfig(&builderNetworkConfig{
+					SubnetID:                  "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/network/providers/Microsoft.Network/virtualNetworks/vnet",
+					ProxyVMSize:               "Standard_A1_v2",
+					ContainerInstanceSubnetID: "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/network/providers/Microsoft.Network/virtualNetworks/other/subnets/aci",
+				})
+			},
+			expectProblems: []string{
+				"invalid builder subnet",
+				"proxy VM size cannot be set",
+			},
+		},
+		{
+			name: "container instance subnet in another virtual network",
+			modify: func(template *armvirtualmachineimagebuilder.ImageTemplate) {
+				template.Properties.VMProfile.VnetConfig = builderVnetConfig(&builderNetworkConfig{
+					SubnetID:                  "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/network/providers/Microsoft.Network/virtualNetworks/vnet/subnets/builders",
+					ContainerInstanceSubnetID: "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/network/providers/Microsoft.Network/virtualNetworks/other/subnets/aci",
+				})
+			},
+			expectProblems: []string{"virtual network vnet"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			template := newTemplate()
+			test.modify(template)
+
+			err := preflightImageTemplate(template)
+			if len(test.expectProblems) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			for _, problem := range test.expectProblems {
+				require.Contains(t, err.Error(), problem)
+			}
+		})
+	}
+}
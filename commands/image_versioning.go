@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/schoolyear/avd-cli/schema"
+)
+
+type versionStrategy string
+
+const (
+	versionStrategyLatestPatch     versionStrategy = "latest-patch+1"
+	versionStrategyMajorFromBundle versionStrategy = "major-from-bundle"
+	versionStrategyDate            versionStrategy = "date"
+)
+
+var versionStrategies = []versionStrategy{versionStrategyLatestPatch, versionStrategyMajorFromBundle, versionStrategyDate}
+
+func joinVersionStrategies() string {
+	names := make([]string, len(versionStrategies))
+	for i, strategy := range versionStrategies {
+		names[i] = string(strategy)
+	}
+	return strings.Join(names, ", ")
+}
+
+func parseVersionStrategy(value string) (versionStrategy, error) {
+	for _, strategy := range versionStrategies {
+		if string(strategy) == value {
+			return strategy, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown version strategy %s. available: %s", value, joinVersionStrategies())
+}
+
+// imageVersioning decides the version number of the image version that the Image Template distributes.
+// either the version is explicit, or the Image Builder picks it using the versioner.
+// if both are empty, Azure picks a version number
+type imageVersioning struct {
+	version   string
+	versioner armvirtualmachineimagebuilder.DistributeVersionerClassification
+
+	description string
+}
+
+// galleryImageId returns the distribution target, including the version if it is explicit
+func (v imageVersioning) galleryImageId(imageDefinitionId string) string {
+	if v.version == "" {
+		return imageDefinitionId
+	}
+	return imageDefinitionId + "/versions/" + v.version
+}
+
+// resolveImageVersioning picks the versioning for the explicit version or strategy.
+// returns nil if neither is set
+func resolveImageVersioning(explicitVersion, strategyName string, bundleSources *schema.V2BundleSources, existingVersions []lib_azure.ImageVersion, now time.Time) (*imageVersioning, error) {
+	if explicitVersion != "" && strategyName != "" {
+		return nil, fmt.Errorf("--image-version and --version-strategy cannot be used together")
+	}
+
+	if explicitVersion != "" {
+		version, err := lib_azure.ParseGalleryVersion(explicitVersion)
+		if err != nil {
+			return nil, err
+		}
+		return explicitImageVersioning(version, existingVersions, "set with --image-version")
+	}
+
+	if strategyName == "" {
+		return nil, nil
+	}
+
+	strategy, err := parseVersionStrategy(strategyName)
+	if err != nil {
+		return nil, err
+	}
+
+	latest, hasLatest := lib_azure.LatestGalleryVersion(existingVersions)
+
+	switch strategy {
+	case versionStrategyLatestPatch:
+		// the Image Builder determines the latest version when it distributes, so concurrent builds don't pick the same number
+		description := "latest version + 1 patch"
+		if hasLatest {
+			description = fmt.Sprintf("%s (currently %s, so %s)", description, latest, lib_azure.GalleryVersion{Major: latest.Major, Minor: latest.Minor, Patch: latest.Patch + 1})
+		}
+		return &imageVersioning{
+			versioner: &armvirtualmachineimagebuilder.DistributeVersionerLatest{
+				Scheme: to.Ptr("Latest"),
+				Major:  to.Ptr(int32(-1)),
+			},
+			description: description,
+		}, nil
+	case versionStrategyMajorFromBundle:
+		if bundleSources == nil || bundleSources.Version == "" {
+			return nil, fmt.Errorf("the bundle has no version. Create the bundle using \"bundle layers --bundle-version\" to use the %s strategy", strategy)
+		}
+		major, err := bundleMajorVersion(bundleSources.Version)
+		if err != nil {
+			return nil, err
+		}
+		return &imageVersioning{
+			versioner: &armvirtualmachineimagebuilder.DistributeVersionerLatest{
+				Scheme: to.Ptr("Latest"),
+				Major:  to.Ptr(major),
+			},
+			description: fmt.Sprintf("latest %d.x.x version + 1 patch (bundle version %s)", major, bundleSources.Version),
+		}, nil
+	case versionStrategyDate:
+		now = now.UTC()
+		version := lib_azure.GalleryVersion{
+			Major: int32(now.Year()),
+			Minor: int32(now.Month())*100 + int32(now.Day()),
+			Patch: int32(now.Hour())*100 + int32(now.Minute()),
+		}
+		return explicitImageVersioning(version, existingVersions, "date of the build, YYYY.MMDD.HHmm in UTC")
+	default:
+		return nil, fmt.Errorf("unknown version strategy %s", strategy)
+	}
+}
+
+func explicitImageVersioning(version lib_azure.GalleryVersion, existingVersions []lib_azure.ImageVersion, reason string) (*imageVersioning, error) {
+	for _, existing := range existingVersions {
+		if parsed, err := lib_azure.ParseGalleryVersion(existing.Name); err == nil && parsed == version {
+			return nil, fmt.Errorf("image version %s already exists", version)
+		}
+	}
+
+	description := fmt.Sprintf("%s (%s)", version, reason)
+	if latest, ok := lib_azure.LatestGalleryVersion(existingVersions); ok && version.Compare(latest) < 0 {
+		description += fmt.Sprintf(". Note: it is lower than the latest version %s, so it will not become the latest image", latest)
+	}
+
+	return &imageVersioning{
+		version:     version.String(),
+		description: description,
+	}, nil
+}
+
+// bundleMajorVersion returns the major version of a bundle version like 1, 1.4 or v1.4.0
+func bundleMajorVersion(value string) (int32, error) {
+	trimmed := strings.TrimPrefix(value, "v")
+	parts := strings.Split(trimmed, ".")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid bundle version %s: expected major[.minor[.patch]]", value)
+	}
+
+	var major int32
+	for i, part := range parts {
+		number, err := strconv.ParseInt(part, 10, 32)
+		if err != nil || number < 0 {
+			return 0, fmt.Errorf("invalid bundle version %s: expected major[.minor[.patch]]", value)
+		}
+		if i == 0 {
+			major = int32(number)
+		}
+	}
+
+	return major, nil
+}
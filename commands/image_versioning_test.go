@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/schoolyear/avd-cli/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resolveImageVersioning(t *testing.T) {
+	now := time.Date(2026, time.March, 5, 9, 7, 0, 0, time.UTC)
+	existing := []lib_azure.ImageVersion{{Name: "1.2.3"}, {Name: "1.10.0"}, {Name: "invalid"}}
+
+	tests := []struct {
+		name            string
+		explicitVersion string
+		strategy        string
+		bundleSources   *schema.V2BundleSources
+		expectVersion   string
+		expectMajor     *int32 // major of the Latest versioner, nil if no versioner is expected
+		expectErr       bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:            "explicit",
+			explicitVersion: "1.4.0",
+			expectVersion:   "1.4.0",
+		},
+		{
+			name:            "explicit exists",
+			explicitVersion: "1.10.0",
+			expectErr:       true,
+		},
+		{
+			name:            "explicit invalid",
+			explicitVersion: "1.4",
+			expectErr:       true,
+		},
+		{
+			name:            "explicit and strategy",
+			explicitVersion: "1.4.0",
+			strategy:        "date",
+			expectErr:       true,
+		},
+		{
+			name:          "date",
+			strategy:      "date",
+			expectVersion: "2026.305.907",
+		},
+		{
+			name:        "latest patch",
+			strategy:    "latest-patch+1",
+			expectMajor: to.Ptr(int32(-1)),
+		},
+		{
+			name:          "major from bundle",
+			strategy:      "major-from-bundle",
+			bundleSources: &schema.V2BundleSources{Version: "v3.1"},
+			expectMajor:   to.Ptr(int32(3)),
+		},
+		{
+			name:          "major from bundle without version",
+			strategy:      "major-from-bundle",
+			bundleSources: &schema.V2BundleSources{},
+			expectErr:     true,
+		},
+		{
+			name:      "unknown strategy",
+			strategy:  "random",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			versioning, err := resolveImageVersioning(test.explicitVersion, test.strategy, test.bundleSources, existing, now)
+			if test.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			if test.explicitVersion == "" && test.strategy == "" {
+				require.Nil(t, versioning)
+				return
+			}
+
+			require.Equal(t, test.expectVersion, versioning.version)
+			if test.expectMajor == nil {
+				require.Nil(t, versioning.versioner)
+			} else {
+				latest, ok := versioning.versioner.(*armvirtualmachineimagebuilder.DistributeVersionerLatest)
+				require.True(t, ok)
+				require.Equal(t, *test.expectMajor, *latest.Major)
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder"
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/embeddedfiles"
+	"github.com/schoolyear/avd-cli/lib"
+	"github.com/schoolyear/avd-cli/schema"
+	avdimagetypes "github.com/schoolyear/avd-image-types"
+	"github.com/urfave/cli/v2"
+)
+
+var LayerMigrateV1Command = &cli.Command{
+	Name:      "migrate-v1",
+	Usage:     "Convert a v1 image folder into a v2 image layer",
+	ArgsUsage: "<image-dir>",
+	Description: `The whitelisted hosts of properties.json become the proxy whitelist of the layer.
+The build steps run in order from install.ps1 and the resources are copied into the layer.
+The scripts in the resources/sessionhost_setup_scripts, session_scripts and user_scripts folders are combined into
+on_sessionhost_setup.ps1, on_user_login.admin.ps1 and on_user_login.user.ps1.
+Everything that can't be migrated is listed in MIGRATION_TODO.md in the layer folder.`,
+	Flags: []cli.Flag{
+		&cli.PathFlag{
+			Name:     "output",
+			Required: true,
+			Usage:    "Path in which the image layer folder should be created",
+			Aliases:  []string{"o"},
+		},
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "Name of the layer. Defaults to the name of the image folder",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return errors.New("expected the path to the v1 image folder as argument")
+		}
+		imagePath := c.Args().First()
+		targetPath := c.Path("output")
+		layerName := c.String("name")
+		if layerName == "" {
+			absImagePath, err := filepath.Abs(imagePath)
+			if err != nil {
+				return errors.Wrap(err, "failed to convert image path to absolute path")
+			}
+			layerName = filepath.Base(absImagePath)
+		}
+
+		if err := ensureLayerPathsExist([]string{imagePath}); err != nil {
+			return err
+		}
+
+		imageFs := os.DirFS(imagePath)
+		migration, err := migrateV1Image(imageFs, layerName)
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate %s", imagePath)
+		}
+
+		if err := lib.EnsureEmptyDirectory(targetPath, false); err != nil {
+			return errors.Wrap(err, "failed to create target directory")
+		}
+
+		fmt.Printf("Writing layer to %s...", targetPath)
+		if err := writeV1Migration(imageFs, migration, targetPath); err != nil {
+			color.Red("[FAILED]")
+			return err
+		}
+		color.Green("[DONE]")
+
+		if len(migration.todos) == 0 {
+			fmt.Println("Everything was migrated")
+			return nil
+		}
+		color.Yellow("The following could not be migrated, see %s:", filepath.Join(targetPath, v1MigrationTodoFilename))
+		for _, todo := range migration.todos {
+			fmt.Printf("\t- %s\n", todo)
+		}
+
+		return nil
+	},
+}
+
+const v1MigrationTodoFilename = "MIGRATION_TODO.md"
+
+// v1LifecycleScripts maps the v1 resource folders with lifecycle scripts to the v2 script that runs them
+var v1LifecycleScripts = []struct {
+	dir    string
+	script string
+}{
+	{dir: "sessionhost_setup_scripts", script: "on_sessionhost_setup.ps1"},
+	{dir: "session_scripts", script: "on_user_login.admin.ps1"},
+	{dir: "user_scripts", script: "on_user_login.user.ps1"},
+}
+
+var (
+	v1PlaceholderRegex     = regexp.MustCompile(`\[{3}[^\[\]]+]{3}`)
+	powershellParamRegex   = regexp.MustCompile(`(?im)^\s*param\s*\(`)
+	powershellExitRegex    = regexp.MustCompile(`(?im)^\s*exit\b`)
+	migratedStepNameRegex  = regexp.MustCompile(`[^\w.-]`)
+	migratedUserLoginParam = `Param (
+    [Parameter(Mandatory = $true)]
+    [string]$uid,          # SID of the Windows user logging in
+
+    [Parameter(Mandatory = $true)]
+    [string]$gid,          # SID of the Windows user logging in
+
+    [Parameter(Mandatory = $true)]
+    [string]$username,     # Username of the Windows user logging in
+
+    [Parameter(Mandatory = $true)]
+    [string]$homedir,      # Absolute path to the user's home directory
+
+    # To make sure this script doesn't break when new parameters are added
+    [Parameter(ValueFromRemainingArguments)]
+    [string[]]$RemainingArgs
+)
+`
+)
+
+// v1Migration is the v2 layer converted from a v1 image folder
+type v1Migration struct {
+	files     map[string][]byte // generated files, by path in the layer folder
+	resources []string          // files to copy, by path in the resources folder of the image
+	todos     []string
+}
+
+// migratedLayerProperties is the subset of the v2 layer properties that is filled in by the migration
+type migratedLayerProperties struct {
+	Version         string               `json:"version"`
+	Name            string               `json:"name"`
+	Description     string               `json:"description"`
+	PlatformVersion string               `json:"platform_version"`
+	Network         migratedLayerNetwork `json:"network"`
+	BuildParameters map[string]any       `json:"build_parameters"`
+}
+
+type migratedLayerNetwork struct {
+	HTTPProxyWhitelist []string `json:"http_proxy_whitelist"`
+}
+
+func migrateV1Image(imageFs fs.FS, layerName string) (*v1Migration, error) {
+	migration := &v1Migration{files: map[string][]byte{}}
+
+	propertiesJSON, err := migrateV1Properties(imageFs, layerName, migration)
+	if err != nil {
+		return nil, err
+	}
+	migration.files[layerPropertiesFilename+".json"] = propertiesJSON
+
+	hasResources, err := migrateV1Resources(imageFs, migration)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateV1BuildSteps(imageFs, hasResources, migration); err != nil {
+		return nil, err
+	}
+
+	if len(migration.todos) > 0 {
+		var todo strings.Builder
+		todo.WriteString("# Migration TODO\n\n")
+		todo.WriteString("The following parts of the v1 image could not be migrated to this layer automatically.\n\n")
+		for _, item := range migration.todos {
+			fmt.Fprintf(&todo, "- [ ] %s\n", item)
+		}
+		migration.files[v1MigrationTodoFilename] = []byte(todo.String())
+	}
+
+	return migration, nil
+}
+
+func migrateV1Properties(imageFs fs.FS, layerName string, migration *v1Migration) ([]byte, error) {
+	properties := migratedLayerProperties{
+		Version:         "v2.1",
+		Name:            layerName,
+		Description:     "Migrated from v1 image " + layerName,
+		PlatformVersion: "2",
+		Network:         migratedLayerNetwork{HTTPProxyWhitelist: []string{}},
+		BuildParameters: map[string]any{},
+	}
+	migration.todos = append(migration.todos, "Review the name and description in properties.json, and add an author")
+
+	v1Properties, cleanJSON, err := lib.UnmarshalJSONorJSON5File[schema.ImageProperties](imageFs, imagePropertiesFilename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Wrap(err, "failed to read properties file")
+	}
+
+	if v1Properties != nil {
+		for _, host := range slices.Sorted(maps.Keys(v1Properties.WhitelistedHosts)) {
+			properties.Network.HTTPProxyWhitelist = append(properties.Network.HTTPProxyWhitelist, host)
+			if !strings.Contains(host, ":") {
+				migration.todos = append(migration.todos, fmt.Sprintf("Whitelisted host %s has no port, v2 expects hostname:port (e.g. %s:443)", host, host))
+			}
+		}
+
+		if len(v1Properties.PlaceholderProperties) > 0 {
+			names := slices.Sorted(maps.Keys(v1Properties.PlaceholderProperties))
+			migration.todos = append(migration.todos, fmt.Sprintf("Placeholder properties %s: replace them with build_parameters", strings.Join(names, ", ")))
+		}
+
+		if len(v1Properties.InternalServices) > 0 {
+			names := slices.Sorted(maps.Keys(v1Properties.InternalServices))
+			migration.todos = append(migration.todos, fmt.Sprintf("Internal services %s are not part of a v2 layer, configure them in the deployment", strings.Join(names, ", ")))
+		}
+
+		var imageTemplate struct {
+			ImageTemplate map[string]json.RawMessage `json:"imageTemplate"`
+		}
+		if err := json.Unmarshal(cleanJSON, &imageTemplate); err != nil {
+			return nil, errors.Wrap(err, "failed to parse image template of properties file")
+		}
+		if len(imageTemplate.ImageTemplate) > 0 {
+			fields := slices.Sorted(maps.Keys(imageTemplate.ImageTemplate))
+			migration.todos = append(migration.todos, fmt.Sprintf("The image template (%s) is not part of a v2 layer: set the base image and build VM with the bundle autobuild flags", strings.Join(fields, ", ")))
+		}
+	}
+
+	propertiesJSON, err := json.MarshalIndent(properties, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal layer properties")
+	}
+
+	if err := lib.ValidateAVDImageType(avdimagetypes.V2LayerPropertiesDefinition, propertiesJSON); err != nil {
+		migration.todos = append(migration.todos, fmt.Sprintf("properties.json is not valid: %s", err))
+	}
+
+	return propertiesJSON, nil
+}
+
+// migrateV1Resources copies all resources and combines the lifecycle scripts into their v2 scripts.
+// returns whether the image has resources
+func migrateV1Resources(imageFs fs.FS, migration *v1Migration) (bool, error) {
+	resourcesInfo, err := fs.Stat(imageFs, resourcesDirName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to get info on resources folder")
+	}
+	if !resourcesInfo.IsDir() {
+		return false, errors.New(`expected "resources" to be a folder, it isn't`)
+	}
+
+	resourcesFs, err := fs.Sub(imageFs, resourcesDirName)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create sub-filesystem for resources folder")
+	}
+
+	if err := fs.WalkDir(resourcesFs, ".", func(resourcePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			migration.resources = append(migration.resources, resourcePath)
+		}
+		return nil
+	}); err != nil {
+		return false, errors.Wrap(err, "failed to list resources")
+	}
+
+	for _, lifecycle := range v1LifecycleScripts {
+		entries, err := fs.ReadDir(resourcesFs, lifecycle.dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return false, errors.Wrapf(err, "failed to read %s", lifecycle.dir)
+		}
+
+		var script strings.Builder
+		fmt.Fprintf(&script, "# Generated by \"avdcli layer migrate-v1\" from the scripts in resources/%s of the v1 image\n\n", lifecycle.dir)
+		if strings.HasPrefix(lifecycle.script, "on_user_login") {
+			script.WriteString(migratedUserLoginParam + "\n")
+		}
+		script.WriteString("$ErrorActionPreference = \"Stop\"\n")
+
+		scripts := 0
+		for _, entry := range entries {
+			entryPath := path.Join(resourcesDirName, lifecycle.dir, entry.Name())
+			if entry.IsDir() || !strings.EqualFold(path.Ext(entry.Name()), ".ps1") {
+				migration.todos = append(migration.todos, fmt.Sprintf("%s is not a PowerShell script and is not run by %s", entryPath, lifecycle.script))
+				continue
+			}
+
+			content, err := fs.ReadFile(resourcesFs, path.Join(lifecycle.dir, entry.Name()))
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to read %s", entryPath)
+			}
+			if powershellParamRegex.Match(content) {
+				migration.todos = append(migration.todos, fmt.Sprintf("%s declares parameters, %s runs it without arguments", entryPath, lifecycle.script))
+			}
+			if powershellExitRegex.Match(content) {
+				migration.todos = append(migration.todos, fmt.Sprintf("%s calls exit, which stops %s before the scripts after it", entryPath, lifecycle.script))
+			}
+
+			// each script runs in its own script block, so they don't share variables
+			fmt.Fprintf(&script, "\n# %s\n& {\n%s\n}\n", entryPath, strings.TrimRight(string(content), "\r\n"))
+			scripts++
+		}
+
+		if scripts > 0 {
+			migration.files[lifecycle.script] = []byte(script.String())
+		}
+	}
+
+	return len(migration.resources) > 0, nil
+}
+
+func migrateV1BuildSteps(imageFs fs.FS, hasResources bool, migration *v1Migration) error {
+	buildStepsConfig, _, err := lib.UnmarshalJSONorJSON5File[schema.BuildStepsConfig](imageFs, buildStepsFileName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "failed to read build_steps file")
+	}
+
+	var steps schema.BuildSteps
+	if buildStepsConfig != nil {
+		steps = append(steps, buildStepsConfig.Pre...)
+		steps = append(steps, buildStepsConfig.Default...)
+		steps = append(steps, buildStepsConfig.Post...)
+	}
+
+	var installSteps []string
+	for i, step := range steps {
+		name := deref(step.V.GetImageTemplateCustomizer().Name)
+		if name == "" {
+			name = "step " + strconv.Itoa(i+1)
+		}
+
+		stepJSON, err := json.Marshal(step)
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal build step %s", name)
+		}
+		if placeholders := v1PlaceholderRegex.FindAll(stepJSON, -1); len(placeholders) > 0 {
+			migration.todos = append(migration.todos, fmt.Sprintf("Build step %s uses placeholders (%s): replace them with build parameters", name, joinBytes(placeholders)))
+		}
+
+		switch customizer := step.V.(type) {
+		case *armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer:
+			installStep := "Invoke-MigratedStep -Name " + powershellQuote(name)
+			if scriptUri := deref(customizer.ScriptURI); scriptUri != "" {
+				installStep += " -ScriptUri " + powershellQuote(scriptUri)
+				if sha256 := deref(customizer.SHA256Checksum); sha256 != "" {
+					installStep += " -Sha256 " + powershellQuote(sha256)
+				}
+			} else {
+				stepPath := fmt.Sprintf("install/%03d_%s.ps1", i+1, migratedStepNameRegex.ReplaceAllString(name, "_"))
+				lines := make([]string, len(customizer.Inline))
+				for j, line := range customizer.Inline {
+					lines[j] = deref(line)
+				}
+				migration.files[stepPath] = []byte(strings.Join(lines, "\n") + "\n")
+				installStep += " -Path " + powershellQuote(strings.ReplaceAll(stepPath, "/", `\`))
+			}
+
+			if len(customizer.ValidExitCodes) > 0 {
+				exitCodes := make([]string, len(customizer.ValidExitCodes))
+				for j, code := range customizer.ValidExitCodes {
+					exitCodes[j] = strconv.Itoa(int(deref(code)))
+				}
+				installStep += " -ValidExitCodes @(" + strings.Join(exitCodes, ", ") + ")"
+			}
+			installSteps = append(installSteps, installStep)
+		case *armvirtualmachineimagebuilder.ImageTemplateFileCustomizer:
+			installStep := fmt.Sprintf("Invoke-MigratedDownload -Name %s -Uri %s -Destination %s",
+				powershellQuote(name), powershellQuote(deref(customizer.SourceURI)), powershellQuote(deref(customizer.Destination)))
+			if sha256 := deref(customizer.SHA256Checksum); sha256 != "" {
+				installStep += " -Sha256 " + powershellQuote(sha256)
+			}
+			installSteps = append(installSteps, installStep)
+		case *armvirtualmachineimagebuilder.ImageTemplateRestartCustomizer:
+			migration.todos = append(migration.todos, fmt.Sprintf("Build step %s restarts the VM, which a layer can't do: move the steps after it to a separate layer", name))
+		case *armvirtualmachineimagebuilder.ImageTemplateWindowsUpdateCustomizer:
+			migration.todos = append(migration.todos, fmt.Sprintf("Build step %s installs Windows updates: configure them with the --windows-update flags of bundle autobuild", name))
+		default:
+			migration.todos = append(migration.todos, fmt.Sprintf("Build step %s of type %s can't be migrated", name, deref(step.V.GetImageTemplateCustomizer().Type)))
+		}
+	}
+
+	if len(installSteps) == 0 && !hasResources {
+		return nil
+	}
+	if len(installSteps) == 0 {
+		installSteps = append(installSteps, "# the v1 image has no build steps")
+	}
+
+	migration.files["install.ps1"] = []byte(strings.Replace(embeddedfiles.V2MigratedInstallScript, embeddedfiles.V2MigratedInstallScriptStepsPlaceholder, strings.Join(installSteps, "\n"), 1))
+	return nil
+}
+
+func writeV1Migration(imageFs fs.FS, migration *v1Migration, targetPath string) error {
+	for _, resourcePath := range migration.resources {
+		target := filepath.Join(targetPath, resourcesDirName, filepath.FromSlash(resourcePath))
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return errors.Wrapf(err, "failed to create directory for %s", target)
+		}
+		if err := lib.CopyFile(imageFs, path.Join(resourcesDirName, resourcePath), target); err != nil {
+			return errors.Wrapf(err, "failed to copy resource %s", resourcePath)
+		}
+	}
+
+	for filePath, data := range migration.files {
+		target := filepath.Join(targetPath, filepath.FromSlash(filePath))
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return errors.Wrapf(err, "failed to create directory for %s", target)
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return errors.Wrapf(err, "failed to write %s", target)
+		}
+	}
+
+	return nil
+}
+
+func joinBytes(values [][]byte) string {
+	strs := make([]string, len(values))
+	for i, value := range values {
+		strs[i] = string(value)
+	}
+	return strings.Join(strs, ", ")
+}
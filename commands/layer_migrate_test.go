@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_migrateV1Image(t *testing.T) {
+	imageFs := fstest.MapFS{
+		"properties.json": {Data: []byte(`{
+			"whitelistedHosts": {"example.com:443": {}, "api.example.com": {}},
+			"placeholderProperties": {"licenseKey": "x"},
+			"imageTemplate": {"properties": {"vmProfile": {"vmSize": "Standard_D4s_v5"}}}
+		}`)},
+		"build_steps.json5": {Data: []byte(`{
+			pre: [{type: "WindowsUpdate", name: "Updates"}],
+			default: [
+				{type: "PowerShell", name: "Install app", inline: ["Start-Process C:\\imagebuild_resources\\app.msi -Wait", "exit 0"], validExitCodes: [0, 3010]},
+				{type: "File", name: "Config", sourceUri: "https://example.com/[[[param:configFile]]]", destination: "C:\\config.json"},
+			],
+			post: [{type: "PowerShell", name: "Remote", scriptUri: "https://example.com/remote.ps1", sha256Checksum: "ABC"}],
+		}`)},
+		"resources/app.msi": {Data: []byte("msi")},
+		"resources/sessionhost_setup_scripts/000_dns.ps1": {Data: []byte("Set-DnsClient\r\n")},
+		"resources/sessionhost_setup_scripts/001_fw.ps1":  {Data: []byte("param($port)\nexit 1\n")},
+		"resources/user_scripts/000_drive.ps1":            {Data: []byte("New-PSDrive")},
+		"resources/user_scripts/readme.txt":               {Data: []byte("readme")},
+	}
+
+	migration, err := migrateV1Image(imageFs, "my-image")
+	require.NoError(t, err)
+
+	var properties migratedLayerProperties
+	require.NoError(t, json.Unmarshal(migration.files["properties.json"], &properties))
+	require.Equal(t, "my-image", properties.Name)
+	require.Equal(t, []string{"api.example.com", "example.com:443"}, properties.Network.HTTPProxyWhitelist)
+
+	require.ElementsMatch(t, []string{
+		"app.msi",
+		"sessionhost_setup_scripts/000_dns.ps1",
+		"sessionhost_setup_scripts/001_fw.ps1",
+		"user_scripts/000_drive.ps1",
+		"user_scripts/readme.txt",
+	}, migration.resources)
+
+	require.Equal(t, "Start-Process C:\\imagebuild_resources\\app.msi -Wait\nexit 0\n", string(migration.files["install/002_Install_app.ps1"]))
+	install := string(migration.files["install.ps1"])
+	require.NotContains(t, install, "## STEPS ##")
+	require.Contains(t, install, strings.Join([]string{
+		`Invoke-MigratedStep -Name 'Install app' -Path 'install\002_Install_app.ps1' -ValidExitCodes @(0, 3010)`,
+		`Invoke-MigratedDownload -Name 'Config' -Uri 'https://example.com/[[[param:configFile]]]' -Destination 'C:\config.json'`,
+		`Invoke-MigratedStep -Name 'Remote' -ScriptUri 'https://example.com/remote.ps1' -Sha256 'ABC'`,
+	}, "\n"))
+
+	setup := string(migration.files["on_sessionhost_setup.ps1"])
+	require.Contains(t, setup, "# resources/sessionhost_setup_scripts/000_dns.ps1\n& {\nSet-DnsClient\n}\n")
+	require.Contains(t, setup, "# resources/sessionhost_setup_scripts/001_fw.ps1\n& {\nparam($port)\nexit 1\n}\n")
+	require.Contains(t, string(migration.files["on_user_login.user.ps1"]), "[string]$homedir")
+	require.NotContains(t, migration.files, "on_user_login.admin.ps1")
+
+	todos := strings.Join(migration.todos, "\n")
+	for _, expected := range []string{
+		"api.example.com has no port",
+		"Placeholder properties licenseKey",
+		"The image template (properties)",
+		"resources/sessionhost_setup_scripts/001_fw.ps1 declares parameters",
+		"resources/sessionhost_setup_scripts/001_fw.ps1 calls exit",
+		"resources/user_scripts/readme.txt is not a PowerShell script",
+		"Build step Updates installs Windows updates",
+		"Build step Config uses placeholders ([[[param:configFile]]])",
+	} {
+		require.Contains(t, todos, expected)
+	}
+	require.Contains(t, string(migration.files[v1MigrationTodoFilename]), "- [ ] Build step Updates installs Windows updates")
+}
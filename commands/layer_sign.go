@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib"
+	"github.com/schoolyear/avd-cli/schema"
+	"github.com/urfave/cli/v2"
+)
+
+var LayerSignCommand = &cli.Command{
+	Name:      "sign",
+	Usage:     "Sign a layer, so bundlers can verify who published it",
+	ArgsUsage: "<layer-dir>",
+	Flags: []cli.Flag{
+		&cli.PathFlag{
+			Name:      "key",
+			Usage:     "Path to the secret key file (create one with \"layer keygen\")",
+			Required:  true,
+			TakesFile: true,
+			Aliases:   []string{"k"},
+		},
+		&cli.StringFlag{
+			Name:  "comment",
+			Usage: "Trusted comment to include in the signature. Defaults to the layer name and a timestamp",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		layerPath := c.Args().First()
+		keyPath := c.Path("key")
+		trustedComment := c.String("comment")
+
+		if layerPath == "" {
+			return errors.New("layer directory argument is required")
+		}
+		layerPath = filepath.Clean(layerPath)
+
+		keyData, err := os.ReadFile(keyPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to read secret key file")
+		}
+
+		secretKey, err := lib.ParseLayerSecretKey(string(keyData))
+		if err != nil {
+			return errors.Wrap(err, "failed to parse secret key file")
+		}
+
+		layer, err := validateLayer(layerToBundle{
+			originalPathName: layerPath,
+			path:             filepath.Base(layerPath),
+			fs:               os.DirFS(filepath.Dir(layerPath)),
+		})
+		if err != nil {
+			return errors.Wrap(err, "invalid layer")
+		}
+
+		if trustedComment == "" {
+			trustedComment = fmt.Sprintf("layer:%s timestamp:%d", layer.properties.Name, time.Now().Unix())
+		}
+		if strings.ContainsAny(trustedComment, "\r\n") {
+			return errors.New("the trusted comment cannot contain newlines")
+		}
+
+		fmt.Printf("Creating the manifest of %s...", layer.properties.Name)
+		manifest, err := lib.BuildLayerManifest(layer.fs, layer.path, schema.V2LayerSignatureFilename)
+		if err != nil {
+			return errors.Wrap(err, "failed to create layer manifest")
+		}
+		color.Green("[DONE] (%d files)", strings.Count(string(manifest), "\n"))
+
+		signature := lib.SignLayerManifest(secretKey, manifest, trustedComment)
+		signaturePath := filepath.Join(layerPath, schema.V2LayerSignatureFilename)
+		if err := os.WriteFile(signaturePath, signature, 0644); err != nil {
+			return errors.Wrap(err, "failed to write signature file")
+		}
+
+		fmt.Printf("Signed with key %s\n", secretKey.KeyId)
+		color.HiGreen("Signature written to %s", signaturePath)
+		color.Yellow("Note: any change to the files of this layer invalidates the signature")
+
+		return nil
+	},
+}
+
+var LayerKeygenCommand = &cli.Command{
+	Name:  "keygen",
+	Usage: "Generate a key pair for signing layers",
+	Flags: []cli.Flag{
+		&cli.PathFlag{
+			Name:      "output",
+			Usage:     "Path prefix for the key files. Writes <output>.pub and <output>.key",
+			Value:     "avdcli-layer",
+			TakesFile: true,
+			Aliases:   []string{"o"},
+		},
+	},
+	Action: func(c *cli.Context) error {
+		outputPrefix := c.Path("output")
+		publicKeyPath := outputPrefix + ".pub"
+		secretKeyPath := outputPrefix + ".key"
+
+		for _, keyPath := range []string{publicKeyPath, secretKeyPath} {
+			if _, err := os.Stat(keyPath); err == nil {
+				return fmt.Errorf("key file already exists: %s", keyPath)
+			} else if !os.IsNotExist(err) {
+				return errors.Wrapf(err, "failed to check for existing key file %s", keyPath)
+			}
+		}
+
+		publicKey, secretKey, err := lib.GenerateLayerSigningKey()
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(secretKeyPath, secretKey.FileContents(), 0600); err != nil {
+			return errors.Wrap(err, "failed to write secret key file")
+		}
+		if err := os.WriteFile(publicKeyPath, publicKey.FileContents(), 0644); err != nil {
+			return errors.Wrap(err, "failed to write public key file")
+		}
+
+		fmt.Printf("Secret key: %s (keep this file private)\n", secretKeyPath)
+		fmt.Printf("Public key: %s\n", publicKeyPath)
+		fmt.Println()
+		fmt.Println(`To trust layers signed with this key, add the public key to "trusted_layer_keys" in ~/.avdcli/config:`)
+		color.Cyan("    %s", publicKey)
+
+		return nil
+	},
+}
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/schoolyear/avd-cli/embeddedfiles"
+	"github.com/schoolyear/avd-cli/lib"
+	avdimagetypes "github.com/schoolyear/avd-image-types"
+)
+
+// bundleLayer is a layer in a bundle. bundles list their layers in execution order
+type bundleLayer struct {
+	directory  string // name of the layer directory in the bundle
+	properties *avdimagetypes.V2LayerProperties
+	stage      layerStageProperties
+}
+
+// layerStageProperties are the properties of a layer that control the build stages.
+// they are read from the raw properties file, as they are not part of the layer types
+type layerStageProperties struct {
+	// RestartAfter restarts the builder VM before the next layer is executed, for example after installing drivers
+	RestartAfter bool `json:"restart_after"`
+	// WindowsUpdateAfter installs Windows updates before the next layer is executed
+	WindowsUpdateAfter bool `json:"windows_update_after"`
+}
+
+func (p layerStageProperties) endsStage() bool {
+	return p.RestartAfter || p.WindowsUpdateAfter
+}
+
+// executionStage is a slice of the layers that is executed by one customizer
+type executionStage struct {
+	firstLayerIndex int
+	layers          []bundleLayer
+}
+
+// splitExecutionStages splits the layers after each layer that ends a stage
+func splitExecutionStages(layers []bundleLayer) []executionStage {
+	var (
+		stages  []executionStage
+		current = executionStage{}
+	)
+	for i, layer := range layers {
+		if len(current.layers) == 0 {
+			current.firstLayerIndex = i
+		}
+		current.layers = append(current.layers, layer)
+
+		if layer.stage.endsStage() {
+			stages = append(stages, current)
+			current = executionStage{}
+		}
+	}
+	if len(current.layers) > 0 {
+		stages = append(stages, current)
+	}
+	return stages
+}
+
+// bundleExecutionCustomizers executes the layers of the extracted bundle, and ends with a restart.
+// the layers are split in stages after layers that ask for a restart or Windows update,
+// a bundle with a single stage is executed as a whole
+func bundleExecutionCustomizers(layers []bundleLayer, imageBundleFilepath string) []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification {
+	postBundleExecutionRestart := &armvirtualmachineimagebuilder.ImageTemplateRestartCustomizer{
+		Type: to.Ptr("WindowsRestart"),
+		Name: to.Ptr("PostBundleExecutionRestart"),
+	}
+
+	stages := splitExecutionStages(layers)
+	if len(stages) == 0 {
+		stages = []executionStage{{}}
+	}
+
+	var customizers []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification
+	for i, stage := range stages {
+		if len(stages) == 1 {
+			customizers = append(customizers, bundleExecutionCustomizer("BundleExecution", imageBundleFilepath, "-ScanForDirectories -Force"))
+		} else {
+			layerPaths := make([]string, len(stage.layers))
+			for j, layer := range stage.layers {
+				layerPaths[j] = fmt.Sprintf(`'.\%s'`, layer.directory)
+			}
+			arguments := fmt.Sprintf("-LayerPaths %s -LayerIndexOffset %d -Force", strings.Join(layerPaths, ","), stage.firstLayerIndex)
+			customizers = append(customizers, bundleExecutionCustomizer(fmt.Sprintf("BundleExecutionStage%d", i+1), imageBundleFilepath, arguments))
+		}
+		if len(stage.layers) == 0 {
+			continue
+		}
+
+		lastLayer := stage.layers[len(stage.layers)-1]
+		isLastStage := i == len(stages)-1
+		if lastLayer.stage.RestartAfter && !isLastStage {
+			customizers = append(customizers, &armvirtualmachineimagebuilder.ImageTemplateRestartCustomizer{
+				Type: to.Ptr("WindowsRestart"),
+				Name: to.Ptr("RestartAfterLayer-" + lastLayer.properties.Name),
+			})
+		}
+		if lastLayer.stage.WindowsUpdateAfter {
+			customizers = append(customizers, lib.NewWindowsUpdateCustomizer("WindowsUpdateAfterLayer-"+lastLayer.properties.Name, nil, "", 0))
+			if !isLastStage {
+				// updates are only completed after a restart
+				customizers = append(customizers, &armvirtualmachineimagebuilder.ImageTemplateRestartCustomizer{
+					Type: to.Ptr("WindowsRestart"),
+					Name: to.Ptr("RestartAfterWindowsUpdateAfterLayer-" + lastLayer.properties.Name),
+				})
+			}
+		}
+	}
+
+	return append(customizers, postBundleExecutionRestart)
+}
+
+func bundleExecutionCustomizer(name, imageBundleFilepath, arguments string) *armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer {
+	return &armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer{
+		Type: to.Ptr("PowerShell"),
+		Inline: to.SliceOfPtrs(
+			`Write-Host "Set error action to 'stop'"`,
+			`$ErrorActionPreference = "Stop"`,
+			`Write-Host "Set progressPreference to silentlyContinue"`,
+			`$ProgressPreference = "SilentlyContinue"`,
+			`Write-Host "Entering the bundle directory"`,
+			fmt.Sprintf(`Push-Location "%s"`, imageBundleFilepath),
+			`Write-Host "Executing bundle"`,
+			fmt.Sprintf(`& "./%s" %s`, embeddedfiles.V2ExecuteScriptFilename, arguments),
+			`if (!$?) {Write-Error "The bundle execution failed"; exit 5}`,
+			`Write-Host Exiting the bundle directory`,
+			`Pop-Location`,
+		),
+		Name:        to.Ptr(name),
+		RunAsSystem: to.Ptr(true),
+		RunElevated: to.Ptr(true),
+	}
+}
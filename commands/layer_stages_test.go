@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	avdimagetypes "github.com/schoolyear/avd-image-types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_bundleExecutionCustomizers(t *testing.T) {
+	layer := func(directory string, stage layerStageProperties) bundleLayer {
+		name := directory[strings.Index(directory, "-")+1:]
+		return bundleLayer{
+			directory:  directory,
+			properties: &avdimagetypes.V2LayerProperties{Name: name},
+			stage:      stage,
+		}
+	}
+
+	tests := []struct {
+		name              string
+		layers            []bundleLayer
+		expectCustomizers []string
+		expectArguments   []string
+	}{
+		{
+			name: "single stage",
+			layers: []bundleLayer{
+				layer("001-base", layerStageProperties{}),
+				layer("002-apps", layerStageProperties{RestartAfter: true}),
+			},
+			expectCustomizers: []string{"BundleExecution", "PostBundleExecutionRestart"},
+			expectArguments:   []string{"-ScanForDirectories -Force"},
+		},
+		{
+			name: "restart after a layer",
+			layers: []bundleLayer{
+				layer("001-drivers", layerStageProperties{RestartAfter: true}),
+				layer("002-dotnet", layerStageProperties{}),
+				layer("003-apps", layerStageProperties{}),
+			},
+			expectCustomizers: []string{"BundleExecutionStage1", "RestartAfterLayer-drivers", "BundleExecutionStage2", "PostBundleExecutionRestart"},
+			expectArguments: []string{
+				`-LayerPaths '.\001-drivers' -LayerIndexOffset 0 -Force`,
+				`-LayerPaths '.\002-dotnet','.\003-apps' -LayerIndexOffset 1 -Force`,
+			},
+		},
+		{
+			name: "windows update after layers",
+			layers: []bundleLayer{
+				layer("001-dotnet", layerStageProperties{RestartAfter: true, WindowsUpdateAfter: true}),
+				layer("002-apps", layerStageProperties{WindowsUpdateAfter: true}),
+			},
+			expectCustomizers: []string{
+				"BundleExecutionStage1", "RestartAfterLayer-dotnet", "WindowsUpdateAfterLayer-dotnet", "RestartAfterWindowsUpdateAfterLayer-dotnet",
+				"BundleExecutionStage2", "WindowsUpdateAfterLayer-apps", "PostBundleExecutionRestart",
+			},
+			expectArguments: []string{
+				`-LayerPaths '.\001-dotnet' -LayerIndexOffset 0 -Force`,
+				`-LayerPaths '.\002-apps' -LayerIndexOffset 1 -Force`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			customizers := bundleExecutionCustomizers(test.layers, `C:\image_bundle`)
+
+			var (
+				names     []string
+				arguments []string
+			)
+			for _, customizer := range customizers {
+				names = append(names, *customizer.GetImageTemplateCustomizer().Name)
+
+				if powershell, ok := customizer.(*armvirtualmachineimagebuilder.ImageTemplatePowerShellCustomizer); ok {
+					for _, line := range powershell.Inline {
+						if command, found := strings.CutPrefix(*line, `& "./execute.ps1" `); found {
+							arguments = append(arguments, command)
+						}
+					}
+				}
+			}
+
+			require.Equal(t, test.expectCustomizers, names)
+			require.Equal(t, test.expectArguments, arguments)
+		})
+	}
+}
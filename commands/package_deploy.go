@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/fs"
 
 	"io"
+	"maps"
 	"net/url"
 	"os"
 	"path"
@@ -42,14 +44,12 @@ var PackageDeployCommand = &cli.Command{
 			Aliases:  []string{"n"},
 		},
 		&cli.StringFlag{
-			Name:     "subscription",
-			Required: true,
-			Aliases:  []string{"s"},
+			Name:    "subscription",
+			Aliases: []string{"s"},
 		},
 		&cli.StringFlag{
-			Name:     "resource-group",
-			Required: true,
-			Aliases:  []string{"rg"},
+			Name:    "resource-group",
+			Aliases: []string{"rg"},
 		},
 		&cli.PathFlag{
 			Name:    "package",
@@ -102,7 +102,9 @@ var PackageDeployCommand = &cli.Command{
 			Name:  "parameters",
 			Usage: "A coma separated list of parameter key=value pairs to be automatically resolved",
 		},
+		commandProfileFlag,
 	},
+	Before: applyProfile("subscription", "resource-group"),
 	Action: func(c *cli.Context) error {
 		imageTemplateName := c.Path("name")
 		subscription := c.Path("subscription")
@@ -136,7 +138,7 @@ var PackageDeployCommand = &cli.Command{
 		fullPackagePath := filepath.Join(cwd, packagePath)
 
 		packageFs := os.DirFS(packagePath)
-		imageProperties, resourcesArchiveChecksum, err := scanPackagePath(packageFs, deploymentTemplateOutput, envFilePaths, argParams, resolveInteractively)
+		imageProperties, resourcesArchiveChecksum, resolvedParams, err := scanPackagePath(packageFs, deploymentTemplateOutput, envFilePaths, argParams, resolveInteractively)
 		if err != nil {
 			return errors.Wrapf(err, "failed to scan image package directory %s", fullPackagePath)
 		}
@@ -187,24 +189,58 @@ var PackageDeployCommand = &cli.Command{
 
 		imageBuilderClient := clientFactory.NewVirtualMachineImageTemplatesClient()
 
+		buildRecord := lib.NewBuildRecord("package deploy", subscription, resourceGroup, imageTemplateName)
+		buildRecord.ImageDefinition = galleryImageIdOfTemplate(&imageProperties.ImageTemplate.V)
+		buildRecord.BundleSha256 = hex.EncodeToString(resourcesArchiveChecksum)
+		buildRecord.Parameters = lib.RedactParameters(resolvedParams)
+
 		fmt.Println("Deploying image building template: " + imageTemplateName)
 		imageTemplateResourceID, err := createImageTemplate(ctx, imageBuilderClient, resourceGroup, imageTemplateName, imageProperties)
 		if err != nil {
+			buildRecord.Status = lib.BuildStatusDeploymentFailed
+			buildRecord.Message = err.Error()
+			lib.RecordBuild(buildRecord)
 			return err
 		}
 		fmt.Println("Image Template created: ", imageTemplateResourceID)
 
+		buildRecord.Status = lib.BuildStatusDeployed
+		lib.RecordBuild(buildRecord)
+
 		if startImageBuilderFlag {
 			fmt.Println("Starting image builder")
-			if err := startImageBuilder(context.Background(), imageBuilderClient, resourceGroup, imageTemplateName, waitForImageCompletion); err != nil {
+			if err := startImageBuilder(context.Background(), imageBuilderClient, resourceGroup, imageTemplateName, waitForImageCompletion, buildStatusCommand(c, imageTemplateName, subscription, resourceGroup)); err != nil {
+				if waitForImageCompletion {
+					buildRecord.Finish(lib.BuildStatusFailed, err.Error())
+					lib.RecordBuild(buildRecord)
+				}
 				return errors.Wrap(err, "failed to start image builder")
 			}
+
+			if waitForImageCompletion {
+				buildRecord.Finish(lib.BuildStatusSucceeded, "")
+				lib.RecordBuild(buildRecord)
+			}
 		}
 
 		return nil
 	},
 }
 
+// galleryImageIdOfTemplate returns the image definition of the first gallery distribution target of the template
+func galleryImageIdOfTemplate(imageTemplate *armvirtualmachineimagebuilder.ImageTemplate) string {
+	if imageTemplate.Properties == nil {
+		return ""
+	}
+
+	for _, distributor := range imageTemplate.Properties.Distribute {
+		if sharedImage, ok := distributor.(*armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor); ok && sharedImage.GalleryImageID != nil {
+			return *sharedImage.GalleryImageID
+		}
+	}
+	return ""
+}
+
 var errMalformedParams = errors.New("malformed parameters")
 
 func parseParametersFromArgument(paramStr string) (map[string]string, error) {
@@ -272,27 +308,30 @@ func parseResourcesURI(resourcesURI string) (*storageAccountBlob, error) {
 	}, nil
 }
 
-func scanPackagePath(packageFs fs.FS, deploymentTemplateOutputPath string, envFiles []string, argumentParameters map[string]string, resolveInteractively bool) (imageProperties *schema.ImageProperties, archiveSha256 []byte, err error) {
+func scanPackagePath(packageFs fs.FS, deploymentTemplateOutputPath string, envFiles []string, argumentParameters map[string]string, resolveInteractively bool) (imageProperties *schema.ImageProperties, archiveSha256 []byte, resolvedParams map[string]string, err error) {
 	// resolve parameters in the properties file
 	propertiesFile, err := packageFs.Open(imagePropertiesFileWithExtension)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to open properties file")
+		return nil, nil, nil, errors.Wrap(err, "failed to open properties file")
 	}
 	defer propertiesFile.Close()
 
 	propertiesFileContent, err := io.ReadAll(propertiesFile)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to read properties file")
+		return nil, nil, nil, errors.Wrap(err, "failed to read properties file")
 	}
 
-	var resolvedParams map[string]string
 	paramsToResolve := schema.FindPlaceholdersInJSON(propertiesFileContent, schema.ParameterPlaceholder)
 	if len(paramsToResolve) > 0 {
 		fmt.Printf("Resolving %d package parameters\n", len(paramsToResolve))
 		var err error
-		resolvedParams, err = resolveParameters(envFiles, argumentParameters, paramsToResolve, resolveInteractively)
+		defaults, err := schema.FindPlaceholderDefaults(propertiesFileContent, schema.ParameterPlaceholder)
+		if err != nil {
+			return nil, nil, nil, errors.Wrapf(err, "failed to find the parameter defaults in %s", imagePropertiesFileWithExtension)
+		}
+		resolvedParams, err = resolveParameters(envFiles, argumentParameters, paramsToResolve, defaults, resolveInteractively)
 		if err != nil {
-			return nil, nil, errors.Wrap(err, "failed to resolve parameters")
+			return nil, nil, nil, errors.Wrap(err, "failed to resolve parameters")
 		}
 
 		propertiesFileContent = schema.ReplacePlaceholders(propertiesFileContent, resolvedParams, schema.ParameterPlaceholder)
@@ -300,23 +339,23 @@ func scanPackagePath(packageFs fs.FS, deploymentTemplateOutputPath string, envFi
 
 	resolvedPropertiesFileContent, err := resolvePlaceholderProperties(propertiesFileContent)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to resolve placeholder properties")
+		return nil, nil, nil, errors.Wrap(err, "failed to resolve placeholder properties")
 	}
 
 	if err := json.Unmarshal(resolvedPropertiesFileContent, &imageProperties); err != nil {
-		return nil, nil, errors.Wrapf(err, "failed to parse image properties json:\n%s", resolvedPropertiesFileContent)
+		return nil, nil, nil, errors.Wrapf(err, "failed to parse image properties json:\n%s", resolvedPropertiesFileContent)
 	}
 
 	// resolve parameters in deployment template
 	deploymentTemplateFile, err := packageFs.Open(deploymentTemplateFileWithExtension)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to open deployment template file")
+		return nil, nil, nil, errors.Wrap(err, "failed to open deployment template file")
 	}
 	defer deploymentTemplateFile.Close()
 
 	originalDeploymentTemplateFileContents, err := io.ReadAll(deploymentTemplateFile)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to read deployment template file contents")
+		return nil, nil, nil, errors.Wrap(err, "failed to read deployment template file contents")
 	}
 
 	// first do a pass with previously resolved parameters from the properties file content
@@ -327,41 +366,58 @@ func scanPackagePath(packageFs fs.FS, deploymentTemplateOutputPath string, envFi
 	paramsToResolve = schema.FindPlaceholdersInJSON(resolvedDeploymentTemplateFileContents, schema.ParameterPlaceholder)
 	if len(paramsToResolve) > 0 {
 		fmt.Printf("Resolving %d deployment template parameters\n", len(paramsToResolve))
-		resolvedParams, err := resolveParameters(envFiles, argumentParameters, paramsToResolve, resolveInteractively)
+		defaults, err := schema.FindPlaceholderDefaults(resolvedDeploymentTemplateFileContents, schema.ParameterPlaceholder)
 		if err != nil {
-			return nil, nil, errors.Wrap(err, "failed to resolve parameters")
+			return nil, nil, nil, errors.Wrapf(err, "failed to find the parameter defaults in %s", deploymentTemplateFileWithExtension)
+		}
+		templateParams, err := resolveParameters(envFiles, argumentParameters, paramsToResolve, defaults, resolveInteractively)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "failed to resolve parameters")
 		}
 
-		resolvedDeploymentTemplateFileContents = schema.ReplacePlaceholders(resolvedDeploymentTemplateFileContents, resolvedParams, schema.ParameterPlaceholder)
+		resolvedDeploymentTemplateFileContents = schema.ReplacePlaceholders(resolvedDeploymentTemplateFileContents, templateParams, schema.ParameterPlaceholder)
+
+		// the returned parameters include the ones of the deployment template, so the build record is complete
+		if resolvedParams == nil {
+			resolvedParams = map[string]string{}
+		}
+		maps.Copy(resolvedParams, templateParams)
+	}
+
+	if err := checkUnresolvedPlaceholders(imagePropertiesFileWithExtension, resolvedPropertiesFileContent); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkUnresolvedPlaceholders(deploymentTemplateFileWithExtension, resolvedDeploymentTemplateFileContents); err != nil {
+		return nil, nil, nil, err
 	}
 
 	// Write the deployment template output file
 	if err := os.WriteFile(deploymentTemplateOutputPath, resolvedDeploymentTemplateFileContents, 0644); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to write deployment template file to disk")
+		return nil, nil, nil, errors.Wrap(err, "failed to write deployment template file to disk")
 	}
 
 	hash := sha256.New()
 	resourcesFile, err := packageFs.Open(resourcesArchiveName)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to open resources archive")
+		return nil, nil, nil, errors.Wrap(err, "failed to open resources archive")
 	}
 	defer resourcesFile.Close()
 
 	resourcesFileStats, err := resourcesFile.Stat()
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to check for resources archive")
+		return nil, nil, nil, errors.Wrap(err, "failed to check for resources archive")
 	}
 	if resourcesFileStats.IsDir() {
-		return nil, nil, errors.New("resources archive is expected to be a file, but it is a directory")
+		return nil, nil, nil, errors.New("resources archive is expected to be a file, but it is a directory")
 	}
 
 	bar := progressbar.DefaultBytes(resourcesFileStats.Size(), "Calculating resources archive checksum")
 
 	if _, err := io.Copy(io.MultiWriter(bar, hash), resourcesFile); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to calculate resources archive checksum")
+		return nil, nil, nil, errors.Wrap(err, "failed to calculate resources archive checksum")
 	}
 
-	return imageProperties, hash.Sum(nil), nil
+	return imageProperties, hash.Sum(nil), resolvedParams, nil
 }
 
 func replaceSourceURIPlaceholder(imageTemplate armvirtualmachineimagebuilder.ImageTemplate, resourcesURI *storageAccountBlob) error {
@@ -390,7 +446,9 @@ func replaceSourceURIPlaceholder(imageTemplate armvirtualmachineimagebuilder.Ima
 	return nil
 }
 
-func resolveParameters(envFiles []string, argumentParameters map[string]string, params map[string]struct{}, resolveInteractively bool) (map[string]string, error) {
+// resolveParameters resolves the parameters from the arguments, the env files, the placeholder defaults
+// and finally by prompting the user, in that order
+func resolveParameters(envFiles []string, argumentParameters map[string]string, params map[string]struct{}, defaults map[string]string, resolveInteractively bool) (map[string]string, error) {
 	env, err := godotenv.Read(envFiles...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read env files")
@@ -413,6 +471,12 @@ func resolveParameters(envFiles []string, argumentParameters map[string]string,
 		// we mark as unresolved to resolve interactively
 		value, ok := env[param]
 		if !ok {
+			if defaultValue, ok := defaults[param]; ok {
+				resolvedParams[param] = defaultValue
+				fmt.Printf("\t%s=%s (default)\n", param, defaultValue)
+				continue
+			}
+
 			unresolvedParams = append(unresolvedParams, param)
 
 			message := "UNRESOLVED"
@@ -464,12 +528,21 @@ func resolvePlaceholderProperties(imagePropsJSON []byte) ([]byte, error) {
 		if len(props) == 0 {
 			break
 		}
+		defaults, err := schema.FindPlaceholderDefaults(imagePropsJSON, schema.PropertiesPlaceholder)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find the property defaults")
+		}
 
 		mapping := make(map[string]string, len(props))
 		for prop := range props {
 			jsonValue, ok := imageProperties.PlaceholderProperties[prop]
 			if !ok {
-				return nil, fmt.Errorf("cannot resolve placeholder property %s", prop)
+				defaultValue, ok := defaults[prop]
+				if !ok {
+					return nil, fmt.Errorf("cannot resolve placeholder property %s", prop)
+				}
+				mapping[prop] = defaultValue
+				continue
 			}
 
 			// if the value is not a string, it is some
@@ -495,6 +568,28 @@ func resolvePlaceholderProperties(imagePropsJSON []byte) ([]byte, error) {
 	return imagePropsJSON, nil
 }
 
+// unresolvedPlaceholderTypes are the placeholders that must all be resolved before a package is deployed
+var unresolvedPlaceholderTypes = []schema.PlaceholderType{schema.ParameterPlaceholder, schema.PropertiesPlaceholder, schema.BuiltInPlaceholder}
+
+// checkUnresolvedPlaceholders returns an error listing the placeholders that are left in the resolved file
+func checkUnresolvedPlaceholders(filename string, data []byte) error {
+	placeholders, err := schema.FindPlaceholders(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to search %s for unresolved placeholders", filename)
+	}
+
+	var unresolved []string
+	for _, placeholder := range placeholders {
+		if slices.Contains(unresolvedPlaceholderTypes, placeholder.Type) {
+			unresolved = append(unresolved, fmt.Sprintf("%s at %s", placeholder.Text, placeholder.Pointer))
+		}
+	}
+	if len(unresolved) > 0 {
+		return fmt.Errorf("%s contains %d unresolved placeholder(s):\n\t%s", filename, len(unresolved), strings.Join(unresolved, "\n\t"))
+	}
+	return nil
+}
+
 func uploadResourcesArchive(ctx context.Context, azCreds azcore.TokenCredential, resourcesURI *storageAccountBlob, resourcesFs fs.FS, resourcesArchivePath string) (alreadyUploaded bool, err error) {
 	azBlobClient, err := azblob.NewClient(resourcesURI.serviceURL(), azCreds, nil)
 	if err != nil {
@@ -556,7 +651,7 @@ func createImageTemplate(ctx context.Context, imageTemplateClient *armvirtualmac
 	return *createTemplateRes.ID, nil
 }
 
-func startImageBuilder(ctx context.Context, imageTemplateClient *armvirtualmachineimagebuilder.VirtualMachineImageTemplatesClient, resourceGroup, name string, wait bool) error {
+func startImageBuilder(ctx context.Context, imageTemplateClient *armvirtualmachineimagebuilder.VirtualMachineImageTemplatesClient, resourceGroup, name string, wait bool, statusCommand string) error {
 	poller, err := imageTemplateClient.BeginRun(ctx, resourceGroup, name, nil)
 	if err != nil {
 		return errors.Wrap(err, "failed to call beginRun api")
@@ -571,6 +666,7 @@ func startImageBuilder(ctx context.Context, imageTemplateClient *armvirtualmachi
 		fmt.Println("Image Builder finished. Check the Azure Portal")
 	} else {
 		fmt.Println("Started image builder. You can track the progress in the Azure Portal")
+		fmt.Printf("or with: %s --watch\n", statusCommand)
 	}
 
 	return nil
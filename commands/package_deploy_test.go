@@ -1,8 +1,12 @@
 package commands
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 
+	"github.com/schoolyear/avd-cli/schema"
 	"github.com/stretchr/testify/require"
 )
 
@@ -85,3 +89,48 @@ func Test_parseParametersFromArgument(t *testing.T) {
 		}
 	}
 }
+
+func Test_scanPackagePath_placeholders(t *testing.T) {
+	properties := `{
+		"placeholderProperties": {"team": 42},
+		"whitelistedHosts": {},
+		"imageTemplate": {"location": "[[[param:location|westeurope]]]", "tags": {"team": "[[[props:team]]]", "owner": "[[[props:owner|nobody]]]"}}
+	}`
+	packageFs := fstest.MapFS{
+		imagePropertiesFileWithExtension:    {Data: []byte(properties)},
+		deploymentTemplateFileWithExtension: {Data: []byte(`{"parameters": {"size": "[[[param:vmSize|Standard_D2s_v5]]]", "location": "[[[param:location]]]"}}`)},
+		resourcesArchiveName:                {Data: []byte("zip")},
+	}
+	outputPath := filepath.Join(t.TempDir(), "template.json")
+	envFile := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, os.WriteFile(envFile, nil, 0644))
+
+	imageProperties, _, resolvedParams, err := scanPackagePath(packageFs, outputPath, []string{envFile}, map[string]string{"vmSize": "Standard_D4s_v5"}, false)
+	require.NoError(t, err)
+	require.Equal(t, "westeurope", *imageProperties.ImageTemplate.V.Location)
+	require.Equal(t, "42", *imageProperties.ImageTemplate.V.Tags["team"])
+	require.Equal(t, "nobody", *imageProperties.ImageTemplate.V.Tags["owner"])
+	require.Equal(t, map[string]string{"location": "westeurope", "vmSize": "Standard_D4s_v5"}, resolvedParams)
+
+	template, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"parameters": {"size": "Standard_D4s_v5", "location": "westeurope"}}`, string(template))
+
+	packageFs[deploymentTemplateFileWithExtension] = &fstest.MapFile{Data: []byte(`{"resources": [{"hosts": "[[[builtin:sessionHostProxyWhitelist]]]"}]}`)}
+	_, _, _, err = scanPackagePath(packageFs, filepath.Join(t.TempDir(), "template.json"), []string{envFile}, nil, false)
+	require.ErrorContains(t, err, "deployment_template.json contains 1 unresolved placeholder(s):\n\t[[[builtin:sessionHostProxyWhitelist]]] at /resources/0/hosts")
+}
+
+func Test_findPackagePlaceholders(t *testing.T) {
+	placeholders, err := findPackagePlaceholders(fstest.MapFS{
+		imagePropertiesFileWithExtension:    {Data: []byte(`{"imageTemplate": {"location": "[[[param:location|westeurope]]]"}}`)},
+		deploymentTemplateFileWithExtension: {Data: []byte(`{"parameters": {"hosts": "[[[builtin:sessionHostProxyWhitelist]]]"}}`)},
+	})
+	require.NoError(t, err)
+	require.Len(t, placeholders, 2)
+	require.Equal(t, imagePropertiesFileWithExtension, placeholders[0].File)
+	require.Equal(t, "/imageTemplate/location", placeholders[0].Pointer)
+	require.Equal(t, "westeurope", *placeholders[0].Default)
+	require.Equal(t, deploymentTemplateFileWithExtension, placeholders[1].File)
+	require.Equal(t, schema.BuiltInPlaceholder, placeholders[1].Type)
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/schema"
+	"github.com/urfave/cli/v2"
+)
+
+var PackageParamsCommand = &cli.Command{
+	Name:  "params",
+	Usage: "List the placeholders in a package that are resolved when it is deployed",
+	Description: `Placeholders have the form [[[type:name]]] or [[[type:name|default]]]. A default cannot contain ']'.
+param placeholders are resolved from --parameters, the env files, their default or interactively,
+props placeholders from the placeholderProperties of the image properties or their default.`,
+	Flags: []cli.Flag{
+		&cli.PathFlag{
+			Name:    "package",
+			Value:   "./out",
+			Usage:   "Path to the image building package",
+			Aliases: []string{"p"},
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Print the placeholders as JSON",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		packagePath := c.Path("package")
+		asJSON := c.Bool("json")
+
+		placeholders, err := findPackagePlaceholders(os.DirFS(packagePath))
+		if err != nil {
+			return errors.Wrapf(err, "failed to scan package %s", packagePath)
+		}
+
+		if asJSON {
+			if placeholders == nil {
+				placeholders = []packagePlaceholder{}
+			}
+			return printJSON(placeholders)
+		}
+
+		if len(placeholders) == 0 {
+			fmt.Println("The package has no placeholders")
+			return nil
+		}
+
+		fmt.Printf("%-8s  %-30s  %-20s  %-24s  %s\n", "TYPE", "NAME", "DEFAULT", "FILE", "LOCATION")
+		for _, placeholder := range placeholders {
+			defaultValue := "-"
+			if placeholder.Default != nil {
+				defaultValue = fmt.Sprintf("%q", *placeholder.Default)
+			}
+			fmt.Printf("%-8s  %-30s  %-20s  %-24s  %s\n", placeholder.Type, placeholder.Name, defaultValue, placeholder.File, placeholder.Pointer)
+		}
+
+		return nil
+	},
+}
+
+type packagePlaceholder struct {
+	File string `json:"file"`
+	schema.Placeholder
+}
+
+// findPackagePlaceholders returns the placeholders in the image properties and deployment template of the package
+func findPackagePlaceholders(packageFs fs.FS) ([]packagePlaceholder, error) {
+	var placeholders []packagePlaceholder
+	for _, filename := range []string{imagePropertiesFileWithExtension, deploymentTemplateFileWithExtension} {
+		data, err := fs.ReadFile(packageFs, filename)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", filename)
+		}
+
+		filePlaceholders, err := schema.FindPlaceholders(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", filename)
+		}
+		for _, placeholder := range filePlaceholders {
+			placeholders = append(placeholders, packagePlaceholder{File: filename, Placeholder: placeholder})
+		}
+	}
+	return placeholders, nil
+}
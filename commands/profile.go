@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib"
+	"github.com/urfave/cli/v2"
+)
+
+var ProfileFlag = &cli.StringFlag{
+	Name:    "profile",
+	Usage:   "Profile in the user config (~/.avdcli/config) with default values of the Azure flags. Defaults to the default_profile of the user config",
+	EnvVars: []string{"AVDCLI_PROFILE"},
+}
+
+// commandProfileFlag is registered on the commands that use a profile, so --profile can be passed after the command as well.
+// it has no EnvVars, so AVDCLI_PROFILE doesn't take precedence over a --profile before the command
+var commandProfileFlag = &cli.StringFlag{
+	Name:  ProfileFlag.Name,
+	Usage: ProfileFlag.Usage,
+}
+
+// selectedProfileName returns the --profile passed to the command, or else the one passed to the app or AVDCLI_PROFILE.
+// c.String would only look at the flag of the command, as that shadows the flag of the app
+func selectedProfileName(c *cli.Context) string {
+	for _, ctx := range c.Lineage() {
+		if slices.Contains(ctx.LocalFlagNames(), ProfileFlag.Name) {
+			return ctx.String(ProfileFlag.Name)
+		}
+	}
+	return ""
+}
+
+// profileFlagKeys maps the names of flags to the profile setting they default to
+var profileFlagKeys = map[string]string{
+	"subscription-id":                      "subscription_id",
+	"subscription":                         "subscription_id",
+	azureTenantIdFlag.Name:                 "tenant_id",
+	azureBackendFlag.Name:                  "azure_backend",
+	"resource-group":                       "resource_group",
+	"image-gallery":                        "image_gallery",
+	"storage-account":                      "storage_account",
+	"blob-container":                       "blob_container",
+	"resources-uri":                        "resources_uri",
+	"managed-identity":                     "managed_identity",
+	"template-location":                    "template_location",
+	"replication-regions":                  "replication_regions",
+	"builder-subnet-id":                    "builder_subnet_id",
+	"builder-proxy-vm-size":                "builder_proxy_vm_size",
+	"builder-container-instance-subnet-id": "builder_container_instance_subnet_id",
+}
+
+// applyProfile returns a Before function that sets the flags of the command that are not set to the values of the selected profile.
+// afterwards, it checks that the required flags are set. These flags can't be marked as Required,
+// because urfave/cli checks those before the profile is applied
+func applyProfile(requiredFlags ...string) cli.BeforeFunc {
+	return func(c *cli.Context) error {
+		userConfig, err := lib.LoadUserConfig()
+		if err != nil {
+			return err
+		}
+
+		profileName, profile, err := userConfig.SelectProfile(selectedProfileName(c))
+		if err != nil {
+			return err
+		}
+
+		for _, flag := range c.Command.Flags {
+			name := flag.Names()[0]
+			key, ok := profileFlagKeys[name]
+			if !ok || c.IsSet(name) {
+				continue
+			}
+
+			value := profile[key]
+			if value == "" {
+				continue
+			}
+			if err := c.Set(name, value); err != nil {
+				return errors.Wrapf(err, "invalid %s in profile %s", key, profileName)
+			}
+		}
+
+		var missing []string
+		for _, name := range requiredFlags {
+			if !c.IsSet(name) {
+				missing = append(missing, name)
+			}
+		}
+		switch len(missing) {
+		case 0:
+			return nil
+		case 1:
+			return fmt.Errorf("required flag \"%s\" not set. Pass it, or set %s in a profile with \"avdcli config set\"", missing[0], profileFlagKeys[missing[0]])
+		default:
+			return fmt.Errorf("required flags \"%s\" not set. Pass them, or set them in a profile with \"avdcli config set\"", strings.Join(missing, ", "))
+		}
+	}
+}
+
+// buildStatusCommand returns the command to follow the build of an Image Template.
+// it refers to the profile if the profile holds the subscription and resource group, instead of repeating them
+func buildStatusCommand(c *cli.Context, templateName, subscriptionId, resourceGroup string) string {
+	target := fmt.Sprintf("--subscription-id %s --resource-group %s", subscriptionId, resourceGroup)
+
+	if userConfig, err := lib.LoadUserConfig(); err == nil {
+		profileName, profile, err := userConfig.SelectProfile(selectedProfileName(c))
+		if err == nil && profileName != "" && profile["subscription_id"] == subscriptionId && profile["resource_group"] == resourceGroup {
+			target = "--profile " + profileName
+		}
+	}
+
+	return fmt.Sprintf("avdcli build status %s %s", templateName, target)
+}
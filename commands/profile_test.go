@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"testing"
+
+	"github.com/schoolyear/avd-cli/lib"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func Test_applyProfile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("AVDCLI_PROFILE", "")
+
+	require.NoError(t, lib.SaveUserConfig(&lib.UserConfig{
+		DefaultProfile: "test",
+		Profiles: map[string]lib.Profile{
+			"test": {"subscription_id": "sub-test", "resource_group": "rg-test", "replication_regions": "westeurope=20,northeurope"},
+			"prod": {"subscription_id": "sub-prod"},
+		},
+	}))
+
+	run := func(args ...string) (map[string]any, error) {
+		values := map[string]any{}
+		app := &cli.App{
+			Flags: []cli.Flag{ProfileFlag},
+			Commands: []*cli.Command{{
+				Name: "build",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "subscription-id"},
+					&cli.StringFlag{Name: "resource-group"},
+					&cli.StringSliceFlag{Name: "replication-regions"},
+					commandProfileFlag,
+				},
+				Before: applyProfile("subscription-id", "resource-group"),
+				Action: func(c *cli.Context) error {
+					values["subscription-id"] = c.String("subscription-id")
+					values["resource-group"] = c.String("resource-group")
+					values["replication-regions"] = c.StringSlice("replication-regions")
+					return nil
+				},
+			}},
+		}
+		err := app.RunContext(context.Background(), append([]string{"avdcli"}, args...))
+		return values, err
+	}
+
+	values, err := run("build")
+	require.NoError(t, err)
+	require.Equal(t, "sub-test", values["subscription-id"])
+	require.Equal(t, "rg-test", values["resource-group"])
+	require.Equal(t, []string{"westeurope=20", "northeurope"}, values["replication-regions"])
+
+	values, err = run("build", "--subscription-id", "sub-flag")
+	require.NoError(t, err)
+	require.Equal(t, "sub-flag", values["subscription-id"], "explicit flags take precedence")
+	require.Equal(t, "rg-test", values["resource-group"])
+
+	_, err = run("--profile", "prod", "build")
+	require.ErrorContains(t, err, `required flag "resource-group" not set`)
+
+	values, err = run("--profile", "prod", "build", "-resource-group", "rg-flag")
+	require.NoError(t, err)
+	require.Equal(t, "sub-prod", values["subscription-id"])
+
+	values, err = run("build", "--profile", "prod", "--resource-group", "rg-flag")
+	require.NoError(t, err)
+	require.Equal(t, "sub-prod", values["subscription-id"], "the profile can be passed after the command")
+
+	t.Setenv("AVDCLI_PROFILE", "prod")
+	_, err = run("build")
+	require.ErrorContains(t, err, "resource-group")
+
+	_, err = run("--profile", "missing", "build")
+	require.ErrorContains(t, err, "profile missing does not exist")
+}
+
+func Test_setUserConfigValue(t *testing.T) {
+	userConfig := &lib.UserConfig{}
+
+	profileName, err := setUserConfigValue(userConfig, "", "subscription_id", "sub-1")
+	require.NoError(t, err)
+	require.Equal(t, "default", profileName)
+	require.Equal(t, "default", userConfig.DefaultProfile)
+
+	profileName, err = setUserConfigValue(userConfig, "prod", "resource_group", "rg-prod")
+	require.NoError(t, err)
+	require.Equal(t, "prod", profileName)
+	require.Equal(t, lib.Profile{"resource_group": "rg-prod"}, userConfig.Profiles["prod"])
+
+	_, err = setUserConfigValue(userConfig, "", "default_profile", "prod")
+	require.NoError(t, err)
+	require.Equal(t, "prod", userConfig.DefaultProfile)
+
+	_, err = setUserConfigValue(userConfig, "", "resource_group", "")
+	require.NoError(t, err)
+	require.Empty(t, userConfig.Profiles["prod"])
+
+	_, err = setUserConfigValue(userConfig, "", "default_profile", "staging")
+	require.ErrorContains(t, err, "does not exist")
+
+	_, err = setUserConfigValue(userConfig, "", "image_definition", "win11")
+	require.ErrorContains(t, err, "unknown key")
+}
+
+func Test_buildStatusCommand(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("AVDCLI_PROFILE", "")
+
+	require.NoError(t, lib.SaveUserConfig(&lib.UserConfig{
+		DefaultProfile: "test",
+		Profiles: map[string]lib.Profile{
+			"test": {"subscription_id": "sub-test", "resource_group": "rg-test"},
+		},
+	}))
+
+	tests := []struct {
+		name           string
+		subscriptionId string
+		resourceGroup  string
+		want           string
+	}{
+		{
+			name:           "values from the profile",
+			subscriptionId: "sub-test",
+			resourceGroup:  "rg-test",
+			want:           "avdcli build status template --profile test",
+		},
+		{
+			name:           "flag overrides the profile",
+			subscriptionId: "sub-test",
+			resourceGroup:  "rg-flag",
+			want:           "avdcli build status template --subscription-id sub-test --resource-group rg-flag",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cli.NewContext(&cli.App{}, flag.NewFlagSet("avdcli", flag.ContinueOnError), nil)
+			require.Equal(t, tt.want, buildStatusCommand(c, "template", tt.subscriptionId, tt.resourceGroup))
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+)
+
+// regionReplication is a region to which the image version is replicated
+type regionReplication struct {
+	Region             string
+	ReplicaCount       int32
+	StorageAccountType *armvirtualmachineimagebuilder.SharedImageStorageAccountType // nil uses the Azure default (Standard_LRS)
+}
+
+// parseRegionReplications parses region specs in the format region[=count[:storage-type]], like westeurope=20:Standard_ZRS
+// regions without count get the default count
+func parseRegionReplications(specs []string, defaultCount int32) ([]regionReplication, error) {
+	replications := make([]regionReplication, 0, len(specs))
+	seen := make(map[string]struct{}, len(specs))
+
+	for _, spec := range specs {
+		regionName, settings, hasSettings := strings.Cut(strings.TrimSpace(spec), "=")
+
+		region, _ := lib_azure.NormalizeRegion(regionName)
+		if region == "" {
+			return nil, fmt.Errorf("invalid region spec %q: region name is empty", spec)
+		}
+		if !lib_azure.IsValidRegionName(region) {
+			return nil, fmt.Errorf("invalid region spec %q: %s is not a valid Azure region name", spec, regionName)
+		}
+		if _, ok := seen[region]; ok {
+			return nil, fmt.Errorf("region %s is specified more than once", region)
+		}
+		seen[region] = struct{}{}
+
+		replication := regionReplication{
+			Region:       region,
+			ReplicaCount: defaultCount,
+		}
+
+		if hasSettings {
+			countValue, storageTypeValue, hasStorageType := strings.Cut(settings, ":")
+
+			if countValue != "" {
+				count, err := strconv.ParseInt(countValue, 10, 32)
+				if err != nil || count < 1 || count > 100 {
+					return nil, fmt.Errorf("invalid region spec %q: replica count must be a number between 1 and 100", spec)
+				}
+				replication.ReplicaCount = int32(count)
+			}
+
+			if hasStorageType {
+				storageType, err := parseSharedImageStorageAccountType(storageTypeValue)
+				if err != nil {
+					return nil, fmt.Errorf("invalid region spec %q: %w", spec, err)
+				}
+				replication.StorageAccountType = &storageType
+			}
+		}
+
+		replications = append(replications, replication)
+	}
+
+	return replications, nil
+}
+
+// unknownRegionWarnings warns about regions that are not in the list of public Azure regions.
+// they are not rejected, as they may be regions of a sovereign cloud or regions that were added later
+func unknownRegionWarnings(location string, replications []regionReplication) []string {
+	var warnings []string
+	if _, known := lib_azure.NormalizeRegion(location); !known {
+		warnings = append(warnings, fmt.Sprintf("template location %s is not a known public Azure region, check that it exists", location))
+	}
+	for _, replication := range replications {
+		if _, known := lib_azure.NormalizeRegion(replication.Region); !known {
+			warnings = append(warnings, fmt.Sprintf("replication region %s is not a known public Azure region, check that it exists", replication.Region))
+		}
+	}
+	return warnings
+}
+
+func parseSharedImageStorageAccountType(value string) (armvirtualmachineimagebuilder.SharedImageStorageAccountType, error) {
+	possibleValues := armvirtualmachineimagebuilder.PossibleSharedImageStorageAccountTypeValues()
+	for _, storageType := range possibleValues {
+		if strings.EqualFold(string(storageType), value) {
+			return storageType, nil
+		}
+	}
+
+	names := make([]string, len(possibleValues))
+	for i, storageType := range possibleValues {
+		names[i] = string(storageType)
+	}
+	return "", fmt.Errorf("unknown storage account type %s. available: %s", value, strings.Join(names, ", "))
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRegionReplications(t *testing.T) {
+	tests := []struct {
+		name      string
+		specs     []string
+		expect    []regionReplication
+		expectErr bool
+	}{
+		{
+			name:  "default count",
+			specs: []string{"westeurope", "North Europe"},
+			expect: []regionReplication{
+				{Region: "westeurope", ReplicaCount: 5},
+				{Region: "northeurope", ReplicaCount: 5},
+			},
+		},
+		{
+			name:  "count and storage type",
+			specs: []string{"westeurope=20:standard_zrs", "northeurope=2", "swedencentral=:Premium_LRS"},
+			expect: []regionReplication{
+				{Region: "westeurope", ReplicaCount: 20, StorageAccountType: to.Ptr(armvirtualmachineimagebuilder.SharedImageStorageAccountTypeStandardZRS)},
+				{Region: "northeurope", ReplicaCount: 2},
+				{Region: "swedencentral", ReplicaCount: 5, StorageAccountType: to.Ptr(armvirtualmachineimagebuilder.SharedImageStorageAccountTypePremiumLRS)},
+			},
+		},
+		{
+			name:   "unknown region is allowed",
+			specs:  []string{"usgovvirginia=2"},
+			expect: []regionReplication{{Region: "usgovvirginia", ReplicaCount: 2}},
+		},
+		{name: "invalid region name", specs: []string{"west-europe=2"}, expectErr: true},
+		{name: "duplicate region", specs: []string{"westeurope=2", "West Europe"}, expectErr: true},
+		{name: "invalid count", specs: []string{"westeurope=many"}, expectErr: true},
+		{name: "zero count", specs: []string{"westeurope=0"}, expectErr: true},
+		{name: "unknown storage type", specs: []string{"westeurope=2:Standard_GRS"}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replications, err := parseRegionReplications(test.specs, 5)
+			if test.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expect, replications)
+		})
+	}
+}
+
+func Test_unknownRegionWarnings(t *testing.T) {
+	require.Empty(t, unknownRegionWarnings("westeurope", []regionReplication{{Region: "northeurope"}}))
+
+	warnings := unknownRegionWarnings("usgovvirginia", []regionReplication{{Region: "westeurope"}, {Region: "chinanorth3"}})
+	require.Len(t, warnings, 2)
+	require.Contains(t, warnings[0], "template location usgovvirginia")
+	require.Contains(t, warnings[1], "replication region chinanorth3")
+}
@@ -0,0 +1,397 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	imageTemplateResourceType = "Microsoft.VirtualMachineImages/imageTemplates"
+	imageTemplateApiVersion   = "2024-02-01"
+)
+
+type templateFormat string
+
+const (
+	templateFormatARM       templateFormat = "arm"
+	templateFormatBicep     templateFormat = "bicep"
+	templateFormatTerraform templateFormat = "terraform"
+)
+
+var templateFormats = []templateFormat{templateFormatARM, templateFormatBicep, templateFormatTerraform}
+
+func joinTemplateFormats() string {
+	names := make([]string, len(templateFormats))
+	for i, format := range templateFormats {
+		names[i] = string(format)
+	}
+	return strings.Join(names, ", ")
+}
+
+func parseTemplateFormat(value string) (templateFormat, error) {
+	for _, format := range templateFormats {
+		if string(format) == value {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown template format %s. available: %s", value, joinTemplateFormats())
+}
+
+// defaultFilename is the file to which the template is written if --deployment-template is not set
+func (f templateFormat) defaultFilename() string {
+	switch f {
+	case templateFormatBicep:
+		return "deploy.bicep"
+	case templateFormatTerraform:
+		return "deploy.tf"
+	default:
+		return "deploy.json"
+	}
+}
+
+// templateParameter is an environment-specific value that is a parameter (Bicep) or variable (Terraform)
+// instead of a literal, so the output can be committed and deployed to multiple environments
+type templateParameter struct {
+	bicepName     string
+	terraformName string
+	description   string
+	value         string
+}
+
+// imageTemplateParameters are the parameters of the Bicep and Terraform output.
+// the location is only replaced in the location of the template, the other values are replaced anywhere in the template
+func imageTemplateParameters(location, managedIdentityId, imageDefinitionId string, builderNetwork *builderNetworkConfig) (locationParam templateParameter, params []templateParameter) {
+	locationParam = templateParameter{
+		bicepName:     "location",
+		terraformName: "location",
+		description:   "Azure location of the Image Template",
+		value:         location,
+	}
+	params = []templateParameter{
+		{
+			bicepName:     "managedIdentityId",
+			terraformName: "managed_identity_id",
+			description:   "Resource id of the user-assigned managed identity that builds the image",
+			value:         managedIdentityId,
+		},
+		{
+			bicepName:     "galleryImageId",
+			terraformName: "gallery_image_id",
+			description:   "Resource id of the gallery image definition to which the image is distributed",
+			value:         imageDefinitionId,
+		},
+	}
+
+	if builderNetwork != nil {
+		params = append(params, templateParameter{
+			bicepName:     "builderSubnetId",
+			terraformName: "builder_subnet_id",
+			description:   "Resource id of the subnet in which the builder VM is deployed",
+			value:         builderNetwork.SubnetID,
+		})
+		if builderNetwork.ContainerInstanceSubnetID != "" {
+			params = append(params, templateParameter{
+				bicepName:     "builderContainerInstanceSubnetId",
+				terraformName: "builder_container_instance_subnet_id",
+				description:   "Resource id of the subnet in which the container instance of an isolated build is deployed",
+				value:         builderNetwork.ContainerInstanceSubnetID,
+			})
+		}
+	}
+
+	return locationParam, params
+}
+
+// templateSyntax renders values in the syntax of Bicep or Terraform
+type templateSyntax struct {
+	quote         func(literal string) string // quotes a literal string, without the surrounding quotes
+	quoteChar     string
+	interpolation func(expression string) string
+	reference     func(param templateParameter) string
+	queryEscape   func(expression string) string
+	objectKey     func(key string) string // renders a literal key
+	exprKey       func(expression string) string
+	assign        string
+	listSeparator string
+}
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var (
+	bicepQuoter     = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", `\${`)
+	terraformQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", "$${", "%{", "%%{")
+)
+
+var bicepSyntax = templateSyntax{
+	quote:         bicepQuoter.Replace,
+	quoteChar:     "'",
+	interpolation: func(expression string) string { return "${" + expression + "}" },
+	reference:     func(param templateParameter) string { return param.bicepName },
+	queryEscape:   func(expression string) string { return "uriComponent(" + expression + ")" },
+	objectKey: func(key string) string {
+		if identifierRegex.MatchString(key) {
+			return key
+		}
+		return "'" + bicepQuoter.Replace(key) + "'"
+	},
+	exprKey:       func(expression string) string { return "'${" + expression + "}'" },
+	assign:        ": ",
+	listSeparator: "",
+}
+
+var terraformSyntax = templateSyntax{
+	quote:         terraformQuoter.Replace,
+	quoteChar:     `"`,
+	interpolation: func(expression string) string { return "${" + expression + "}" },
+	reference:     func(param templateParameter) string { return "var." + param.terraformName },
+	queryEscape:   func(expression string) string { return "urlencode(" + expression + ")" },
+	objectKey: func(key string) string {
+		if identifierRegex.MatchString(key) {
+			return key
+		}
+		return `"` + terraformQuoter.Replace(key) + `"`
+	},
+	exprKey:       func(expression string) string { return "(" + expression + ")" },
+	assign:        " = ",
+	listSeparator: ",",
+}
+
+// templateRenderer writes JSON values in Bicep or Terraform syntax, replacing parameter values by references
+type templateRenderer struct {
+	syntax templateSyntax
+	params []templateParameter
+	buf    bytes.Buffer
+}
+
+type parameterOccurrence struct {
+	literal    string
+	expression string
+}
+
+// occurrences returns the literal forms in which parameter values appear, longest first
+func (r *templateRenderer) occurrences() []parameterOccurrence {
+	var occurrences []parameterOccurrence
+	for _, param := range r.params {
+		if param.value == "" {
+			continue
+		}
+		reference := r.syntax.reference(param)
+		occurrences = append(occurrences, parameterOccurrence{literal: param.value, expression: reference})
+		if escaped := url.QueryEscape(param.value); escaped != param.value {
+			occurrences = append(occurrences, parameterOccurrence{literal: escaped, expression: r.syntax.queryEscape(reference)})
+		}
+	}
+	slices.SortStableFunc(occurrences, func(a, b parameterOccurrence) int {
+		return len(b.literal) - len(a.literal)
+	})
+	return occurrences
+}
+
+// stringValue renders a string, as a reference if it equals a parameter value, or with interpolations if it contains one
+func (r *templateRenderer) stringValue(value string) (rendered string, isExpression bool) {
+	occurrences := r.occurrences()
+	for _, occurrence := range occurrences {
+		if value == occurrence.literal {
+			return occurrence.expression, true
+		}
+	}
+
+	var (
+		out        strings.Builder
+		replaced   bool
+		remainder  = value
+		quoteChar  = r.syntax.quoteChar
+		quoteValue = r.syntax.quote
+	)
+	out.WriteString(quoteChar)
+	for remainder != "" {
+		index, match := -1, parameterOccurrence{}
+		for _, occurrence := range occurrences {
+			if i := strings.Index(remainder, occurrence.literal); i >= 0 && (index < 0 || i < index) {
+				index, match = i, occurrence
+			}
+		}
+		if index < 0 {
+			out.WriteString(quoteValue(remainder))
+			break
+		}
+
+		out.WriteString(quoteValue(remainder[:index]))
+		out.WriteString(r.syntax.interpolation(match.expression))
+		remainder = remainder[index+len(match.literal):]
+		replaced = true
+	}
+	out.WriteString(quoteChar)
+
+	return out.String(), replaced
+}
+
+func (r *templateRenderer) key(key string) string {
+	rendered, isExpression := r.stringValue(key)
+	if !isExpression {
+		return r.syntax.objectKey(key)
+	}
+	if strings.HasPrefix(rendered, r.syntax.quoteChar) {
+		// an interpolated string
+		if r.syntax.quoteChar == `"` {
+			return "(" + rendered + ")"
+		}
+		return rendered
+	}
+	return r.syntax.exprKey(rendered)
+}
+
+func (r *templateRenderer) write(value any, indent int) {
+	pad := strings.Repeat("  ", indent)
+
+	switch value := value.(type) {
+	case map[string]any:
+		if len(value) == 0 {
+			r.buf.WriteString("{}")
+			return
+		}
+
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		r.buf.WriteString("{\n")
+		for _, key := range keys {
+			r.buf.WriteString(pad + "  " + r.key(key) + r.syntax.assign)
+			r.write(value[key], indent+1)
+			r.buf.WriteString("\n")
+		}
+		r.buf.WriteString(pad + "}")
+	case []any:
+		if len(value) == 0 {
+			r.buf.WriteString("[]")
+			return
+		}
+
+		r.buf.WriteString("[\n")
+		for _, item := range value {
+			r.buf.WriteString(pad + "  ")
+			r.write(item, indent+1)
+			r.buf.WriteString(r.syntax.listSeparator + "\n")
+		}
+		r.buf.WriteString(pad + "]")
+	case string:
+		rendered, _ := r.stringValue(value)
+		r.buf.WriteString(rendered)
+	case json.Number:
+		r.buf.WriteString(value.String())
+	case bool:
+		r.buf.WriteString(fmt.Sprint(value))
+	case nil:
+		r.buf.WriteString("null")
+	default:
+		panic(fmt.Sprintf("unexpected JSON value %T", value))
+	}
+}
+
+// imageTemplateAsJSONObject returns the template as generic JSON, so it can be rendered in another syntax
+func imageTemplateAsJSONObject(imageTemplate *armvirtualmachineimagebuilder.ImageTemplate) (map[string]any, error) {
+	data, err := json.Marshal(imageTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to serialize the Image Template")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the Image Template")
+	}
+	return object, nil
+}
+
+// renderImageTemplateBicep renders the template as a Bicep file with an imageTemplates resource
+func renderImageTemplateBicep(imageTemplate *armvirtualmachineimagebuilder.ImageTemplate, locationParam templateParameter, params []templateParameter) ([]byte, error) {
+	object, err := imageTemplateAsJSONObject(imageTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &templateRenderer{syntax: bicepSyntax, params: params}
+	r.buf.WriteString("// Image Template generated by avdcli\n\n")
+	for _, param := range append([]templateParameter{locationParam}, params...) {
+		fmt.Fprintf(&r.buf, "@description('%s')\nparam %s string\n\n", bicepSyntax.quote(param.description), param.bicepName)
+	}
+
+	fmt.Fprintf(&r.buf, "resource imageTemplate '%s@%s' = {\n", imageTemplateResourceType, imageTemplateApiVersion)
+	fmt.Fprintf(&r.buf, "  name: ")
+	r.write(object["name"], 1)
+	fmt.Fprintf(&r.buf, "\n  location: %s\n", locationParam.bicepName)
+	for _, key := range []string{"tags", "identity", "properties"} {
+		if value, ok := object[key]; ok {
+			r.buf.WriteString("  " + key + ": ")
+			r.write(value, 1)
+			r.buf.WriteString("\n")
+		}
+	}
+	r.buf.WriteString("}\n")
+
+	return r.buf.Bytes(), nil
+}
+
+// renderImageTemplateTerraform renders the template as an azapi_resource with variables for the environment-specific values
+func renderImageTemplateTerraform(imageTemplate *armvirtualmachineimagebuilder.ImageTemplate, locationParam templateParameter, resourceGroupParam templateParameter, params []templateParameter) ([]byte, error) {
+	object, err := imageTemplateAsJSONObject(imageTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &templateRenderer{syntax: terraformSyntax, params: params}
+	r.buf.WriteString("# Image Template generated by avdcli\n\n")
+	for _, param := range append([]templateParameter{locationParam, resourceGroupParam}, params...) {
+		fmt.Fprintf(&r.buf, "variable \"%s\" {\n  type        = string\n  description = \"%s\"\n}\n\n", param.terraformName, terraformSyntax.quote(param.description))
+	}
+
+	r.buf.WriteString("resource \"azapi_resource\" \"image_template\" {\n")
+	fmt.Fprintf(&r.buf, "  type      = \"%s@%s\"\n", imageTemplateResourceType, imageTemplateApiVersion)
+	r.buf.WriteString("  name      = ")
+	r.write(object["name"], 1)
+	fmt.Fprintf(&r.buf, "\n  parent_id = var.%s\n", resourceGroupParam.terraformName)
+	fmt.Fprintf(&r.buf, "  location  = var.%s\n", locationParam.terraformName)
+
+	if identity, ok := object["identity"].(map[string]any); ok {
+		identities, _ := identity["userAssignedIdentities"].(map[string]any)
+		identityIds := make([]any, 0, len(identities))
+		for id := range identities {
+			identityIds = append(identityIds, id)
+		}
+		slices.SortFunc(identityIds, func(a, b any) int {
+			return strings.Compare(a.(string), b.(string))
+		})
+
+		r.buf.WriteString("\n  identity {\n")
+		r.buf.WriteString("    type         = ")
+		r.write(identity["type"], 2)
+		r.buf.WriteString("\n    identity_ids = ")
+		r.write(identityIds, 2)
+		r.buf.WriteString("\n  }\n")
+	}
+
+	if tags, ok := object["tags"]; ok {
+		r.buf.WriteString("\n  tags = ")
+		r.write(tags, 1)
+		r.buf.WriteString("\n")
+	}
+
+	r.buf.WriteString("\n  body = ")
+	r.write(map[string]any{"properties": object["properties"]}, 1)
+	r.buf.WriteString("\n}\n")
+
+	return r.buf.Bytes(), nil
+}
@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	avdimagetypes "github.com/schoolyear/avd-image-types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_renderImageTemplate(t *testing.T) {
+	const (
+		identity       = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/builder"
+		galleryImageId = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/win11"
+		resourceGroup  = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg"
+		subnet         = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/network/providers/Microsoft.Network/virtualNetworks/vnet/subnets/builders"
+	)
+
+	network := &builderNetworkConfig{SubnetID: subnet}
+
+	template := buildImageTemplate(
+		"win11-2026-01-01_10-00-00",
+		"westeurope",
+		identity,
+		180,
+		true,
+		"https://sa.blob.core.windows.net/bundles/bundle-abc.zip",
+		"Standard_D2s_v4",
+		127,
+		network,
+		&avdimagetypes.V2BaseImage{PlatformImage: &avdimagetypes.V2PlatformImage{Publisher: "MicrosoftWindowsDesktop", Offer: "windows-11", Sku: "win11-24h2-avd", Version: "latest"}},
+		galleryImageId,
+		nil,
+		false,
+		false,
+		[]regionReplication{{Region: "westeurope", ReplicaCount: 5}},
+		"https://sa.blob.core.windows.net/bundles/bundle-win11.json",
+		nil,
+		nil,
+	)
+	locationParam, params := imageTemplateParameters("westeurope", identity, galleryImageId, network)
+	resourceGroupParam := templateParameter{terraformName: "resource_group_id", value: resourceGroup}
+
+	tests := []struct {
+		name   string
+		render func() ([]byte, error)
+		expect []string
+	}{
+		{
+			name: "bicep",
+			render: func() ([]byte, error) {
+				return renderImageTemplateBicep(template, locationParam, params)
+			},
+			expect: []string{
+				"param location string",
+				"param managedIdentityId string",
+				"resource imageTemplate 'Microsoft.VirtualMachineImages/imageTemplates@2024-02-01' = {",
+				"  location: location\n",
+				"'${managedIdentityId}': {}",
+				"galleryImageId: galleryImageId",
+				"uriComponent(managedIdentityId)",
+				"subnetId: builderSubnetId",
+			},
+		},
+		{
+			name: "terraform",
+			render: func() ([]byte, error) {
+				return renderImageTemplateTerraform(template, locationParam, resourceGroupParam, params)
+			},
+			expect: []string{
+				`variable "resource_group_id" {`,
+				`type      = "Microsoft.VirtualMachineImages/imageTemplates@2024-02-01"`,
+				"parent_id = var.resource_group_id",
+				"location  = var.location",
+				"identity_ids = [\n      var.managed_identity_id,\n    ]",
+				"galleryImageId = var.gallery_image_id",
+				"urlencode(var.managed_identity_id)",
+				"subnetId = var.builder_subnet_id",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := test.render()
+			require.NoError(t, err)
+
+			for _, expect := range test.expect {
+				require.Contains(t, string(output), expect)
+			}
+			require.NotContains(t, string(output), identity)
+			require.NotContains(t, string(output), galleryImageId)
+			require.NotContains(t, string(output), resourceGroup)
+			require.NotContains(t, string(output), subnet)
+		})
+	}
+}
+
+func Test_renderImageTemplate_independentOfClock(t *testing.T) {
+	const (
+		identity       = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/builder"
+		galleryImageId = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/win11"
+		bundleHash     = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	)
+
+	render := func(format templateFormat, now time.Time) string {
+		templateName, bundlePropsBlobName := bundleArtifactNames(format, "win11", bundleHash, now)
+		template := buildImageTemplate(
+			templateName,
+			"westeurope",
+			identity,
+			180,
+			true,
+			"https://sa.blob.core.windows.net/bundles/bundle-"+bundleHash+".zip",
+			"Standard_D2s_v4",
+			127,
+			nil,
+			&avdimagetypes.V2BaseImage{PlatformImage: &avdimagetypes.V2PlatformImage{Publisher: "MicrosoftWindowsDesktop", Offer: "windows-11", Sku: "win11-24h2-avd", Version: "latest"}},
+			galleryImageId,
+			nil,
+			false,
+			false,
+			[]regionReplication{{Region: "westeurope", ReplicaCount: 1}},
+			"https://sa.blob.core.windows.net/bundles/"+bundlePropsBlobName,
+			nil,
+			nil,
+		)
+
+		locationParam, params := imageTemplateParameters("westeurope", identity, galleryImageId, nil)
+		var (
+			output []byte
+			err    error
+		)
+		switch format {
+		case templateFormatBicep:
+			output, err = renderImageTemplateBicep(template, locationParam, params)
+		case templateFormatTerraform:
+			resourceGroupParam := templateParameter{terraformName: "resource_group_id", value: "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg"}
+			output, err = renderImageTemplateTerraform(template, locationParam, resourceGroupParam, params)
+		}
+		require.NoError(t, err)
+		return string(output)
+	}
+
+	firstClock := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	secondClock := time.Date(2026, 3, 15, 18, 30, 45, 0, time.UTC)
+
+	for _, format := range []templateFormat{templateFormatBicep, templateFormatTerraform} {
+		t.Run(string(format), func(t *testing.T) {
+			first := render(format, firstClock)
+			require.Equal(t, first, render(format, secondClock))
+			require.Contains(t, first, "win11-9f86d081884c")
+			require.Contains(t, first, "bundle-win11-"+bundleHash+".json")
+		})
+	}
+
+	// ARM templates are deployed right away and keep the timestamp in their names
+	templateName, bundlePropsBlobName := bundleArtifactNames(templateFormatARM, "win11", bundleHash, firstClock)
+	require.Equal(t, "win11-2026-01-01_10-00-00", templateName)
+	require.Equal(t, "bundle-win11-2026-01-01_10-00-00.json", bundlePropsBlobName)
+}
@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/schoolyear/avd-cli/lib"
+	"github.com/schoolyear/avd-cli/schema"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	windowsUpdateNone   = "none"
+	windowsUpdateBefore = "before"
+	windowsUpdateAfter  = "after"
+	windowsUpdateBoth   = "both"
+)
+
+var windowsUpdateStages = []string{windowsUpdateNone, windowsUpdateBefore, windowsUpdateAfter, windowsUpdateBoth}
+
+var windowsUpdateFlag = &cli.StringFlag{
+	Name:  "windows-update",
+	Usage: fmt.Sprintf("Install Windows updates before and/or after the bundle is executed: %s", strings.Join(windowsUpdateStages, ", ")),
+	Action: func(c *cli.Context, s string) error {
+		_, _, err := parseWindowsUpdateStages(s)
+		return err
+	},
+}
+
+var windowsUpdateFilterFlag = &cli.StringSliceFlag{
+	Name:  "windows-update-filter",
+	Usage: "Filter of the updates to install, like \"exclude:$_.Title -like '*Preview*'\" or \"include:$true\". Azure excludes preview updates by default",
+}
+
+var windowsUpdateSearchCriteriaFlag = &cli.StringFlag{
+	Name:  "windows-update-search-criteria",
+	Usage: "Criteria to search for updates, like \"IsInstalled=0 and BrowseOnly=0\". Azure searches for all updates that are not installed by default",
+}
+
+var windowsUpdateLimitFlag = &cli.UintFlag{
+	Name:  "windows-update-limit",
+	Usage: "Maximum number of updates to install at a time. Azure installs up to 1000 updates by default",
+}
+
+func parseWindowsUpdateStages(value string) (before, after bool, err error) {
+	switch value {
+	case windowsUpdateNone:
+		return false, false, nil
+	case windowsUpdateBefore:
+		return true, false, nil
+	case windowsUpdateAfter:
+		return false, true, nil
+	case windowsUpdateBoth:
+		return true, true, nil
+	default:
+		return false, false, fmt.Errorf("unknown Windows update stage %s. available: %s", value, strings.Join(windowsUpdateStages, ", "))
+	}
+}
+
+// resolveWindowsUpdateStage applies the Windows update flags that are set to the settings of the bundle, which may be nil.
+// returns nil if no update stage is enabled
+func resolveWindowsUpdateStage(c *cli.Context, bundleSettings *schema.V2WindowsUpdateStage) (*schema.V2WindowsUpdateStage, error) {
+	var settings schema.V2WindowsUpdateStage
+	if bundleSettings != nil {
+		settings = *bundleSettings
+	}
+
+	if c.IsSet(windowsUpdateFlag.Name) {
+		before, after, err := parseWindowsUpdateStages(c.String(windowsUpdateFlag.Name))
+		if err != nil {
+			return nil, err
+		}
+		settings.Before, settings.After = before, after
+	}
+	if c.IsSet(windowsUpdateFilterFlag.Name) {
+		settings.Filters = c.StringSlice(windowsUpdateFilterFlag.Name)
+	}
+	if c.IsSet(windowsUpdateSearchCriteriaFlag.Name) {
+		settings.SearchCriteria = c.String(windowsUpdateSearchCriteriaFlag.Name)
+	}
+	if c.IsSet(windowsUpdateLimitFlag.Name) {
+		limit := c.Uint(windowsUpdateLimitFlag.Name)
+		if limit > 1000 {
+			return nil, fmt.Errorf("--%s is %d, the maximum is 1000", windowsUpdateLimitFlag.Name, limit)
+		}
+		settings.UpdateLimit = int32(limit)
+	}
+
+	if !settings.Before && !settings.After {
+		if len(settings.Filters) > 0 || settings.SearchCriteria != "" || settings.UpdateLimit > 0 {
+			return nil, fmt.Errorf("Windows update settings are given, but no update stage is enabled. Use --%s", windowsUpdateFlag.Name)
+		}
+		return nil, nil
+	}
+	return &settings, nil
+}
+
+// windowsUpdateCustomizers installs updates and restarts, so the updates are completed before the next step
+func windowsUpdateCustomizers(settings *schema.V2WindowsUpdateStage, name string) []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification {
+	return []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification{
+		lib.NewWindowsUpdateCustomizer(name, settings.Filters, settings.SearchCriteria, settings.UpdateLimit),
+		&armvirtualmachineimagebuilder.ImageTemplateRestartCustomizer{
+			Type: to.Ptr("WindowsRestart"),
+			Name: to.Ptr(name + "Restart"),
+		},
+	}
+}
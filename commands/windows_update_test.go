@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/schoolyear/avd-cli/schema"
+	avdimagetypes "github.com/schoolyear/avd-image-types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_buildCustomizationSteps_windowsUpdate(t *testing.T) {
+	layers := []bundleLayer{{
+		directory: "001-drivers",
+		properties: &avdimagetypes.V2LayerProperties{
+			Name: "drivers",
+			Customizers: &avdimagetypes.V2Customizers{
+				Post: []avdimagetypes.V2Customizer{{
+					WindowsUpdate: &avdimagetypes.V2WindowsUpdateCustomizer{
+						Type:           "WindowsUpdate",
+						Name:           "DriverUpdates",
+						Filters:        []string{"include:$_.Title -like '*Driver*'"},
+						SearchCriteria: "IsInstalled=0 and Type='Driver'",
+						UpdateLimit:    20,
+					},
+				}},
+			},
+		},
+	}}
+
+	tests := []struct {
+		name              string
+		windowsUpdate     *schema.V2WindowsUpdateStage
+		expectCustomizers []string
+	}{
+		{
+			name:              "no update stage",
+			expectCustomizers: []string{"BundleExtraction", "BundleExecution", "PostBundleExecutionRestart", "DriverUpdates", "BundleCleanup"},
+		},
+		{
+			name:          "before and after",
+			windowsUpdate: &schema.V2WindowsUpdateStage{Before: true, After: true, UpdateLimit: 100},
+			expectCustomizers: []string{
+				"PreBundleWindowsUpdate", "PreBundleWindowsUpdateRestart",
+				"BundleExtraction", "BundleExecution", "PostBundleExecutionRestart",
+				"PostBundleWindowsUpdate", "PostBundleWindowsUpdateRestart",
+				"DriverUpdates", "BundleCleanup",
+			},
+		},
+		{
+			name:              "after",
+			windowsUpdate:     &schema.V2WindowsUpdateStage{After: true},
+			expectCustomizers: []string{"BundleExtraction", "BundleExecution", "PostBundleExecutionRestart", "PostBundleWindowsUpdate", "PostBundleWindowsUpdateRestart", "DriverUpdates", "BundleCleanup"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			customizers := buildCustomizationSteps(layers, test.windowsUpdate, "identity", "https://sa.blob.core.windows.net/bundles/bundle.zip")
+
+			var names []string
+			for _, customizer := range customizers {
+				names = append(names, *customizer.GetImageTemplateCustomizer().Name)
+
+				update, ok := customizer.(*armvirtualmachineimagebuilder.ImageTemplateWindowsUpdateCustomizer)
+				if !ok {
+					continue
+				}
+				require.Equal(t, "WindowsUpdate", *update.Type)
+				switch *update.Name {
+				case "DriverUpdates":
+					require.Len(t, update.Filters, 1)
+					require.Equal(t, "IsInstalled=0 and Type='Driver'", *update.SearchCriteria)
+					require.EqualValues(t, 20, *update.UpdateLimit)
+				default:
+					require.Nil(t, update.Filters)
+					require.Nil(t, update.SearchCriteria)
+					require.Equal(t, test.windowsUpdate.UpdateLimit, deref(update.UpdateLimit))
+				}
+			}
+			require.Equal(t, test.expectCustomizers, names)
+		})
+	}
+}
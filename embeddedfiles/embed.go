@@ -18,3 +18,22 @@ const V2ImageTemplateBasePath = "v2_image_template"
 var V2ExecuteScript []byte
 
 const V2ExecuteScriptFilename = "execute.ps1"
+
+// V2ExportScript runs the build steps of a bundle on a VM without Image Builder.
+// the stages and the default bundle source are filled in by "bundle export-script"
+//
+//go:embed v2_export_script.ps1
+var V2ExportScript string
+
+const (
+	V2ExportScriptStagesPlaceholder       = "## STAGES ##"
+	V2ExportScriptBundleSourcePlaceholder = "'##BUNDLE_SOURCE##'"
+)
+
+// V2MigratedInstallScript is the install.ps1 of a layer migrated from a v1 image folder.
+// the build steps are filled in by "layer migrate-v1"
+//
+//go:embed v2_migrated_install.ps1
+var V2MigratedInstallScript string
+
+const V2MigratedInstallScriptStepsPlaceholder = "## STEPS ##"
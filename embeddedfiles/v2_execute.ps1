@@ -10,7 +10,11 @@ param (
     [switch]$Force = $false,
 
     [Parameter(Mandatory=$false)]
-    [string]$BuildParametersPath = "build_parameters.json"
+    [string]$BuildParametersPath = "build_parameters.json",
+
+    # Index of the first layer in the bundle, when the bundle is executed in multiple stages
+    [Parameter(Mandatory=$false)]
+    [int]$LayerIndexOffset = 0
 )
 
 ## Make sure this script fails on an error and does not continue executing
@@ -72,6 +76,7 @@ if (($LayerPaths -and $ScanForDirectories) -or (-not $LayerPaths -and -not $Scan
     Write-Host "  .\v2_execute.ps1 -LayerPaths '.\layer1','..\layer2','C:\layer3'" -ForegroundColor Yellow
     Write-Host "  .\v2_execute.ps1 -ScanForDirectories" -ForegroundColor Yellow
     Write-Host "  .\v2_execute.ps1 -LayerPaths '.\layer1' -BuildParametersPath 'path\to\custom_parameters.json'" -ForegroundColor Yellow
+    Write-Host "  .\v2_execute.ps1 -LayerPaths '.\003-layer3','.\004-layer4' -LayerIndexOffset 2" -ForegroundColor Yellow
     Write-Host "  Add -Force to either command to skip all prompts (processing and cleanup)" -ForegroundColor Yellow
     exit 1
 }
@@ -261,6 +266,11 @@ if ($allBackupPatterns.Count -gt 0) {
         Write-Host " - Creating directory: $backupConfigDestDir"
         New-Item -Path $backupConfigDestDir -ItemType Directory -Force | Out-Null
     }
+    # Earlier stages of the same bundle already wrote their patterns
+    if ($LayerIndexOffset -gt 0 -and (Test-Path -Path $backupConfigDest)) {
+        $existingBackupConfig = Get-Content -Path $backupConfigDest -Raw | ConvertFrom-Json
+        $allBackupPatterns = @($existingBackupConfig.paths) + $allBackupPatterns
+    }
     $backupConfigJson = @{ paths = @($allBackupPatterns) } | ConvertTo-Json
     [System.IO.File]::WriteAllText($backupConfigDest, $backupConfigJson, (New-Object System.Text.UTF8Encoding $false))
     Write-Host " - Wrote $($allBackupPatterns.Count) pattern(s) to $backupConfigDest" -ForegroundColor Green
@@ -360,7 +370,7 @@ for ($layerIndex = 0; $layerIndex -lt $ValidLayers.Count; $layerIndex++) {
         Write-Host " - Warning: This layer has no install.ps1 script" -ForegroundColor Yellow
     }
 
-    $indexPrefix = "{0:D3}" -f $($layerIndex + 1)
+    $indexPrefix = "{0:D3}" -f $($LayerIndexOffset + $layerIndex + 1)
 
     # Move setup script
     $setupScriptPath = Join-Path -Path $layerPath -ChildPath "on_sessionhost_setup.ps1"
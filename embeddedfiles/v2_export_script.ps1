@@ -0,0 +1,212 @@
+#Requires -RunAsAdministrator
+
+## Parameters
+param (
+    # SAS URL or local path of the bundle archive. Resumed runs get it from the arguments of the resume task,
+    # it is never written to the state file as the SAS token is a secret
+    [Parameter(Mandatory=$false)]
+    [string]$BundleSource = '##BUNDLE_SOURCE##',
+
+    # Directory in which the progress, step scripts and downloads are stored
+    [Parameter(Mandatory=$false)]
+    [string]$StateDirectory = "C:\avdcli_build",
+
+    # Restart from this stage (1-based) instead of the stage after the last completed one
+    [Parameter(Mandatory=$false)]
+    [int]$Stage = 0
+)
+
+## This script runs the same steps as Azure Image Builder would run for the bundle.
+## The steps are grouped in stages, which end with a restart. After a restart the script resumes with the next stage
+## through a scheduled task, so it only has to be started once.
+## Unlike Image Builder, all steps run as the user that started the script (or SYSTEM after a restart)
+
+$ErrorActionPreference = "Stop"
+$ProgressPreference = "SilentlyContinue"
+Set-StrictMode -Version Latest
+
+$resumeTaskName = "avdcli-build-resume"
+$statePath = Join-Path -Path $StateDirectory -ChildPath "state.json"
+$deprovisioningScriptPath = "C:\DeprovisioningScript.ps1"
+
+# the state only holds the progress, nothing secret
+function Read-BuildState {
+    if (Test-Path -Path $statePath) {
+        return Get-Content -Path $statePath -Raw | ConvertFrom-Json
+    }
+    return [PSCustomObject]@{ NextStage = 0 }
+}
+
+function Write-BuildState {
+    param ([int]$NextStage)
+
+    [PSCustomObject]@{ NextStage = $NextStage } | ConvertTo-Json | Set-Content -Path $statePath
+}
+
+# the task passes the bundle source to the resumed run, scheduled tasks of SYSTEM can only be read by administrators
+function Register-ResumeTask {
+    $action = New-ScheduledTaskAction -Execute "powershell.exe" -Argument "-NoProfile -ExecutionPolicy Bypass -File `"$PSCommandPath`" -StateDirectory `"$StateDirectory`" -BundleSource `"$script:bundleSource`""
+    $trigger = New-ScheduledTaskTrigger -AtStartup
+    $principal = New-ScheduledTaskPrincipal -UserId "SYSTEM" -LogonType ServiceAccount -RunLevel Highest
+    Register-ScheduledTask -TaskName $resumeTaskName -Action $action -Trigger $trigger -Principal $principal -Force | Out-Null
+}
+
+function Unregister-ResumeTask {
+    if (Get-ScheduledTask -TaskName $resumeTaskName -ErrorAction SilentlyContinue) {
+        Unregister-ScheduledTask -TaskName $resumeTaskName -Confirm:$false
+    }
+}
+
+# Image Builder creates the deprovisioning script before the first step, a manual build has to create it
+function Initialize-DeprovisioningScript {
+    if (Test-Path -Path $deprovisioningScriptPath) {
+        return
+    }
+
+    Set-Content -Path $deprovisioningScriptPath -Value @'
+Write-Output '>>> Sysprepping VM ...'
+& $env:SystemRoot\System32\Sysprep\Sysprep.exe /oobe /generalize /quiet /quit
+while ($true) {
+    $imageState = (Get-ItemProperty HKLM:\SOFTWARE\Microsoft\Windows\CurrentVersion\Setup\State).ImageState
+    Write-Output $imageState
+    if ($imageState -eq 'IMAGE_STATE_GENERALIZE_RESEAL_TO_OOBE') { break }
+    Start-Sleep -Seconds 10
+}
+Write-Output '>>> Sysprep complete ...'
+'@
+}
+
+function Assert-FileHash {
+    param ([string]$Path, [string]$Sha256)
+
+    if ($Sha256 -eq "") {
+        return
+    }
+    $actual = (Get-FileHash -Path $Path -Algorithm SHA256).Hash
+    if ($actual -ne $Sha256) {
+        throw "Checksum of $Path is $actual, expected $Sha256"
+    }
+}
+
+# Runs the script in a new PowerShell process, like Image Builder does
+function Invoke-BuildPowerShell {
+    param (
+        [string]$Name,
+        [string]$Script = "",
+        [string]$ScriptUri = "",
+        [string]$Sha256 = "",
+        [int[]]$ValidExitCodes = @(0)
+    )
+
+    Write-Host "=== $Name ===" -ForegroundColor Cyan
+    $scriptPath = Join-Path -Path $StateDirectory -ChildPath "steps\$($Name -replace '[^\w.-]', '_').ps1"
+    New-Item -ItemType Directory -Path (Split-Path -Path $scriptPath) -Force | Out-Null
+    if ($ScriptUri -ne "") {
+        Invoke-WebRequest -Uri $ScriptUri -OutFile $scriptPath
+        Assert-FileHash -Path $scriptPath -Sha256 $Sha256
+    } else {
+        Set-Content -Path $scriptPath -Value $Script
+    }
+
+    $env:AVDCLI_BUNDLE_SOURCE = $script:bundleSource
+    & powershell.exe -NoProfile -ExecutionPolicy Bypass -File $scriptPath
+    if ($ValidExitCodes -notcontains $LASTEXITCODE) {
+        throw "Step $Name failed with exit code $LASTEXITCODE"
+    }
+}
+
+function Invoke-BuildDownload {
+    param (
+        [string]$Name,
+        [string]$Uri,
+        [string]$Destination,
+        [string]$Sha256 = ""
+    )
+
+    Write-Host "=== $Name ===" -ForegroundColor Cyan
+    Write-Host "Downloading $Uri to $Destination"
+    New-Item -ItemType Directory -Path (Split-Path -Path $Destination) -Force | Out-Null
+    Invoke-WebRequest -Uri $Uri -OutFile $Destination
+    Assert-FileHash -Path $Destination -Sha256 $Sha256
+}
+
+# Installs updates with the Windows Update Agent. A filter is include:<expression> or exclude:<expression>,
+# the first filter of which the expression is true for an update decides whether it is installed
+function Install-BuildWindowsUpdates {
+    param (
+        [string]$Name,
+        [string]$SearchCriteria = "BrowseOnly=0 and IsInstalled=0",
+        [string[]]$Filters = @('exclude:$_.Title -like ''*Preview*''', 'include:$true'),
+        [int]$UpdateLimit = 1000
+    )
+
+    Write-Host "=== $Name ===" -ForegroundColor Cyan
+    $session = New-Object -ComObject Microsoft.Update.Session
+    Write-Host "Searching for updates: $SearchCriteria"
+    $searchResult = $session.CreateUpdateSearcher().Search($SearchCriteria)
+
+    $updates = New-Object -ComObject Microsoft.Update.UpdateColl
+    foreach ($update in $searchResult.Updates) {
+        $include = $false
+        foreach ($filter in $Filters) {
+            $kind, $expression = $filter -split ":", 2
+            $isMatch = [bool]($update | ForEach-Object ([scriptblock]::Create($expression)))
+            if ($isMatch) {
+                $include = $kind -eq "include"
+                break
+            }
+        }
+        if ($include -and $updates.Count -lt $UpdateLimit) {
+            Write-Host " - $($update.Title)"
+            if (-not $update.EulaAccepted) { $update.AcceptEula() }
+            $updates.Add($update) | Out-Null
+        }
+    }
+
+    if ($updates.Count -eq 0) {
+        Write-Host "No updates to install"
+        return
+    }
+
+    $downloader = $session.CreateUpdateDownloader()
+    $downloader.Updates = $updates
+    $downloader.Download() | Out-Null
+
+    $installer = $session.CreateUpdateInstaller()
+    $installer.Updates = $updates
+    $result = $installer.Install()
+    Write-Host "Installed $($updates.Count) updates (result code $($result.ResultCode))"
+}
+
+## STAGES ##
+
+New-Item -ItemType Directory -Path $StateDirectory -Force | Out-Null
+$state = Read-BuildState
+$script:bundleSource = $BundleSource
+if ($script:bundleSource -eq "") {
+    Write-Error "Pass the SAS URL or local path of the bundle archive with -BundleSource"
+}
+
+$nextStage = $state.NextStage
+if ($Stage -gt 0) {
+    $nextStage = $Stage - 1
+}
+
+Initialize-DeprovisioningScript
+
+for ($i = $nextStage; $i -lt $Stages.Count; $i++) {
+    Write-Host "`n##### Stage $($i + 1) of $($Stages.Count) #####" -ForegroundColor Green
+    & $Stages[$i].Steps
+    Write-BuildState -NextStage ($i + 1)
+
+    if ($i -lt $Stages.Count - 1) {
+        Register-ResumeTask
+        Write-Host "Restarting ($($Stages[$i].Restart)), the build resumes with stage $($i + 2) after the restart" -ForegroundColor Yellow
+        Restart-Computer -Force
+        exit 0
+    }
+}
+
+Unregister-ResumeTask
+Remove-Item -Path $statePath -Force -ErrorAction SilentlyContinue
+Write-Host "`nThe build completed. Run $deprovisioningScriptPath to generalize the VM before capturing the image" -ForegroundColor Green
@@ -0,0 +1,80 @@
+# This script is generated by "avdcli layer migrate-v1" from the build steps of a v1 image folder
+# The build steps run in their original order, each PowerShell step in its own PowerShell process like Image Builder ran them
+# Check MIGRATION_TODO.md for the parts of the v1 image that could not be migrated
+
+Param (
+    # To make sure this script doesn't break when new parameters are added
+    [Parameter(ValueFromRemainingArguments)]
+    [string[]]$RemainingArgs
+)
+
+$ErrorActionPreference = "Stop"
+Set-StrictMode -Version Latest
+$ProgressPreference = 'SilentlyContinue'
+
+# v1 build steps read the resources from this folder
+$resourcesPath = "C:\imagebuild_resources"
+
+function Assert-MigratedFileHash {
+    param ([string]$Path, [string]$Sha256)
+
+    if ($Sha256 -eq "") {
+        return
+    }
+    $actual = (Get-FileHash -Path $Path -Algorithm SHA256).Hash
+    if ($actual -ne $Sha256) {
+        throw "Checksum of $Path is $actual, expected $Sha256"
+    }
+}
+
+function Invoke-MigratedStep {
+    param (
+        [string]$Name,
+        [string]$Path = "", # relative to the layer folder
+        [string]$ScriptUri = "",
+        [string]$Sha256 = "",
+        [int[]]$ValidExitCodes = @(0)
+    )
+
+    Write-Host "=== $Name ===" -ForegroundColor Cyan
+    if ($ScriptUri -ne "") {
+        $Path = Join-Path -Path $env:TEMP -ChildPath "$($Name -replace '[^\w.-]', '_').ps1"
+        Invoke-WebRequest -Uri $ScriptUri -OutFile $Path
+        Assert-MigratedFileHash -Path $Path -Sha256 $Sha256
+    } else {
+        $Path = Join-Path -Path $PSScriptRoot -ChildPath $Path
+    }
+
+    & powershell.exe -NoProfile -ExecutionPolicy Bypass -File $Path
+    if ($ValidExitCodes -notcontains $LASTEXITCODE) {
+        throw "Build step $Name failed with exit code $LASTEXITCODE"
+    }
+}
+
+function Invoke-MigratedDownload {
+    param (
+        [string]$Name,
+        [string]$Uri,
+        [string]$Destination,
+        [string]$Sha256 = ""
+    )
+
+    Write-Host "=== $Name ===" -ForegroundColor Cyan
+    New-Item -ItemType Directory -Path (Split-Path -Path $Destination) -Force | Out-Null
+    Invoke-WebRequest -Uri $Uri -OutFile $Destination
+    Assert-MigratedFileHash -Path $Destination -Sha256 $Sha256
+}
+
+$layerResourcesPath = Join-Path -Path $PSScriptRoot -ChildPath "resources"
+if (Test-Path -Path $layerResourcesPath) {
+    Write-Host "Copying resources to $resourcesPath"
+    # copy the contents, copying the folder into an existing $resourcesPath would nest it as $resourcesPath\resources
+    New-Item -ItemType Directory -Path $resourcesPath -Force | Out-Null
+    Copy-Item -Path (Join-Path -Path $layerResourcesPath -ChildPath "*") -Destination $resourcesPath -Recurse -Force
+}
+
+## STEPS ##
+
+if (Test-Path -Path $resourcesPath) {
+    Remove-Item -Path $resourcesPath -Recurse -Force
+}
@@ -0,0 +1,287 @@
+package lib
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+)
+
+const buildLedgerFilename = "builds.jsonl"
+
+type BuildStatus string
+
+const (
+	BuildStatusCreated            BuildStatus = "created"      // template prepared, not deployed yet
+	BuildStatusNotDeployed        BuildStatus = "not_deployed" // deployment was skipped on request
+	BuildStatusDeployed           BuildStatus = "deployed"     // template exists in Azure, run may be in progress
+	BuildStatusDeploymentFailed   BuildStatus = "deployment_failed"
+	BuildStatusSucceeded          BuildStatus = "succeeded"
+	BuildStatusPartiallySucceeded BuildStatus = "partially_succeeded"
+	BuildStatusFailed             BuildStatus = "failed"
+	BuildStatusCanceled           BuildStatus = "canceled"
+)
+
+// BuildStatusFromRunState maps the run state of an Image Template to a final build status
+// returns false if the run state is not final
+func BuildStatusFromRunState(runState string) (BuildStatus, bool) {
+	switch runState {
+	case "Succeeded":
+		return BuildStatusSucceeded, true
+	case "PartiallySucceeded":
+		return BuildStatusPartiallySucceeded, true
+	case "Failed":
+		return BuildStatusFailed, true
+	case "Canceled":
+		return BuildStatusCanceled, true
+	default:
+		return "", false
+	}
+}
+
+// BuildRecord describes a single image build started from this machine
+type BuildRecord struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+
+	SubscriptionId  string `json:"subscription_id"`
+	ResourceGroup   string `json:"resource_group"`
+	TemplateName    string `json:"template_name"`
+	ImageDefinition string `json:"image_definition,omitempty"` // resource id of the target gallery image definition
+	ImageVersion    string `json:"image_version,omitempty"`    // only set if the version number was decided before the build
+
+	BundleSha256 string             `json:"bundle_sha256,omitempty"` // sha256 of the uploaded bundle (v2) or resources archive (v1)
+	Layers       []BuildRecordLayer `json:"layers,omitempty"`
+	Parameters   map[string]string  `json:"parameters,omitempty"` // secrets are redacted
+	Status       BuildStatus        `json:"status"`
+	Message      string             `json:"message,omitempty"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
+	FinishedAt   *time.Time         `json:"finished_at,omitempty"`
+}
+
+type BuildRecordLayer struct {
+	Name   string `json:"name"`
+	Source string `json:"source,omitempty"` // local path, community reference (with resolved ref) or built-in
+}
+
+// NewBuildRecord creates a record with a random id
+func NewBuildRecord(command, subscriptionId, resourceGroup, templateName string) *BuildRecord {
+	idBytes := make([]byte, 4)
+	_, _ = rand.Read(idBytes) // never returns an error
+
+	now := time.Now().UTC()
+	return &BuildRecord{
+		ID:             hex.EncodeToString(idBytes),
+		Command:        command,
+		SubscriptionId: subscriptionId,
+		ResourceGroup:  resourceGroup,
+		TemplateName:   templateName,
+		Status:         BuildStatusCreated,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+}
+
+func (r BuildRecord) Finished() bool {
+	return r.FinishedAt != nil
+}
+
+// Finish sets the final status of the build
+func (r *BuildRecord) Finish(status BuildStatus, message string) {
+	now := time.Now().UTC()
+	r.Status = status
+	r.Message = message
+	r.FinishedAt = &now
+}
+
+var secretParameterRegex = regexp.MustCompile(`(?i)(password|passwd|pwd|secret|token|credential|api_?key|access_?key|private_?key|sas|connection_?string)`)
+
+const redactedValue = "<redacted>"
+
+// RedactParameters returns a copy of the parameters in which values of parameters that look like secrets are redacted
+func RedactParameters(params map[string]string) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(params))
+	for key, value := range params {
+		if secretParameterRegex.MatchString(key) {
+			value = redactedValue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+func BuildLedgerPath() (string, error) {
+	dir, err := AvdcliHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, buildLedgerFilename), nil
+}
+
+// AppendBuildRecord writes the (new version of the) record to the ledger.
+// the ledger is append-only, the last line of a record id is its current version
+func AppendBuildRecord(record *BuildRecord) error {
+	ledgerPath, err := BuildLedgerPath()
+	if err != nil {
+		return err
+	}
+
+	record.UpdatedAt = time.Now().UTC()
+	line, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize build record")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ledgerPath), 0700); err != nil {
+		return errors.Wrap(err, "failed to create avdcli directory")
+	}
+
+	lock, err := LockFile(ledgerPath+".lock", nil)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	f, err := os.OpenFile(ledgerPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open build ledger")
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write build ledger")
+	}
+
+	return f.Close()
+}
+
+// RecordBuild appends the record to the ledger, and only warns if that fails
+// the ledger is informational, so it should never fail a build
+func RecordBuild(record *BuildRecord) {
+	if record == nil {
+		return
+	}
+	if err := AppendBuildRecord(record); err != nil {
+		color.Yellow("Warning: failed to write the build to the local build history: %s", err)
+	}
+}
+
+// LoadBuildRecords returns the current version of all records, ordered by creation time (oldest first)
+// returns an empty list if the ledger does not exist
+func LoadBuildRecords() ([]BuildRecord, error) {
+	ledgerPath, err := BuildLedgerPath()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(ledgerPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to open build ledger")
+	}
+	defer f.Close()
+
+	var (
+		records []BuildRecord
+		indices = map[string]int{}
+	)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record BuildRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			// a line can be corrupt if a process was killed while writing, skip it
+			color.Yellow("Warning: skipping invalid line %d in %s", lineNr, ledgerPath)
+			continue
+		}
+
+		if idx, ok := indices[record.ID]; ok {
+			records[idx] = record
+		} else {
+			indices[record.ID] = len(records)
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read build ledger")
+	}
+
+	return records, nil
+}
+
+// FindBuildRecords returns the records of which the id or template name matches the query
+func FindBuildRecords(query string) ([]BuildRecord, error) {
+	records, err := LoadBuildRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []BuildRecord
+	for _, record := range records {
+		if record.ID == query || record.TemplateName == query {
+			matches = append(matches, record)
+		}
+	}
+	return matches, nil
+}
+
+// FinishBuildRecords marks the unfinished records of the Image Template as finished
+func FinishBuildRecords(subscriptionId, resourceGroup, templateName string, status BuildStatus, message string) error {
+	records, err := LoadBuildRecords()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.Finished() || record.SubscriptionId != subscriptionId || record.ResourceGroup != resourceGroup || record.TemplateName != templateName {
+			continue
+		}
+
+		record.Finish(status, message)
+		if err := AppendBuildRecord(&record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s BuildStatus) String() string {
+	return string(s)
+}
+
+// Colored returns the status in a color that indicates whether it failed
+func (s BuildStatus) Colored() string {
+	switch s {
+	case BuildStatusSucceeded:
+		return color.HiGreenString(s.String())
+	case BuildStatusFailed, BuildStatusDeploymentFailed, BuildStatusCanceled:
+		return color.HiRedString(s.String())
+	case BuildStatusPartiallySucceeded:
+		return color.YellowString(s.String())
+	default:
+		return fmt.Sprint(s)
+	}
+}
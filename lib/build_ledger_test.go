@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildLedger(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USERPROFILE", t.TempDir())
+
+	records, err := LoadBuildRecords()
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	first := NewBuildRecord("bundle autobuild", "sub", "rg", "template-a")
+	first.Status = BuildStatusDeployed
+	require.NoError(t, AppendBuildRecord(first))
+
+	second := NewBuildRecord("package deploy", "sub", "rg", "template-b")
+	second.Status = BuildStatusDeploymentFailed
+	second.Finish(BuildStatusDeploymentFailed, "deployment failed")
+	require.NoError(t, AppendBuildRecord(second))
+
+	require.NoError(t, FinishBuildRecords("sub", "rg", "template-a", BuildStatusSucceeded, ""))
+	// finished records are not changed again
+	require.NoError(t, FinishBuildRecords("sub", "rg", "template-b", BuildStatusSucceeded, ""))
+
+	records, err = LoadBuildRecords()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, first.ID, records[0].ID)
+	require.Equal(t, BuildStatusSucceeded, records[0].Status)
+	require.True(t, records[0].Finished())
+	require.Equal(t, BuildStatusDeploymentFailed, records[1].Status)
+	require.Equal(t, "deployment failed", records[1].Message)
+
+	matches, err := FindBuildRecords("template-b")
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, second.ID, matches[0].ID)
+
+	matches, err = FindBuildRecords(first.ID)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+}
+
+func TestRedactParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		expect map[string]string
+	}{
+		{
+			name:   "empty",
+			params: nil,
+			expect: nil,
+		},
+		{
+			name: "secrets",
+			params: map[string]string{
+				"layer.AdminPassword":  "hunter2",
+				"layer.apiKey":         "abc",
+				"layer.storageSasUrl":  "https://...",
+				"layer.timezone":       "UTC",
+				"other.downloadSource": "https://example.com",
+			},
+			expect: map[string]string{
+				"layer.AdminPassword":  redactedValue,
+				"layer.apiKey":         redactedValue,
+				"layer.storageSasUrl":  redactedValue,
+				"layer.timezone":       "UTC",
+				"other.downloadSource": "https://example.com",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expect, RedactParameters(test.params))
+		})
+	}
+}
@@ -10,10 +10,7 @@ func CustomizerToArmImageTemplateCustomizer(customizer avdimagetypes.V2Customize
 	switch {
 	case customizer.WindowsUpdate != nil:
 		updateCustomizer := customizer.WindowsUpdate
-		return &armvirtualmachineimagebuilder.ImageTemplateWindowsUpdateCustomizer{
-			Type: (*string)(&updateCustomizer.Type),
-			Name: &updateCustomizer.Name,
-		}
+		return NewWindowsUpdateCustomizer(updateCustomizer.Name, updateCustomizer.Filters, updateCustomizer.SearchCriteria, int32(updateCustomizer.UpdateLimit))
 	case customizer.WindowsRestart != nil:
 		restartCustomizer := customizer.WindowsRestart
 		return &armvirtualmachineimagebuilder.ImageTemplateRestartCustomizer{
@@ -69,3 +66,25 @@ func CustomizerToArmImageTemplateCustomizer(customizer avdimagetypes.V2Customize
 		panic("invalid customizer")
 	}
 }
+
+// NewWindowsUpdateCustomizer creates a Windows Update customizer. Empty settings are left out, so Azure uses its defaults
+func NewWindowsUpdateCustomizer(name string, filters []string, searchCriteria string, updateLimit int32) *armvirtualmachineimagebuilder.ImageTemplateWindowsUpdateCustomizer {
+	updateCustomizer := &armvirtualmachineimagebuilder.ImageTemplateWindowsUpdateCustomizer{
+		Type: to.Ptr("WindowsUpdate"),
+		Name: to.Ptr(name),
+	}
+
+	if len(filters) > 0 {
+		updateCustomizer.Filters = to.SliceOfPtrs(filters...)
+	}
+
+	if searchCriteria != "" {
+		updateCustomizer.SearchCriteria = to.Ptr(searchCriteria)
+	}
+
+	if updateLimit > 0 {
+		updateCustomizer.UpdateLimit = to.Ptr(updateLimit)
+	}
+
+	return updateCustomizer
+}
@@ -1,8 +1,10 @@
 package lib
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/friendsofgo/errors"
 	"io"
 	"os/exec"
@@ -12,6 +14,22 @@ import (
 
 var jsonParseFixRegex = regexp.MustCompile(`(?m)^.*(?:pkg_resources is deprecated as an API|__import__\('pkg_resources'\)).*\n?`)
 
+// ExecError is returned when a command exits with an error.
+// Stderr holds what the command wrote to its standard error, so callers can inspect the error details
+type ExecError struct {
+	Command string
+	Stderr  string
+	Err     error
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("failed to execute %s: %s: %s", e.Command, strings.TrimSpace(e.Stderr), e.Err)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
 func ExecuteAsParseAsJSON[T any](ctx context.Context, cmd string, args ...string) (t T, err error) {
 	return ExecuteAsParseAsJSONWithStdin[T](ctx, nil, cmd, args...)
 }
@@ -20,9 +38,16 @@ func ExecuteAsParseAsJSONWithStdin[T any](ctx context.Context, stdin io.Reader,
 	command := exec.CommandContext(ctx, cmd, args...)
 	command.Stdin = stdin
 
-	out, err := command.CombinedOutput()
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+
+	out, err := command.Output()
 	if err != nil {
-		return t, errors.Wrapf(err, "failed to execute %s %s: %s", cmd, strings.Join(args, " "), string(out))
+		return t, &ExecError{
+			Command: cmd + " " + strings.Join(args, " "),
+			Stderr:  stderr.String(),
+			Err:     err,
+		}
 	}
 
 	// fix for: https://github.com/azure/azure-cli/issues/31591
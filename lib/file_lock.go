@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"os"
+
+	"github.com/friendsofgo/errors"
+)
+
+// FileLock is an exclusive advisory lock on a file, shared between processes.
+// It is used to protect caches that can be used by multiple avdcli processes at the same time
+type FileLock struct {
+	file *os.File
+}
+
+// LockFile blocks until an exclusive lock on the file at path is acquired. The file is created if it does not exist.
+// onWait is called once if the lock is held by another process, before blocking
+func LockFile(path string, onWait func()) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open lock file %s", path)
+	}
+
+	acquired, err := tryLockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to lock %s", path)
+	}
+
+	if !acquired {
+		if onWait != nil {
+			onWait()
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "failed to lock %s", path)
+		}
+	}
+
+	return &FileLock{file: f}, nil
+}
+
+// Unlock releases the lock. The lock file itself is left in place, removing it would race with other processes
+func (l *FileLock) Unlock() error {
+	unlockErr := unlockFile(l.file)
+	closeErr := l.file.Close()
+	if unlockErr != nil {
+		return errors.Wrapf(unlockErr, "failed to unlock %s", l.file.Name())
+	}
+	return closeErr
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package lib
+
+import (
+	"os"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/sys/unix"
+)
+
+func tryLockFile(f *os.File) (acquired bool, err error) {
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func lockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
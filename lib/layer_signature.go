@@ -0,0 +1,282 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+// Layer signatures are detached minisign-style signatures over a canonical manifest of the files in a layer.
+// The signature format is compatible with minisign's (legacy) "Ed" algorithm:
+//
+//	untrusted comment: <comment>
+//	base64(<"Ed"><key id (8 bytes)><ed25519 signature of the manifest (64 bytes)>)
+//	trusted comment: <comment>
+//	base64(<ed25519 signature of the manifest signature + trusted comment (64 bytes)>)
+//
+// Public keys use the minisign public key format: base64(<"Ed"><key id (8 bytes)><public key (32 bytes)>)
+
+var (
+	ErrLayerSignatureInvalid   = errors.New("layer signature is invalid")
+	ErrLayerSignatureUntrusted = errors.New("layer is signed by an untrusted key")
+)
+
+const (
+	signatureAlgorithm         = "Ed"
+	signatureKeyIdLength       = 8
+	untrustedCommentPrefix     = "untrusted comment: "
+	trustedCommentPrefix       = "trusted comment: "
+	encodedPublicKeyLength     = 2 + signatureKeyIdLength + ed25519.PublicKeySize
+	encodedSecretKeyLength     = 2 + signatureKeyIdLength + ed25519.SeedSize
+	encodedSignatureBodyLength = 2 + signatureKeyIdLength + ed25519.SignatureSize
+)
+
+type SigningKeyId [signatureKeyIdLength]byte
+
+func (k SigningKeyId) String() string {
+	// minisign displays the little-endian key id as a hex number
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k[:]))
+}
+
+type LayerPublicKey struct {
+	KeyId SigningKeyId
+	Key   ed25519.PublicKey
+}
+
+type LayerSecretKey struct {
+	KeyId SigningKeyId
+	Key   ed25519.PrivateKey
+}
+
+// GenerateLayerSigningKey creates a new random key pair
+func GenerateLayerSigningKey() (*LayerPublicKey, *LayerSecretKey, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate ed25519 key")
+	}
+
+	var keyId SigningKeyId
+	if _, err := rand.Read(keyId[:]); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate key id")
+	}
+
+	return &LayerPublicKey{KeyId: keyId, Key: publicKey}, &LayerSecretKey{KeyId: keyId, Key: privateKey}, nil
+}
+
+// String encodes the public key as a single base64 line, which can be added to the trusted keys
+func (k LayerPublicKey) String() string {
+	data := make([]byte, 0, encodedPublicKeyLength)
+	data = append(data, signatureAlgorithm...)
+	data = append(data, k.KeyId[:]...)
+	data = append(data, k.Key...)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// FileContents returns the public key in the minisign public key file format
+func (k LayerPublicKey) FileContents() []byte {
+	return []byte(fmt.Sprintf("%sminisign public key %s\n%s\n", untrustedCommentPrefix, k.KeyId, k))
+}
+
+// FileContents returns the secret key in an (unencrypted) file format
+func (k LayerSecretKey) FileContents() []byte {
+	data := make([]byte, 0, encodedSecretKeyLength)
+	data = append(data, signatureAlgorithm...)
+	data = append(data, k.KeyId[:]...)
+	data = append(data, k.Key.Seed()...)
+	return []byte(fmt.Sprintf("%savdcli layer secret key %s\n%s\n", untrustedCommentPrefix, k.KeyId, base64.StdEncoding.EncodeToString(data)))
+}
+
+// ParseLayerPublicKey parses either a base64 encoded key or the contents of a minisign public key file
+func ParseLayerPublicKey(value string) (*LayerPublicKey, error) {
+	data, err := decodeKeyLine(value, encodedPublicKeyLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+
+	var key LayerPublicKey
+	copy(key.KeyId[:], data[2:2+signatureKeyIdLength])
+	key.Key = slices.Clone(data[2+signatureKeyIdLength:])
+	return &key, nil
+}
+
+// ParseLayerSecretKey parses the contents of a secret key file created by LayerSecretKey.FileContents
+func ParseLayerSecretKey(value string) (*LayerSecretKey, error) {
+	data, err := decodeKeyLine(value, encodedSecretKeyLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid secret key")
+	}
+
+	var key LayerSecretKey
+	copy(key.KeyId[:], data[2:2+signatureKeyIdLength])
+	key.Key = ed25519.NewKeyFromSeed(data[2+signatureKeyIdLength:])
+	return &key, nil
+}
+
+// decodeKeyLine decodes the last non-comment line of a key
+func decodeKeyLine(value string, expectedLength int) ([]byte, error) {
+	var keyLine string
+	for _, line := range strings.Split(strings.TrimSpace(value), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, untrustedCommentPrefix) {
+			keyLine = line
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(keyLine)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode base64")
+	}
+	if len(data) != expectedLength {
+		return nil, fmt.Errorf("expected %d bytes, got %d", expectedLength, len(data))
+	}
+	if string(data[:2]) != signatureAlgorithm {
+		return nil, fmt.Errorf("unsupported algorithm %q", data[:2])
+	}
+	return data, nil
+}
+
+// BuildLayerManifest creates the canonical manifest of all files in a layer directory.
+// each line contains the sha256 of a file and its path relative to the layer directory (forward slashes), sorted by path.
+// files with the name excludeFilename in the root of the layer are skipped
+func BuildLayerManifest(fsys fs.FS, layerPath string, excludeFilename string) ([]byte, error) {
+	type manifestEntry struct {
+		path string
+		sha  string
+	}
+
+	var entries []manifestEntry
+	err := fs.WalkDir(fsys, layerPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath := filePath
+		if layerPath != "." {
+			relPath = strings.TrimPrefix(filePath, layerPath+"/")
+		}
+		if relPath == excludeFilename {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("cannot sign non-regular file %s", relPath)
+		}
+
+		f, err := fsys.Open(filePath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %s", filePath)
+		}
+		defer f.Close()
+
+		hash := sha256.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return errors.Wrapf(err, "failed to hash %s", filePath)
+		}
+
+		entries = append(entries, manifestEntry{path: relPath, sha: hex.EncodeToString(hash.Sum(nil))})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to walk layer directory")
+	}
+
+	slices.SortFunc(entries, func(a, b manifestEntry) int {
+		return strings.Compare(a.path, b.path)
+	})
+
+	var manifest bytes.Buffer
+	for _, entry := range entries {
+		if strings.ContainsAny(entry.path, "\n\r") {
+			return nil, fmt.Errorf("file paths with newlines cannot be signed: %q", entry.path)
+		}
+		fmt.Fprintf(&manifest, "%s  %s\n", entry.sha, entry.path)
+	}
+
+	return manifest.Bytes(), nil
+}
+
+// SignLayerManifest creates a detached signature over the manifest
+func SignLayerManifest(key *LayerSecretKey, manifest []byte, trustedComment string) []byte {
+	signature := ed25519.Sign(key.Key, manifest)
+
+	signatureBody := make([]byte, 0, encodedSignatureBodyLength)
+	signatureBody = append(signatureBody, signatureAlgorithm...)
+	signatureBody = append(signatureBody, key.KeyId[:]...)
+	signatureBody = append(signatureBody, signature...)
+
+	globalSignature := ed25519.Sign(key.Key, append(slices.Clone(signature), trustedComment...))
+
+	return []byte(fmt.Sprintf("%ssignature from avdcli secret key %s\n%s\n%s%s\n%s\n",
+		untrustedCommentPrefix, key.KeyId,
+		base64.StdEncoding.EncodeToString(signatureBody),
+		trustedCommentPrefix, trustedComment,
+		base64.StdEncoding.EncodeToString(globalSignature),
+	))
+}
+
+type LayerSignature struct {
+	KeyId          SigningKeyId
+	TrustedComment string
+}
+
+// VerifyLayerSignature checks the signature of the manifest against the trusted keys.
+// returns ErrLayerSignatureUntrusted if the signing key is not trusted
+// returns ErrLayerSignatureInvalid if the signature does not match the manifest
+func VerifyLayerSignature(manifest, signatureFile []byte, trustedKeys []*LayerPublicKey) (*LayerSignature, error) {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimSpace(string(signatureFile)), "\r\n", "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], untrustedCommentPrefix) || !strings.HasPrefix(lines[2], trustedCommentPrefix) {
+		return nil, errors.Wrap(ErrLayerSignatureInvalid, "malformed signature file")
+	}
+
+	signatureBody, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(signatureBody) != encodedSignatureBodyLength {
+		return nil, errors.Wrap(ErrLayerSignatureInvalid, "malformed signature")
+	}
+	if string(signatureBody[:2]) != signatureAlgorithm {
+		return nil, errors.Wrapf(ErrLayerSignatureInvalid, "unsupported signature algorithm %q", signatureBody[:2])
+	}
+
+	result := LayerSignature{
+		TrustedComment: strings.TrimPrefix(lines[2], trustedCommentPrefix),
+	}
+	copy(result.KeyId[:], signatureBody[2:2+signatureKeyIdLength])
+	signature := signatureBody[2+signatureKeyIdLength:]
+
+	var publicKey *LayerPublicKey
+	for _, key := range trustedKeys {
+		if key.KeyId == result.KeyId {
+			publicKey = key
+			break
+		}
+	}
+	if publicKey == nil {
+		return &result, errors.Wrapf(ErrLayerSignatureUntrusted, "key id %s", result.KeyId)
+	}
+
+	if !ed25519.Verify(publicKey.Key, manifest, signature) {
+		return &result, errors.Wrap(ErrLayerSignatureInvalid, "signature does not match the layer contents")
+	}
+
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSignature) != ed25519.SignatureSize {
+		return &result, errors.Wrap(ErrLayerSignatureInvalid, "malformed trusted comment signature")
+	}
+	if !ed25519.Verify(publicKey.Key, append(slices.Clone(signature), result.TrustedComment...), globalSignature) {
+		return &result, errors.Wrap(ErrLayerSignatureInvalid, "trusted comment signature does not match")
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayerSignature(t *testing.T) {
+	layerFs := fstest.MapFS{
+		"layer/properties.json5":     {Data: []byte(`{name: "test"}`)},
+		"layer/install.ps1":          {Data: []byte(`Write-Host "hi"`)},
+		"layer/install/Script.ps1":   {Data: []byte(`Write-Host "nested"`)},
+		"layer/signature.minisig":    {Data: []byte("ignored")},
+		"other/should_not_be_signed": {Data: []byte("other")},
+	}
+
+	manifest, err := BuildLayerManifest(layerFs, "layer", "signature.minisig")
+	require.NoError(t, err)
+	require.Contains(t, string(manifest), "  install.ps1\n")
+	require.Contains(t, string(manifest), "  install/Script.ps1\n")
+	require.Contains(t, string(manifest), "  properties.json5\n")
+	require.NotContains(t, string(manifest), "signature.minisig")
+	require.NotContains(t, string(manifest), "should_not_be_signed")
+
+	publicKey, secretKey, err := GenerateLayerSigningKey()
+	require.NoError(t, err)
+
+	parsedSecretKey, err := ParseLayerSecretKey(string(secretKey.FileContents()))
+	require.NoError(t, err)
+	parsedPublicKey, err := ParseLayerPublicKey(string(publicKey.FileContents()))
+	require.NoError(t, err)
+	require.Equal(t, publicKey.String(), parsedPublicKey.String())
+
+	signature := SignLayerManifest(parsedSecretKey, manifest, "layer:test")
+
+	verified, err := VerifyLayerSignature(manifest, signature, []*LayerPublicKey{parsedPublicKey})
+	require.NoError(t, err)
+	require.Equal(t, publicKey.KeyId, verified.KeyId)
+	require.Equal(t, "layer:test", verified.TrustedComment)
+
+	_, err = VerifyLayerSignature(manifest, signature, nil)
+	require.ErrorIs(t, err, ErrLayerSignatureUntrusted)
+
+	tampered := append([]byte{}, manifest...)
+	tampered[0] ^= 1
+	_, err = VerifyLayerSignature(tampered, signature, []*LayerPublicKey{parsedPublicKey})
+	require.ErrorIs(t, err, ErrLayerSignatureInvalid)
+}
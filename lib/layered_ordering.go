@@ -1,8 +1,12 @@
 package lib
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	stdErr "errors"
 	"fmt"
 	"github.com/friendsofgo/errors"
+	"io"
 	"io/fs"
 	"math"
 	"os"
@@ -10,6 +14,8 @@ import (
 	"regexp"
 	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,11 +26,17 @@ type FileMapping struct {
 	FileMode   fs.FileMode
 	Modified   time.Time
 	Size       int64
+	// OverriddenLayerIndexes are the lower layers of which the file at TargetPath was replaced by this file
+	OverriddenLayerIndexes []int
+	// DeduplicatedLayerIndexes are the other layers that contain a file with the same content at TargetPath
+	DeduplicatedLayerIndexes []int
 }
 
 type FileCollision struct {
 	Path                  string
 	CollidingLayerIndexes []int
+
+	entries []layerEntry
 }
 
 type EntryPathTypeCollision struct {
@@ -33,9 +45,53 @@ type EntryPathTypeCollision struct {
 	FileLayerIndexes      []int
 }
 
+// OverridePrefix marks an unordered file that replaces the file with the same name (without the prefix) in lower layers
+const OverridePrefix = "override_"
+
 // MergeDirectoryLayers merges multiple layers of directories.
+// overrides contains, per layer, the file paths that replace the files of lower layers, like files with the OverridePrefix do.
+// overrides may be nil or shorter than layers.
+// colliding files with the same content are not a collision, they are merged into a single mapping.
 // returns a list of file mappings for each input layer (same slice size as input)
-func MergeDirectoryLayers(layers []fs.FS, basePath string) (fileMappings []FileMapping, fileCollisions []FileCollision, typeCollisions []EntryPathTypeCollision, err error) {
+func MergeDirectoryLayers(layers []fs.FS, overrides []map[string]struct{}, basePath string) (fileMappings []FileMapping, fileCollisions []FileCollision, typeCollisions []EntryPathTypeCollision, err error) {
+	fileMappings, collisions, typeCollisions, err := mergeDirectoryLayers(layers, overrides, basePath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(collisions) == 0 {
+		return fileMappings, nil, typeCollisions, nil
+	}
+
+	// only files with the same size as another file of the collision can have the same content
+	var collidingEntries []layerEntry
+	for _, collision := range collisions {
+		for _, entry := range collision.entries {
+			if slices.ContainsFunc(collision.entries, func(other layerEntry) bool {
+				return other.key() != entry.key() && other.size == entry.size
+			}) {
+				collidingEntries = append(collidingEntries, entry)
+			}
+		}
+	}
+	hashes, err := hashLayerEntries(layers, collidingEntries)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to hash colliding files")
+	}
+
+	for _, collision := range collisions {
+		mapping, ok := mergeUnorderedFileEntries(collision.entries, hashes)
+		if !ok {
+			fileCollisions = append(fileCollisions, collision)
+			continue
+		}
+		fileMappings = append(fileMappings, mapping)
+	}
+
+	return fileMappings, fileCollisions, typeCollisions, nil
+}
+
+// mergeDirectoryLayers merges the directories without comparing the content of colliding files
+func mergeDirectoryLayers(layers []fs.FS, overrides []map[string]struct{}, basePath string) (fileMappings []FileMapping, fileCollisions []FileCollision, typeCollisions []EntryPathTypeCollision, err error) {
 	entriesByNormalizedName := map[normalizedEntryName][]layerEntry{}
 	for layerIdx, layer := range layers {
 		entries, err := fs.ReadDir(layer, basePath)
@@ -50,6 +106,12 @@ func MergeDirectoryLayers(layers []fs.FS, basePath string) (fileMappings []FileM
 			isDir := entry.IsDir()
 			sourcePath := path.Join(basePath, entryName)
 			normalizedName, targetName, orderingIndex, entryOrderingType := normalizeEntryName(entryName, isDir)
+			override := !isDir && !normalizedName.ordered && targetName != entryName // the override prefix was removed
+			if layerIdx < len(overrides) {
+				if _, ok := overrides[layerIdx][path.Join(basePath, targetName)]; ok && !isDir {
+					override = true
+				}
+			}
 
 			entryInfo, err := entry.Info()
 			if err != nil {
@@ -63,6 +125,7 @@ func MergeDirectoryLayers(layers []fs.FS, basePath string) (fileMappings []FileM
 				isDir:         isDir,
 				orderingIndex: orderingIndex,
 				orderingType:  entryOrderingType,
+				override:      override,
 				fileMode:      entryInfo.Mode(),
 				modified:      entryInfo.ModTime(),
 				size:          entryInfo.Size(),
@@ -90,6 +153,7 @@ func MergeDirectoryLayers(layers []fs.FS, basePath string) (fileMappings []FileM
 				fileCollisions = append(fileCollisions, FileCollision{
 					Path:                  normalizedFullPath,
 					CollidingLayerIndexes: collisionLayerIdxs,
+					entries:               entries,
 				})
 			}
 		} else if len(fileIndexes) == 0 {
@@ -97,7 +161,7 @@ func MergeDirectoryLayers(layers []fs.FS, basePath string) (fileMappings []FileM
 				panic("programming error: directories should never be ordered")
 			}
 			// all directories -> merge directory
-			subFileMappings, subFileCollisions, subTypeCollisions, err := MergeDirectoryLayers(layers, normalizedFullPath)
+			subFileMappings, subFileCollisions, subTypeCollisions, err := mergeDirectoryLayers(layers, overrides, normalizedFullPath)
 			if err != nil {
 				return nil, nil, nil, errors.Wrapf(err, "failed to merge sub directory %s", normalizedFullPath)
 			}
@@ -124,6 +188,7 @@ type layerEntry struct {
 	isDir         bool
 	orderingIndex int
 	orderingType  orderingType
+	override      bool
 	fileMode      fs.FileMode
 	modified      time.Time
 	size          int64
@@ -157,7 +222,8 @@ type normalizedEntryName struct {
 }
 
 // normalizeEntryName normalizes the name of a DirEntry
-// targetName: same as normalized.name if not an ordered file
+// targetName: same as normalized.name if not an ordered file.
+// the OverridePrefix is removed from unordered files
 func normalizeEntryName(name string, isDir bool) (normalized normalizedEntryName, targetName string, index int, orderingType orderingType) {
 	if isDir {
 		return normalizedEntryName{
@@ -169,6 +235,10 @@ func normalizeEntryName(name string, isDir bool) (normalized normalizedEntryName
 	subMatches := orderedEntryNameRegex.FindStringSubmatch(name)
 	switch len(subMatches) {
 	case 0:
+		// ordered files never collide, so they can't be overrides
+		if overriddenName, ok := strings.CutPrefix(name, OverridePrefix); ok && overriddenName != "" && !orderedEntryNameRegex.MatchString(overriddenName) {
+			name = overriddenName
+		}
 		return normalizedEntryName{
 			ordered: false,
 			name:    name,
@@ -221,7 +291,8 @@ func checkPathEntryTypes(entries []layerEntry) (dirIndexes []int, fileIndexes []
 //   - are files
 //   - have the same normalized paths (and thus either all ordered or all unordered)
 //
-// returns either the same number of mappings as input entries OR a list of colliding layer indexes
+// unordered files of higher layers replace the file of lower layers if they are marked as override.
+// returns either the mappings of the files that end up in the merged directory OR a list of colliding layer indexes
 func mergeFileEntries(entries []layerEntry) (mappings []FileMapping, collidingLayerIndexes []int) {
 	if len(entries) == 0 {
 		return []FileMapping{}, nil
@@ -252,11 +323,11 @@ func mergeFileEntries(entries []layerEntry) (mappings []FileMapping, collidingLa
 
 	// handle only-unordered files case
 	if unorderedCount > 0 {
-		if len(entries) > 1 {
+		mapping, ok := mergeUnorderedFileEntries(entries, nil)
+		if !ok {
 			return nil, layerIndexes
 		}
-		onlyEntry := entries[0]
-		return []FileMapping{onlyEntry.toFileMapping(onlyEntry.sourcePath)}, nil
+		return []FileMapping{mapping}, nil
 	}
 
 	mappings = make([]FileMapping, 0, len(entries))
@@ -269,6 +340,104 @@ func mergeFileEntries(entries []layerEntry) (mappings []FileMapping, collidingLa
 	return mappings, nil
 }
 
+// mergeUnorderedFileEntries returns the mapping of the file that replaces the files of all lower layers.
+// files with the same hash as the current file are deduplicated, hashes may be nil.
+// returns false if a file does not override the file of a lower layer and has different content
+func mergeUnorderedFileEntries(entries []layerEntry, hashes map[layerEntryKey]string) (FileMapping, bool) {
+	entries = slices.Clone(entries)
+	slices.SortStableFunc(entries, func(a, b layerEntry) int {
+		return a.layerIdx - b.layerIdx
+	})
+
+	winner := entries[0]
+	var overridden, deduplicated []int
+	for _, entry := range entries[1:] {
+		switch {
+		case entry.size == winner.size && sameHash(hashes, entry, winner):
+			deduplicated = append(deduplicated, entry.layerIdx)
+		case entry.override && entry.layerIdx != winner.layerIdx:
+			// the copies of the winner are overridden as well
+			overridden = append(overridden, winner.layerIdx)
+			overridden = append(overridden, deduplicated...)
+			deduplicated = nil
+			winner = entry
+		default:
+			return FileMapping{}, false
+		}
+	}
+
+	mapping := winner.toFileMapping(path.Join(path.Dir(winner.sourcePath), winner.targetName))
+	mapping.OverriddenLayerIndexes = overridden
+	mapping.DeduplicatedLayerIndexes = deduplicated
+	return mapping, true
+}
+
+// sameHash returns whether both entries were hashed and have the same content
+func sameHash(hashes map[layerEntryKey]string, a, b layerEntry) bool {
+	aHash, aOk := hashes[a.key()]
+	bHash, bOk := hashes[b.key()]
+	return aOk && bOk && aHash == bHash
+}
+
+type layerEntryKey struct {
+	layerIdx   int
+	sourcePath string
+}
+
+func (l layerEntry) key() layerEntryKey {
+	return layerEntryKey{layerIdx: l.layerIdx, sourcePath: l.sourcePath}
+}
+
+// maxConcurrentHashes limits the number of files that are read at the same time
+const maxConcurrentHashes = 4
+
+// hashLayerEntries calculates the sha256 hash of the files concurrently
+func hashLayerEntries(layers []fs.FS, entries []layerEntry) (map[layerEntryKey]string, error) {
+	hashes := make([]string, len(entries))
+	errs := make([]error, len(entries))
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentHashes)
+	for i, entry := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			hashes[i], errs[i] = hashFile(layers[entry.layerIdx], entry.sourcePath)
+			if errs[i] != nil {
+				errs[i] = errors.Wrapf(errs[i], "failed to hash %s in layer %d", entry.sourcePath, entry.layerIdx+1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := stdErr.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	hashesByEntry := make(map[layerEntryKey]string, len(entries))
+	for i, entry := range entries {
+		hashesByEntry[entry.key()] = hashes[i]
+	}
+	return hashesByEntry, nil
+}
+
+func hashFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", errors.Wrap(err, "failed to read file")
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 func intBase10Length(number int) uint {
 	if number == 0 {
 		return 1
@@ -1,8 +1,12 @@
 package lib
 
 import (
+	"io/fs"
 	"reflect"
 	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
 )
 
 func Test_normalizeEntryName(t *testing.T) {
@@ -25,6 +29,9 @@ func Test_normalizeEntryName(t *testing.T) {
 		{"post ordered file", args{"020_post_file.txt", false}, normalizedEntryName{true, ""}, "file.txt", 20, postOrderingPreference},
 		{"directory", args{"testdir", true}, normalizedEntryName{false, "testdir"}, "testdir", 0, unordered},
 		{"directory with ordering", args{"000_testdir", true}, normalizedEntryName{false, "000_testdir"}, "000_testdir", 0, unordered},
+		{"override file", args{"override_file.txt", false}, normalizedEntryName{false, "file.txt"}, "file.txt", 0, unordered},
+		{"override ordered file", args{"override_020_file.txt", false}, normalizedEntryName{false, "override_020_file.txt"}, "override_020_file.txt", 0, unordered},
+		{"override directory", args{"override_testdir", true}, normalizedEntryName{false, "override_testdir"}, "override_testdir", 0, unordered},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -44,3 +51,99 @@ func Test_normalizeEntryName(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeDirectoryLayers_overrides(t *testing.T) {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+	layers := []fs.FS{
+		fstest.MapFS{"config/app.json": file("1"), "config/other.json": file("1"), "readme.txt": file("1")},
+		fstest.MapFS{"config/override_app.json": file("2"), "readme.txt": file("2")},
+		fstest.MapFS{"config/app.json": file("3")},
+	}
+
+	mappings, fileCollisions, typeCollisions, err := MergeDirectoryLayers(layers, []map[string]struct{}{nil, {"readme.txt": {}}, {"config/app.json": {}}}, ".")
+	require.NoError(t, err)
+	require.Empty(t, fileCollisions)
+	require.Empty(t, typeCollisions)
+
+	byTarget := map[string]FileMapping{}
+	for _, mapping := range mappings {
+		byTarget[mapping.TargetPath] = mapping
+	}
+	require.Len(t, byTarget, 3)
+	require.Equal(t, 2, byTarget["config/app.json"].LayerIdx)
+	require.Equal(t, []int{0, 1}, byTarget["config/app.json"].OverriddenLayerIndexes)
+	require.Equal(t, 1, byTarget["readme.txt"].LayerIdx)
+	require.Equal(t, []int{0}, byTarget["readme.txt"].OverriddenLayerIndexes)
+	require.Empty(t, byTarget["config/other.json"].OverriddenLayerIndexes)
+
+	// without the overrides list the files of the last layer collide
+	_, fileCollisions, _, err = MergeDirectoryLayers(layers, nil, ".")
+	require.NoError(t, err)
+	require.Len(t, fileCollisions, 2)
+	for _, collision := range fileCollisions {
+		switch collision.Path {
+		case "config/app.json":
+			require.Equal(t, []int{0, 1, 2}, collision.CollidingLayerIndexes)
+		case "readme.txt":
+			require.Equal(t, []int{0, 1}, collision.CollidingLayerIndexes)
+		default:
+			t.Fatalf("unexpected collision %s", collision.Path)
+		}
+	}
+}
+
+func TestMergeDirectoryLayers_identicalFiles(t *testing.T) {
+	layers := []fs.FS{
+		fstest.MapFS{"scripts/helper.ps1": {Data: []byte("Write-Host helper")}, "config.json": {Data: []byte("{}")}},
+		fstest.MapFS{"scripts/helper.ps1": {Data: []byte("Write-Host helper")}, "config.json": {Data: []byte(`{"a":1}`)}},
+		fstest.MapFS{"scripts/helper.ps1": {Data: []byte("Write-Host helper")}, "override_config.json": {Data: []byte(`{"b":1}`)}},
+	}
+
+	mappings, fileCollisions, _, err := MergeDirectoryLayers(layers, nil, ".")
+	require.NoError(t, err)
+	require.Len(t, fileCollisions, 1)
+	require.Equal(t, "config.json", fileCollisions[0].Path)
+	require.Len(t, mappings, 1)
+	require.Equal(t, "scripts/helper.ps1", mappings[0].TargetPath)
+	require.Equal(t, 0, mappings[0].LayerIdx)
+	require.Equal(t, []int{1, 2}, mappings[0].DeduplicatedLayerIndexes)
+
+	// an identical file in the middle doesn't collide with the override on top
+	layers[1].(fstest.MapFS)["config.json"] = &fstest.MapFile{Data: []byte("{}")}
+	mappings, fileCollisions, _, err = MergeDirectoryLayers(layers, nil, ".")
+	require.NoError(t, err)
+	require.Empty(t, fileCollisions)
+	require.Len(t, mappings, 2)
+	for _, mapping := range mappings {
+		if mapping.TargetPath == "config.json" {
+			require.Equal(t, 2, mapping.LayerIdx)
+			require.Empty(t, mapping.DeduplicatedLayerIndexes)
+			require.Equal(t, []int{0, 1}, mapping.OverriddenLayerIndexes)
+		}
+	}
+}
+
+// unreadableFS fails to open the files in unreadable
+type unreadableFS struct {
+	fstest.MapFS
+	unreadable string
+}
+
+func (u unreadableFS) Open(name string) (fs.File, error) {
+	if name == u.unreadable {
+		return nil, fs.ErrPermission
+	}
+	return u.MapFS.Open(name)
+}
+
+func TestMergeDirectoryLayers_onlyHashesFilesOfTheSameSize(t *testing.T) {
+	layers := []fs.FS{
+		fstest.MapFS{"config.json": {Data: []byte("{}")}},
+		unreadableFS{MapFS: fstest.MapFS{"config.json": {Data: []byte(`{"a":1}`)}}, unreadable: "config.json"},
+	}
+
+	_, fileCollisions, _, err := MergeDirectoryLayers(layers, nil, ".")
+	require.NoError(t, err)
+	require.Len(t, fileCollisions, 1)
+	require.Equal(t, "config.json", fileCollisions[0].Path)
+}
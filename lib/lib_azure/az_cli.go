@@ -0,0 +1,550 @@
+package lib_azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib"
+)
+
+// AzCLIBackend shells out to the Azure CLI, using the account that is logged in to the CLI
+type AzCLIBackend struct {
+	tenantId string
+}
+
+func NewAzCLIBackend(tenantId string) (*AzCLIBackend, error) {
+	if _, err := exec.LookPath("az"); err != nil {
+		return nil, fmt.Errorf("az command not found. Install the Azure CLI and restart this terminal: https://learn.microsoft.com/en-us/cli/azure/install-azure-cli (%w)", err)
+	}
+
+	return &AzCLIBackend{tenantId: tenantId}, nil
+}
+
+func (a *AzCLIBackend) Name() string {
+	return "Azure CLI"
+}
+
+func (a *AzCLIBackend) GetSubscription(ctx context.Context, subscriptionId string) (*Subscription, error) {
+	accounts, err := lib.ExecuteAsParseAsJSON[[]lib.AzAccount](ctx, "az", "account", "list", "--only-show-errors")
+	if err != nil {
+		return nil, errors.Wrap(err, "Azure CLI login check failed. Make sure you are logged in. You can run 'az login' (https://learn.microsoft.com/en-us/cli/azure/authenticate-azure-cli?view=azure-cli-latest#sign-into-azure-with-azure-cli)")
+	}
+
+	for _, account := range accounts {
+		if account.SubscriptionId == subscriptionId && (a.tenantId == "" || account.TenantId == a.tenantId) {
+			return &Subscription{
+				SubscriptionId: account.SubscriptionId,
+				Name:           account.Name,
+				TenantId:       account.TenantId,
+			}, nil
+		}
+	}
+
+	return nil, ErrSubscriptionNotFound
+}
+
+func (a *AzCLIBackend) ListImageDefinitions(ctx context.Context, subscriptionId, resourceGroup, gallery string) ([]ImageDefinition, error) {
+	azDefinitions, err := lib.ExecuteAsParseAsJSON[[]lib.AzImageDefinition](ctx, "az", "sig", "image-definition", "list", "-r", gallery, "-g", resourceGroup, "--subscription", subscriptionId)
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := make([]ImageDefinition, len(azDefinitions))
+	for i, def := range azDefinitions {
+		definitions[i] = ImageDefinition{
+			ID:       def.ID,
+			Name:     def.Name,
+			Location: def.Location,
+			Tags:     def.Tags,
+		}
+	}
+
+	return definitions, nil
+}
+
+// azImageVersion is an image version as the Azure CLI returns it, with flattened properties
+type azImageVersion struct {
+	ID                string                   `json:"id"`
+	Name              string                   `json:"name"`
+	Location          string                   `json:"location"`
+	Tags              map[string]string        `json:"tags"`
+	ProvisioningState string                   `json:"provisioningState"`
+	PublishingProfile azImagePublishingProfile `json:"publishingProfile"`
+	ReplicationStatus struct {
+		AggregatedState string `json:"aggregatedState"`
+	} `json:"replicationStatus"`
+}
+
+// azImagePublishingProfile is the Azure CLI representation of the publishing profile of a gallery image version
+type azImagePublishingProfile struct {
+	ExcludeFromLatest bool       `json:"excludeFromLatest"`
+	PublishedDate     *time.Time `json:"publishedDate"`
+	EndOfLifeDate     *time.Time `json:"endOfLifeDate"`
+	TargetRegions     []struct {
+		Name string `json:"name"`
+	} `json:"targetRegions"`
+}
+
+func (p azImagePublishingProfile) targetRegionNames() []string {
+	names := make([]string, len(p.TargetRegions))
+	for i, region := range p.TargetRegions {
+		names[i] = region.Name
+	}
+	return names
+}
+
+func (v azImageVersion) toImageVersion() ImageVersion {
+	return ImageVersion{
+		ID:                v.ID,
+		Name:              v.Name,
+		Location:          v.Location,
+		ProvisioningState: v.ProvisioningState,
+		PublishedDate:     v.PublishingProfile.PublishedDate,
+		EndOfLifeDate:     v.PublishingProfile.EndOfLifeDate,
+		ExcludeFromLatest: v.PublishingProfile.ExcludeFromLatest,
+		TargetRegions:     v.PublishingProfile.targetRegionNames(),
+		ReplicationState:  v.ReplicationStatus.AggregatedState,
+		Tags:              v.Tags,
+	}
+}
+
+func (a *AzCLIBackend) ListImageVersions(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition string) ([]ImageVersion, error) {
+	azVersions, err := lib.ExecuteAsParseAsJSON[[]azImageVersion](ctx, "az", "sig", "image-version", "list",
+		"-r", gallery,
+		"-i", imageDefinition,
+		"-g", resourceGroup,
+		"--subscription", subscriptionId,
+		"--only-show-errors")
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]ImageVersion, len(azVersions))
+	for i, version := range azVersions {
+		versions[i] = version.toImageVersion()
+	}
+
+	return versions, nil
+}
+
+func (a *AzCLIBackend) GetImageVersion(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string) (*ImageVersion, error) {
+	azVersion, err := lib.ExecuteAsParseAsJSON[*azImageVersion](ctx, "az", "sig", "image-version", "show",
+		"-r", gallery,
+		"-i", imageDefinition,
+		"-e", version,
+		"-g", resourceGroup,
+		"--subscription", subscriptionId,
+		"--expand", "ReplicationStatus",
+		"--only-show-errors")
+	if err != nil {
+		if azErrorCode(err) == "ResourceNotFound" {
+			return nil, ErrImageVersionNotFound
+		}
+		return nil, err
+	}
+
+	imageVersion := azVersion.toImageVersion()
+	return &imageVersion, nil
+}
+
+func (a *AzCLIBackend) DeleteImageVersion(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string) error {
+	cmd := exec.CommandContext(ctx, "az", "sig", "image-version", "delete",
+		"-r", gallery,
+		"-i", imageDefinition,
+		"-e", version,
+		"-g", resourceGroup,
+		"--subscription", subscriptionId,
+		"--only-show-errors")
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (a *AzCLIBackend) UpdateImageVersion(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string, update ImageVersionUpdate) error {
+	tags, err := json.Marshal(update.Tags)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize tags")
+	}
+
+	cmd := exec.CommandContext(ctx, "az", "sig", "image-version", "update",
+		"-r", gallery,
+		"-i", imageDefinition,
+		"-e", version,
+		"-g", resourceGroup,
+		"--subscription", subscriptionId,
+		"--set", fmt.Sprintf("publishingProfile.excludeFromLatest=%t", update.ExcludeFromLatest),
+		"--set", "tags="+string(tags),
+		"--only-show-errors",
+		"--output", "none")
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (a *AzCLIBackend) BlobExists(ctx context.Context, storageAccount, container, blobName string) (bool, error) {
+	type existsOut struct {
+		Exists bool `json:"exists"`
+	}
+	exists, err := lib.ExecuteAsParseAsJSON[*existsOut](ctx, "az", "storage", "blob", "exists",
+		"--account-name", storageAccount,
+		"--container-name", container,
+		"-n", blobName,
+		"--auth-mode", "login",
+		"--only-show-errors")
+	if err != nil {
+		return false, err
+	}
+
+	return exists.Exists, nil
+}
+
+func (a *AzCLIBackend) UploadBlob(ctx context.Context, storageAccount, container, blobName, filePath string) error {
+	uploadCmd := exec.CommandContext(ctx, "az", "storage", "blob", "upload",
+		"-f", filePath,
+		"-c", container,
+		"-n", blobName,
+		"--account-name", storageAccount,
+		"--only-show-errors",
+		"--auth-mode", "login",
+		"-o", "none")
+	uploadCmd.Stderr = os.Stderr
+	uploadCmd.Stdout = os.Stdout
+
+	return uploadCmd.Run()
+}
+
+func (a *AzCLIBackend) ListBlobs(ctx context.Context, storageAccount, container, prefix string) ([]Blob, error) {
+	type azBlob struct {
+		Name         string    `json:"name"`
+		LastModified time.Time `json:"lastModified"`
+		Size         int64     `json:"size"`
+	}
+
+	azBlobs, err := lib.ExecuteAsParseAsJSON[[]azBlob](ctx, "az", "storage", "blob", "list",
+		"--account-name", storageAccount,
+		"-c", container,
+		"--prefix", prefix,
+		"--num-results", "*",
+		"--auth-mode", "login",
+		"--query", "[].{name:name, lastModified:properties.lastModified, size:properties.contentLength}",
+		"--only-show-errors")
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make([]Blob, len(azBlobs))
+	for i, blob := range azBlobs {
+		blobs[i] = Blob(blob)
+	}
+	return blobs, nil
+}
+
+func (a *AzCLIBackend) TouchBlob(ctx context.Context, storageAccount, container, blobName string) error {
+	cmd := exec.CommandContext(ctx, "az", "storage", "blob", "metadata", "update",
+		"--account-name", storageAccount,
+		"-c", container,
+		"-n", blobName,
+		"--metadata", touchMetadataKey+"="+time.Now().UTC().Format(time.RFC3339),
+		"--auth-mode", "login",
+		"--only-show-errors",
+		"-o", "none")
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (a *AzCLIBackend) DeleteBlob(ctx context.Context, storageAccount, container, blobName string) error {
+	cmd := exec.CommandContext(ctx, "az", "storage", "blob", "delete",
+		"--account-name", storageAccount,
+		"-c", container,
+		"-n", blobName,
+		"--auth-mode", "login",
+		"--only-show-errors")
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (a *AzCLIBackend) DownloadBlob(ctx context.Context, storageAccount, container, blobName, targetPath string) error {
+	cmd := exec.CommandContext(ctx, "az", "storage", "blob", "download",
+		"--account-name", storageAccount,
+		"-c", container,
+		"-n", blobName,
+		"-f", targetPath,
+		"--auth-mode", "login",
+		"--only-show-errors",
+		"-o", "none")
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (a *AzCLIBackend) GetBlobSize(ctx context.Context, storageAccount, container, blobName string) (int64, error) {
+	return lib.ExecuteAsParseAsJSON[int64](ctx, "az", "storage", "blob", "show",
+		"--account-name", storageAccount,
+		"-c", container,
+		"-n", blobName,
+		"--auth-mode", "login",
+		"--query", "properties.contentLength",
+		"--only-show-errors")
+}
+
+func (a *AzCLIBackend) ReadBlobRange(ctx context.Context, storageAccount, container, blobName string, offset, count int64) ([]byte, error) {
+	rangeFile, err := os.CreateTemp("", "avdcli-blob-range-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary file")
+	}
+	rangeFile.Close()
+	defer os.Remove(rangeFile.Name())
+
+	cmd := exec.CommandContext(ctx, "az", "storage", "blob", "download",
+		"--account-name", storageAccount,
+		"-c", container,
+		"-n", blobName,
+		"-f", rangeFile.Name(),
+		"--start-range", strconv.FormatInt(offset, 10),
+		"--end-range", strconv.FormatInt(offset+count-1, 10), // inclusive
+		"--auth-mode", "login",
+		"--only-show-errors",
+		"-o", "none")
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(rangeFile.Name())
+}
+
+func (a *AzCLIBackend) DeployTemplate(ctx context.Context, subscriptionId, resourceGroup, deploymentName string, template []byte) error {
+	templateFile, err := os.CreateTemp("", "avdcli-deployment-*.json")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary deployment template file")
+	}
+	defer os.Remove(templateFile.Name())
+
+	if _, err := templateFile.Write(template); err != nil {
+		templateFile.Close()
+		return errors.Wrap(err, "failed to write temporary deployment template file")
+	}
+	if err := templateFile.Close(); err != nil {
+		return errors.Wrap(err, "failed to write temporary deployment template file")
+	}
+
+	cmd := exec.CommandContext(ctx, "az", "deployment", "group", "create",
+		"--subscription", subscriptionId,
+		"--resource-group", resourceGroup,
+		"--name", deploymentName,
+		"--template-file", templateFile.Name(),
+		"--no-prompt", "true",
+		"--only-show-errors",
+		"-o", "none")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (a *AzCLIBackend) GetImageTemplateStatus(ctx context.Context, subscriptionId, resourceGroup, name string) (*ImageTemplateStatus, error) {
+	template, err := lib.ExecuteAsParseAsJSON[azImageTemplate](ctx, "az", "image", "builder", "show",
+		"-n", name,
+		"-g", resourceGroup,
+		"--subscription", subscriptionId,
+		"--only-show-errors")
+	if err != nil {
+		if azErrorCode(err) == "ResourceNotFound" {
+			return nil, ErrImageTemplateNotFound
+		}
+		return nil, err
+	}
+
+	return template.status(), nil
+}
+
+// azImageTemplate is the Image Template as printed by the Azure CLI, which flattens the properties
+type azImageTemplate struct {
+	ID                        string `json:"id"`
+	Name                      string `json:"name"`
+	ProvisioningState         string `json:"provisioningState"`
+	ExactStagingResourceGroup string `json:"exactStagingResourceGroup"`
+	ProvisioningError         *struct {
+		Message string `json:"message"`
+	} `json:"provisioningError"`
+	LastRunStatus *struct {
+		RunState    string     `json:"runState"`
+		RunSubState string     `json:"runSubState"`
+		Message     string     `json:"message"`
+		StartTime   *time.Time `json:"startTime"`
+		EndTime     *time.Time `json:"endTime"`
+	} `json:"lastRunStatus"`
+	Distribute []struct {
+		GalleryImageId string `json:"galleryImageId"`
+	} `json:"distribute"`
+	SystemData *struct {
+		CreatedAt *time.Time `json:"createdAt"`
+	} `json:"systemData"`
+}
+
+func (t azImageTemplate) status() *ImageTemplateStatus {
+	status := &ImageTemplateStatus{
+		ID:                   t.ID,
+		ProvisioningState:    t.ProvisioningState,
+		StagingResourceGroup: t.ExactStagingResourceGroup,
+	}
+	if t.ProvisioningError != nil {
+		status.ProvisioningError = t.ProvisioningError.Message
+	}
+	if runStatus := t.LastRunStatus; runStatus != nil {
+		status.RunState = runStatus.RunState
+		status.RunSubState = runStatus.RunSubState
+		status.RunMessage = runStatus.Message
+		status.StartTime = runStatus.StartTime
+		status.EndTime = runStatus.EndTime
+	}
+	return status
+}
+
+func (a *AzCLIBackend) ListImageTemplates(ctx context.Context, subscriptionId, resourceGroup string) ([]ImageTemplate, error) {
+	rawTemplates, err := lib.ExecuteAsParseAsJSON[[]json.RawMessage](ctx, "az", "image", "builder", "list",
+		"-g", resourceGroup,
+		"--subscription", subscriptionId,
+		"--only-show-errors")
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make([]ImageTemplate, len(rawTemplates))
+	for i, raw := range rawTemplates {
+		var template azImageTemplate
+		if err := json.Unmarshal(raw, &template); err != nil {
+			return nil, errors.Wrap(err, "failed to parse Image Template")
+		}
+
+		templates[i] = ImageTemplate{
+			ID:      template.ID,
+			Name:    template.Name,
+			Status:  *template.status(),
+			Content: string(raw),
+		}
+		if template.SystemData != nil {
+			templates[i].CreatedAt = template.SystemData.CreatedAt
+		}
+		for _, distributor := range template.Distribute {
+			if distributor.GalleryImageId != "" {
+				templates[i].GalleryImageIDs = append(templates[i].GalleryImageIDs, distributor.GalleryImageId)
+			}
+		}
+	}
+
+	return templates, nil
+}
+
+func (a *AzCLIBackend) DeleteImageTemplate(ctx context.Context, subscriptionId, resourceGroup, name string) error {
+	cmd := exec.CommandContext(ctx, "az", "image", "builder", "delete",
+		"-n", name,
+		"-g", resourceGroup,
+		"--subscription", subscriptionId,
+		"--only-show-errors")
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (a *AzCLIBackend) DownloadCustomizationLog(ctx context.Context, subscriptionId, stagingResourceGroup, targetPath string) error {
+	accountNames, err := lib.ExecuteAsParseAsJSON[[]string](ctx, "az", "storage", "account", "list",
+		"-g", stagingResourceGroup,
+		"--subscription", subscriptionId,
+		"--query", "[].name",
+		"--only-show-errors")
+	if err != nil {
+		return errors.Wrap(err, "failed to list storage accounts in the staging resource group")
+	}
+
+	type azBlob struct {
+		Name         string    `json:"name"`
+		LastModified time.Time `json:"lastModified"`
+	}
+
+	for _, accountName := range accountNames {
+		// the Image Builder staging storage account often has no data plane role assignments, so the account key is used
+		blobs, err := lib.ExecuteAsParseAsJSON[[]azBlob](ctx, "az", "storage", "blob", "list",
+			"--account-name", accountName,
+			"-c", customizationLogContainer,
+			"--subscription", subscriptionId,
+			"--auth-mode", "key",
+			"--query", "[].{name:name, lastModified:properties.lastModified}",
+			"--only-show-errors")
+		if err != nil {
+			if azErrorCode(err) == "ContainerNotFound" {
+				continue
+			}
+			return errors.Wrapf(err, "failed to list logs in storage account %s", accountName)
+		}
+
+		var latest *azBlob
+		for i, blob := range blobs {
+			if strings.HasSuffix(blob.Name, customizationLogFilename) && (latest == nil || blob.LastModified.After(latest.LastModified)) {
+				latest = &blobs[i]
+			}
+		}
+		if latest == nil {
+			continue
+		}
+
+		downloadCmd := exec.CommandContext(ctx, "az", "storage", "blob", "download",
+			"--account-name", accountName,
+			"-c", customizationLogContainer,
+			"-n", latest.Name,
+			"-f", targetPath,
+			"--subscription", subscriptionId,
+			"--auth-mode", "key",
+			"--only-show-errors",
+			"-o", "none")
+		downloadCmd.Stderr = os.Stderr
+		return downloadCmd.Run()
+	}
+
+	return ErrCustomizationLogNotFound
+}
+
+var (
+	azErrorCodeLineRegex   = regexp.MustCompile(`(?m)^\s*(?:Code|ErrorCode):\s*(\w+)\s*$`)
+	azErrorCodePrefixRegex = regexp.MustCompile(`(?m)^ERROR: \((\w+)\)`)
+)
+
+// azErrorCode returns the error code that the Azure CLI reported on stderr, or an empty string if there is none.
+// depending on the command, the Azure CLI reports the error as a JSON error response,
+// as "ERROR: (<code>) <message>" with a "Code: <code>" line, or as an "ErrorCode:<code>" line for the storage data plane
+func azErrorCode(err error) string {
+	var execErr *lib.ExecError
+	if !errors.As(err, &execErr) {
+		return ""
+	}
+	stderr := execErr.Stderr
+
+	if start := strings.Index(stderr, "{"); start >= 0 {
+		var response struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(strings.NewReader(stderr[start:])).Decode(&response) == nil && response.Error.Code != "" {
+			return response.Error.Code
+		}
+	}
+
+	if match := azErrorCodeLineRegex.FindStringSubmatch(stderr); match != nil {
+		return match[1]
+	}
+	if match := azErrorCodePrefixRegex.FindStringSubmatch(stderr); match != nil {
+		return match[1]
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package lib_azure
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/schoolyear/avd-cli/lib"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_azErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "resource manager error",
+			err: &lib.ExecError{Stderr: "ERROR: (ResourceNotFound) The Resource 'Microsoft.Compute/galleries/gallery/images/win11/versions/1.0.0' under resource group 'rg' was not found.\n" +
+				"Code: ResourceNotFound\nMessage: The Resource 'Microsoft.Compute/galleries/gallery/images/win11/versions/1.0.0' under resource group 'rg' was not found.\n"},
+			want: "ResourceNotFound",
+		},
+		{
+			name: "resource manager error without code line",
+			err:  &lib.ExecError{Stderr: "ERROR: (AuthorizationFailed) The client does not have authorization\n"},
+			want: "AuthorizationFailed",
+		},
+		{
+			name: "json error response",
+			err:  &lib.ExecError{Stderr: "ERROR: Not Found({\"error\":{\"code\":\"ResourceGroupNotFound\",\"message\":\"Resource group 'rg' could not be found.\"}})\n"},
+			want: "ResourceGroupNotFound",
+		},
+		{
+			name: "storage error",
+			err:  &lib.ExecError{Stderr: "The specified container does not exist.\nRequestId:00000000-0000-0000-0000-000000000000\nTime:2026-01-01T00:00:00.0000000Z\nErrorCode:ContainerNotFound\n"},
+			want: "ContainerNotFound",
+		},
+		{
+			name: "message mentioning a code",
+			err:  &lib.ExecError{Stderr: "ERROR: (InvalidParameter) the name ResourceNotFound is reserved\nCode: InvalidParameter\n"},
+			want: "InvalidParameter",
+		},
+		{
+			name: "no code",
+			err:  &lib.ExecError{Stderr: "ERROR: Please run 'az login' to setup account.\n"},
+			want: "",
+		},
+		{
+			name: "other error",
+			err:  errors.New("ResourceNotFound"),
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, azErrorCode(tt.err))
+		})
+	}
+}
@@ -0,0 +1,313 @@
+// Package azuretest provides fakes of Azure for tests. It is only imported by tests, so it is not part of the binary
+package azuretest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+)
+
+// FakeBackend is an in-memory lib_azure.Backend
+type FakeBackend struct {
+	lock sync.Mutex
+
+	Subscriptions []lib_azure.Subscription
+	// ImageDefinitions per gallery, keyed by FakeGalleryKey
+	ImageDefinitions map[string][]lib_azure.ImageDefinition
+	// ImageVersions per image definition, keyed by FakeImageDefinitionKey
+	ImageVersions map[string][]lib_azure.ImageVersion
+	// Blobs keyed by FakeBlobKey
+	Blobs map[string][]byte
+	// BlobsLastModified keyed by FakeBlobKey, blobs without an entry have a zero time
+	BlobsLastModified map[string]time.Time
+	// Deployments keyed by FakeResourceKey
+	Deployments map[string][]byte
+	// ImageTemplates keyed by FakeResourceKey
+	ImageTemplates map[string]*lib_azure.ImageTemplateStatus
+	// ImageTemplateSummaries are returned by ListImageTemplates, keyed by FakeResourceKey
+	ImageTemplateSummaries map[string]lib_azure.ImageTemplate
+	// CustomizationLogs keyed by staging resource group
+	CustomizationLogs map[string][]byte
+}
+
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		ImageDefinitions:       map[string][]lib_azure.ImageDefinition{},
+		ImageVersions:          map[string][]lib_azure.ImageVersion{},
+		Blobs:                  map[string][]byte{},
+		BlobsLastModified:      map[string]time.Time{},
+		Deployments:            map[string][]byte{},
+		ImageTemplates:         map[string]*lib_azure.ImageTemplateStatus{},
+		ImageTemplateSummaries: map[string]lib_azure.ImageTemplate{},
+		CustomizationLogs:      map[string][]byte{},
+	}
+}
+
+func FakeGalleryKey(subscriptionId, resourceGroup, gallery string) string {
+	return fmt.Sprintf("%s/%s/%s", subscriptionId, resourceGroup, gallery)
+}
+
+func FakeImageDefinitionKey(subscriptionId, resourceGroup, gallery, imageDefinition string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", subscriptionId, resourceGroup, gallery, imageDefinition)
+}
+
+func FakeBlobKey(storageAccount, container, blobName string) string {
+	return fmt.Sprintf("%s/%s/%s", storageAccount, container, blobName)
+}
+
+// FakeResourceKey is the key of resources in a resource group, like deployments and image templates
+func FakeResourceKey(subscriptionId, resourceGroup, name string) string {
+	return fmt.Sprintf("%s/%s/%s", subscriptionId, resourceGroup, name)
+}
+
+func (f *FakeBackend) Name() string {
+	return "fake"
+}
+
+func (f *FakeBackend) GetSubscription(_ context.Context, subscriptionId string) (*lib_azure.Subscription, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, subscription := range f.Subscriptions {
+		if subscription.SubscriptionId == subscriptionId {
+			return &subscription, nil
+		}
+	}
+	return nil, lib_azure.ErrSubscriptionNotFound
+}
+
+func (f *FakeBackend) ListImageDefinitions(_ context.Context, subscriptionId, resourceGroup, gallery string) ([]lib_azure.ImageDefinition, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	definitions, ok := f.ImageDefinitions[FakeGalleryKey(subscriptionId, resourceGroup, gallery)]
+	if !ok {
+		return nil, fmt.Errorf("gallery %s not found", gallery)
+	}
+	return definitions, nil
+}
+
+func (f *FakeBackend) ListImageVersions(_ context.Context, subscriptionId, resourceGroup, gallery, imageDefinition string) ([]lib_azure.ImageVersion, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return slices.Clone(f.ImageVersions[FakeImageDefinitionKey(subscriptionId, resourceGroup, gallery, imageDefinition)]), nil
+}
+
+func (f *FakeBackend) GetImageVersion(_ context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string) (*lib_azure.ImageVersion, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, v := range f.ImageVersions[FakeImageDefinitionKey(subscriptionId, resourceGroup, gallery, imageDefinition)] {
+		if v.Name == version {
+			return &v, nil
+		}
+	}
+	return nil, lib_azure.ErrImageVersionNotFound
+}
+
+func (f *FakeBackend) DeleteImageVersion(_ context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := FakeImageDefinitionKey(subscriptionId, resourceGroup, gallery, imageDefinition)
+	versions := f.ImageVersions[key]
+	for i, existing := range versions {
+		if existing.Name == version {
+			f.ImageVersions[key] = append(versions[:i:i], versions[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("image version %s not found", version)
+}
+
+func (f *FakeBackend) UpdateImageVersion(_ context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string, update lib_azure.ImageVersionUpdate) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	versions := f.ImageVersions[FakeImageDefinitionKey(subscriptionId, resourceGroup, gallery, imageDefinition)]
+	for i := range versions {
+		if versions[i].Name == version {
+			versions[i].ExcludeFromLatest = update.ExcludeFromLatest
+			versions[i].Tags = update.Tags
+			return nil
+		}
+	}
+	return fmt.Errorf("image version %s not found", version)
+}
+
+func (f *FakeBackend) BlobExists(_ context.Context, storageAccount, container, blobName string) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, ok := f.Blobs[FakeBlobKey(storageAccount, container, blobName)]
+	return ok, nil
+}
+
+func (f *FakeBackend) UploadBlob(_ context.Context, storageAccount, container, blobName, filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", filePath)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := FakeBlobKey(storageAccount, container, blobName)
+	f.Blobs[key] = data
+	f.BlobsLastModified[key] = time.Now()
+	return nil
+}
+
+func (f *FakeBackend) TouchBlob(_ context.Context, storageAccount, container, blobName string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := FakeBlobKey(storageAccount, container, blobName)
+	if _, ok := f.Blobs[key]; !ok {
+		return fmt.Errorf("blob %s not found", blobName)
+	}
+	f.BlobsLastModified[key] = time.Now()
+	return nil
+}
+
+func (f *FakeBackend) ListBlobs(_ context.Context, storageAccount, container, prefix string) ([]lib_azure.Blob, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var blobs []lib_azure.Blob
+	keyPrefix := FakeBlobKey(storageAccount, container, prefix)
+	for key, data := range f.Blobs {
+		if strings.HasPrefix(key, keyPrefix) {
+			blobs = append(blobs, lib_azure.Blob{
+				Name:         strings.TrimPrefix(key, FakeBlobKey(storageAccount, container, "")),
+				LastModified: f.BlobsLastModified[key],
+				Size:         int64(len(data)),
+			})
+		}
+	}
+	return blobs, nil
+}
+
+func (f *FakeBackend) DeleteBlob(_ context.Context, storageAccount, container, blobName string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := FakeBlobKey(storageAccount, container, blobName)
+	if _, ok := f.Blobs[key]; !ok {
+		return fmt.Errorf("blob %s not found", blobName)
+	}
+	delete(f.Blobs, key)
+	return nil
+}
+
+func (f *FakeBackend) DownloadBlob(_ context.Context, storageAccount, container, blobName, targetPath string) error {
+	f.lock.Lock()
+	data, ok := f.Blobs[FakeBlobKey(storageAccount, container, blobName)]
+	f.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("blob %s not found", blobName)
+	}
+
+	return os.WriteFile(targetPath, data, 0644)
+}
+
+func (f *FakeBackend) GetBlobSize(_ context.Context, storageAccount, container, blobName string) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	data, ok := f.Blobs[FakeBlobKey(storageAccount, container, blobName)]
+	if !ok {
+		return 0, fmt.Errorf("blob %s not found", blobName)
+	}
+	return int64(len(data)), nil
+}
+
+func (f *FakeBackend) ReadBlobRange(_ context.Context, storageAccount, container, blobName string, offset, count int64) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	data, ok := f.Blobs[FakeBlobKey(storageAccount, container, blobName)]
+	if !ok {
+		return nil, fmt.Errorf("blob %s not found", blobName)
+	}
+	if offset > int64(len(data)) {
+		return nil, fmt.Errorf("range %d-%d is outside of blob %s", offset, offset+count, blobName)
+	}
+	return slices.Clone(data[offset:min(offset+count, int64(len(data)))]), nil
+}
+
+func (f *FakeBackend) DeployTemplate(_ context.Context, subscriptionId, resourceGroup, deploymentName string, template []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.Deployments[FakeResourceKey(subscriptionId, resourceGroup, deploymentName)] = template
+	return nil
+}
+
+func (f *FakeBackend) GetImageTemplateStatus(_ context.Context, subscriptionId, resourceGroup, name string) (*lib_azure.ImageTemplateStatus, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	status, ok := f.ImageTemplates[FakeResourceKey(subscriptionId, resourceGroup, name)]
+	if !ok {
+		return nil, lib_azure.ErrImageTemplateNotFound
+	}
+
+	statusCopy := *status
+	return &statusCopy, nil
+}
+
+func (f *FakeBackend) DownloadCustomizationLog(_ context.Context, _, stagingResourceGroup, targetPath string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	log, ok := f.CustomizationLogs[stagingResourceGroup]
+	if !ok {
+		return lib_azure.ErrCustomizationLogNotFound
+	}
+
+	return os.WriteFile(targetPath, log, 0644)
+}
+
+func (f *FakeBackend) ListImageTemplates(_ context.Context, subscriptionId, resourceGroup string) ([]lib_azure.ImageTemplate, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var templates []lib_azure.ImageTemplate
+	keyPrefix := FakeResourceKey(subscriptionId, resourceGroup, "")
+	for key, template := range f.ImageTemplateSummaries {
+		if strings.HasPrefix(key, keyPrefix) {
+			templates = append(templates, template)
+		}
+	}
+	return templates, nil
+}
+
+func (f *FakeBackend) DeleteImageTemplate(_ context.Context, subscriptionId, resourceGroup, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := FakeResourceKey(subscriptionId, resourceGroup, name)
+	if _, ok := f.ImageTemplateSummaries[key]; !ok {
+		return lib_azure.ErrImageTemplateNotFound
+	}
+	delete(f.ImageTemplateSummaries, key)
+	delete(f.ImageTemplates, key)
+	return nil
+}
+
+// UpdateImageTemplate changes the status of an Image Template while the fake is in use
+func (f *FakeBackend) UpdateImageTemplate(key string, update func(status *lib_azure.ImageTemplateStatus)) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	update(f.ImageTemplates[key])
+}
@@ -0,0 +1,426 @@
+package azuretest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	azfake "github.com/Azure/azure-sdk-for-go/sdk/azcore/fake"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	computefake "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5/fake"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	resourcesfake "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources/fake"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	storagefake "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage/fake"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+)
+
+// Credential returns the token that FakeAzure accepts
+type Credential struct{}
+
+func (Credential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "fake-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// FakeAzure holds the state behind the fake Azure APIs.
+// Resource manager calls of the typed clients are served by the fake servers of the Azure SDK,
+// subscription lookups and the blob data plane by an HTTP server
+type FakeAzure struct {
+	lock sync.Mutex
+	// Subscriptions keyed by subscription id
+	Subscriptions map[string]lib_azure.Subscription
+	// ImageDefinitions keyed by gallery name, in listing order
+	ImageDefinitions map[string][]armcompute.GalleryImage
+	// ImageVersions keyed by FakeImageVersionKey
+	ImageVersions map[string]armcompute.GalleryImageVersion
+	// StorageAccountKeys keyed by resource group, then storage account name
+	StorageAccountKeys map[string]map[string]string
+	// Blobs keyed by path: /<account>/<container>/<blob>
+	Blobs map[string][]byte
+	// BlobMetadata keyed by blob path, as set with Set Blob Metadata
+	BlobMetadata map[string]map[string]string
+	// DownloadedBytes counts the bytes served per blob path
+	DownloadedBytes map[string]int
+	// Deployments templates keyed by deployment name
+	Deployments map[string]json.RawMessage
+}
+
+func NewFakeAzure() *FakeAzure {
+	return &FakeAzure{
+		Subscriptions:      map[string]lib_azure.Subscription{},
+		ImageDefinitions:   map[string][]armcompute.GalleryImage{},
+		ImageVersions:      map[string]armcompute.GalleryImageVersion{},
+		StorageAccountKeys: map[string]map[string]string{},
+		Blobs:              map[string][]byte{},
+		BlobMetadata:       map[string]map[string]string{},
+		DownloadedBytes:    map[string]int{},
+		Deployments:        map[string]json.RawMessage{},
+	}
+}
+
+// FakeImageVersionKey is the key of an image version in FakeAzure.ImageVersions
+func FakeImageVersionKey(gallery, imageDefinition, version string) string {
+	return gallery + "/" + imageDefinition + "/" + version
+}
+
+// NewSDKBackend starts a server for the fake and returns an SDK backend that talks to it.
+// the server must be closed after use
+func NewSDKBackend(fake *FakeAzure) (*lib_azure.SDKBackend, *httptest.Server, error) {
+	server := httptest.NewServer(fake)
+
+	backend, err := lib_azure.NewSDKBackend(Credential{}, &lib_azure.SDKBackendOptions{
+		ClientOptions: policy.ClientOptions{
+			Cloud: cloud.Configuration{
+				Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+					cloud.ResourceManager: {Endpoint: server.URL, Audience: "https://management.core.windows.net/"},
+				},
+			},
+			Transport:                       fake.transport(server.Client()),
+			InsecureAllowCredentialWithHTTP: true,
+			Retry:                           policy.RetryOptions{MaxRetries: -1},
+		},
+		BlobServiceURL: func(storageAccount string) string {
+			return server.URL + "/" + storageAccount + "/"
+		},
+	})
+	if err != nil {
+		server.Close()
+		return nil, nil, err
+	}
+	return backend, server, nil
+}
+
+// fakeTransport dispatches resource manager requests to the fake server of the resource provider
+type fakeTransport struct {
+	providers map[string]policy.Transporter
+	fallback  policy.Transporter
+}
+
+func (t *fakeTransport) Do(req *http.Request) (*http.Response, error) {
+	for provider, transport := range t.providers {
+		if strings.Contains(req.URL.Path, "/providers/"+provider+"/") {
+			return transport.Do(req)
+		}
+	}
+	return t.fallback.Do(req)
+}
+
+func (f *FakeAzure) transport(fallback policy.Transporter) policy.Transporter {
+	return &fakeTransport{
+		providers: map[string]policy.Transporter{
+			"Microsoft.Compute": computefake.NewServerFactoryTransport(&computefake.ServerFactory{
+				GalleryImagesServer:        f.galleryImagesServer(),
+				GalleryImageVersionsServer: f.galleryImageVersionsServer(),
+			}),
+			"Microsoft.Resources": resourcesfake.NewServerFactoryTransport(&resourcesfake.ServerFactory{
+				DeploymentsServer: f.deploymentsServer(),
+			}),
+			"Microsoft.Storage": storagefake.NewServerFactoryTransport(&storagefake.ServerFactory{
+				AccountsServer: f.accountsServer(),
+			}),
+		},
+		fallback: fallback,
+	}
+}
+
+func (f *FakeAzure) galleryImagesServer() computefake.GalleryImagesServer {
+	return computefake.GalleryImagesServer{
+		NewListByGalleryPager: func(resourceGroupName string, galleryName string, options *armcompute.GalleryImagesClientListByGalleryOptions) (resp azfake.PagerResponder[armcompute.GalleryImagesClientListByGalleryResponse]) {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+
+			// one definition per page, so callers have to follow the next links
+			for _, definition := range f.ImageDefinitions[galleryName] {
+				resp.AddPage(http.StatusOK, armcompute.GalleryImagesClientListByGalleryResponse{
+					GalleryImageList: armcompute.GalleryImageList{Value: []*armcompute.GalleryImage{to.Ptr(definition)}},
+				}, nil)
+			}
+			return resp
+		},
+	}
+}
+
+func (f *FakeAzure) galleryImageVersionsServer() computefake.GalleryImageVersionsServer {
+	return computefake.GalleryImageVersionsServer{
+		NewListByGalleryImagePager: func(resourceGroupName string, galleryName string, galleryImageName string, options *armcompute.GalleryImageVersionsClientListByGalleryImageOptions) (resp azfake.PagerResponder[armcompute.GalleryImageVersionsClientListByGalleryImageResponse]) {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+
+			prefix := FakeImageVersionKey(galleryName, galleryImageName, "")
+			var keys []string
+			for key := range f.ImageVersions {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+
+			var page armcompute.GalleryImageVersionList
+			for _, key := range keys {
+				// the list API does not support expanding the replication status
+				version := f.ImageVersions[key]
+				if version.Properties != nil {
+					properties := *version.Properties
+					properties.ReplicationStatus = nil
+					version.Properties = &properties
+				}
+				page.Value = append(page.Value, &version)
+			}
+			resp.AddPage(http.StatusOK, armcompute.GalleryImageVersionsClientListByGalleryImageResponse{GalleryImageVersionList: page}, nil)
+			return resp
+		},
+		Get: func(ctx context.Context, resourceGroupName string, galleryName string, galleryImageName string, galleryImageVersionName string, options *armcompute.GalleryImageVersionsClientGetOptions) (resp azfake.Responder[armcompute.GalleryImageVersionsClientGetResponse], errResp azfake.ErrorResponder) {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+
+			version, ok := f.ImageVersions[FakeImageVersionKey(galleryName, galleryImageName, galleryImageVersionName)]
+			if !ok {
+				errResp.SetResponseError(http.StatusNotFound, "ResourceNotFound")
+				return
+			}
+			if version.Properties != nil && (options == nil || options.Expand == nil || *options.Expand != armcompute.ReplicationStatusTypesReplicationStatus) {
+				properties := *version.Properties
+				properties.ReplicationStatus = nil
+				version.Properties = &properties
+			}
+			resp.SetResponse(http.StatusOK, armcompute.GalleryImageVersionsClientGetResponse{GalleryImageVersion: version}, nil)
+			return
+		},
+		BeginDelete: func(ctx context.Context, resourceGroupName string, galleryName string, galleryImageName string, galleryImageVersionName string, options *armcompute.GalleryImageVersionsClientBeginDeleteOptions) (resp azfake.PollerResponder[armcompute.GalleryImageVersionsClientDeleteResponse], errResp azfake.ErrorResponder) {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+
+			key := FakeImageVersionKey(galleryName, galleryImageName, galleryImageVersionName)
+			if _, ok := f.ImageVersions[key]; !ok {
+				errResp.SetResponseError(http.StatusNotFound, "ResourceNotFound")
+				return
+			}
+			delete(f.ImageVersions, key)
+			resp.SetTerminalResponse(http.StatusOK, armcompute.GalleryImageVersionsClientDeleteResponse{}, nil)
+			return
+		},
+		BeginUpdate: func(ctx context.Context, resourceGroupName string, galleryName string, galleryImageName string, galleryImageVersionName string, update armcompute.GalleryImageVersionUpdate, options *armcompute.GalleryImageVersionsClientBeginUpdateOptions) (resp azfake.PollerResponder[armcompute.GalleryImageVersionsClientUpdateResponse], errResp azfake.ErrorResponder) {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+
+			key := FakeImageVersionKey(galleryName, galleryImageName, galleryImageVersionName)
+			version, ok := f.ImageVersions[key]
+			if !ok {
+				errResp.SetResponseError(http.StatusNotFound, "ResourceNotFound")
+				return
+			}
+			if update.Tags != nil {
+				version.Tags = update.Tags
+			}
+			if update.Properties != nil && update.Properties.PublishingProfile != nil && update.Properties.PublishingProfile.ExcludeFromLatest != nil {
+				properties := armcompute.GalleryImageVersionProperties{}
+				if version.Properties != nil {
+					properties = *version.Properties
+				}
+				publishingProfile := armcompute.GalleryImageVersionPublishingProfile{}
+				if properties.PublishingProfile != nil {
+					publishingProfile = *properties.PublishingProfile
+				}
+				publishingProfile.ExcludeFromLatest = update.Properties.PublishingProfile.ExcludeFromLatest
+				properties.PublishingProfile = &publishingProfile
+				version.Properties = &properties
+			}
+			f.ImageVersions[key] = version
+			resp.SetTerminalResponse(http.StatusOK, armcompute.GalleryImageVersionsClientUpdateResponse{GalleryImageVersion: version}, nil)
+			return
+		},
+	}
+}
+
+func (f *FakeAzure) deploymentsServer() resourcesfake.DeploymentsServer {
+	return resourcesfake.DeploymentsServer{
+		BeginCreateOrUpdate: func(ctx context.Context, resourceGroupName string, deploymentName string, parameters armresources.Deployment, options *armresources.DeploymentsClientBeginCreateOrUpdateOptions) (resp azfake.PollerResponder[armresources.DeploymentsClientCreateOrUpdateResponse], errResp azfake.ErrorResponder) {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+
+			if parameters.Properties == nil || parameters.Properties.Template == nil {
+				errResp.SetResponseError(http.StatusBadRequest, "InvalidTemplate")
+				return
+			}
+			template, err := json.Marshal(parameters.Properties.Template)
+			if err != nil {
+				errResp.SetError(err)
+				return
+			}
+			f.Deployments[deploymentName] = template
+
+			resp.SetTerminalResponse(http.StatusOK, armresources.DeploymentsClientCreateOrUpdateResponse{
+				DeploymentExtended: armresources.DeploymentExtended{
+					Name: to.Ptr(deploymentName),
+					Properties: &armresources.DeploymentPropertiesExtended{
+						ProvisioningState: to.Ptr(armresources.ProvisioningStateSucceeded),
+					},
+				},
+			}, nil)
+			return
+		},
+	}
+}
+
+func (f *FakeAzure) accountsServer() storagefake.AccountsServer {
+	return storagefake.AccountsServer{
+		NewListByResourceGroupPager: func(resourceGroupName string, options *armstorage.AccountsClientListByResourceGroupOptions) (resp azfake.PagerResponder[armstorage.AccountsClientListByResourceGroupResponse]) {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+
+			var names []string
+			for name := range f.StorageAccountKeys[resourceGroupName] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			var page armstorage.AccountListResult
+			for _, name := range names {
+				page.Value = append(page.Value, &armstorage.Account{Name: to.Ptr(name)})
+			}
+			resp.AddPage(http.StatusOK, armstorage.AccountsClientListByResourceGroupResponse{AccountListResult: page}, nil)
+			return resp
+		},
+		ListKeys: func(ctx context.Context, resourceGroupName string, accountName string, options *armstorage.AccountsClientListKeysOptions) (resp azfake.Responder[armstorage.AccountsClientListKeysResponse], errResp azfake.ErrorResponder) {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+
+			key, ok := f.StorageAccountKeys[resourceGroupName][accountName]
+			if !ok {
+				errResp.SetResponseError(http.StatusNotFound, "StorageAccountNotFound")
+				return
+			}
+			resp.SetResponse(http.StatusOK, armstorage.AccountsClientListKeysResponse{
+				AccountListKeysResult: armstorage.AccountListKeysResult{
+					Keys: []*armstorage.AccountKey{{KeyName: to.Ptr("key1"), Value: to.Ptr(key)}},
+				},
+			}, nil)
+			return
+		},
+	}
+}
+
+// ServeHTTP serves the subscriptions API and the blob service
+func (f *FakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer fake-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if subscriptionId, ok := strings.CutPrefix(r.URL.Path, "/subscriptions/"); ok {
+		subscription, ok := f.Subscriptions[subscriptionId]
+		if !ok || r.Method != http.MethodGet {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": map[string]any{"code": "SubscriptionNotFound"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"subscriptionId": subscription.SubscriptionId,
+			"displayName":    subscription.Name,
+			"tenantId":       subscription.TenantId,
+		})
+		return
+	}
+
+	// blob service: /<account>/<container>/<blob>
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		data, ok := f.Blobs[r.URL.Path]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		status := http.StatusOK
+		if r.Method == http.MethodGet {
+			blobRange := r.Header.Get("x-ms-range")
+			if blobRange == "" {
+				blobRange = r.Header.Get("Range")
+			}
+			if blobRange != "" {
+				start, end, ok := parseBlobRange(blobRange, len(data))
+				if !ok {
+					w.Header().Set("x-ms-error-code", "InvalidRange")
+					w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+					return
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(data)))
+				data = data[start:end]
+				status = http.StatusPartialContent
+			}
+			f.DownloadedBytes[r.URL.Path] += len(data)
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodPut:
+		if r.URL.Query().Get("comp") == "metadata" {
+			if _, ok := f.Blobs[r.URL.Path]; !ok {
+				w.Header().Set("x-ms-error-code", "BlobNotFound")
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			metadata := map[string]string{}
+			for name := range r.Header {
+				if key, ok := strings.CutPrefix(strings.ToLower(name), "x-ms-meta-"); ok {
+					metadata[key] = r.Header.Get(name)
+				}
+			}
+			f.BlobMetadata[r.URL.Path] = metadata
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		f.Blobs[r.URL.Path] = data
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// parseBlobRange parses a range header like "bytes=0-99" into a half-open interval
+func parseBlobRange(value string, size int) (start, end int, ok bool) {
+	startValue, endValue, found := strings.Cut(strings.TrimPrefix(value, "bytes="), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	start, err := strconv.Atoi(startValue)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end = size
+	if endValue != "" {
+		last, err := strconv.Atoi(endValue)
+		if err != nil || last < start {
+			return 0, 0, false
+		}
+		end = min(last+1, size)
+	}
+	return start, end, true
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,147 @@
+package lib_azure
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/friendsofgo/errors"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrImageVersionNotFound = errors.New("image version not found")
+)
+
+// Backend performs the Azure operations of the CLI
+type Backend interface {
+	Name() string
+
+	// GetSubscription returns ErrSubscriptionNotFound if the subscription does not exist or is not accessible
+	GetSubscription(ctx context.Context, subscriptionId string) (*Subscription, error)
+	ListImageDefinitions(ctx context.Context, subscriptionId, resourceGroup, gallery string) ([]ImageDefinition, error)
+	ListImageVersions(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition string) ([]ImageVersion, error)
+	// GetImageVersion includes the replication state of the version
+	// returns ErrImageVersionNotFound if the version does not exist
+	GetImageVersion(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string) (*ImageVersion, error)
+
+	// DeleteImageVersion waits until the version is deleted
+	DeleteImageVersion(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string) error
+	// UpdateImageVersion waits until the version is updated
+	UpdateImageVersion(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string, update ImageVersionUpdate) error
+
+	BlobExists(ctx context.Context, storageAccount, container, blobName string) (bool, error)
+	UploadBlob(ctx context.Context, storageAccount, container, blobName, filePath string) error
+	// TouchBlob updates the last modified time of the blob without changing its content
+	TouchBlob(ctx context.Context, storageAccount, container, blobName string) error
+	ListBlobs(ctx context.Context, storageAccount, container, prefix string) ([]Blob, error)
+	DeleteBlob(ctx context.Context, storageAccount, container, blobName string) error
+	DownloadBlob(ctx context.Context, storageAccount, container, blobName, targetPath string) error
+	GetBlobSize(ctx context.Context, storageAccount, container, blobName string) (int64, error)
+	// ReadBlobRange reads count bytes starting at offset, without downloading the rest of the blob
+	ReadBlobRange(ctx context.Context, storageAccount, container, blobName string, offset, count int64) ([]byte, error)
+
+	// DeployTemplate deploys the ARM template to the resource group and waits until the deployment finished
+	DeployTemplate(ctx context.Context, subscriptionId, resourceGroup, deploymentName string, template []byte) error
+
+	// GetImageTemplateStatus returns ErrImageTemplateNotFound if the template does not exist
+	GetImageTemplateStatus(ctx context.Context, subscriptionId, resourceGroup, name string) (*ImageTemplateStatus, error)
+	ListImageTemplates(ctx context.Context, subscriptionId, resourceGroup string) ([]ImageTemplate, error)
+	// DeleteImageTemplate waits until the template is deleted
+	DeleteImageTemplate(ctx context.Context, subscriptionId, resourceGroup, name string) error
+	// DownloadCustomizationLog downloads the most recent customization.log from the staging resource group of an Image Template
+	// returns ErrCustomizationLogNotFound if no log has been written (yet)
+	DownloadCustomizationLog(ctx context.Context, subscriptionId, stagingResourceGroup, targetPath string) error
+}
+
+type Subscription struct {
+	SubscriptionId string
+	Name           string
+	TenantId       string
+}
+
+type ImageDefinition struct {
+	ID       string
+	Name     string
+	Location string
+	Tags     map[string]string
+}
+
+// touchMetadataKey is the blob metadata that TouchBlob sets to the current time
+const touchMetadataKey = "avdcli_last_used"
+
+type Blob struct {
+	Name         string
+	LastModified time.Time
+	Size         int64
+}
+
+// BlobURL is the URL of a blob in the public Azure cloud
+func BlobURL(storageAccount, container, blobName string) string {
+	return fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", storageAccount, container, blobName)
+}
+
+// ParseBlobURL is the inverse of BlobURL
+func ParseBlobURL(blobURL string) (storageAccount, container, blobName string, err error) {
+	parsed, err := url.Parse(blobURL)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid blob URL %s: %w", blobURL, err)
+	}
+
+	storageAccount, found := strings.CutSuffix(parsed.Hostname(), ".blob.core.windows.net")
+	if !found || storageAccount == "" {
+		return "", "", "", fmt.Errorf("invalid blob URL %s: not a blob in the public Azure cloud", blobURL)
+	}
+
+	container, blobName, found = strings.Cut(strings.TrimPrefix(parsed.Path, "/"), "/")
+	if !found || container == "" || blobName == "" {
+		return "", "", "", fmt.Errorf("invalid blob URL %s: expected a container and blob name", blobURL)
+	}
+
+	return storageAccount, container, blobName, nil
+}
+
+type BackendKind string
+
+const (
+	BackendKindSDK   BackendKind = "sdk"
+	BackendKindAzCLI BackendKind = "az"
+)
+
+var BackendKinds = []BackendKind{BackendKindSDK, BackendKindAzCLI}
+
+func ParseBackendKind(value string) (BackendKind, error) {
+	for _, kind := range BackendKinds {
+		if string(kind) == value {
+			return kind, nil
+		}
+	}
+
+	names := make([]string, len(BackendKinds))
+	for i, kind := range BackendKinds {
+		names[i] = string(kind)
+	}
+	return "", fmt.Errorf("unknown Azure backend %s. available: %s", value, strings.Join(names, ", "))
+}
+
+// NewBackend creates the backend of the given kind.
+// the SDK backend authenticates using the default Azure credential chain (environment, managed identity, Azure CLI, ...)
+func NewBackend(kind BackendKind, tenantId string) (Backend, error) {
+	switch kind {
+	case BackendKindSDK:
+		cred, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			TenantID: tenantId,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get default Azure Credentials")
+		}
+		return NewSDKBackend(cred, nil)
+	case BackendKindAzCLI:
+		return NewAzCLIBackend(tenantId)
+	default:
+		return nil, fmt.Errorf("unknown Azure backend %s", kind)
+	}
+}
@@ -0,0 +1,80 @@
+package lib_azure
+
+import (
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+var (
+	ErrImageTemplateNotFound    = errors.New("image template not found")
+	ErrCustomizationLogNotFound = errors.New("customization log not found")
+)
+
+// the Image Builder writes its logs to this container in the storage account of the staging resource group
+const (
+	customizationLogContainer = "packerlogs"
+	customizationLogFilename  = "customization.log"
+)
+
+// ImageTemplate is a summary of an existing Image Template
+type ImageTemplate struct {
+	ID        string
+	Name      string
+	CreatedAt *time.Time // nil if Azure did not report it
+	Status    ImageTemplateStatus
+	// GalleryImageIDs are the targets of the gallery distributors (image definitions or versions)
+	GalleryImageIDs []string
+	// Content is the JSON definition of the template, used to find the blobs that it references
+	Content string
+}
+
+// References returns whether the definition of the template contains the URL
+func (t ImageTemplate) References(url string) bool {
+	return strings.Contains(t.Content, url)
+}
+
+// ImageTemplateStatus is the state of an Image Template and its last run
+type ImageTemplateStatus struct {
+	ID                   string
+	ProvisioningState    string
+	ProvisioningError    string
+	StagingResourceGroup string
+
+	// empty if the template has never run
+	RunState    string
+	RunSubState string
+	RunMessage  string
+	StartTime   *time.Time
+	EndTime     *time.Time
+}
+
+func (s ImageTemplateStatus) HasRun() bool {
+	return s.RunState != ""
+}
+
+// RunFinished returns true if the last run is in a terminal state
+func (s ImageTemplateStatus) RunFinished() bool {
+	switch s.RunState {
+	case "Succeeded", "PartiallySucceeded", "Failed", "Canceled":
+		return true
+	default:
+		return false
+	}
+}
+
+func (s ImageTemplateStatus) RunFailed() bool {
+	return s.RunState == "Failed" || s.RunState == "Canceled"
+}
+
+// RunDuration returns how long the last run took, or is running so far
+func (s ImageTemplateStatus) RunDuration(now time.Time) time.Duration {
+	if s.StartTime == nil {
+		return 0
+	}
+	if s.EndTime != nil && !s.EndTime.Before(*s.StartTime) {
+		return s.EndTime.Sub(*s.StartTime)
+	}
+	return now.Sub(*s.StartTime)
+}
@@ -0,0 +1,90 @@
+package lib_azure
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImageVersion is a version of an image definition in a Compute Gallery
+type ImageVersion struct {
+	ID                string
+	Name              string // the version number, like 1.4.0
+	Location          string
+	ProvisioningState string
+	PublishedDate     *time.Time
+	EndOfLifeDate     *time.Time
+	ExcludeFromLatest bool
+	TargetRegions     []string
+	// ReplicationState is the aggregated replication state, like Completed or InProgress.
+	// only set by GetImageVersion
+	ReplicationState string
+	Tags             map[string]string
+}
+
+// ImageVersionUpdate changes whether an image version can be the latest version, and its tags
+type ImageVersionUpdate struct {
+	ExcludeFromLatest bool
+	Tags              map[string]string // replaces all tags of the version
+}
+
+// GalleryVersion is a version number of a gallery image version.
+// galleries require versions in the form major.minor.patch, where each part is a 32-bit integer
+type GalleryVersion struct {
+	Major, Minor, Patch int32
+}
+
+func ParseGalleryVersion(value string) (GalleryVersion, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return GalleryVersion{}, fmt.Errorf("invalid image version %s: expected the format major.minor.patch", value)
+	}
+
+	var numbers [3]int32
+	for i, part := range parts {
+		number, err := strconv.ParseInt(part, 10, 32)
+		if err != nil || number < 0 {
+			return GalleryVersion{}, fmt.Errorf("invalid image version %s: %q is not a positive 32-bit number", value, part)
+		}
+		numbers[i] = int32(number)
+	}
+
+	return GalleryVersion{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+func (v GalleryVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or 1 if v is smaller than, equal to or greater than other
+func (v GalleryVersion) Compare(other GalleryVersion) int {
+	if c := cmp.Compare(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	return cmp.Compare(v.Patch, other.Patch)
+}
+
+// LatestGalleryVersion returns the highest version number of the image versions
+// versions with an invalid number are ignored. returns false if there are none
+func LatestGalleryVersion(versions []ImageVersion) (GalleryVersion, bool) {
+	var (
+		latest GalleryVersion
+		found  bool
+	)
+	for _, version := range versions {
+		parsed, err := ParseGalleryVersion(version.Name)
+		if err != nil {
+			continue
+		}
+		if !found || parsed.Compare(latest) > 0 {
+			latest = parsed
+			found = true
+		}
+	}
+	return latest, found
+}
@@ -0,0 +1,43 @@
+package lib_azure
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// knownRegions are the names of the public Azure regions.
+// sovereign clouds and regions that were added later are missing, so an unknown region is not necessarily invalid
+var knownRegions = []string{
+	"australiacentral", "australiacentral2", "australiaeast", "australiasoutheast", "austriaeast",
+	"belgiumcentral", "brazilsouth", "brazilsoutheast",
+	"canadacentral", "canadaeast", "centralindia", "centralus", "centraluseuap", "chilecentral",
+	"denmarkeast",
+	"eastasia", "eastus", "eastus2", "eastus2euap",
+	"francecentral", "francesouth",
+	"germanynorth", "germanywestcentral",
+	"indonesiacentral", "israelcentral", "italynorth",
+	"japaneast", "japanwest", "jioindiacentral", "jioindiawest",
+	"koreacentral", "koreasouth",
+	"malaysiawest", "mexicocentral",
+	"newzealandnorth", "northcentralus", "northeurope", "norwayeast", "norwaywest",
+	"polandcentral",
+	"qatarcentral",
+	"southafricanorth", "southafricawest", "southcentralus", "southeastasia", "southindia", "spaincentral", "swedencentral", "swedensouth", "switzerlandnorth", "switzerlandwest",
+	"uaecentral", "uaenorth", "uksouth", "ukwest",
+	"westcentralus", "westeurope", "westindia", "westus", "westus2", "westus3",
+}
+
+var regionNameRegex = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// NormalizeRegion returns the name of the region as Azure uses it in APIs, so "West Europe" becomes "westeurope".
+// returns false if the region is not a known public Azure region
+func NormalizeRegion(region string) (string, bool) {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(region), " ", ""))
+	return normalized, slices.Contains(knownRegions, normalized)
+}
+
+// IsValidRegionName returns whether the normalized name has the form of an Azure region name, whether it is known or not
+func IsValidRegionName(normalized string) bool {
+	return regionNameRegex.MatchString(normalized)
+}
@@ -0,0 +1,32 @@
+package lib_azure
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+var guidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ParseResourceIDOfType parses a resource id in a resource group, and checks that it is of the expected type,
+// like Microsoft.ManagedIdentity/userAssignedIdentities
+func ParseResourceIDOfType(id, resourceType string) (*arm.ResourceID, error) {
+	parsed, err := arm.ParseResourceID(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource id %q: %w", id, err)
+	}
+
+	if !strings.EqualFold(parsed.ResourceType.String(), resourceType) {
+		return nil, fmt.Errorf("resource id %q is of type %s, expected %s", id, parsed.ResourceType.String(), resourceType)
+	}
+	if !guidRegex.MatchString(parsed.SubscriptionID) {
+		return nil, fmt.Errorf("resource id %q does not contain a valid subscription id", id)
+	}
+	if parsed.ResourceGroupName == "" {
+		return nil, fmt.Errorf("resource id %q does not contain a resource group", id)
+	}
+
+	return parsed, nil
+}
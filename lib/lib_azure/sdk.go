@@ -0,0 +1,489 @@
+package lib_azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/friendsofgo/errors"
+	"github.com/schollz/progressbar/v3"
+	"github.com/schoolyear/avd-cli/static"
+)
+
+const subscriptionsApiVersion = "2022-12-01"
+
+// deployments are polled at this interval until they finished
+const deploymentPollFrequency = 10 * time.Second
+
+type SDKBackendOptions struct {
+	// ClientOptions are used for all Azure clients. Set Cloud to use another cloud (or a local fake)
+	ClientOptions policy.ClientOptions
+
+	// BlobServiceURL returns the blob service URL of the storage account. Defaults to the public Azure cloud
+	BlobServiceURL func(storageAccount string) string
+}
+
+// SDKBackend talks to the Azure APIs directly, so the Azure CLI does not have to be installed
+type SDKBackend struct {
+	cred           azcore.TokenCredential
+	clientOptions  policy.ClientOptions
+	blobServiceURL func(storageAccount string) string
+
+	armEndpoint string
+	armPipeline runtime.Pipeline
+}
+
+func NewSDKBackend(cred azcore.TokenCredential, options *SDKBackendOptions) (*SDKBackend, error) {
+	if options == nil {
+		options = &SDKBackendOptions{}
+	}
+
+	blobServiceURL := options.BlobServiceURL
+	if blobServiceURL == nil {
+		blobServiceURL = func(storageAccount string) string {
+			return fmt.Sprintf("https://%s.blob.core.windows.net/", storageAccount)
+		}
+	}
+
+	armClient, err := arm.NewClient("avdcli", static.Version, cred, &arm.ClientOptions{
+		ClientOptions: options.ClientOptions,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize Azure SDK")
+	}
+
+	return &SDKBackend{
+		cred:           cred,
+		clientOptions:  options.ClientOptions,
+		blobServiceURL: blobServiceURL,
+		armEndpoint:    armClient.Endpoint(),
+		armPipeline:    armClient.Pipeline(),
+	}, nil
+}
+
+func (s *SDKBackend) Name() string {
+	return "Azure SDK"
+}
+
+func (s *SDKBackend) GetSubscription(ctx context.Context, subscriptionId string) (*Subscription, error) {
+	// the typed subscriptions client (armsubscriptions) is not a dependency, so the subscription is requested through the ARM pipeline
+	req, err := runtime.NewRequest(ctx, http.MethodGet, runtime.JoinPaths(s.armEndpoint, "/subscriptions/"+url.PathEscape(subscriptionId)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", subscriptionsApiVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header.Set("Accept", "application/json")
+
+	resp, err := s.armPipeline.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get subscription. Make sure you are logged in, for example by running 'az login' or by setting the AZURE_* environment variables")
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		return nil, ErrSubscriptionNotFound
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return nil, errors.Wrap(runtime.NewResponseError(resp), "failed to get subscription")
+	}
+
+	var res struct {
+		SubscriptionId string `json:"subscriptionId"`
+		DisplayName    string `json:"displayName"`
+		TenantId       string `json:"tenantId"`
+	}
+	if err := runtime.UnmarshalAsJSON(resp, &res); err != nil {
+		return nil, errors.Wrap(err, "failed to parse subscription")
+	}
+
+	return &Subscription{
+		SubscriptionId: res.SubscriptionId,
+		Name:           res.DisplayName,
+		TenantId:       res.TenantId,
+	}, nil
+}
+
+func (s *SDKBackend) armClientOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: s.clientOptions}
+}
+
+func (s *SDKBackend) galleryImagesClient(subscriptionId string) (*armcompute.GalleryImagesClient, error) {
+	client, err := armcompute.NewGalleryImagesClient(subscriptionId, s.cred, s.armClientOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize Azure SDK")
+	}
+	return client, nil
+}
+
+func (s *SDKBackend) galleryImageVersionsClient(subscriptionId string) (*armcompute.GalleryImageVersionsClient, error) {
+	client, err := armcompute.NewGalleryImageVersionsClient(subscriptionId, s.cred, s.armClientOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize Azure SDK")
+	}
+	return client, nil
+}
+
+func (s *SDKBackend) ListImageDefinitions(ctx context.Context, subscriptionId, resourceGroup, gallery string) ([]ImageDefinition, error) {
+	client, err := s.galleryImagesClient(subscriptionId)
+	if err != nil {
+		return nil, err
+	}
+
+	var definitions []ImageDefinition
+	pager := client.NewListByGalleryPager(resourceGroup, gallery, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, def := range page.Value {
+			definitions = append(definitions, ImageDefinition{
+				ID:       stringOf(def.ID),
+				Name:     stringOf(def.Name),
+				Location: stringOf(def.Location),
+				Tags:     tagsOf(def.Tags),
+			})
+		}
+	}
+
+	return definitions, nil
+}
+
+func (s *SDKBackend) ListImageVersions(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition string) ([]ImageVersion, error) {
+	client, err := s.galleryImageVersionsClient(subscriptionId)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []ImageVersion
+	pager := client.NewListByGalleryImagePager(resourceGroup, gallery, imageDefinition, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, version := range page.Value {
+			versions = append(versions, imageVersionOf(version))
+		}
+	}
+
+	return versions, nil
+}
+
+func (s *SDKBackend) GetImageVersion(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string) (*ImageVersion, error) {
+	client, err := s.galleryImageVersionsClient(subscriptionId)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Get(ctx, resourceGroup, gallery, imageDefinition, version, &armcompute.GalleryImageVersionsClientGetOptions{
+		Expand: to.Ptr(armcompute.ReplicationStatusTypesReplicationStatus),
+	})
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, ErrImageVersionNotFound
+		}
+		return nil, err
+	}
+
+	imageVersion := imageVersionOf(&res.GalleryImageVersion)
+	return &imageVersion, nil
+}
+
+func (s *SDKBackend) DeleteImageVersion(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string) error {
+	client, err := s.galleryImageVersionsClient(subscriptionId)
+	if err != nil {
+		return err
+	}
+
+	poller, err := client.BeginDelete(ctx, resourceGroup, gallery, imageDefinition, version, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: deploymentPollFrequency})
+	return err
+}
+
+func (s *SDKBackend) UpdateImageVersion(ctx context.Context, subscriptionId, resourceGroup, gallery, imageDefinition, version string, update ImageVersionUpdate) error {
+	client, err := s.galleryImageVersionsClient(subscriptionId)
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[string]*string, len(update.Tags))
+	for key, value := range update.Tags {
+		tags[key] = to.Ptr(value)
+	}
+
+	poller, err := client.BeginUpdate(ctx, resourceGroup, gallery, imageDefinition, version, armcompute.GalleryImageVersionUpdate{
+		Tags: tags,
+		Properties: &armcompute.GalleryImageVersionProperties{
+			PublishingProfile: &armcompute.GalleryImageVersionPublishingProfile{
+				ExcludeFromLatest: to.Ptr(update.ExcludeFromLatest),
+			},
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: deploymentPollFrequency})
+	return err
+}
+
+func imageVersionOf(version *armcompute.GalleryImageVersion) ImageVersion {
+	imageVersion := ImageVersion{
+		ID:       stringOf(version.ID),
+		Name:     stringOf(version.Name),
+		Location: stringOf(version.Location),
+		Tags:     tagsOf(version.Tags),
+	}
+
+	props := version.Properties
+	if props == nil {
+		return imageVersion
+	}
+
+	if props.ProvisioningState != nil {
+		imageVersion.ProvisioningState = string(*props.ProvisioningState)
+	}
+	if props.ReplicationStatus != nil && props.ReplicationStatus.AggregatedState != nil {
+		imageVersion.ReplicationState = string(*props.ReplicationStatus.AggregatedState)
+	}
+	if profile := props.PublishingProfile; profile != nil {
+		imageVersion.PublishedDate = profile.PublishedDate
+		imageVersion.EndOfLifeDate = profile.EndOfLifeDate
+		imageVersion.ExcludeFromLatest = profile.ExcludeFromLatest != nil && *profile.ExcludeFromLatest
+		imageVersion.TargetRegions = make([]string, 0, len(profile.TargetRegions))
+		for _, region := range profile.TargetRegions {
+			imageVersion.TargetRegions = append(imageVersion.TargetRegions, stringOf(region.Name))
+		}
+	}
+
+	return imageVersion
+}
+
+func stringOf(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func tagsOf(tags map[string]*string) map[string]string {
+	if tags == nil {
+		return nil
+	}
+
+	values := make(map[string]string, len(tags))
+	for key, value := range tags {
+		values[key] = stringOf(value)
+	}
+	return values
+}
+
+func isNotFoundError(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+func (s *SDKBackend) BlobExists(ctx context.Context, storageAccount, container, blobName string) (bool, error) {
+	client, err := s.blobClient(storageAccount)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = client.ServiceClient().NewContainerClient(container).NewBlobClient(blobName).GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *SDKBackend) UploadBlob(ctx context.Context, storageAccount, container, blobName, filePath string) error {
+	client, err := s.blobClient(storageAccount)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", filePath)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "failed to check size of %s", filePath)
+	}
+
+	bar := progressbar.DefaultBytes(stat.Size(), "Uploading")
+	defer bar.Exit()
+
+	if _, err := client.UploadFile(ctx, container, blobName, f, &azblob.UploadFileOptions{
+		Progress: func(bytesTransferred int64) {
+			_ = bar.Set64(bytesTransferred)
+		},
+	}); err != nil {
+		return err
+	}
+
+	_ = bar.Finish()
+	return nil
+}
+
+func (s *SDKBackend) ListBlobs(ctx context.Context, storageAccount, container, prefix string) ([]Blob, error) {
+	client, err := s.blobClient(storageAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []Blob
+	pager := client.NewListBlobsFlatPager(container, &azblob.ListBlobsFlatOptions{Prefix: to.Ptr(prefix)})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+
+			blob := Blob{Name: *item.Name}
+			if item.Properties != nil {
+				if item.Properties.LastModified != nil {
+					blob.LastModified = *item.Properties.LastModified
+				}
+				if item.Properties.ContentLength != nil {
+					blob.Size = *item.Properties.ContentLength
+				}
+			}
+			blobs = append(blobs, blob)
+		}
+	}
+
+	return blobs, nil
+}
+
+func (s *SDKBackend) TouchBlob(ctx context.Context, storageAccount, container, blobName string) error {
+	client, err := s.blobClient(storageAccount)
+	if err != nil {
+		return err
+	}
+
+	// setting the metadata is the cheapest write that updates the last modified time
+	lastUsed := time.Now().UTC().Format(time.RFC3339)
+	_, err = client.ServiceClient().NewContainerClient(container).NewBlobClient(blobName).SetMetadata(ctx, map[string]*string{
+		touchMetadataKey: &lastUsed,
+	}, nil)
+	return err
+}
+
+func (s *SDKBackend) DeleteBlob(ctx context.Context, storageAccount, container, blobName string) error {
+	client, err := s.blobClient(storageAccount)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.DeleteBlob(ctx, container, blobName, nil)
+	return err
+}
+
+func (s *SDKBackend) DownloadBlob(ctx context.Context, storageAccount, container, blobName, targetPath string) error {
+	client, err := s.blobClient(storageAccount)
+	if err != nil {
+		return err
+	}
+
+	return downloadBlobToPath(ctx, client, container, blobName, targetPath)
+}
+
+func (s *SDKBackend) GetBlobSize(ctx context.Context, storageAccount, container, blobName string) (int64, error) {
+	client, err := s.blobClient(storageAccount)
+	if err != nil {
+		return 0, err
+	}
+
+	props, err := client.ServiceClient().NewContainerClient(container).NewBlobClient(blobName).GetProperties(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	if props.ContentLength == nil {
+		return 0, fmt.Errorf("blob %s has no content length", blobName)
+	}
+
+	return *props.ContentLength, nil
+}
+
+func (s *SDKBackend) ReadBlobRange(ctx context.Context, storageAccount, container, blobName string, offset, count int64) ([]byte, error) {
+	client, err := s.blobClient(storageAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.DownloadStream(ctx, container, blobName, &azblob.DownloadStreamOptions{
+		Range: azblob.HTTPRange{Offset: offset, Count: count},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return io.ReadAll(res.Body)
+}
+
+func (s *SDKBackend) DeployTemplate(ctx context.Context, subscriptionId, resourceGroup, deploymentName string, template []byte) error {
+	client, err := armresources.NewDeploymentsClient(subscriptionId, s.cred, s.armClientOptions())
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize Azure SDK")
+	}
+
+	poller, err := client.BeginCreateOrUpdate(ctx, resourceGroup, deploymentName, armresources.Deployment{
+		Properties: &armresources.DeploymentProperties{
+			Mode:     to.Ptr(armresources.DeploymentModeIncremental),
+			Template: json.RawMessage(template),
+		},
+	}, nil)
+	if err != nil {
+		return errors.Wrap(err, "deployment failed")
+	}
+
+	if _, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: deploymentPollFrequency}); err != nil {
+		return errors.Wrap(err, "deployment failed")
+	}
+
+	return nil
+}
+
+func (s *SDKBackend) blobClient(storageAccount string) (*azblob.Client, error) {
+	client, err := azblob.NewClient(s.blobServiceURL(storageAccount), s.cred, &azblob.ClientOptions{
+		ClientOptions: s.clientOptions,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize Azure SDK")
+	}
+	return client, nil
+}
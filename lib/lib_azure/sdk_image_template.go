@@ -0,0 +1,269 @@
+package lib_azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/friendsofgo/errors"
+)
+
+func (s *SDKBackend) imageTemplatesClient(subscriptionId string) (*armvirtualmachineimagebuilder.VirtualMachineImageTemplatesClient, error) {
+	client, err := armvirtualmachineimagebuilder.NewVirtualMachineImageTemplatesClient(subscriptionId, s.cred, s.armClientOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize Azure SDK")
+	}
+	return client, nil
+}
+
+func (s *SDKBackend) GetImageTemplateStatus(ctx context.Context, subscriptionId, resourceGroup, name string) (*ImageTemplateStatus, error) {
+	client, err := s.imageTemplatesClient(subscriptionId)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Get(ctx, resourceGroup, name, nil)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, ErrImageTemplateNotFound
+		}
+		return nil, err
+	}
+
+	return imageTemplateStatusOf(&res.ImageTemplate), nil
+}
+
+func imageTemplateStatusOf(template *armvirtualmachineimagebuilder.ImageTemplate) *ImageTemplateStatus {
+	status := &ImageTemplateStatus{}
+	if template.ID != nil {
+		status.ID = *template.ID
+	}
+
+	props := template.Properties
+	if props == nil {
+		return status
+	}
+
+	if props.ProvisioningState != nil {
+		status.ProvisioningState = string(*props.ProvisioningState)
+	}
+	if props.ProvisioningError != nil && props.ProvisioningError.Message != nil {
+		status.ProvisioningError = *props.ProvisioningError.Message
+	}
+	if props.ExactStagingResourceGroup != nil {
+		status.StagingResourceGroup = *props.ExactStagingResourceGroup
+	}
+
+	if runStatus := props.LastRunStatus; runStatus != nil {
+		if runStatus.RunState != nil {
+			status.RunState = string(*runStatus.RunState)
+		}
+		if runStatus.RunSubState != nil {
+			status.RunSubState = string(*runStatus.RunSubState)
+		}
+		if runStatus.Message != nil {
+			status.RunMessage = *runStatus.Message
+		}
+		status.StartTime = runStatus.StartTime
+		status.EndTime = runStatus.EndTime
+	}
+
+	return status
+}
+
+func (s *SDKBackend) ListImageTemplates(ctx context.Context, subscriptionId, resourceGroup string) ([]ImageTemplate, error) {
+	client, err := s.imageTemplatesClient(subscriptionId)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []ImageTemplate
+	pager := client.NewListByResourceGroupPager(resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, template := range page.Value {
+			content, err := json.Marshal(template)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to serialize Image Template")
+			}
+
+			summary := ImageTemplate{
+				Status:  *imageTemplateStatusOf(template),
+				Content: string(content),
+			}
+			summary.ID = summary.Status.ID
+			if template.Name != nil {
+				summary.Name = *template.Name
+			}
+			if template.SystemData != nil {
+				summary.CreatedAt = template.SystemData.CreatedAt
+			}
+			if template.Properties != nil {
+				for _, distributor := range template.Properties.Distribute {
+					if sharedImage, ok := distributor.(*armvirtualmachineimagebuilder.ImageTemplateSharedImageDistributor); ok && sharedImage.GalleryImageID != nil {
+						summary.GalleryImageIDs = append(summary.GalleryImageIDs, *sharedImage.GalleryImageID)
+					}
+				}
+			}
+
+			templates = append(templates, summary)
+		}
+	}
+
+	return templates, nil
+}
+
+func (s *SDKBackend) DeleteImageTemplate(ctx context.Context, subscriptionId, resourceGroup, name string) error {
+	client, err := s.imageTemplatesClient(subscriptionId)
+	if err != nil {
+		return err
+	}
+
+	poller, err := client.BeginDelete(ctx, resourceGroup, name, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: deploymentPollFrequency})
+	return err
+}
+
+func (s *SDKBackend) DownloadCustomizationLog(ctx context.Context, subscriptionId, stagingResourceGroup, targetPath string) error {
+	accountsClient, err := armstorage.NewAccountsClient(subscriptionId, s.cred, s.armClientOptions())
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize Azure SDK")
+	}
+
+	var accountNames []string
+	pager := accountsClient.NewListByResourceGroupPager(stagingResourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to list storage accounts in the staging resource group")
+		}
+		for _, account := range page.Value {
+			if account.Name != nil {
+				accountNames = append(accountNames, *account.Name)
+			}
+		}
+	}
+
+	for _, accountName := range accountNames {
+		client, err := s.blobClient(accountName)
+		if err != nil {
+			return err
+		}
+
+		blobName, err := latestCustomizationLog(ctx, client)
+		if isAuthorizationError(err) {
+			// the Image Builder staging storage account often has no data plane role assignments, fall back to the account key
+			client, err = s.sharedKeyBlobClient(ctx, accountsClient, stagingResourceGroup, accountName)
+			if err != nil {
+				return err
+			}
+			blobName, err = latestCustomizationLog(ctx, client)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to list logs in storage account %s", accountName)
+		}
+		if blobName == "" {
+			continue
+		}
+
+		return downloadBlobToPath(ctx, client, customizationLogContainer, blobName, targetPath)
+	}
+
+	return ErrCustomizationLogNotFound
+}
+
+// latestCustomizationLog returns the name of the most recent customization log blob
+// returns an empty string if there is none
+func latestCustomizationLog(ctx context.Context, client *azblob.Client) (string, error) {
+	var (
+		latestName     string
+		latestModified time.Time
+	)
+
+	pager := client.NewListBlobsFlatPager(customizationLogContainer, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			if isNotFoundError(err) {
+				return "", nil
+			}
+			return "", err
+		}
+
+		for _, blob := range page.Segment.BlobItems {
+			if blob.Name == nil || !strings.HasSuffix(*blob.Name, customizationLogFilename) {
+				continue
+			}
+
+			var modified time.Time
+			if blob.Properties != nil && blob.Properties.LastModified != nil {
+				modified = *blob.Properties.LastModified
+			}
+			if latestName == "" || modified.After(latestModified) {
+				latestName = *blob.Name
+				latestModified = modified
+			}
+		}
+	}
+
+	return latestName, nil
+}
+
+func downloadBlobToPath(ctx context.Context, client *azblob.Client, container, blobName, targetPath string) error {
+	f, err := os.Create(targetPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", targetPath)
+	}
+	defer f.Close()
+
+	if _, err := client.DownloadFile(ctx, container, blobName, f, nil); err != nil {
+		return errors.Wrapf(err, "failed to download %s", blobName)
+	}
+
+	return f.Close()
+}
+
+func (s *SDKBackend) sharedKeyBlobClient(ctx context.Context, accountsClient *armstorage.AccountsClient, resourceGroup, storageAccount string) (*azblob.Client, error) {
+	keys, err := accountsClient.ListKeys(ctx, resourceGroup, storageAccount, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the keys of storage account %s", storageAccount)
+	}
+	if len(keys.Keys) == 0 || keys.Keys[0].Value == nil {
+		return nil, fmt.Errorf("storage account %s has no keys", storageAccount)
+	}
+
+	cred, err := azblob.NewSharedKeyCredential(storageAccount, *keys.Keys[0].Value)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid storage account key")
+	}
+
+	client, err := azblob.NewClientWithSharedKeyCredential(s.blobServiceURL(storageAccount), cred, &azblob.ClientOptions{
+		ClientOptions: s.clientOptions,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize Azure SDK")
+	}
+	return client, nil
+}
+
+func isAuthorizationError(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden
+}
@@ -0,0 +1,113 @@
+package lib_azure_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/schoolyear/avd-cli/lib/lib_azure"
+	"github.com/schoolyear/avd-cli/lib/lib_azure/azuretest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSDKBackend(t *testing.T) {
+	fake := azuretest.NewFakeAzure()
+	fake.Subscriptions["sub-1"] = lib_azure.Subscription{SubscriptionId: "sub-1", Name: "Exams", TenantId: "tenant-1"}
+	fake.ImageDefinitions["gallery"] = []armcompute.GalleryImage{
+		{ID: to.Ptr("/images/win11"), Name: to.Ptr("win11"), Location: to.Ptr("westeurope")},
+		{ID: to.Ptr("/images/win10"), Name: to.Ptr("win10"), Location: to.Ptr("westeurope")},
+	}
+	fake.ImageVersions[azuretest.FakeImageVersionKey("gallery", "win11", "1.0.0")] = armcompute.GalleryImageVersion{
+		ID:       to.Ptr("/images/win11/versions/1.0.0"),
+		Name:     to.Ptr("1.0.0"),
+		Location: to.Ptr("westeurope"),
+		Tags:     map[string]*string{"SY_BUNDLE_URL": to.Ptr("https://account.blob.core.windows.net/bundles/bundle.json")},
+		Properties: &armcompute.GalleryImageVersionProperties{
+			ProvisioningState: to.Ptr(armcompute.GalleryProvisioningStateSucceeded),
+			PublishingProfile: &armcompute.GalleryImageVersionPublishingProfile{
+				TargetRegions: []*armcompute.TargetRegion{{Name: to.Ptr("West Europe")}, {Name: to.Ptr("North Europe")}},
+			},
+			ReplicationStatus: &armcompute.ReplicationStatus{AggregatedState: to.Ptr(armcompute.AggregatedReplicationStateCompleted)},
+		},
+	}
+	backend, server, err := azuretest.NewSDKBackend(fake)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx := context.Background()
+
+	subscription, err := backend.GetSubscription(ctx, "sub-1")
+	require.NoError(t, err)
+	require.Equal(t, &lib_azure.Subscription{SubscriptionId: "sub-1", Name: "Exams", TenantId: "tenant-1"}, subscription)
+
+	_, err = backend.GetSubscription(ctx, "sub-2")
+	require.ErrorIs(t, err, lib_azure.ErrSubscriptionNotFound)
+
+	definitions, err := backend.ListImageDefinitions(ctx, "sub-1", "rg", "gallery")
+	require.NoError(t, err)
+	require.Len(t, definitions, 2)
+	require.Equal(t, "win11", definitions[0].Name)
+	require.Equal(t, "win10", definitions[1].Name)
+
+	exists, err := backend.BlobExists(ctx, "account", "bundles", "bundle.zip")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	bundlePath := filepath.Join(t.TempDir(), "bundle.zip")
+	require.NoError(t, os.WriteFile(bundlePath, []byte("bundle contents"), 0644))
+	require.NoError(t, backend.UploadBlob(ctx, "account", "bundles", "bundle.zip", bundlePath))
+	require.Equal(t, []byte("bundle contents"), fake.Blobs["/account/bundles/bundle.zip"])
+
+	exists, err = backend.BlobExists(ctx, "account", "bundles", "bundle.zip")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	require.NoError(t, backend.TouchBlob(ctx, "account", "bundles", "bundle.zip"))
+	require.Contains(t, fake.BlobMetadata["/account/bundles/bundle.zip"], "avdcli_last_used")
+	require.Equal(t, []byte("bundle contents"), fake.Blobs["/account/bundles/bundle.zip"])
+
+	downloadPath := filepath.Join(t.TempDir(), "downloaded.zip")
+	require.NoError(t, backend.DownloadBlob(ctx, "account", "bundles", "bundle.zip", downloadPath))
+	downloaded, err := os.ReadFile(downloadPath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("bundle contents"), downloaded)
+
+	version, err := backend.GetImageVersion(ctx, "sub-1", "rg", "gallery", "win11", "1.0.0")
+	require.NoError(t, err)
+	require.Equal(t, "Completed", version.ReplicationState)
+	require.Equal(t, []string{"West Europe", "North Europe"}, version.TargetRegions)
+	require.Equal(t, "https://account.blob.core.windows.net/bundles/bundle.json", version.Tags["SY_BUNDLE_URL"])
+
+	versions, err := backend.ListImageVersions(ctx, "sub-1", "rg", "gallery", "win11")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.Equal(t, "1.0.0", versions[0].Name)
+
+	require.NoError(t, backend.UpdateImageVersion(ctx, "sub-1", "rg", "gallery", "win11", "1.0.0", lib_azure.ImageVersionUpdate{ExcludeFromLatest: true, Tags: map[string]string{"SY_BUNDLE_URL": "updated"}}))
+	updated := fake.ImageVersions[azuretest.FakeImageVersionKey("gallery", "win11", "1.0.0")]
+	require.Equal(t, "updated", *updated.Tags["SY_BUNDLE_URL"])
+	require.True(t, *updated.Properties.PublishingProfile.ExcludeFromLatest)
+
+	_, err = backend.GetImageVersion(ctx, "sub-1", "rg", "gallery", "win11", "2.0.0")
+	require.ErrorIs(t, err, lib_azure.ErrImageVersionNotFound)
+
+	template := []byte(`{"resources":[]}`)
+	require.NoError(t, backend.DeployTemplate(ctx, "sub-1", "rg", "image-template", template))
+	require.JSONEq(t, string(template), string(fake.Deployments["image-template"]))
+
+	require.NoError(t, backend.DeleteImageVersion(ctx, "sub-1", "rg", "gallery", "win11", "1.0.0"))
+	require.Empty(t, fake.ImageVersions)
+}
+
+func TestSDKBackend_DownloadCustomizationLog(t *testing.T) {
+	fake := azuretest.NewFakeAzure()
+	backend, server, err := azuretest.NewSDKBackend(fake)
+	require.NoError(t, err)
+	defer server.Close()
+
+	err = backend.DownloadCustomizationLog(context.Background(), "sub-1", "IT_staging", filepath.Join(t.TempDir(), "customization.log"))
+	require.ErrorIs(t, err, lib_azure.ErrCustomizationLogNotFound)
+}
@@ -0,0 +1,287 @@
+package lib_credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/lib"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseEnvVar can be set to encrypt the credentials file with a passphrase instead of the machine key
+const PassphraseEnvVar = "AVDCLI_CREDENTIAL_PASSPHRASE"
+
+const credentialsFilename = "credentials"
+
+const (
+	keySourcePassphrase = "passphrase"
+	keySourceMachine    = "machine"
+)
+
+// scrypt parameters recommended for interactive logins
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLength   = 16
+)
+
+// FileStore stores all credentials in a single AES-GCM sealed file in ~/.avdcli.
+// The key is derived (scrypt) from the passphrase in AVDCLI_CREDENTIAL_PASSPHRASE or, if not set,
+// from the machine id and user name. Those are not secret: anyone who can read the file on this machine can derive the machine key,
+// so without a passphrase the file is only obfuscated and the file permissions (0600) are what protect it against other users.
+type FileStore struct {
+	path       string
+	passphrase string
+	keySource  string
+}
+
+func NewFileStore() (*FileStore, error) {
+	dir, err := lib.AvdcliHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	store := &FileStore{
+		path:      filepath.Join(dir, credentialsFilename),
+		keySource: keySourcePassphrase,
+	}
+
+	if passphrase := os.Getenv(PassphraseEnvVar); passphrase != "" {
+		store.passphrase = passphrase
+	} else {
+		machineKey, err := machineIdentifier()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to determine the machine key. set %s to use a passphrase instead", PassphraseEnvVar)
+		}
+		store.passphrase = machineKey
+		store.keySource = keySourceMachine
+	}
+
+	return store, nil
+}
+
+func (e *FileStore) Name() string {
+	return fmt.Sprintf("file %s (%s key)", e.path, e.keySource)
+}
+
+func (e *FileStore) Warning() string {
+	if e.keySource != keySourceMachine {
+		return ""
+	}
+	return fmt.Sprintf("the credentials file is only protected by its file permissions, the machine key does not keep the token secret from anyone who can read the file. Set %s to protect it with a passphrase", PassphraseEnvVar)
+}
+
+func (e *FileStore) Get(key string) (string, error) {
+	entries, err := e.read()
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := entries[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (e *FileStore) Set(key, value string) error {
+	entries, err := e.read()
+	if err != nil {
+		return err
+	}
+
+	entries[key] = value
+	return e.write(entries)
+}
+
+func (e *FileStore) Delete(key string) error {
+	entries, err := e.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := entries[key]; !ok {
+		return ErrNotFound
+	}
+
+	delete(entries, key)
+	return e.write(entries)
+}
+
+type encryptedCredentialsFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	KeySource  string `json:"key_source"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// read decrypts all entries in the file
+// returns an empty map if the file does not exist
+func (e *FileStore) read() (map[string]string, error) {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]string{}, nil
+		}
+		return nil, errors.Wrap(err, "failed to check credentials file")
+	}
+
+	// file modes are not enforced on Windows
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("credentials file %s is accessible by other users (%s). run: chmod 600 %s", e.path, info.Mode().Perm(), e.path)
+	}
+
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read credentials file")
+	}
+
+	var file encryptedCredentialsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrap(err, "failed to parse credentials file")
+	}
+
+	if file.Version != 1 || file.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported credentials file (version %d, kdf %s)", file.Version, file.KDF)
+	}
+	if file.KeySource != e.keySource {
+		return nil, fmt.Errorf("credentials file %s is encrypted with a %s key, but a %s key is configured (see %s)", e.path, file.KeySource, e.keySource, PassphraseEnvVar)
+	}
+
+	aead, err := e.cipher(file.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, []byte(file.KeySource))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials file %s. was it created on another machine or with another passphrase?", e.path)
+	}
+
+	var entries map[string]string
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return nil, errors.Wrap(err, "failed to parse decrypted credentials")
+	}
+	if entries == nil {
+		entries = map[string]string{}
+	}
+
+	return entries, nil
+}
+
+func (e *FileStore) write(entries map[string]string) error {
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize credentials")
+	}
+
+	file := encryptedCredentialsFile{
+		Version:   1,
+		KDF:       "scrypt",
+		KeySource: e.keySource,
+		Salt:      make([]byte, saltLength),
+	}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return errors.Wrap(err, "failed to generate salt")
+	}
+
+	aead, err := e.cipher(file.Salt)
+	if err != nil {
+		return err
+	}
+
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return errors.Wrap(err, "failed to generate nonce")
+	}
+	file.Ciphertext = aead.Seal(nil, file.Nonce, plaintext, []byte(file.KeySource))
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize credentials file")
+	}
+
+	dir := filepath.Dir(e.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "failed to create credentials directory")
+	}
+
+	// write to a temporary file first, so an interrupted write never corrupts existing credentials
+	tmpFile, err := os.CreateTemp(dir, credentialsFilename+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary credentials file")
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if err := tmpFile.Chmod(0600); err != nil && runtime.GOOS != "windows" {
+		tmpFile.Close()
+		return errors.Wrap(err, "failed to restrict permissions of credentials file")
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "failed to write credentials file")
+	}
+	if err := tmpFile.Close(); err != nil {
+		return errors.Wrap(err, "failed to write credentials file")
+	}
+
+	if err := os.Rename(tmpFile.Name(), e.path); err != nil {
+		return errors.Wrap(err, "failed to replace credentials file")
+	}
+
+	return nil
+}
+
+func (e *FileStore) cipher(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(e.passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive encryption key")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// machineIdentifier combines a stable identifier of the machine with the current user
+func machineIdentifier() (string, error) {
+	var machineId string
+	for _, idPath := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		data, err := os.ReadFile(idPath)
+		if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+			machineId = strings.TrimSpace(string(data))
+			break
+		}
+	}
+
+	if machineId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to get hostname")
+		}
+		machineId = hostname
+	}
+
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get current user")
+	}
+
+	return fmt.Sprintf("avdcli:%s:%s:%s", machineId, currentUser.Uid, currentUser.Username), nil
+}
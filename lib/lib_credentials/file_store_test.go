@@ -0,0 +1,48 @@
+package lib_credentials
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv(PassphraseEnvVar, "correct horse battery staple")
+
+	store, err := NewFileStore()
+	require.NoError(t, err)
+
+	_, err = store.Get("token")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.Set("token", "secret-value"))
+	require.NoError(t, store.Set("other", "other-value"))
+
+	value, err := store.Get("token")
+	require.NoError(t, err)
+	require.Equal(t, "secret-value", value)
+
+	raw, err := os.ReadFile(filepath.Join(home, ".avdcli", credentialsFilename))
+	require.NoError(t, err)
+	require.NotContains(t, string(raw), "secret-value")
+
+	// a different passphrase cannot decrypt the file
+	t.Setenv(PassphraseEnvVar, "wrong")
+	wrongStore, err := NewFileStore()
+	require.NoError(t, err)
+	_, err = wrongStore.Get("token")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.Delete("token"))
+	require.ErrorIs(t, store.Delete("token"), ErrNotFound)
+
+	value, err = store.Get("other")
+	require.NoError(t, err)
+	require.Equal(t, "other-value", value)
+}
@@ -0,0 +1,253 @@
+package lib_credentials
+
+import (
+	stdErr "errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/friendsofgo/errors"
+	"github.com/schoolyear/avd-cli/static"
+	"github.com/zalando/go-keyring"
+)
+
+var ErrNotFound = errors.New("credential not found")
+
+// Store stores secrets of the CLI, like the GitHub tokens
+type Store interface {
+	// Get returns ErrNotFound if no value is stored under the key
+	Get(key string) (string, error)
+	Set(key, value string) error
+	// Delete returns ErrNotFound if no value is stored under the key
+	Delete(key string) error
+	Name() string
+	// Warning explains how weakly the store protects secrets, or is empty if there is nothing to warn about
+	Warning() string
+}
+
+type StoreKind string
+
+const (
+	StoreKindAuto    StoreKind = "auto"
+	StoreKindKeyring StoreKind = "keyring"
+	StoreKindFile    StoreKind = "file"
+)
+
+var StoreKinds = []StoreKind{StoreKindAuto, StoreKindKeyring, StoreKindFile}
+
+func ParseStoreKind(value string) (StoreKind, error) {
+	for _, kind := range StoreKinds {
+		if string(kind) == value {
+			return kind, nil
+		}
+	}
+
+	names := make([]string, len(StoreKinds))
+	for i, kind := range StoreKinds {
+		names[i] = string(kind)
+	}
+	return "", fmt.Errorf("unknown credential store %s. available: %s", value, strings.Join(names, ", "))
+}
+
+// NewStore creates the store of the given kind.
+// auto uses the OS keyring and falls back to the credentials file when the keyring is unavailable
+func NewStore(kind StoreKind) (Store, error) {
+	switch kind {
+	case StoreKindKeyring:
+		return KeyringStore{}, nil
+	case StoreKindFile:
+		return NewFileStore()
+	case StoreKindAuto:
+		return &autoStore{
+			keyring: KeyringStore{},
+			newFileStore: func() (Store, error) {
+				return NewFileStore()
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown credential store %s", kind)
+	}
+}
+
+// KeyringStore uses the credential store of the OS (Keychain, Windows Credential Manager, Secret Service)
+type KeyringStore struct{}
+
+func (k KeyringStore) Get(key string) (string, error) {
+	value, err := keyring.Get(static.KeyringServiceName, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func (k KeyringStore) Set(key, value string) error {
+	return keyring.Set(static.KeyringServiceName, key, value)
+}
+
+func (k KeyringStore) Delete(key string) error {
+	err := keyring.Delete(static.KeyringServiceName, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (k KeyringStore) Name() string {
+	return "OS keyring"
+}
+
+func (k KeyringStore) Warning() string {
+	return ""
+}
+
+// autoStore uses the OS keyring until it fails, after which it switches to the credentials file.
+// Secrets may have been stored in the file during an earlier run in which the keyring was unavailable,
+// so lookups and deletes that do not find the secret in the keyring also check the file
+type autoStore struct {
+	lock         sync.Mutex
+	keyring      Store
+	newFileStore func() (Store, error)
+
+	// file is opened on first use
+	file Store
+	// keyringFailed is set once the keyring returned an error other than ErrNotFound
+	keyringFailed bool
+	// foundInFile is set when a secret was only found in the file
+	foundInFile bool
+}
+
+func (a *autoStore) Get(key string) (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if !a.keyringFailed {
+		value, err := a.keyring.Get(key)
+		if err == nil {
+			return value, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			if fallbackErr := a.fallback(err); fallbackErr != nil {
+				return "", fallbackErr
+			}
+		}
+	}
+
+	file, err := a.fileStore()
+	if err != nil {
+		if !a.keyringFailed {
+			// the file cannot have been written either, so the secret was never stored
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+
+	value, err := file.Get(key)
+	if err == nil {
+		a.foundInFile = true
+	}
+	return value, err
+}
+
+func (a *autoStore) Set(key, value string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if !a.keyringFailed {
+		err := a.keyring.Set(key, value)
+		if err == nil {
+			return nil
+		}
+		if fallbackErr := a.fallback(err); fallbackErr != nil {
+			return fallbackErr
+		}
+	}
+
+	file, err := a.fileStore()
+	if err != nil {
+		return err
+	}
+	return file.Set(key, value)
+}
+
+// Delete removes the secret from both the keyring and the file
+func (a *autoStore) Delete(key string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	deleted := false
+	if !a.keyringFailed {
+		err := a.keyring.Delete(key)
+		switch {
+		case err == nil:
+			deleted = true
+		case errors.Is(err, ErrNotFound):
+		default:
+			if fallbackErr := a.fallback(err); fallbackErr != nil {
+				return fallbackErr
+			}
+		}
+	}
+
+	file, err := a.fileStore()
+	if err != nil {
+		if !a.keyringFailed {
+			if deleted {
+				return nil
+			}
+			return ErrNotFound
+		}
+		return err
+	}
+
+	err = file.Delete(key)
+	if errors.Is(err, ErrNotFound) && deleted {
+		return nil
+	}
+	return err
+}
+
+func (a *autoStore) Name() string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if (a.keyringFailed || a.foundInFile) && a.file != nil {
+		return a.file.Name()
+	}
+	return a.keyring.Name()
+}
+
+func (a *autoStore) Warning() string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if (a.keyringFailed || a.foundInFile) && a.file != nil {
+		return a.file.Warning()
+	}
+	return a.keyring.Warning()
+}
+
+// fileStore opens the credentials file store
+func (a *autoStore) fileStore() (Store, error) {
+	if a.file != nil {
+		return a.file, nil
+	}
+
+	file, err := a.newFileStore()
+	if err != nil {
+		return nil, err
+	}
+	a.file = file
+	return file, nil
+}
+
+// fallback switches to the credentials file after the keyring failed with keyringErr
+func (a *autoStore) fallback(keyringErr error) error {
+	file, err := a.fileStore()
+	if err != nil {
+		return stdErr.Join(errors.Wrap(keyringErr, "OS keyring is unavailable"), errors.Wrap(err, "failed to fall back to the credentials file"))
+	}
+
+	color.Yellow("Warning: the OS keyring is unavailable (%s)", keyringErr)
+	color.Yellow("Warning: falling back to %s. Use --credential-store to select a store explicitly", file.Name())
+	a.keyringFailed = true
+	return nil
+}
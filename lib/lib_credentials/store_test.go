@@ -0,0 +1,202 @@
+package lib_credentials
+
+import (
+	"testing"
+
+	"github.com/friendsofgo/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// mapStore keeps secrets in memory
+type mapStore map[string]string
+
+func (m mapStore) Get(key string) (string, error) {
+	value, ok := m[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (m mapStore) Set(key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func (m mapStore) Delete(key string) error {
+	if _, ok := m[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m, key)
+	return nil
+}
+
+func (m mapStore) Name() string {
+	return "memory"
+}
+
+func (m mapStore) Warning() string {
+	return "memory is not persisted"
+}
+
+// failingStore is a keyring that is unavailable
+type failingStore struct{}
+
+var errKeyringUnavailable = errors.New("keyring unavailable")
+
+func (failingStore) Get(string) (string, error) { return "", errKeyringUnavailable }
+func (failingStore) Set(string, string) error   { return errKeyringUnavailable }
+func (failingStore) Delete(string) error        { return errKeyringUnavailable }
+func (failingStore) Name() string               { return "failing keyring" }
+func (failingStore) Warning() string            { return "" }
+
+func Test_autoStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyring Store
+		file    mapStore
+		// operation runs against the auto store
+		operation func(t *testing.T, store Store)
+		// wantKeyring and wantFile are the contents of the stores afterwards, nil skips the check
+		wantKeyring mapStore
+		wantFile    mapStore
+	}{
+		{
+			name:    "get prefers the keyring",
+			keyring: mapStore{"token": "keyring"},
+			file:    mapStore{"token": "file"},
+			operation: func(t *testing.T, store Store) {
+				value, err := store.Get("token")
+				require.NoError(t, err)
+				require.Equal(t, "keyring", value)
+				require.Equal(t, "memory", store.Name())
+			},
+		},
+		{
+			name:    "get falls back to the file when the keyring has no value",
+			keyring: mapStore{},
+			file:    mapStore{"token": "file"},
+			operation: func(t *testing.T, store Store) {
+				value, err := store.Get("token")
+				require.NoError(t, err)
+				require.Equal(t, "file", value)
+				require.Equal(t, "memory is not persisted", store.Warning())
+			},
+		},
+		{
+			name:    "get not found in either store",
+			keyring: mapStore{},
+			file:    mapStore{},
+			operation: func(t *testing.T, store Store) {
+				_, err := store.Get("token")
+				require.ErrorIs(t, err, ErrNotFound)
+			},
+		},
+		{
+			name:    "get from the file when the keyring fails",
+			keyring: failingStore{},
+			file:    mapStore{"token": "file"},
+			operation: func(t *testing.T, store Store) {
+				value, err := store.Get("token")
+				require.NoError(t, err)
+				require.Equal(t, "file", value)
+				require.Equal(t, "memory", store.Name())
+			},
+		},
+		{
+			name:    "set stores in the keyring",
+			keyring: mapStore{},
+			file:    mapStore{},
+			operation: func(t *testing.T, store Store) {
+				require.NoError(t, store.Set("token", "value"))
+			},
+			wantKeyring: mapStore{"token": "value"},
+			wantFile:    mapStore{},
+		},
+		{
+			name:    "set stores in the file when the keyring fails",
+			keyring: failingStore{},
+			file:    mapStore{},
+			operation: func(t *testing.T, store Store) {
+				require.NoError(t, store.Set("token", "value"))
+			},
+			wantFile: mapStore{"token": "value"},
+		},
+		{
+			name:    "delete removes the secret from both stores",
+			keyring: mapStore{"token": "keyring", "other": "keyring"},
+			file:    mapStore{"token": "file", "other": "file"},
+			operation: func(t *testing.T, store Store) {
+				require.NoError(t, store.Delete("token"))
+			},
+			wantKeyring: mapStore{"other": "keyring"},
+			wantFile:    mapStore{"other": "file"},
+		},
+		{
+			name:    "delete only in the file",
+			keyring: mapStore{},
+			file:    mapStore{"token": "file"},
+			operation: func(t *testing.T, store Store) {
+				require.NoError(t, store.Delete("token"))
+			},
+			wantKeyring: mapStore{},
+			wantFile:    mapStore{},
+		},
+		{
+			name:    "delete not found in either store",
+			keyring: mapStore{},
+			file:    mapStore{},
+			operation: func(t *testing.T, store Store) {
+				require.ErrorIs(t, store.Delete("token"), ErrNotFound)
+			},
+		},
+		{
+			name:    "delete from the file when the keyring fails",
+			keyring: failingStore{},
+			file:    mapStore{"token": "file"},
+			operation: func(t *testing.T, store Store) {
+				require.NoError(t, store.Delete("token"))
+			},
+			wantFile: mapStore{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &autoStore{
+				keyring: tt.keyring,
+				newFileStore: func() (Store, error) {
+					return tt.file, nil
+				},
+			}
+
+			tt.operation(t, store)
+
+			if tt.wantKeyring != nil {
+				require.Equal(t, tt.wantKeyring, tt.keyring)
+			}
+			if tt.wantFile != nil {
+				require.Equal(t, tt.wantFile, tt.file)
+			}
+		})
+	}
+}
+
+func Test_autoStore_unavailableFile(t *testing.T) {
+	store := &autoStore{
+		keyring: mapStore{},
+		newFileStore: func() (Store, error) {
+			return nil, errors.New("no machine id")
+		},
+	}
+
+	// nothing can have been stored in a file that cannot be opened
+	_, err := store.Get("token")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, store.Delete("token"), ErrNotFound)
+
+	store.keyring = failingStore{}
+	err = store.Set("token", "value")
+	require.ErrorIs(t, err, errKeyringUnavailable)
+	require.NotErrorIs(t, err, ErrNotFound)
+}
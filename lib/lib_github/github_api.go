@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/friendsofgo/errors"
@@ -130,6 +132,47 @@ type Tree struct {
 	URL  *string `json:"url,omitempty"`
 }
 
+// GithubListMatchingTags lists all tags in the repository that start with the given prefix
+func GithubListMatchingTags(client *resty.Client, bearer string, owner, repo, prefix string) ([]GithubRef, error) {
+	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/matching-refs/tags/%s", url.PathEscape(owner), url.PathEscape(repo), prefix)
+
+	res, err := client.R().
+		SetHeader("Accept", "application/vnd.github+json").
+		SetHeader("X-GitHub-Api-Version", "2022-11-28").
+		SetAuthToken(bearer).
+		Get(apiURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list tags from Github")
+	}
+
+	switch res.StatusCode() {
+	case 200:
+		var refs []GithubRef
+		if err := json.Unmarshal(res.Body(), &refs); err != nil {
+			return nil, errors.Wrap(err, "failed to parse response from Github")
+		}
+
+		return refs, nil
+	case 404:
+		return nil, errors.Wrap(ErrGithubNotFound, res.String())
+	default:
+		return nil, fmt.Errorf("expected 200 status code, got %d: %s", res.StatusCode(), res.String())
+	}
+}
+
+type GithubRef struct {
+	Ref    string          `json:"ref"`
+	NodeID string          `json:"node_id"`
+	URL    string          `json:"url"`
+	Object GithubRefObject `json:"object"`
+}
+
+type GithubRefObject struct {
+	SHA  string `json:"sha"`
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
 func GithubStartDeviceFlow(client *resty.Client, clientId string, redirectUri *string) (*DeviceStartResponse, error) {
 	req := client.R().
 		SetHeader("Accept", "application/json").
@@ -223,4 +266,101 @@ type GithubAccessToken struct {
 	AccessToken string `json:"access_token"`
 	TokenType   string `json:"token_type"`
 	Scope       string `json:"scope"`
+
+	// only set for GitHub Apps with expiring user tokens
+	ExpiresIn             int    `json:"expires_in,omitempty"`
+	RefreshToken          string `json:"refresh_token,omitempty"`
+	RefreshTokenExpiresIn int    `json:"refresh_token_expires_in,omitempty"`
+}
+
+var ErrGithubBadRefreshToken = errors.New("github bad refresh token")
+
+// GithubRefreshAccessToken exchanges a refresh token for a new access token (and a new refresh token)
+func GithubRefreshAccessToken(client *resty.Client, clientId string, refreshToken string) (*GithubAccessToken, error) {
+	res, err := client.R().
+		SetHeader("Accept", "application/json").
+		SetQueryParam("client_id", clientId).
+		SetQueryParam("refresh_token", refreshToken).
+		SetQueryParam("grant_type", "refresh_token").
+		Post("https://github.com/login/oauth/access_token")
+	if err != nil {
+		return nil, errors.Wrap(err, "request failed")
+	}
+
+	if res.StatusCode() != 200 {
+		return nil, errors.Errorf("expected 200 status code, got %d: %s", res.StatusCode(), res.String())
+	}
+
+	body := res.Body()
+
+	errStr, err := jsonparser.GetString(body, "error")
+	if err != nil {
+		if !errors.Is(err, jsonparser.KeyPathNotFoundError) {
+			return nil, errors.Wrap(err, "failed to parse response")
+		}
+	} else {
+		switch errStr {
+		case "bad_refresh_token":
+			return nil, ErrGithubBadRefreshToken
+		default:
+			errDescription, _ := jsonparser.GetString(body, "error_description")
+			return nil, fmt.Errorf("unexpected error: %s: %s", errStr, errDescription)
+		}
+	}
+
+	var data GithubAccessToken
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, errors.Wrap(err, "failed to parse response")
+	}
+
+	return &data, nil
+}
+
+var ErrGithubUnauthorized = errors.New("github token is invalid or expired")
+
+// GithubGetAuthenticatedUser fetches the user that owns the token
+// scopes are only set for OAuth tokens. expiresAt is nil if GitHub does not report an expiration
+func GithubGetAuthenticatedUser(client *resty.Client, bearer string) (user *GithubUser, scopes []string, expiresAt *time.Time, err error) {
+	res, err := client.R().
+		SetHeader("Accept", "application/vnd.github+json").
+		SetHeader("X-GitHub-Api-Version", "2022-11-28").
+		SetAuthToken(bearer).
+		Get("https://api.github.com/user")
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to get user from Github")
+	}
+
+	switch res.StatusCode() {
+	case 200:
+	case 401:
+		return nil, nil, nil, errors.Wrap(ErrGithubUnauthorized, res.String())
+	default:
+		return nil, nil, nil, fmt.Errorf("expected 200 status code, got %d: %s", res.StatusCode(), res.String())
+	}
+
+	if err := json.Unmarshal(res.Body(), &user); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to parse response from Github")
+	}
+
+	for _, scope := range strings.Split(res.Header().Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	// e.g. "2024-06-01 12:00:00 UTC"
+	if expiration := res.Header().Get("GitHub-Authentication-Token-Expiration"); expiration != "" {
+		parsed, err := time.Parse("2006-01-02 15:04:05 MST", expiration)
+		if err == nil {
+			expiresAt = &parsed
+		}
+	}
+
+	return user, scopes, expiresAt, nil
+}
+
+type GithubUser struct {
+	Login string  `json:"login"`
+	ID    int64   `json:"id"`
+	Name  *string `json:"name"`
 }
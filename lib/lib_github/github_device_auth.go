@@ -8,36 +8,84 @@ import (
 	"github.com/fatih/color"
 	"github.com/friendsofgo/errors"
 	"github.com/go-resty/resty/v2"
-	"github.com/schoolyear/avd-cli/static"
-	"github.com/zalando/go-keyring"
+	"github.com/schoolyear/avd-cli/lib/lib_credentials"
 )
 
-func getGithubKeyringKeyName(clientId string) string {
+// tokens that expire within this margin are treated as expired, so they don't expire while in use
+const tokenExpiryMargin = 5 * time.Minute
+
+// fallbackTokenLifetime is used when GitHub reports neither in the token response nor in the API when a token expires
+const fallbackTokenLifetime = 4 * time.Hour
+
+func getGithubCredentialKeyName(clientId string) string {
 	return "gh-device-" + clientId
 }
 
-func GithubDeviceFlow(client *resty.Client, clientId string, checkKeyringCache, writeToKeyring bool, reason string) (token string, err error) {
-	keyringKeyName := getGithubKeyringKeyName(clientId)
-
-	if checkKeyringCache {
-		value, err := keyring.Get(static.KeyringServiceName, keyringKeyName)
+func GithubDeviceFlow(client *resty.Client, store lib_credentials.Store, clientId string, checkCache, writeToCache bool, reason string) (token string, err error) {
+	if checkCache {
+		cachedToken, err := GithubCachedToken(client, store, clientId, writeToCache)
 		if err != nil {
-			if !errors.Is(err, keyring.ErrNotFound) {
-				return "", errors.Wrap(err, "failed to get github secret from local keyring")
-			}
-		} else {
-			var keyringValue githubTokenKeyringValue
-			if err := json.Unmarshal([]byte(value), &keyringValue); err != nil {
-				color.HiRed("ERROR: Failed to parse github secret from local keyring")
-			} else if time.Now().Before(keyringValue.ExpiresAt) {
-				return keyringValue.AccessToken, nil
-			}
+			return "", err
+		}
+		if cachedToken != nil {
+			return cachedToken.AccessToken, nil
+		}
+	}
+
+	tokenRes, err := githubRunDeviceFlow(client, clientId, reason)
+	if err != nil {
+		return "", err
+	}
+
+	if writeToCache {
+		stored := newGithubStoredToken(tokenRes, lookupGithubTokenExpiry(client, tokenRes), time.Now())
+		if err := StoreGithubToken(store, clientId, stored); err != nil {
+			return "", err
+		}
+	}
+
+	return tokenRes.AccessToken, nil
+}
+
+// GithubCachedToken returns the token from the credential store.
+// if the access token is expired, it is refreshed using the refresh token (if available and valid).
+// returns nil if no usable token is cached
+func GithubCachedToken(client *resty.Client, store lib_credentials.Store, clientId string, writeRefreshedToken bool) (*GithubStoredToken, error) {
+	cached, err := LoadGithubToken(store, clientId)
+	if err != nil || cached == nil {
+		return nil, err
+	}
+
+	if !cached.AccessTokenExpired() {
+		return cached, nil
+	}
+
+	if cached.RefreshToken == "" || cached.RefreshTokenExpired() {
+		return nil, nil
+	}
+
+	fmt.Printf("Refreshing GitHub token...")
+	refreshed, err := GithubRefreshAccessToken(client, clientId, cached.RefreshToken)
+	if err != nil {
+		color.Yellow("[FAILED]: %s", err)
+		return nil, nil
+	}
+	color.Green("[DONE]")
+
+	stored := newGithubStoredToken(refreshed, lookupGithubTokenExpiry(client, refreshed), time.Now())
+	if writeRefreshedToken {
+		if err := StoreGithubToken(store, clientId, stored); err != nil {
+			return nil, err
 		}
 	}
 
+	return stored, nil
+}
+
+func githubRunDeviceFlow(client *resty.Client, clientId string, reason string) (*GithubAccessToken, error) {
 	startRes, err := GithubStartDeviceFlow(client, clientId, nil)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to start device flow")
+		return nil, errors.Wrap(err, "failed to start device flow")
 	}
 
 	color.Yellow("Log into GitHub: %s", reason)
@@ -47,8 +95,9 @@ func GithubDeviceFlow(client *resty.Client, clientId string, checkKeyringCache,
 	color.Yellow("    4. Return here")
 	fmt.Printf("Waiting...")
 
+	var tokenRes *GithubAccessToken
 	for {
-		tokenRes, err := GithubGetAccessToken(client, clientId, startRes.DeviceCode)
+		tokenRes, err = GithubGetAccessToken(client, clientId, startRes.DeviceCode)
 		if err != nil {
 			switch {
 			case errors.Is(err, ErrGithubAuthorizationPending):
@@ -57,41 +106,120 @@ func GithubDeviceFlow(client *resty.Client, clientId string, checkKeyringCache,
 				fmt.Printf("x")
 				time.Sleep(time.Duration(startRes.Interval) * time.Second)
 			case errors.Is(err, ErrGithubExpiredToken):
-				return "", errors.Wrap(err, "github authorization took too long")
+				return nil, errors.Wrap(err, "github authorization took too long")
 			case errors.Is(err, ErrAccessDenied):
-				return "", errors.Wrap(err, "user denied access to GitHub")
+				return nil, errors.Wrap(err, "user denied access to GitHub")
 			default:
-				return "", errors.Wrap(err, "failed to get github access token")
+				return nil, errors.Wrap(err, "failed to get github access token")
 			}
 
 			time.Sleep(time.Duration(startRes.Interval) * time.Second)
 		} else {
-			token = tokenRes.AccessToken
 			break
 		}
 	}
 
-	if writeToKeyring {
-		valueJson, err := json.Marshal(githubTokenKeyringValue{
-			AccessToken: token,
-			ExpiresAt:   time.Now().Add(4 * time.Hour),
-		})
-		if err != nil {
-			return "", errors.Wrap(err, "failed to serialize github access token")
-		}
-
-		if err := keyring.Set(static.KeyringServiceName, keyringKeyName, string(valueJson)); err != nil {
-			return "", errors.Wrap(err, "failed to write github secret to local keyring")
-		}
-	}
-
 	fmt.Println()
 	fmt.Println()
 
-	return token, nil
+	return tokenRes, nil
 }
 
-type githubTokenKeyringValue struct {
+// GithubStoredToken is the token as it is stored in the credential store
+type GithubStoredToken struct {
 	AccessToken string    `json:"access_token"`
 	ExpiresAt   time.Time `json:"expires_at"`
+
+	// only set for GitHub Apps with expiring user tokens
+	RefreshToken          string     `json:"refresh_token,omitempty"`
+	RefreshTokenExpiresAt *time.Time `json:"refresh_token_expires_at,omitempty"`
+}
+
+// lookupGithubTokenExpiry asks the GitHub API when the access token expires, for token responses without expires_in.
+// returns nil if the response included expires_in, or if GitHub does not report an expiration
+func lookupGithubTokenExpiry(client *resty.Client, token *GithubAccessToken) *time.Time {
+	if token.ExpiresIn > 0 {
+		return nil
+	}
+
+	_, _, expiresAt, err := GithubGetAuthenticatedUser(client, token.AccessToken)
+	if err != nil {
+		return nil
+	}
+	return expiresAt
+}
+
+// newGithubStoredToken converts a token response received at now.
+// apiExpiresAt is the expiry reported by the GitHub API (see lookupGithubTokenExpiry), it is used if the response has no expires_in
+func newGithubStoredToken(token *GithubAccessToken, apiExpiresAt *time.Time, now time.Time) *GithubStoredToken {
+	stored := &GithubStoredToken{
+		AccessToken:  token.AccessToken,
+		ExpiresAt:    now.Add(fallbackTokenLifetime),
+		RefreshToken: token.RefreshToken,
+	}
+
+	if token.ExpiresIn > 0 {
+		stored.ExpiresAt = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	} else if apiExpiresAt != nil {
+		stored.ExpiresAt = *apiExpiresAt
+	}
+	if token.RefreshToken != "" && token.RefreshTokenExpiresIn > 0 {
+		refreshExpiresAt := now.Add(time.Duration(token.RefreshTokenExpiresIn) * time.Second)
+		stored.RefreshTokenExpiresAt = &refreshExpiresAt
+	}
+
+	return stored
+}
+
+func (t GithubStoredToken) AccessTokenExpired() bool {
+	return time.Now().Add(tokenExpiryMargin).After(t.ExpiresAt)
+}
+
+func (t GithubStoredToken) RefreshTokenExpired() bool {
+	return t.RefreshTokenExpiresAt != nil && time.Now().Add(tokenExpiryMargin).After(*t.RefreshTokenExpiresAt)
+}
+
+// LoadGithubToken reads the token from the credential store
+// returns nil if no token is stored or if the stored value cannot be parsed
+func LoadGithubToken(store lib_credentials.Store, clientId string) (*GithubStoredToken, error) {
+	value, err := store.Get(getGithubCredentialKeyName(clientId))
+	if err != nil {
+		if errors.Is(err, lib_credentials.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to get github secret from %s", store.Name())
+	}
+
+	var stored GithubStoredToken
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		color.HiRed("ERROR: Failed to parse github secret from %s", store.Name())
+		return nil, nil
+	}
+
+	return &stored, nil
+}
+
+func StoreGithubToken(store lib_credentials.Store, clientId string, token *GithubStoredToken) error {
+	valueJson, err := json.Marshal(token)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize github access token")
+	}
+
+	if err := store.Set(getGithubCredentialKeyName(clientId), string(valueJson)); err != nil {
+		return errors.Wrapf(err, "failed to write github secret to %s", store.Name())
+	}
+
+	return nil
+}
+
+// DeleteGithubToken removes the token from the credential store
+// returns false if no token was stored
+func DeleteGithubToken(store lib_credentials.Store, clientId string) (deleted bool, err error) {
+	if err := store.Delete(getGithubCredentialKeyName(clientId)); err != nil {
+		if errors.Is(err, lib_credentials.ErrNotFound) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "failed to delete github secret from %s", store.Name())
+	}
+	return true, nil
 }
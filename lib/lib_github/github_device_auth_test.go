@@ -0,0 +1,233 @@
+package lib_github
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/schoolyear/avd-cli/lib/lib_credentials"
+	"github.com/stretchr/testify/require"
+)
+
+// redirectTransport sends all requests to the test server, regardless of the GitHub host in the URL
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (r redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *resty.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return resty.New().SetTransport(redirectTransport{target: target})
+}
+
+// memoryStore keeps credentials in memory
+type memoryStore map[string]string
+
+func (m memoryStore) Get(key string) (string, error) {
+	value, ok := m[key]
+	if !ok {
+		return "", lib_credentials.ErrNotFound
+	}
+	return value, nil
+}
+
+func (m memoryStore) Set(key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func (m memoryStore) Delete(key string) error {
+	if _, ok := m[key]; !ok {
+		return lib_credentials.ErrNotFound
+	}
+	delete(m, key)
+	return nil
+}
+
+func (m memoryStore) Name() string    { return "memory" }
+func (m memoryStore) Warning() string { return "" }
+
+func TestGithubRefreshAccessToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    *GithubAccessToken
+		wantErr error
+	}{
+		{
+			name:   "success",
+			status: http.StatusOK,
+			body:   `{"access_token":"new-access","token_type":"bearer","expires_in":28800,"refresh_token":"new-refresh","refresh_token_expires_in":15811200}`,
+			want: &GithubAccessToken{
+				AccessToken:           "new-access",
+				TokenType:             "bearer",
+				ExpiresIn:             28800,
+				RefreshToken:          "new-refresh",
+				RefreshTokenExpiresIn: 15811200,
+			},
+		},
+		{
+			name:    "expired refresh token",
+			status:  http.StatusOK,
+			body:    `{"error":"bad_refresh_token","error_description":"The refresh token passed is incorrect or expired."}`,
+			wantErr: ErrGithubBadRefreshToken,
+		},
+		{
+			name:   "other error",
+			status: http.StatusOK,
+			body:   `{"error":"unsupported_grant_type","error_description":"The grant type is not supported."}`,
+		},
+		{
+			name:   "unexpected status code",
+			status: http.StatusInternalServerError,
+			body:   `oops`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/login/oauth/access_token", r.URL.Path)
+				require.Equal(t, "client-id", r.URL.Query().Get("client_id"))
+				require.Equal(t, "refresh-token", r.URL.Query().Get("refresh_token"))
+				require.Equal(t, "refresh_token", r.URL.Query().Get("grant_type"))
+
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			})
+
+			token, err := GithubRefreshAccessToken(client, "client-id", "refresh-token")
+			if tt.want != nil {
+				require.NoError(t, err)
+				require.Equal(t, tt.want, token)
+				return
+			}
+
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NotErrorIs(t, err, ErrGithubBadRefreshToken)
+			}
+		})
+	}
+}
+
+func TestGithubCachedToken(t *testing.T) {
+	expired := func(refreshToken string) *GithubStoredToken {
+		return &GithubStoredToken{
+			AccessToken:  "old-access",
+			ExpiresAt:    time.Now().Add(-time.Hour),
+			RefreshToken: refreshToken,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		cached   *GithubStoredToken
+		response string
+		// wantAccessToken is empty if no usable token should be returned
+		wantAccessToken string
+		wantRequest     bool
+	}{
+		{
+			name:            "valid token is not refreshed",
+			cached:          &GithubStoredToken{AccessToken: "access", ExpiresAt: time.Now().Add(time.Hour)},
+			wantAccessToken: "access",
+		},
+		{
+			name:            "expired token is refreshed",
+			cached:          expired("refresh-token"),
+			response:        `{"access_token":"new-access","expires_in":28800,"refresh_token":"new-refresh","refresh_token_expires_in":15811200}`,
+			wantAccessToken: "new-access",
+			wantRequest:     true,
+		},
+		{
+			name:        "expired refresh token",
+			cached:      expired("refresh-token"),
+			response:    `{"error":"bad_refresh_token"}`,
+			wantRequest: true,
+		},
+		{
+			name:   "expired token without refresh token",
+			cached: expired(""),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requested := false
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				requested = true
+				_, _ = w.Write([]byte(tt.response))
+			})
+
+			store := memoryStore{}
+			require.NoError(t, StoreGithubToken(store, "client-id", tt.cached))
+
+			token, err := GithubCachedToken(client, store, "client-id", true)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantRequest, requested)
+
+			if tt.wantAccessToken == "" {
+				require.Nil(t, token)
+				return
+			}
+			require.Equal(t, tt.wantAccessToken, token.AccessToken)
+
+			var stored GithubStoredToken
+			require.NoError(t, json.Unmarshal([]byte(store[getGithubCredentialKeyName("client-id")]), &stored))
+			require.Equal(t, tt.wantAccessToken, stored.AccessToken)
+		})
+	}
+}
+
+func Test_newGithubStoredToken(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	apiExpiresAt := now.Add(2 * time.Hour)
+
+	stored := newGithubStoredToken(&GithubAccessToken{AccessToken: "access", ExpiresIn: 3600, RefreshToken: "refresh", RefreshTokenExpiresIn: 7200}, &apiExpiresAt, now)
+	require.Equal(t, now.Add(time.Hour), stored.ExpiresAt)
+	require.Equal(t, now.Add(2*time.Hour), *stored.RefreshTokenExpiresAt)
+
+	stored = newGithubStoredToken(&GithubAccessToken{AccessToken: "access"}, &apiExpiresAt, now)
+	require.Equal(t, apiExpiresAt, stored.ExpiresAt)
+	require.Nil(t, stored.RefreshTokenExpiresAt)
+
+	stored = newGithubStoredToken(&GithubAccessToken{AccessToken: "access"}, nil, now)
+	require.Equal(t, now.Add(fallbackTokenLifetime), stored.ExpiresAt)
+}
+
+func Test_lookupGithubTokenExpiry(t *testing.T) {
+	requests := 0
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		require.Equal(t, "/user", r.URL.Path)
+		w.Header().Set("GitHub-Authentication-Token-Expiration", "2026-06-01 12:00:00 UTC")
+		_, _ = w.Write([]byte(`{"login":"octocat"}`))
+	})
+
+	// the token response already reports the expiry, so the API is not asked
+	require.Nil(t, lookupGithubTokenExpiry(client, &GithubAccessToken{AccessToken: "access", ExpiresIn: 3600}))
+	require.Equal(t, 0, requests)
+
+	expiresAt := lookupGithubTokenExpiry(client, &GithubAccessToken{AccessToken: "access"})
+	require.Equal(t, 1, requests)
+	require.NotNil(t, expiresAt)
+	require.Equal(t, time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC), *expiresAt)
+}
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// semverConstraintOperators are the prefixes that mark a reference as a version constraint instead of a literal ref
+var semverConstraintOperators = []string{">=", "<=", ">", "<", "=", "^", "~"}
+
+// SemverConstraint is a version range, parsed from constraints like ^1.4, ~2.0.1 or >=1.2
+type SemverConstraint struct {
+	raw string
+
+	lower          string // canonical semver, empty if unbounded
+	lowerInclusive bool
+	upper          string // canonical semver, empty if unbounded
+	upperInclusive bool
+}
+
+// IsSemverConstraint returns whether the value starts with one of the supported constraint operators
+func IsSemverConstraint(value string) bool {
+	for _, operator := range semverConstraintOperators {
+		if strings.HasPrefix(value, operator) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSemverConstraint parses a single constraint.
+// supported operators:
+//   - ^1.4: compatible with 1.4 (>=1.4.0, <2.0.0). for 0.x versions the minor version must match
+//   - ~2.0.1: patch-level changes only (>=2.0.1, <2.1.0). ~2 allows minor-level changes
+//   - =1.4.2: exactly this version
+//   - >=, >, <=, <: open-ended ranges
+func ParseSemverConstraint(value string) (*SemverConstraint, error) {
+	var operator string
+	for _, op := range semverConstraintOperators {
+		if strings.HasPrefix(value, op) {
+			operator = op
+			break
+		}
+	}
+	if operator == "" {
+		return nil, fmt.Errorf("version constraint %s must start with one of %s", value, strings.Join(semverConstraintOperators, ", "))
+	}
+
+	version := strings.TrimSpace(strings.TrimPrefix(value, operator))
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	if !semver.IsValid(version) {
+		return nil, fmt.Errorf("invalid version in constraint %s", value)
+	}
+	if semver.Build(version) != "" {
+		return nil, fmt.Errorf("build metadata is not supported in constraint %s", value)
+	}
+
+	// the number of version components that were explicitly set (1.4 -> 2)
+	components := strings.Count(strings.TrimSuffix(strings.TrimPrefix(version, "v"), semver.Prerelease(version)), ".") + 1
+	canonical := semver.Canonical(version)
+	major, minor, patch := semverParts(canonical)
+
+	constraint := SemverConstraint{raw: value}
+	switch operator {
+	case "^":
+		constraint.lower, constraint.lowerInclusive = canonical, true
+		switch {
+		case major > 0 || components == 1:
+			constraint.upper = fmt.Sprintf("v%d.0.0", major+1)
+		case minor > 0 || components == 2:
+			constraint.upper = fmt.Sprintf("v0.%d.0", minor+1)
+		default:
+			constraint.upper = fmt.Sprintf("v0.0.%d", patch+1)
+		}
+	case "~":
+		constraint.lower, constraint.lowerInclusive = canonical, true
+		if components == 1 {
+			constraint.upper = fmt.Sprintf("v%d.0.0", major+1)
+		} else {
+			constraint.upper = fmt.Sprintf("v%d.%d.0", major, minor+1)
+		}
+	case "=":
+		if components != 3 {
+			return nil, fmt.Errorf("exact version constraint %s must specify major, minor and patch", value)
+		}
+		constraint.lower, constraint.lowerInclusive = canonical, true
+		constraint.upper, constraint.upperInclusive = canonical, true
+	case ">=":
+		constraint.lower, constraint.lowerInclusive = canonical, true
+	case ">":
+		constraint.lower = canonical
+	case "<=":
+		constraint.upper, constraint.upperInclusive = canonical, true
+	case "<":
+		constraint.upper = canonical
+	}
+
+	return &constraint, nil
+}
+
+func (c SemverConstraint) String() string {
+	return c.raw
+}
+
+// Matches returns whether the version satisfies the constraint.
+// pre-release versions only match if they are explicitly pinned using =
+func (c SemverConstraint) Matches(version string) bool {
+	if !semver.IsValid(version) {
+		return false
+	}
+	if semver.Prerelease(version) != "" && (c.lower != c.upper || c.lower == "") {
+		return false
+	}
+
+	if c.lower != "" {
+		cmp := semver.Compare(version, c.lower)
+		if cmp < 0 || (cmp == 0 && !c.lowerInclusive) {
+			return false
+		}
+	}
+	if c.upper != "" {
+		cmp := semver.Compare(version, c.upper)
+		if cmp > 0 || (cmp == 0 && !c.upperInclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolve picks the highest version that matches the constraint.
+// newerMajor is set to the highest available version if it has a higher major version than the selected one
+func (c SemverConstraint) Resolve(versions []string) (selected string, newerMajor string, found bool) {
+	var highest string
+	for _, version := range versions {
+		if !semver.IsValid(version) || semver.Prerelease(version) != "" {
+			continue
+		}
+		if highest == "" || semver.Compare(version, highest) > 0 {
+			highest = version
+		}
+	}
+
+	for _, version := range versions {
+		if !c.Matches(version) {
+			continue
+		}
+		if selected == "" || semver.Compare(version, selected) > 0 {
+			selected = version
+		}
+	}
+
+	if selected == "" {
+		return "", "", false
+	}
+
+	if highest != "" && semver.Compare(semver.Major(highest), semver.Major(selected)) > 0 {
+		newerMajor = highest
+	}
+
+	return selected, newerMajor, true
+}
+
+// semverParts returns the numeric parts of a canonical semver string (vX.Y.Z[-pre])
+func semverParts(canonical string) (major, minor, patch int) {
+	core := strings.TrimSuffix(strings.TrimPrefix(canonical, "v"), semver.Prerelease(canonical))
+	_, _ = fmt.Sscanf(core, "%d.%d.%d", &major, &minor, &patch)
+	return major, minor, patch
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemverConstraint_Matches(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"^1.4", "v1.4.0", true},
+		{"^1.4", "v1.9.3", true},
+		{"^1.4", "v1.3.9", false},
+		{"^1.4", "v2.0.0", false},
+		{"^0.3", "v0.3.5", true},
+		{"^0.3", "v0.4.0", false},
+		{"^0.0.3", "v0.0.4", false},
+		{"~2.0.1", "v2.0.5", true},
+		{"~2.0.1", "v2.0.0", false},
+		{"~2.0.1", "v2.1.0", false},
+		{"~2", "v2.7.0", true},
+		{"=1.4.2", "v1.4.2", true},
+		{"=1.4.2", "v1.4.3", false},
+		{"=1.4.2-rc.1", "v1.4.2-rc.1", true},
+		{">=1.2", "v3.0.0", true},
+		{">1.2.0", "v1.2.0", false},
+		{"<2", "v1.99.0", true},
+		{"<=2.0.0", "v2.0.0", true},
+		{"^1.4", "v1.5.0-beta", false},
+		{"^1.4", "not-a-version", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint+" "+tt.version, func(t *testing.T) {
+			constraint, err := ParseSemverConstraint(tt.constraint)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, constraint.Matches(tt.version))
+		})
+	}
+}
+
+func TestParseSemverConstraint_Invalid(t *testing.T) {
+	for _, value := range []string{"main", "^", "^abc", "=1.4", "^1.4.2+build"} {
+		_, err := ParseSemverConstraint(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestSemverConstraint_Resolve(t *testing.T) {
+	versions := []string{"v1.3.0", "v1.4.2", "v1.4.10", "v2.0.0", "v2.1.0-beta", "garbage"}
+
+	constraint, err := ParseSemverConstraint("^1.4")
+	require.NoError(t, err)
+
+	selected, newerMajor, found := constraint.Resolve(versions)
+	require.True(t, found)
+	require.Equal(t, "v1.4.10", selected)
+	require.Equal(t, "v2.0.0", newerMajor)
+
+	constraint, err = ParseSemverConstraint("^2")
+	require.NoError(t, err)
+
+	selected, newerMajor, found = constraint.Resolve(versions)
+	require.True(t, found)
+	require.Equal(t, "v2.0.0", selected)
+	require.Empty(t, newerMajor)
+
+	constraint, err = ParseSemverConstraint("^3")
+	require.NoError(t, err)
+
+	_, _, found = constraint.Resolve(versions)
+	require.False(t, found)
+}
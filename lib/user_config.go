@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	avdcliHomeDirName  = ".avdcli"
+	userConfigFilename = "config"
+)
+
+// AvdcliHomeDir returns the path of the ~/.avdcli directory. The directory is not created
+func AvdcliHomeDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get home directory")
+	}
+	return filepath.Join(homeDir, avdcliHomeDirName), nil
+}
+
+// ExpandHomeDir resolves a leading ~ in the path to the home directory of the user
+func ExpandHomeDir(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get home directory")
+	}
+	return filepath.Join(homeDir, path[1:]), nil
+}
+
+// UserConfig is the configuration of the local user, stored as JSON in ~/.avdcli/config
+type UserConfig struct {
+	// TrustedLayerKeys are public keys (minisign format) of which layer signatures are trusted
+	TrustedLayerKeys []string `json:"trusted_layer_keys,omitempty"`
+	// DefaultProfile is used when no profile is selected with --profile or AVDCLI_PROFILE
+	DefaultProfile string `json:"default_profile,omitempty"`
+	// Profiles are named sets of Azure settings, see ProfileKeys
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+}
+
+// Profile holds values of Azure flags by their ProfileKeys name, so they don't have to be repeated for every command.
+// lists, like replication_regions, are comma separated
+type Profile map[string]string
+
+type ProfileKey struct {
+	Name        string
+	Description string
+}
+
+// ProfileKeys are the settings that a profile can hold
+var ProfileKeys = []ProfileKey{
+	{Name: "subscription_id", Description: "Azure subscription ID"},
+	{Name: "tenant_id", Description: "Azure Tenant ID"},
+	{Name: "azure_backend", Description: "How to talk to Azure: sdk or az"},
+	{Name: "resource_group", Description: "Resource Group of the Image Gallery and Image Templates"},
+	{Name: "image_gallery", Description: "Name of the shared image gallery"},
+	{Name: "storage_account", Description: "Storage Account to which bundles are uploaded"},
+	{Name: "blob_container", Description: "Blob Container to which bundles are uploaded"},
+	{Name: "resources_uri", Description: "URI to which \"package deploy\" uploads the resources archive"},
+	{Name: "managed_identity", Description: "Managed identity used during image building"},
+	{Name: "template_location", Description: "Azure location in which Image Templates are created"},
+	{Name: "replication_regions", Description: "Comma separated regions to replicate images to, as region[=count[:storage-type]]"},
+	{Name: "builder_subnet_id", Description: "Subnet in which the builder VM is deployed"},
+	{Name: "builder_proxy_vm_size", Description: "VM size of the proxy VM of the builder VM"},
+	{Name: "builder_container_instance_subnet_id", Description: "Subnet of the Container Instance used for isolated builds"},
+}
+
+func IsProfileKey(key string) bool {
+	for _, profileKey := range ProfileKeys {
+		if profileKey.Name == key {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectProfile returns the profile with the name, or the default profile if the name is empty.
+// returns nil if the name is empty and there is no default profile
+func (c *UserConfig) SelectProfile(name string) (string, Profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+		if name == "" {
+			return "", nil, nil
+		}
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return "", nil, fmt.Errorf("profile %s does not exist in the user config. Create it with \"avdcli config set\"", name)
+	}
+	return name, profile, nil
+}
+
+func UserConfigPath() (string, error) {
+	dir, err := AvdcliHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, userConfigFilename), nil
+}
+
+// LoadUserConfig reads the user config file
+// returns an empty config if the file does not exist
+func LoadUserConfig() (*UserConfig, error) {
+	configPath, err := UserConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &UserConfig{}, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read user config %s", configPath)
+	}
+
+	var config UserConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse user config %s", configPath)
+	}
+
+	return &config, nil
+}
+
+// SaveUserConfig writes the user config file, creating the ~/.avdcli directory if needed
+func SaveUserConfig(config *UserConfig) error {
+	configPath, err := UserConfigPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
+		return errors.Wrap(err, "failed to create config directory")
+	}
+
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize user config")
+	}
+
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write user config %s", configPath)
+	}
+
+	return nil
+}
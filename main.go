@@ -46,12 +46,19 @@ func main() {
 Visit https://avd.schoolyear.com for more information on how to use this tool.`,
 		Version: static.Version,
 		Suggest: true,
+		Flags: []cli.Flag{
+			commands.CredentialStoreFlag,
+			commands.ProfileFlag,
+		},
 		Commands: cli.Commands{
 			{
 				Name:  "layer",
 				Usage: "manage image layers",
 				Subcommands: cli.Commands{
 					commands.LayerNewCommand,
+					commands.LayerSignCommand,
+					commands.LayerKeygenCommand,
+					commands.LayerMigrateV1Command,
 				},
 			},
 			{
@@ -60,6 +67,16 @@ Visit https://avd.schoolyear.com for more information on how to use this tool.`,
 				Subcommands: cli.Commands{
 					commands.BundleLayersCommand,
 					commands.BundleAutoDeployCommand,
+					commands.BundleExportScriptCommand,
+				},
+			},
+			{
+				Name:  "build",
+				Usage: "track image builds",
+				Subcommands: cli.Commands{
+					commands.BuildStatusCommand,
+					commands.BuildListCommand,
+					commands.BuildShowCommand,
 				},
 			},
 			{
@@ -68,6 +85,11 @@ Visit https://avd.schoolyear.com for more information on how to use this tool.`,
 				Subcommands: cli.Commands{
 					commands.ImageNewCommand,
 					commands.ImagePackageCommand,
+					commands.ImagePromoteCommand,
+					commands.ImageDemoteCommand,
+					commands.ImageRollbackCommand,
+					commands.ImageListCommand,
+					commands.ImageProvenanceCommand,
 				},
 			},
 			{
@@ -75,8 +97,28 @@ Visit https://avd.schoolyear.com for more information on how to use this tool.`,
 				Usage: "manage image building packages (used for v1 images)",
 				Subcommands: cli.Commands{
 					commands.PackageDeployCommand,
+					commands.PackageParamsCommand,
+				},
+			},
+			{
+				Name:  "auth",
+				Usage: "manage your GitHub login for community layers",
+				Subcommands: cli.Commands{
+					commands.AuthLoginCommand,
+					commands.AuthStatusCommand,
+					commands.AuthLogoutCommand,
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "manage the profiles in the user config (~/.avdcli/config) with default values of the Azure flags",
+				Subcommands: cli.Commands{
+					commands.ConfigListCommand,
+					commands.ConfigGetCommand,
+					commands.ConfigSetCommand,
 				},
 			},
+			commands.CleanupCommand,
 			commands.UpdateCommand,
 		},
 		EnableBashCompletion: true,
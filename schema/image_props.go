@@ -1,9 +1,11 @@
 package schema
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/virtualmachineimagebuilder/armvirtualmachineimagebuilder"
@@ -75,7 +77,9 @@ const (
 	BuiltInInternalServicesPlaceholder          string = "internalServiceLinkIdsJSON"
 )
 
-var placeholderRegex = regexp.MustCompile(`\[{3}([a-zA-Z0-9_]+):([a-zA-Z0-9_]+)]{3}`)
+// placeholderRegex matches [[[type:name]]] and [[[type:name|default]]].
+// a default cannot contain ']', as there is no escape for it
+var placeholderRegex = regexp.MustCompile(`\[{3}([a-zA-Z0-9_]+):([a-zA-Z0-9_]+)(?:\|([^\]]*))?]{3}`)
 
 func FindPlaceholdersInJSON(bytes []byte, placeholderType PlaceholderType) map[string]struct{} {
 	matchIndexes := placeholderRegex.FindAllSubmatchIndex(bytes, -1)
@@ -91,6 +95,27 @@ func FindPlaceholdersInJSON(bytes []byte, placeholderType PlaceholderType) map[s
 	return matches
 }
 
+// FindPlaceholderDefaults returns the default values of the placeholders of the type that have one.
+// returns an error if the document is not valid JSON, or if a placeholder has different defaults
+func FindPlaceholderDefaults(data []byte, placeholderType PlaceholderType) (map[string]string, error) {
+	placeholders, err := FindPlaceholders(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find placeholders: %w", err)
+	}
+
+	defaults := map[string]string{}
+	for _, placeholder := range placeholders {
+		if placeholder.Type != placeholderType || placeholder.Default == nil {
+			continue
+		}
+		if existing, ok := defaults[placeholder.Name]; ok && existing != *placeholder.Default {
+			return nil, fmt.Errorf("placeholder %s:%s has conflicting defaults %q and %q", placeholderType, placeholder.Name, existing, *placeholder.Default)
+		}
+		defaults[placeholder.Name] = *placeholder.Default
+	}
+	return defaults, nil
+}
+
 func ReplacePlaceholders(bytes []byte, mapping map[string]string, placeholderType PlaceholderType) []byte {
 	return placeholderRegex.ReplaceAllFunc(bytes, func(bytes []byte) []byte {
 		match := placeholderRegex.FindSubmatch(bytes)
@@ -109,6 +134,82 @@ func ReplacePlaceholders(bytes []byte, mapping map[string]string, placeholderTyp
 	})
 }
 
+// Placeholder is a placeholder found in a JSON document
+type Placeholder struct {
+	Type    PlaceholderType `json:"type"`
+	Name    string          `json:"name"`
+	Default *string         `json:"default,omitempty"` // nil if the placeholder has no default
+	Pointer string          `json:"pointer"`           // JSON pointer to the key or value that contains the placeholder
+	Text    string          `json:"text"`              // the placeholder as it appears in the document
+}
+
+// FindPlaceholders returns all placeholders in the JSON document, in document order
+func FindPlaceholders(data []byte) ([]Placeholder, error) {
+	var placeholders []Placeholder
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := walkJSONStrings(decoder, "", func(pointer, value string) {
+		for _, match := range placeholderRegex.FindAllStringSubmatchIndex(value, -1) {
+			placeholder := Placeholder{
+				Type:    PlaceholderType(value[match[2]:match[3]]),
+				Name:    value[match[4]:match[5]],
+				Pointer: pointer,
+				Text:    value[match[0]:match[1]],
+			}
+			if match[6] >= 0 {
+				defaultValue := value[match[6]:match[7]]
+				placeholder.Default = &defaultValue
+			}
+			placeholders = append(placeholders, placeholder)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return placeholders, nil
+}
+
+// walkJSONStrings calls visit for every object key and string value with its JSON pointer
+func walkJSONStrings(decoder *json.Decoder, pointer string, visit func(pointer, value string)) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		switch token {
+		case '{':
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := keyToken.(string)
+				memberPointer := pointer + "/" + jsonPointerEscaper.Replace(key)
+				visit(memberPointer, key)
+				if err := walkJSONStrings(decoder, memberPointer, visit); err != nil {
+					return err
+				}
+			}
+		case '[':
+			for i := 0; decoder.More(); i++ {
+				if err := walkJSONStrings(decoder, pointer+"/"+strconv.Itoa(i), visit); err != nil {
+					return err
+				}
+			}
+		}
+		// closing delimiter
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	case string:
+		visit(pointer, token)
+	}
+	return nil
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
 // SetBuildSteps sets the customizer steps for the image template.
 // returns true if some customizer steps are already set (meaning the new steps are not added)
 func (i ImageProperties) SetBuildSteps(buildSteps []armvirtualmachineimagebuilder.ImageTemplateCustomizerClassification) (conflict bool) {
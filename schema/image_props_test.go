@@ -0,0 +1,50 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindPlaceholders(t *testing.T) {
+	data := []byte(`{
+		"name": "[[[param:imageName]]]",
+		"tags": {"[[[param:tagName|owner]]]": "[[[props:team|it \"ops\"]]]"},
+		"steps": [{"uri": "[[[deployment:sourceURI]]]"}, {"inline": ["x", "a [[[builtin:proxy]]] b [[[param:a/b|]]]"]}]
+	}`)
+
+	placeholders, err := FindPlaceholders(data)
+	require.NoError(t, err)
+	require.Equal(t, []Placeholder{
+		{Type: ParameterPlaceholder, Name: "imageName", Pointer: "/name", Text: "[[[param:imageName]]]"},
+		{Type: ParameterPlaceholder, Name: "tagName", Default: to.Ptr("owner"), Pointer: "/tags/[[[param:tagName|owner]]]", Text: "[[[param:tagName|owner]]]"},
+		{Type: PropertiesPlaceholder, Name: "team", Default: to.Ptr(`it "ops"`), Pointer: "/tags/[[[param:tagName|owner]]]", Text: `[[[props:team|it "ops"]]]`},
+		{Type: "deployment", Name: "sourceURI", Pointer: "/steps/0/uri", Text: "[[[deployment:sourceURI]]]"},
+		{Type: BuiltInPlaceholder, Name: "proxy", Pointer: "/steps/1/inline/1", Text: "[[[builtin:proxy]]]"},
+	}, placeholders, "a/b is not a valid name")
+
+	defaults, err := FindPlaceholderDefaults(data, ParameterPlaceholder)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"tagName": "owner"}, defaults)
+	defaults, err = FindPlaceholderDefaults(data, PropertiesPlaceholder)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"team": `it "ops"`}, defaults)
+
+	defaults, err = FindPlaceholderDefaults([]byte(`["[[[param:size|small]]]", "[[[param:size|small]]]"]`), ParameterPlaceholder)
+	require.NoError(t, err, "the same default may be repeated")
+	require.Equal(t, map[string]string{"size": "small"}, defaults)
+	_, err = FindPlaceholderDefaults([]byte(`["[[[param:size|small]]]", "[[[param:size]]]", "[[[param:size|large]]]"]`), ParameterPlaceholder)
+	require.ErrorContains(t, err, `placeholder param:size has conflicting defaults "small" and "large"`)
+	_, err = FindPlaceholderDefaults([]byte(`["[[[param:size|small]]]"`), ParameterPlaceholder)
+	require.Error(t, err, "invalid JSON")
+
+	// a ] ends the default, even if it is escaped in the JSON
+	placeholders, err = FindPlaceholders([]byte(`["[[[param:size|a]b]]]", "[[[param:size|a\u005db]]]"]`))
+	require.NoError(t, err)
+	require.Empty(t, placeholders)
+
+	replaced := ReplacePlaceholders(data, map[string]string{"tagName": "creator", "imageName": "win11"}, ParameterPlaceholder)
+	require.Contains(t, string(replaced), `"name": "win11"`)
+	require.Contains(t, string(replaced), `{"creator": "[[[props:team|it \"ops\"]]]"}`)
+}
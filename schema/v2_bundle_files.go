@@ -5,9 +5,37 @@ const (
 	V2OnSessionHostSetupScriptFilename = "on_sessionhost_setup.ps1"
 	V2OnUserLoginAdminScriptFilename   = "on_user_login.admin.ps1"
 	V2OnUserLoginUserScriptFilename    = "on_user_login.user.ps1"
+	V2LayerSignatureFilename           = "signature.minisig"
 )
 
 const (
 	V2BuildParametersFilename  = "build_parameters.json"
 	V2BundlePropertiesFilename = "bundle_properties.json"
+	V2BundleSourcesFilename    = "bundle_sources.json"
 )
+
+// V2BundleSources records where the layers of a bundle came from, which version the user gave the bundle and its Windows Update stages.
+// the layer sources are informational. The version is used by the major-from-bundle version strategy, and the Windows Update stages
+// are the defaults of autobuild and the export script, which add them as customizers.
+// it is missing in bundles created by older versions, which then have no version and no Windows Update stages
+type V2BundleSources struct {
+	Version       string                `json:"version,omitempty"`
+	Layers        []V2BundleLayerSource `json:"layers"`
+	WindowsUpdate *V2WindowsUpdateStage `json:"windows_update,omitempty"`
+}
+
+type V2BundleLayerSource struct {
+	Directory string `json:"directory"` // name of the layer directory in the bundle
+	Name      string `json:"name"`
+	Source    string `json:"source"`
+}
+
+// V2WindowsUpdateStage configures the built-in Windows Update stages of autobuild, before and/or after the bundle is executed.
+// empty settings use the defaults of Azure Image Builder
+type V2WindowsUpdateStage struct {
+	Before         bool     `json:"before,omitempty"`
+	After          bool     `json:"after,omitempty"`
+	Filters        []string `json:"filters,omitempty"`
+	SearchCriteria string   `json:"search_criteria,omitempty"`
+	UpdateLimit    int32    `json:"update_limit,omitempty"`
+}